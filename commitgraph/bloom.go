@@ -0,0 +1,125 @@
+package commitgraph
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// The parameters of the changed-path Bloom filters, which are those
+// the reference Git client uses.
+const (
+	bloomHashVersion = 2  // version of the murmur3 hashing
+	bloomHashes      = 7  // number of hash functions
+	bloomBitsPerPath = 10 // filter size in bits per path
+
+	// MaxChangedPaths is the largest number of changed paths, their
+	// leading directories included, that a Bloom filter records.
+	// The filters of commits that change more paths match every
+	// path.
+	MaxChangedPaths = 512
+)
+
+// A BloomFilter is a changed-path Bloom filter: a probabilistic set of
+// the paths of the files a commit changes relative to its first
+// parent, or to the empty tree if it has none, and of their leading
+// directories.  A path in the set is always reported as such, but a
+// path not in it may be too.
+type BloomFilter []byte
+
+// NewBloomFilter returns the Bloom filter of the given changed paths,
+// which are slash-separated paths of files.  The leading directories
+// of the paths are added to the filter too.
+func NewBloomFilter(paths []string) BloomFilter {
+	if len(paths) > MaxChangedPaths {
+		return BloomFilter{0xff}
+	}
+	set := make(map[string]bool)
+	for _, p := range paths {
+		for p != "" && !set[p] {
+			set[p] = true
+			i := strings.LastIndex(p, "/")
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+	}
+	if len(set) > MaxChangedPaths {
+		return BloomFilter{0xff}
+	}
+	n := (len(set)*bloomBitsPerPath + 7) / 8
+	if n == 0 {
+		n = 1
+	}
+	b := make(BloomFilter, n)
+	for p := range set {
+		for _, bit := range b.bits(p) {
+			b[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	return b
+}
+
+// MaybeContains reports whether the path may be in the filter.  The
+// path of a directory must not end in a slash.  A nil filter may
+// contain any path.
+func (b BloomFilter) MaybeContains(path string) bool {
+	if len(b) == 0 {
+		return true
+	}
+	for _, bit := range b.bits(path) {
+		if b[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bits returns the positions of the bits the path sets in the filter.
+func (b BloomFilter) bits(path string) [bloomHashes]uint64 {
+	h0 := murmur3(0x293ae76f, []byte(path))
+	h1 := murmur3(0x7e646e2c, []byte(path))
+	var bits [bloomHashes]uint64
+	for i := range bits {
+		bits[i] = uint64(h0+uint32(i)*h1) % uint64(8*len(b))
+	}
+	return bits
+}
+
+// murmur3 returns the 32-bit MurmurHash3 hash of the data with the
+// given seed.
+func murmur3(seed uint32, data []byte) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	rotl := func(x uint32, r uint) uint32 {
+		return x<<r | x>>(32-r)
+	}
+	h := seed
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k = rotl(k*c1, 15) * c2
+		h = rotl(h^k, 13)*5 + 0xe6546b64
+	}
+	var k uint32
+	switch len(data) & 3 {
+	case 3:
+		k ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[n])
+		h ^= rotl(k*c1, 15) * c2
+	}
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,81 @@
+package commitgraph
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The expected hashes are those of the reference MurmurHash3
+// implementation, which the Bloom filter tests of the reference Git
+// client share.  The last string has bytes above 0x7f, which version 1
+// of the Git hashing sign-extends.
+var murmur3Tests = []struct {
+	seed uint32
+	data string
+	want uint32
+}{
+	{0, "", 0},
+	{0, "Hello world!", 0x627b0c2c},
+	{0, "The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	{1234, "Hello, world!", 0xfaf6cdb3},
+	{0, "\x99\xaa\xbb\xcc\xdd\xee\xff", 0xa183ccfd},
+}
+
+func TestMurmur3(t *testing.T) {
+	for _, tt := range murmur3Tests {
+		if got := murmur3(tt.seed, []byte(tt.data)); got != tt.want {
+			t.Errorf("murmur3(%#x, %q) = %#08x, want %#08x", tt.seed, tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestBloomKey checks the bits of the empty path in a 16-bit filter
+// against those the reference Git client computes.
+func TestBloomKey(t *testing.T) {
+	b := make(BloomFilter, 2)
+	want := [bloomHashes]uint64{12, 0, 4, 8, 12, 0, 4}
+	if got := b.bits(""); got != want {
+		t.Errorf("bits(\"\") = %v, want %v", got, want)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	paths := []string{"a", "b/c", "d/e/f", "d/e/g"}
+	b := NewBloomFilter(paths)
+	if len(b) != 9 {
+		t.Errorf("filter of 7 paths is %d bytes long", len(b))
+	}
+	for _, p := range []string{"a", "b", "b/c", "d", "d/e", "d/e/f", "d/e/g"} {
+		if !b.MaybeContains(p) {
+			t.Errorf("%s not in filter of %q", p, paths)
+		}
+	}
+	missing := 0
+	for i := 0; i < 100; i++ {
+		if !b.MaybeContains(fmt.Sprintf("x/%d", i)) {
+			missing++
+		}
+	}
+	if missing < 50 {
+		t.Errorf("only %d paths of 100 not in filter of %q", missing, paths)
+	}
+
+	if b := NewBloomFilter(nil); len(b) != 1 || b[0] != 0 || b.MaybeContains("a") {
+		t.Errorf("empty filter is %x", b)
+	}
+	var nilFilter BloomFilter
+	if !nilFilter.MaybeContains("a") {
+		t.Error("path not in nil filter")
+	}
+
+	many := make([]string, MaxChangedPaths/2+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("%d/file", i)
+	}
+	if b := NewBloomFilter(many); len(b) != 1 || b[0] != 0xff {
+		t.Errorf("filter of %d paths is %x", 2*len(many), b)
+	}
+	if b := NewBloomFilter(many[1:]); len(b) == 1 {
+		t.Errorf("filter of %d paths is %x", 2*len(many)-2, b)
+	}
+}
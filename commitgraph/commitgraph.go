@@ -0,0 +1,196 @@
+// Package commitgraph implements the commit-graph file of the reference
+// Git client, which records the parents, trees and dates of the
+// commits of a repository along with their generation numbers and
+// changed-path Bloom filters, so that the commit history can be walked
+// without retrieving and parsing the commits themselves.  See
+// https://git-scm.com/docs/gitformat-commit-graph for details.
+package commitgraph
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+var (
+	// ErrChecksum is returned when reading a commit-graph file that
+	// has an invalid checksum.
+	ErrChecksum = errors.New("commitgraph: invalid checksum")
+	// ErrGraph is returned when reading a malformed commit-graph
+	// file, and when creating a graph of commits whose parents are
+	// not in it.
+	ErrGraph = errors.New("commitgraph: invalid commit-graph")
+	// ErrVersion is returned when reading a commit-graph file with
+	// an unsupported version, or one that is part of a chain of
+	// split commit-graph files.
+	ErrVersion = errors.New("commitgraph: unsupported version")
+)
+
+// The largest values the commit-graph file format can hold.
+const (
+	MaxLevel = 1<<30 - 1 // largest topological level
+	MaxTime  = 1<<34 - 1 // largest commit date
+)
+
+// A Graph is a commit-graph: a set of commits closed under the parent
+// relation, that is, including the parents of each of its commits.
+type Graph struct {
+	Format  object.Format
+	Commits []Commit // in ascending order by ID
+}
+
+// A Commit is a commit in a commit-graph.
+type Commit struct {
+	ID      object.ID
+	Tree    object.ID
+	Parents []object.ID
+
+	// Time is the committer date of the commit in seconds since
+	// the Unix epoch, clamped to between 0 and MaxTime.
+	Time int64
+
+	// Level is the topological level of the commit, the generation
+	// number version 1: 1 for root commits and one more than the
+	// greatest level of its parents for others, up to MaxLevel.
+	Level uint32
+
+	// Generation is the corrected commit date of the commit, the
+	// generation number version 2: the greater of Time and one more
+	// than the greatest generation of its parents.
+	Generation int64
+
+	// Bloom is the changed-path Bloom filter of the commit, or nil
+	// if it has none.
+	Bloom BloomFilter
+}
+
+// New returns a commit-graph of the given commits.  The commits are
+// sorted, and their levels and generations are computed from their
+// parents and dates.  It returns ErrGraph if a parent of a commit is
+// not among the commits, or if a commit is listed twice.
+func New(f object.Format, commits []Commit) (*Graph, error) {
+	g := &Graph{Format: f, Commits: commits}
+	sort.Sort(commitSlice(commits))
+	for i := 1; i < len(commits); i++ {
+		if commits[i].ID == commits[i-1].ID {
+			return nil, ErrGraph
+		}
+	}
+	for i := range commits {
+		c := &commits[i]
+		if c.Time < 0 {
+			c.Time = 0
+		} else if c.Time > MaxTime {
+			c.Time = MaxTime
+		}
+		c.Level = 0
+	}
+	if err := g.computeGenerations(true); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// computeGenerations computes the generations of the commits, and
+// their levels too if levels is set.  The commits are visited parents
+// first, without recursion, as histories can be deep.
+func (g *Graph) computeGenerations(levels bool) error {
+	// the number of unvisited parents of each commit, and the
+	// children of each commit
+	waiting := make([]int, len(g.Commits))
+	children := make([][]int, len(g.Commits))
+	var ready []int
+	for i, c := range g.Commits {
+		for _, id := range c.Parents {
+			k := g.find(id)
+			if k < 0 {
+				return ErrGraph
+			}
+			children[k] = append(children[k], i)
+		}
+		if waiting[i] = len(c.Parents); waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		c := &g.Commits[i]
+		level, gen := uint32(0), int64(0)
+		for _, id := range c.Parents {
+			p := &g.Commits[g.find(id)]
+			if p.Level > level {
+				level = p.Level
+			}
+			if p.Generation > gen {
+				gen = p.Generation
+			}
+		}
+		if levels {
+			if c.Level = level + 1; c.Level > MaxLevel {
+				c.Level = MaxLevel
+			}
+		}
+		if c.Generation = gen + 1; c.Generation < c.Time {
+			c.Generation = c.Time
+		}
+		visited++
+		for _, k := range children[i] {
+			if waiting[k]--; waiting[k] == 0 {
+				ready = append(ready, k)
+			}
+		}
+	}
+	if visited < len(g.Commits) {
+		return ErrGraph // the parent relation has a cycle
+	}
+	return nil
+}
+
+// find returns the index of the commit with the given ID, or -1 if
+// there is none.
+func (g *Graph) find(id object.ID) int {
+	b := id.Bytes()
+	i := sort.Search(len(g.Commits), func(i int) bool {
+		return bytes.Compare(g.Commits[i].ID.Bytes(), b) >= 0
+	})
+	if i < len(g.Commits) && g.Commits[i].ID == id {
+		return i
+	}
+	return -1
+}
+
+// Find returns the commit with the given ID.
+func (g *Graph) Find(id object.ID) (*Commit, bool) {
+	if i := g.find(id); i >= 0 {
+		return &g.Commits[i], true
+	}
+	return nil, false
+}
+
+// MaybeChanged reports whether the commit with the given ID may have
+// changed the file or directory at the slash-separated path relative to
+// its first parent, as far as its Bloom filter tells.  It returns true
+// if the commit is not in the graph or has no Bloom filter.
+func (g *Graph) MaybeChanged(id object.ID, path string) bool {
+	c, ok := g.Find(id)
+	return !ok || c.Bloom.MaybeContains(path)
+}
+
+// commitSlice sorts commits in ascending order by ID.
+type commitSlice []Commit
+
+func (s commitSlice) Len() int {
+	return len(s)
+}
+
+func (s commitSlice) Less(i, j int) bool {
+	return bytes.Compare(s[i].ID.Bytes(), s[j].ID.Bytes()) < 0
+}
+
+func (s commitSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
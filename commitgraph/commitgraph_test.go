@@ -0,0 +1,169 @@
+package commitgraph
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// A testCommit describes a commit of a test graph by name.
+type testCommit struct {
+	name    string
+	parents string // space-separated names
+	time    int64
+}
+
+// testGraph creates a graph of the described commits, whose IDs and
+// trees are hashes of their names.
+func testGraph(t *testing.T, f object.Format, commits []testCommit) *Graph {
+	id := func(name string) object.ID {
+		id, err := f.Sum([]byte(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var cs []Commit
+	for _, tc := range commits {
+		c := Commit{
+			ID:    id(tc.name),
+			Tree:  id("tree " + tc.name),
+			Time:  tc.time,
+			Bloom: NewBloomFilter([]string{tc.name}),
+		}
+		for _, p := range strings.Fields(tc.parents) {
+			c.Parents = append(c.Parents, id(p))
+		}
+		cs = append(cs, c)
+	}
+	g, err := New(f, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// graphHistory has an octopus merge, whose parents the commit-graph
+// file lists in its extra edges chunk, and a commit dated long before
+// its parent, whose generation offset goes in the generation data
+// overflow chunk.  The dates of some commits do not fit in 32 bits.
+var graphHistory = []testCommit{
+	{"a", "", 1000},
+	{"b", "a", 2000},
+	{"c", "a", 3000},
+	{"d", "a", 4000},
+	{"octopus", "b c d", 5000},
+	{"future", "octopus", 5000000000},
+	{"past", "future", 1000000000},
+	{"merge", "past b", 1000000060},
+	{"clamped", "merge", MaxTime + 1},
+}
+
+func TestNew(t *testing.T) {
+	g := testGraph(t, object.SHA1, graphHistory)
+	want := map[string]struct {
+		level uint32
+		gen   int64
+	}{
+		"a":       {1, 1000},
+		"b":       {2, 2000},
+		"c":       {2, 3000},
+		"d":       {2, 4000},
+		"octopus": {3, 5000},
+		"future":  {4, 5000000000},
+		"past":    {5, 5000000001},
+		"merge":   {6, 5000000002},
+		"clamped": {7, MaxTime},
+	}
+	for name, w := range want {
+		id, _ := object.SHA1.Sum([]byte(name))
+		c, ok := g.Find(id)
+		if !ok {
+			t.Errorf("%s not found", name)
+		} else if c.Level != w.level || c.Generation != w.gen {
+			t.Errorf("%s: level %d and generation %d, want %d and %d", name, c.Level, c.Generation, w.level, w.gen)
+		}
+	}
+
+	ab := testGraph(t, object.SHA1, graphHistory[:2]).Commits
+	if _, err := New(object.SHA1, ab[1:]); err != ErrGraph {
+		t.Errorf("New with missing parent: %v, want %v", err, ErrGraph)
+	}
+	if _, err := New(object.SHA1, append(ab[:2:2], ab[0])); err != ErrGraph {
+		t.Errorf("New with duplicate commit: %v, want %v", err, ErrGraph)
+	}
+}
+
+func TestReadWriteTo(t *testing.T) {
+	for _, f := range []object.Format{object.SHA1, object.SHA256} {
+		g := testGraph(t, f, graphHistory)
+		var buf bytes.Buffer
+		if _, err := g.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, chunk := range []string{"GDA2", "GDO2", "EDGE", "BIDX", "BDAT"} {
+			if !bytes.Contains(buf.Bytes()[:8+12*9], []byte(chunk)) {
+				t.Errorf("%s: no %s chunk", f, chunk)
+			}
+		}
+		got, err := Read(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, g) {
+			t.Errorf("%s: read\n%+v\nwrote\n%+v", f, got, g)
+		}
+
+		for i := range g.Commits {
+			g.Commits[i].Bloom = nil
+		}
+		buf.Reset()
+		if _, err := g.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(buf.Bytes()[:8+12*7], []byte("BDAT")) {
+			t.Errorf("%s: Bloom filters written for commits with none", f)
+		}
+		got, err = Read(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, g) {
+			t.Errorf("%s: read\n%+v\nwrote\n%+v", f, got, g)
+		}
+	}
+}
+
+func TestReadError(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := testGraph(t, object.SHA1, graphHistory).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte(nil), file...)
+		c[i] = b
+		return c
+	}
+	tests := []struct {
+		desc string
+		file []byte
+		err  error
+	}{
+		{"empty file", nil, ErrGraph},
+		{"bad signature", corrupt(0, 'X'), ErrGraph},
+		{"version 2", corrupt(4, 2), ErrVersion},
+		{"unknown hash", corrupt(5, 3), ErrVersion},
+		{"split graph", corrupt(7, 1), ErrVersion},
+		{"bad checksum", corrupt(len(file)-1, file[len(file)-1]^1), ErrChecksum},
+		{"truncated", file[:len(file)-1], ErrChecksum},
+	}
+	for _, tt := range tests {
+		if _, err := Read(bytes.NewReader(tt.file)); err != tt.err {
+			t.Errorf("%s: got %v, want %v", tt.desc, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+// This file implements the reading and writing of commit-graph files,
+// which consist of a header, a table of contents, a number of chunks
+// and a trailing checksum.
+
+package commitgraph
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+var signature = [4]byte{'C', 'G', 'P', 'H'}
+
+// The IDs of the chunks of a commit-graph file.
+const (
+	chunkFanout     = 0x4f494446 // "OIDF"
+	chunkIDs        = 0x4f49444c // "OIDL"
+	chunkData       = 0x43444154 // "CDAT"
+	chunkGeneration = 0x47444132 // "GDA2"
+	chunkOverflow   = 0x47444f32 // "GDO2"
+	chunkEdges      = 0x45444745 // "EDGE"
+	chunkBloomIndex = 0x42494458 // "BIDX"
+	chunkBloomData  = 0x42444154 // "BDAT"
+)
+
+// Special values of the parent positions in the commit data chunk.
+// The second parent position of a merge of more than two parents has
+// parentEdges set, and the rest of it is the index of its second
+// parent in the extra edge chunk, which lists the rest of its parents
+// too, with parentEdges set on the last.
+const (
+	parentNone  = 0x70000000
+	parentEdges = 0x80000000
+)
+
+// overflowed is set on generation offsets that are indexes into the
+// generation data overflow chunk.
+const overflowed = 0x80000000
+
+// hashVersion returns the hash version byte of the given object format.
+func hashVersion(f object.Format) byte {
+	if f == object.SHA256 {
+		return 2
+	}
+	return 1
+}
+
+// WriteTo writes the graph to w in the commit-graph file format, with
+// the corrected commit dates as generation numbers, and with Bloom
+// filters if any of the commits has one.
+func (g *Graph) WriteTo(w io.Writer) (int64, error) {
+	type chunk struct {
+		id   uint32
+		data []byte
+	}
+	var chunks []chunk
+	be := binary.BigEndian
+	pos := make(map[object.ID]uint32, len(g.Commits))
+	for i, c := range g.Commits {
+		pos[c.ID] = uint32(i)
+	}
+	position := func(id object.ID) (uint32, error) {
+		p, ok := pos[id]
+		if !ok {
+			return 0, ErrGraph
+		}
+		return p, nil
+	}
+
+	fanout := make([]byte, 256*4)
+	var ids []byte
+	for _, c := range g.Commits {
+		ids = append(ids, c.ID.Bytes()...)
+	}
+	for i, j := 0, 0; i < 256; i++ {
+		for j < len(g.Commits) && int(g.Commits[j].ID.Bytes()[0]) <= i {
+			j++
+		}
+		be.PutUint32(fanout[4*i:], uint32(j))
+	}
+	chunks = append(chunks, chunk{chunkFanout, fanout}, chunk{chunkIDs, ids})
+
+	var data, edges []byte
+	for _, c := range g.Commits {
+		data = append(data, c.Tree.Bytes()...)
+		parents := [2]uint32{parentNone, parentNone}
+		for i, id := range c.Parents {
+			p, err := position(id)
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case i < 2 && len(c.Parents) <= 2:
+				parents[i] = p
+			case i == 0:
+				parents[0] = p
+			case i == 1:
+				parents[1] = parentEdges | uint32(len(edges)/4)
+				fallthrough
+			default:
+				if i == len(c.Parents)-1 {
+					p |= parentEdges
+				}
+				edges = appendUint32(edges, p)
+			}
+		}
+		data = appendUint32(data, parents[0])
+		data = appendUint32(data, parents[1])
+		data = appendUint32(data, c.Level<<2|uint32(c.Time>>32)&3)
+		data = appendUint32(data, uint32(c.Time))
+	}
+	chunks = append(chunks, chunk{chunkData, data})
+
+	var gens, overflow []byte
+	for _, c := range g.Commits {
+		offset := uint64(c.Generation - c.Time)
+		if offset >= overflowed {
+			gens = appendUint32(gens, overflowed|uint32(len(overflow)/8))
+			overflow = appendUint64(overflow, offset)
+		} else {
+			gens = appendUint32(gens, uint32(offset))
+		}
+	}
+	chunks = append(chunks, chunk{chunkGeneration, gens})
+	if len(overflow) > 0 {
+		chunks = append(chunks, chunk{chunkOverflow, overflow})
+	}
+	if len(edges) > 0 {
+		chunks = append(chunks, chunk{chunkEdges, edges})
+	}
+
+	hasBloom := false
+	for _, c := range g.Commits {
+		hasBloom = hasBloom || c.Bloom != nil
+	}
+	if hasBloom {
+		var index []byte
+		bloom := make([]byte, 0, 12)
+		bloom = appendUint32(bloom, bloomHashVersion)
+		bloom = appendUint32(bloom, bloomHashes)
+		bloom = appendUint32(bloom, bloomBitsPerPath)
+		for _, c := range g.Commits {
+			bloom = append(bloom, c.Bloom...)
+			index = appendUint32(index, uint32(len(bloom)-12))
+		}
+		chunks = append(chunks, chunk{chunkBloomIndex, index}, chunk{chunkBloomData, bloom})
+	}
+
+	buf := bytes.NewBuffer(signature[:len(signature):len(signature)])
+	buf.Write([]byte{1, hashVersion(g.Format), byte(len(chunks)), 0})
+	offset := uint64(8 + 12*(len(chunks)+1))
+	for _, c := range chunks {
+		binary.Write(buf, be, c.id)
+		binary.Write(buf, be, offset)
+		offset += uint64(len(c.data))
+	}
+	binary.Write(buf, be, uint32(0))
+	binary.Write(buf, be, offset)
+	for _, c := range chunks {
+		buf.Write(c.data)
+	}
+	sum, err := g.Format.Sum(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	buf.Write(sum.Bytes())
+	return buf.WriteTo(w)
+}
+
+// appendUint32 and appendUint64 append big-endian integers to b.
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+// Read reads a commit-graph file from r.  It returns ErrGraph if the
+// file is malformed and ErrChecksum if its checksum is not valid.  The
+// generations of the commits are computed if the file has none.  Bloom
+// filters of other versions than the one NewBloomFilter creates are
+// discarded.
+func Read(r io.Reader) (*Graph, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	be := binary.BigEndian
+	if len(b) < 8 || !bytes.Equal(b[:4], signature[:]) {
+		return nil, ErrGraph
+	}
+	var f object.Format
+	switch {
+	case b[4] != 1 || b[7] != 0:
+		return nil, ErrVersion
+	case b[5] == 1:
+		f = object.SHA1
+	case b[5] == 2:
+		f = object.SHA256
+	default:
+		return nil, ErrVersion
+	}
+	size := f.Size()
+	if len(b) < 8+size {
+		return nil, ErrGraph
+	}
+	body := b[:len(b)-size]
+	sum, err := f.Sum(body)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum.Bytes(), b[len(body):]) {
+		return nil, ErrChecksum
+	}
+
+	// the chunks, ending at the offset of the next entry of the
+	// table of contents
+	nchunks := int(b[6])
+	if len(body) < 8+12*(nchunks+1) {
+		return nil, ErrGraph
+	}
+	chunks := make(map[uint32][]byte)
+	for i := 0; i < nchunks; i++ {
+		entry := body[8+12*i:]
+		id := be.Uint32(entry)
+		start, end := be.Uint64(entry[4:]), be.Uint64(entry[16:])
+		if start > end || end > uint64(len(body)) {
+			return nil, ErrGraph
+		}
+		chunks[id] = body[start:end]
+	}
+
+	fanout, ids, data := chunks[chunkFanout], chunks[chunkIDs], chunks[chunkData]
+	if len(fanout) != 256*4 {
+		return nil, ErrGraph
+	}
+	n := int(be.Uint32(fanout[255*4:]))
+	if len(ids) != n*size || len(data) != n*(size+16) {
+		return nil, ErrGraph
+	}
+	g := &Graph{Format: f, Commits: make([]Commit, n)}
+	for i := range g.Commits {
+		c := &g.Commits[i]
+		c.ID, _ = object.NewID(f, ids[i*size:(i+1)*size])
+		if i > 0 && bytes.Compare(g.Commits[i-1].ID.Bytes(), c.ID.Bytes()) >= 0 {
+			return nil, ErrGraph
+		}
+	}
+	for i, j := 0, 0; i < 256; i++ {
+		for j < n && int(g.Commits[j].ID.Bytes()[0]) <= i {
+			j++
+		}
+		if be.Uint32(fanout[4*i:]) != uint32(j) {
+			return nil, ErrGraph
+		}
+	}
+
+	edges := chunks[chunkEdges]
+	parent := func(p uint32) (object.ID, error) {
+		if int(p) >= n {
+			return f.ZeroID(), ErrGraph
+		}
+		return g.Commits[p].ID, nil
+	}
+	for i := range g.Commits {
+		c := &g.Commits[i]
+		d := data[i*(size+16):]
+		c.Tree, _ = object.NewID(f, d[:size])
+		p1, p2 := be.Uint32(d[size:]), be.Uint32(d[size+4:])
+		if p1 != parentNone {
+			id, err := parent(p1)
+			if err != nil {
+				return nil, err
+			}
+			c.Parents = append(c.Parents, id)
+		}
+		switch {
+		case p2 == parentNone:
+		case p2&parentEdges == 0:
+			id, err := parent(p2)
+			if err != nil {
+				return nil, err
+			}
+			c.Parents = append(c.Parents, id)
+		default:
+			for j := int(p2 &^ parentEdges); ; j++ {
+				if 4*j+4 > len(edges) {
+					return nil, ErrGraph
+				}
+				p := be.Uint32(edges[4*j:])
+				id, err := parent(p &^ parentEdges)
+				if err != nil {
+					return nil, err
+				}
+				c.Parents = append(c.Parents, id)
+				if p&parentEdges != 0 {
+					break
+				}
+			}
+		}
+		hi, lo := be.Uint32(d[size+8:]), be.Uint32(d[size+12:])
+		c.Level = hi >> 2
+		c.Time = int64(hi&3)<<32 | int64(lo)
+	}
+
+	if gens := chunks[chunkGeneration]; gens == nil {
+		if err := g.computeGenerations(false); err != nil {
+			return nil, err
+		}
+	} else if len(gens) != 4*n {
+		return nil, ErrGraph
+	} else {
+		overflow := chunks[chunkOverflow]
+		for i := range g.Commits {
+			c := &g.Commits[i]
+			offset := uint64(be.Uint32(gens[4*i:]))
+			if offset&overflowed != 0 {
+				j := int(offset &^ overflowed)
+				if 8*j+8 > len(overflow) {
+					return nil, ErrGraph
+				}
+				offset = be.Uint64(overflow[8*j:])
+			}
+			c.Generation = c.Time + int64(offset)
+		}
+	}
+
+	index, bloom := chunks[chunkBloomIndex], chunks[chunkBloomData]
+	if index != nil && len(bloom) >= 12 &&
+		be.Uint32(bloom) == bloomHashVersion &&
+		be.Uint32(bloom[4:]) == bloomHashes &&
+		be.Uint32(bloom[8:]) == bloomBitsPerPath {
+		if len(index) != 4*n {
+			return nil, ErrGraph
+		}
+		bloom = bloom[12:]
+		start := uint32(0)
+		for i := range g.Commits {
+			end := be.Uint32(index[4*i:])
+			if end < start || end > uint32(len(bloom)) {
+				return nil, ErrGraph
+			}
+			if end > start {
+				g.Commits[i].Bloom = BloomFilter(bloom[start:end:end])
+			}
+			start = end
+		}
+	}
+	return g, nil
+}
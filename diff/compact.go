@@ -0,0 +1,296 @@
+package diff
+
+// The weights of the indent heuristic, which picks where to place a
+// group of changed lines that can be slid up or down by how the lines
+// around its ends are indented.  They are those of the reference Git
+// client, which were tuned on a corpus of hand-picked diffs.
+const (
+	maxIndent  = 200
+	maxBlanks  = 20
+	maxSliding = 100
+
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+	indentWeight                    = 60
+)
+
+// A group is a run of changed lines [start, end) of one sequence, which
+// is empty if there are none between the surrounding unchanged lines.
+type group struct {
+	start, end int
+}
+
+// A side is one of the sequences of a diff and its changed flags, which
+// are offset by one like those of a differ.  The groups of the two
+// sides are walked in step, as each unchanged line of one side matches
+// one of the other.
+type side struct {
+	lines   []string
+	changed []bool
+}
+
+func (s side) chg(i int) bool { return s.changed[i+1] }
+
+func (s side) first() group {
+	g := group{0, 0}
+	for s.chg(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (s side) next(g *group) bool {
+	if g.end == len(s.changed)-2 {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; s.chg(g.end); g.end++ {
+	}
+	return true
+}
+
+func (s side) previous(g *group) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; s.chg(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (s side) slideDown(g *group) bool {
+	if g.end < len(s.lines) && s.lines[g.start] == s.lines[g.end] {
+		s.changed[g.start+1] = false
+		s.changed[g.end+1] = true
+		g.start++
+		g.end++
+		for s.chg(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (s side) slideUp(g *group) bool {
+	if g.start > 0 && s.lines[g.start-1] == s.lines[g.end-1] {
+		g.start--
+		g.end--
+		s.changed[g.start+1] = true
+		s.changed[g.end+1] = false
+		for s.chg(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// compact slides the groups of changed lines of the lines a, flagged in
+// ca, to where they read best, keeping the changed flags of the other
+// sequence, cb, in step.  Groups that can be slid into one are merged,
+// groups that can be aligned with a change in the other sequence are,
+// and the rest are placed by the indent heuristic if indentHeuristic is
+// set, or left as far down as they go if not.
+func compact(a []string, ca, cb []bool, indentHeuristic bool) {
+	s := side{a, ca}
+	o := side{changed: cb} // only the flags of the other side are needed
+	g, og := s.first(), o.first()
+	for {
+		if g.end != g.start {
+			var size, earliestEnd, endMatchingOther int
+			for {
+				size = g.end - g.start
+				endMatchingOther = -1
+				for s.slideUp(&g) {
+					o.previous(&og)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+				for s.slideDown(&g) {
+					o.next(&og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+				if size == g.end-g.start {
+					break
+				}
+			}
+			switch {
+			case g.end == earliestEnd:
+				// the group cannot be slid
+			case endMatchingOther != -1:
+				for og.end == og.start {
+					s.slideUp(&g)
+					o.previous(&og)
+				}
+			case indentHeuristic:
+				shift := earliestEnd
+				if g.end-size-1 > shift {
+					shift = g.end - size - 1
+				}
+				if g.end-maxSliding > shift {
+					shift = g.end - maxSliding
+				}
+				bestShift := -1
+				var best splitScore
+				for ; shift <= g.end; shift++ {
+					var score splitScore
+					score.add(s.measure(shift))
+					score.add(s.measure(shift - size))
+					if bestShift == -1 || score.cmp(best) <= 0 {
+						best = score
+						bestShift = shift
+					}
+				}
+				for g.end > bestShift {
+					s.slideUp(&g)
+					o.previous(&og)
+				}
+			}
+		}
+		if !s.next(&g) {
+			break
+		}
+		o.next(&og)
+	}
+}
+
+// indent returns the width of the leading whitespace of line, with tabs
+// expanded to multiples of eight, or -1 if the line is blank.
+func indent(line string) int {
+	n := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			n++
+		case '\t':
+			n += 8 - n%8
+		case '\n', '\v', '\f', '\r':
+		default:
+			return n
+		}
+		if n >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+// A splitMeasurement describes the lines around a split between lines:
+// the indent of the line after it, and the numbers of blank lines and
+// the indents of the first nonblank lines before and after that.
+type splitMeasurement struct {
+	endOfFile  bool
+	indent     int
+	preBlank   int
+	preIndent  int
+	postBlank  int
+	postIndent int
+}
+
+// measure measures the split before line i.
+func (s side) measure(i int) splitMeasurement {
+	var m splitMeasurement
+	if i >= len(s.lines) {
+		m.endOfFile = true
+		m.indent = -1
+	} else {
+		m.indent = indent(s.lines[i])
+	}
+	m.preIndent = -1
+	for j := i - 1; j >= 0; j-- {
+		if m.preIndent = indent(s.lines[j]); m.preIndent != -1 {
+			break
+		}
+		if m.preBlank++; m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+	m.postIndent = -1
+	for j := i + 1; j < len(s.lines); j++ {
+		if m.postIndent = indent(s.lines[j]); m.postIndent != -1 {
+			break
+		}
+		if m.postBlank++; m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+	return m
+}
+
+// A splitScore is the badness of placing a group between two splits.
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+func (s *splitScore) add(m splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+	s.penalty += totalBlankWeight * totalBlank
+	s.penalty += postBlankWeight * postBlank
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+	s.effectiveIndent += indent
+	switch {
+	case indent == -1, m.preIndent == -1, indent == m.preIndent:
+	case indent > m.preIndent:
+		if anyBlanks {
+			s.penalty += relativeIndentWithBlankPenalty
+		} else {
+			s.penalty += relativeIndentPenalty
+		}
+	case m.postIndent != -1 && m.postIndent > indent:
+		if anyBlanks {
+			s.penalty += relativeOutdentWithBlankPenalty
+		} else {
+			s.penalty += relativeOutdentPenalty
+		}
+	default:
+		if anyBlanks {
+			s.penalty += relativeDedentWithBlankPenalty
+		} else {
+			s.penalty += relativeDedentPenalty
+		}
+	}
+}
+
+// cmp returns a negative number if s is better than t, a positive one
+// if it is worse, and zero if they are equally good.
+func (s splitScore) cmp(t splitScore) int {
+	c := 0
+	switch {
+	case s.effectiveIndent > t.effectiveIndent:
+		c = 1
+	case s.effectiveIndent < t.effectiveIndent:
+		c = -1
+	}
+	return indentWeight*c + s.penalty - t.penalty
+}
@@ -0,0 +1,195 @@
+// Package diff implements line-based diffs of texts in the manner of
+// the reference Git client, their output as unified diffs, and
+// three-way merges of texts.
+//
+// The differences between two texts are computed with one of several
+// algorithms, and the changed lines are then slid up or down where
+// that is possible without altering the result, to places where they
+// read best.
+package diff
+
+import "bytes"
+
+// An Algorithm is an algorithm for computing the differences between
+// two sequences of lines.
+type Algorithm int
+
+// The algorithms the reference Git client supports.
+const (
+	// Myers finds the smallest set of differences, as described by
+	// Eugene W. Myers in "An O(ND) Difference Algorithm and Its
+	// Variations".
+	Myers Algorithm = iota
+
+	// Patience aligns the lines that occur exactly once in both
+	// sequences first, which often keeps unrelated lines that
+	// happen to be equal, such as braces, from being matched.
+	Patience
+
+	// Histogram is like Patience, but also aligns lines that occur
+	// a few times, preferring the least frequent.
+	Histogram
+)
+
+func (alg Algorithm) String() string {
+	switch alg {
+	case Myers:
+		return "myers"
+	case Patience:
+		return "patience"
+	case Histogram:
+		return "histogram"
+	default:
+		return "unknown"
+	}
+}
+
+// An Edit is a run of lines that differ between two sequences of lines
+// a and b: the lines a[OldStart:OldEnd] are replaced with the lines
+// b[NewStart:NewEnd].  Either run may be empty, but not both.
+type Edit struct {
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+}
+
+// SplitLines splits text into lines after each newline.  The last line
+// has no newline if the text does not end in one.
+func SplitLines(text []byte) []string {
+	var lines []string
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		lines = append(lines, string(text[:i]))
+		text = text[i:]
+	}
+	return lines
+}
+
+// Diff returns the differences between the sequences of lines a and b,
+// computed with the given algorithm, in ascending order.  Lines are
+// equal only if their bytes are, line terminators included.
+func Diff(a, b []string, alg Algorithm) []Edit {
+	return diffLines(a, b, alg, true)
+}
+
+// diffLines is Diff, but the groups of changed lines that can be slid
+// are placed by the indent heuristic only if indentHeuristic is set,
+// and slid as far down as they go otherwise.
+func diffLines(a, b []string, alg Algorithm, indentHeuristic bool) []Edit {
+	// number the distinct lines, so that they can be compared as
+	// integers
+	classes := make(map[string]int)
+	d := &differ{
+		a:  make([]int, len(a)),
+		b:  make([]int, len(b)),
+		ca: make([]bool, len(a)+2),
+		cb: make([]bool, len(b)+2),
+	}
+	for i, line := range a {
+		c, ok := classes[line]
+		if !ok {
+			c = len(classes)
+			classes[line] = c
+		}
+		d.a[i] = c
+	}
+	for i, line := range b {
+		c, ok := classes[line]
+		if !ok {
+			c = len(classes)
+			classes[line] = c
+		}
+		d.b[i] = c
+	}
+	switch alg {
+	case Patience:
+		d.patience(0, len(a), 0, len(b))
+	case Histogram:
+		d.histogram(0, len(a), 0, len(b))
+	default:
+		d.myers(0, len(a), 0, len(b))
+	}
+	compact(a, d.ca, d.cb, indentHeuristic)
+	compact(b, d.cb, d.ca, indentHeuristic)
+	return d.edits()
+}
+
+// A differ holds the state of a diff: the line classes of the sequences
+// and the lines found changed.  The changed flags of line i are at
+// index i+1, and the flags before the first and after the last line are
+// always unset.
+type differ struct {
+	a, b   []int
+	ca, cb []bool
+}
+
+// changeA and changeB mark the lines in the given ranges changed.
+func (d *differ) changeA(start, end int) {
+	for i := start; i < end; i++ {
+		d.ca[i+1] = true
+	}
+}
+
+func (d *differ) changeB(start, end int) {
+	for i := start; i < end; i++ {
+		d.cb[i+1] = true
+	}
+}
+
+// trim returns the given ranges of a and b with their common prefix and
+// suffix removed.
+func (d *differ) trim(a0, a1, b0, b1 int) (int, int, int, int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		a0++
+		b0++
+	}
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+	return a0, a1, b0, b1
+}
+
+// edits returns the runs of changed lines as Edits.
+func (d *differ) edits() []Edit {
+	var edits []Edit
+	i, j := 0, 0
+	for i < len(d.a) || j < len(d.b) {
+		if !d.ca[i+1] && !d.cb[j+1] {
+			i++
+			j++
+			continue
+		}
+		e := Edit{OldStart: i, NewStart: j}
+		for d.ca[i+1] {
+			i++
+		}
+		for d.cb[j+1] {
+			j++
+		}
+		e.OldEnd, e.NewEnd = i, j
+		edits = append(edits, e)
+	}
+	return edits
+}
+
+// Stat returns the numbers of lines the edits add and delete.
+func Stat(edits []Edit) (added, deleted int) {
+	for _, e := range edits {
+		added += e.NewEnd - e.NewStart
+		deleted += e.OldEnd - e.OldStart
+	}
+	return added, deleted
+}
+
+// IsBinary reports whether data looks like binary rather than text,
+// which the reference Git client decides by whether there is a NUL byte
+// among the first 8000 bytes.
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// The golden files hold the hunks of git diff --no-index between the
+// files name.a and name.b of testdata, with the given algorithm and
+// number of context lines.
+var unifiedTests = []struct {
+	name    string
+	alg     Algorithm
+	context int
+	golden  string
+}{
+	{"braces", Myers, 3, "braces.myers.diff"},
+	{"braces", Patience, 3, "braces.patience.diff"},
+	{"braces", Histogram, 3, "braces.patience.diff"},
+	{"repeats", Myers, 3, "repeats.myers.diff"},
+	{"repeats", Patience, 3, "repeats.patience.diff"},
+	{"repeats", Histogram, 3, "repeats.histogram.diff"},
+	{"insert", Myers, 3, "insert.myers.diff"},
+	{"slider", Myers, 3, "slider.myers.diff"},
+	{"slider", Patience, 3, "slider.myers.diff"},
+	{"slider", Histogram, 3, "slider.myers.diff"},
+	{"noeol", Myers, 3, "noeol.myers.diff"},
+	{"new", Myers, 3, "new.myers.diff"},
+	{"mergebase", Myers, 3, "mergebase.myers.diff"},
+	{"mergebase", Patience, 3, "mergebase.myers.diff"},
+	{"mergebase", Histogram, 3, "mergebase.myers.diff"},
+	{"mergebase", Myers, 1, "mergebase.u1.diff"},
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWriteUnified(t *testing.T) {
+	for _, tt := range unifiedTests {
+		a := SplitLines(readTestdata(t, tt.name+".a"))
+		b := SplitLines(readTestdata(t, tt.name+".b"))
+		want := readTestdata(t, tt.golden)
+		var buf bytes.Buffer
+		if err := WriteUnified(&buf, a, b, Hunks(a, b, Diff(a, b, tt.alg), tt.context)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s, %s, %d lines of context: got\n%s\nwant\n%s", tt.name, tt.alg, tt.context, buf.Bytes(), want)
+		}
+	}
+}
+
+// randomLines returns n lines drawn from a small alphabet, so that
+// lines repeat.
+func randomLines(rnd *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = strconv.Itoa(rnd.Intn(8)) + "\n"
+	}
+	return lines
+}
+
+// TestDiffEdits checks that the edits of each algorithm turn a into b.
+func TestDiffEdits(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < 300; n++ {
+		a := randomLines(rnd, rnd.Intn(40))
+		b := append([]string(nil), a...)
+		for k := rnd.Intn(6); k > 0; k-- {
+			i := rnd.Intn(len(b) + 1)
+			j := i + rnd.Intn(len(b)-i+1)
+			b = append(b[:i], append(randomLines(rnd, rnd.Intn(4)), b[j:]...)...)
+		}
+		for _, alg := range []Algorithm{Myers, Patience, Histogram} {
+			edits := Diff(a, b, alg)
+			var got []string
+			i := 0
+			for k, e := range edits {
+				if e.OldStart == e.OldEnd && e.NewStart == e.NewEnd ||
+					e.OldStart-i != e.NewStart-len(got) ||
+					k > 0 && e.OldStart <= edits[k-1].OldEnd {
+					t.Fatalf("%s: bad edits %v of %q to %q", alg, edits, a, b)
+				}
+				got = append(got, a[i:e.OldStart]...)
+				got = append(got, b[e.NewStart:e.NewEnd]...)
+				i = e.OldEnd
+			}
+			got = append(got, a[i:]...)
+			if !equalLines(got, b) {
+				t.Fatalf("%s: edits %v turn %q into %q, not %q", alg, edits, a, got, b)
+			}
+			added, deleted := Stat(edits)
+			if len(a)-deleted+added != len(b) {
+				t.Errorf("%s: Stat(%v) = %d, %d for %d lines to %d", alg, edits, added, deleted, len(a), len(b))
+			}
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	text := bytes.Repeat([]byte("text\n"), 2000)
+	if IsBinary(text) {
+		t.Error("text reported as binary")
+	}
+	if !IsBinary(append([]byte("a\x00"), text...)) {
+		t.Error("NUL byte not noticed")
+	}
+	if IsBinary(append(text[:8000:8000], 0)) {
+		t.Error("NUL byte after the first 8000 bytes noticed")
+	}
+}
@@ -0,0 +1,130 @@
+package diff
+
+// maxChain is the number of times a line may occur in a range of a for
+// histogram to consider aligning it.
+const maxChain = 64
+
+// histogram marks the lines that differ between the given ranges of a
+// and b.  The longest run of common lines whose least frequent line
+// occurs least often in a is aligned, and the lines before and after it
+// diffed recursively.  Ranges whose common lines are all too frequent
+// are diffed with myers.
+func (d *differ) histogram(a0, a1, b0, b1 int) {
+	for {
+		switch {
+		case a0 == a1:
+			d.changeB(b0, b1)
+			return
+		case b0 == b1:
+			d.changeA(a0, a1)
+			return
+		}
+		h := newHistogram(d, a0, a1, b0, b1)
+		for j := b0; j < b1; {
+			j = h.try(j)
+		}
+		switch {
+		case h.common && h.best > maxChain:
+			d.myers(a0, a1, b0, b1)
+			return
+		case !h.found:
+			d.changeA(a0, a1)
+			d.changeB(b0, b1)
+			return
+		}
+		d.histogram(a0, h.as, b0, h.bs)
+		a0, b0 = h.ae, h.be
+	}
+}
+
+// A histogramIndex records the occurrences of the lines of a range of
+// a, and the best run of common lines found so far.
+type histogramIndex struct {
+	d              *differ
+	a0, a1, b0, b1 int
+
+	first map[int]int // first occurrence of each line class
+	count map[int]int // number of occurrences of each line class
+	next  []int       // next occurrence of the line at a0+i, or -1
+
+	common         bool // whether any common line was seen
+	found          bool // whether a run was found
+	as, ae, bs, be int  // the best run
+	best           int  // occurrences of its least frequent line
+}
+
+func newHistogram(d *differ, a0, a1, b0, b1 int) *histogramIndex {
+	h := &histogramIndex{
+		d:     d,
+		a0:    a0,
+		a1:    a1,
+		b0:    b0,
+		b1:    b1,
+		first: make(map[int]int),
+		count: make(map[int]int),
+		next:  make([]int, a1-a0),
+		best:  maxChain + 1,
+	}
+	for i := a1 - 1; i >= a0; i-- {
+		c := d.a[i]
+		if j, ok := h.first[c]; ok {
+			h.next[i-a0] = j
+		} else {
+			h.next[i-a0] = -1
+		}
+		h.first[c] = i
+		h.count[c]++
+	}
+	return h
+}
+
+// try extends each occurrence in a of line j of b to the longest run of
+// common lines around it, and keeps the run if it is longer than or
+// rarer than the best so far.  It returns the line of b from which to
+// continue.
+func (h *histogramIndex) try(j int) int {
+	d := h.d
+	next := j + 1
+	c := d.b[j]
+	i, ok := h.first[c]
+	if !ok {
+		return next
+	}
+	h.common = true
+	if h.count[c] > h.best {
+		return next
+	}
+	for {
+		as, bs := i, j
+		ae, be := i+1, j+1
+		rc := h.count[c]
+		for as > h.a0 && bs > h.b0 && d.a[as-1] == d.b[bs-1] {
+			as--
+			bs--
+			if rc > 1 && h.count[d.a[as]] < rc {
+				rc = h.count[d.a[as]]
+			}
+		}
+		for ae < h.a1 && be < h.b1 && d.a[ae] == d.b[be] {
+			if rc > 1 && h.count[d.a[ae]] < rc {
+				rc = h.count[d.a[ae]]
+			}
+			ae++
+			be++
+		}
+		if next < be {
+			next = be
+		}
+		if !h.found || h.ae-h.as < ae-as || rc < h.best {
+			h.found = true
+			h.as, h.ae, h.bs, h.be = as, ae, bs, be
+			h.best = rc
+		}
+		// skip the occurrences inside the run
+		for i = h.next[i-h.a0]; i >= 0 && i < ae; i = h.next[i-h.a0] {
+		}
+		if i < 0 {
+			return next
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+)
+
+// A ConflictStyle is a way of showing the conflicts of a merge.
+type ConflictStyle int
+
+// The conflict styles the reference Git client supports.
+const (
+	// StyleMerge shows the lines of each side of a conflict
+	// between markers:
+	//
+	//	<<<<<<< ours
+	//	lines of ours
+	//	=======
+	//	lines of theirs
+	//	>>>>>>> theirs
+	//
+	// Lines that both sides changed the same way are left out of
+	// the conflict.
+	StyleMerge ConflictStyle = iota
+
+	// StyleDiff3 also shows the lines of the merge base, after a
+	// ||||||| marker following the lines of ours.  Conflicts are
+	// not narrowed down to the lines the sides changed differently,
+	// as the lines of the merge base would no longer correspond.
+	StyleDiff3
+)
+
+// DefaultMarkerSize is the length of conflict markers.
+const DefaultMarkerSize = 7
+
+// MergeOptions control Merge.  A nil *MergeOptions uses the defaults.
+type MergeOptions struct {
+	// Algorithm is the algorithm with which the sides are compared
+	// with the merge base.
+	Algorithm Algorithm
+
+	// Style is the way conflicts are shown.
+	Style ConflictStyle
+
+	// OursLabel, TheirsLabel and BaseLabel follow the conflict
+	// markers of the respective sides, if not empty.
+	OursLabel   string
+	TheirsLabel string
+	BaseLabel   string
+
+	// MarkerSize is the length of the conflict markers.  If zero,
+	// it is DefaultMarkerSize.
+	MarkerSize int
+}
+
+// Merge merges the changes from the lines base to the lines ours and
+// theirs, as the reference Git client merges files, and returns the
+// merged text and the number of conflicts in it.  Changes that overlap
+// or touch conflict unless they are identical, and are shown between
+// conflict markers in the merged text.
+func Merge(base, ours, theirs []string, opts *MergeOptions) ([]byte, int) {
+	if opts == nil {
+		opts = new(MergeOptions)
+	}
+	// the sides are compared without the indent heuristic, so that
+	// changes slide as they do in the reference Git client
+	e1 := diffLines(base, ours, opts.Algorithm, false)
+	e2 := diffLines(base, theirs, opts.Algorithm, false)
+	switch {
+	case len(e1) == 0:
+		return []byte(strings.Join(theirs, "")), 0
+	case len(e2) == 0:
+		return []byte(strings.Join(ours, "")), 0
+	}
+	m := &merger{base: base, ours: ours, theirs: theirs, opts: opts}
+	m.combine(e1, e2)
+	if opts.Style != StyleDiff3 {
+		m.refine()
+		m.simplify()
+	}
+	return m.output()
+}
+
+// The ways a hunk of a merge is resolved.
+const (
+	hunkConflict  = 0
+	hunkOurs      = 1
+	hunkTheirs    = 2
+	hunkIdentical = 4 // a conflict whose sides turned out identical
+)
+
+// A mergeHunk is a run of lines of the merge base, [i0, i0+chg0), that
+// either side or both changed, and the corresponding runs of lines of
+// ours, [i1, i1+chg1), and theirs, [i2, i2+chg2).
+type mergeHunk struct {
+	mode     int
+	i0, chg0 int
+	i1, chg1 int
+	i2, chg2 int
+}
+
+// A merger holds the state of a merge.
+type merger struct {
+	base, ours, theirs []string
+	opts               *MergeOptions
+	hunks              []mergeHunk
+}
+
+// add appends a hunk to the merge, or extends the last hunk with it if
+// they overlap on either side.
+func (m *merger) add(mode, i0, chg0, i1, chg1, i2, chg2 int) {
+	if n := len(m.hunks); n > 0 {
+		h := &m.hunks[n-1]
+		if i1 <= h.i1+h.chg1 || i2 <= h.i2+h.chg2 {
+			if mode != h.mode {
+				h.mode = hunkConflict
+			}
+			h.chg0 = i0 + chg0 - h.i0
+			h.chg1 = i1 + chg1 - h.i1
+			h.chg2 = i2 + chg2 - h.i2
+			return
+		}
+	}
+	m.hunks = append(m.hunks, mergeHunk{mode, i0, chg0, i1, chg1, i2, chg2})
+}
+
+// combine merges the edits from the base to ours, e1, with the edits
+// from the base to theirs, e2, into hunks.
+func (m *merger) combine(e1, e2 []Edit) {
+	for len(e1) > 0 && len(e2) > 0 {
+		x, y := e1[0], e2[0]
+		switch {
+		case x.OldEnd < y.OldStart:
+			m.add(hunkOurs, x.OldStart, x.OldEnd-x.OldStart,
+				x.NewStart, x.NewEnd-x.NewStart,
+				y.NewStart-y.OldStart+x.OldStart, x.OldEnd-x.OldStart)
+			e1 = e1[1:]
+			continue
+		case y.OldEnd < x.OldStart:
+			m.add(hunkTheirs, y.OldStart, y.OldEnd-y.OldStart,
+				x.NewStart-x.OldStart+y.OldStart, y.OldEnd-y.OldStart,
+				y.NewStart, y.NewEnd-y.NewStart)
+			e2 = e2[1:]
+			continue
+		}
+		if x.OldStart != y.OldStart || x.OldEnd != y.OldEnd ||
+			!equalLines(m.ours[x.NewStart:x.NewEnd], m.theirs[y.NewStart:y.NewEnd]) {
+			// the conflict spans both edits
+			i0, i1, i2 := x.OldStart, x.NewStart, y.NewStart
+			if off := x.OldStart - y.OldStart; off > 0 {
+				i0 -= off
+				i1 -= off
+			} else {
+				i2 += off
+			}
+			chg0 := x.OldEnd - i0
+			chg1 := x.NewEnd - i1
+			chg2 := y.NewEnd - i2
+			if ffo := x.OldEnd - y.OldEnd; ffo < 0 {
+				chg0 -= ffo
+				chg1 -= ffo
+			} else {
+				chg2 += ffo
+			}
+			m.add(hunkConflict, i0, chg0, i1, chg1, i2, chg2)
+		}
+		if x.OldEnd >= y.OldEnd {
+			e2 = e2[1:]
+		}
+		if y.OldEnd >= x.OldEnd {
+			e1 = e1[1:]
+		}
+	}
+	for _, x := range e1 {
+		m.add(hunkOurs, x.OldStart, x.OldEnd-x.OldStart,
+			x.NewStart, x.NewEnd-x.NewStart,
+			x.OldStart+len(m.theirs)-len(m.base), x.OldEnd-x.OldStart)
+	}
+	for _, y := range e2 {
+		m.add(hunkTheirs, y.OldStart, y.OldEnd-y.OldStart,
+			y.OldStart+len(m.ours)-len(m.base), y.OldEnd-y.OldStart,
+			y.NewStart, y.NewEnd-y.NewStart)
+	}
+}
+
+// equalLines reports whether the sequences of lines are equal.
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// refine narrows each conflict down to the runs of lines in which the
+// sides differ, splitting it where they agree.
+func (m *merger) refine() {
+	var hunks []mergeHunk
+	for _, h := range m.hunks {
+		if h.mode != hunkConflict || h.chg1 == 0 || h.chg2 == 0 {
+			hunks = append(hunks, h)
+			continue
+		}
+		edits := diffLines(m.ours[h.i1:h.i1+h.chg1], m.theirs[h.i2:h.i2+h.chg2], m.opts.Algorithm, false)
+		if len(edits) == 0 {
+			h.mode = hunkIdentical
+			hunks = append(hunks, h)
+			continue
+		}
+		for i, e := range edits {
+			r := mergeHunk{mode: hunkConflict}
+			if i == 0 {
+				r.i0, r.chg0 = h.i0, h.chg0
+			}
+			r.i1, r.chg1 = h.i1+e.OldStart, e.OldEnd-e.OldStart
+			r.i2, r.chg2 = h.i2+e.NewStart, e.NewEnd-e.NewStart
+			hunks = append(hunks, r)
+		}
+	}
+	m.hunks = hunks
+}
+
+// simplify merges conflicts separated by at most three unchanged lines,
+// as that takes up no more lines than showing them separately and
+// reads more easily.
+func (m *merger) simplify() {
+	if len(m.hunks) == 0 {
+		return
+	}
+	hunks := m.hunks[:1]
+	for _, next := range m.hunks[1:] {
+		h := &hunks[len(hunks)-1]
+		if h.mode != hunkConflict || next.mode != hunkConflict || next.i1-(h.i1+h.chg1) > 3 {
+			hunks = append(hunks, next)
+			continue
+		}
+		h.chg1 = next.i1 + next.chg1 - h.i1
+		h.chg2 = next.i2 + next.chg2 - h.i2
+	}
+	m.hunks = hunks
+}
+
+// output returns the merged text and the number of conflicts in it.
+func (m *merger) output() ([]byte, int) {
+	var buf bytes.Buffer
+	conflicts := 0
+	i := 0
+	for _, h := range m.hunks {
+		switch h.mode {
+		case hunkConflict:
+			conflicts++
+			m.writeConflict(&buf, i, h)
+		case hunkOurs:
+			copyLines(&buf, m.ours[i:h.i1+h.chg1], false, false)
+		case hunkTheirs:
+			copyLines(&buf, m.ours[i:h.i1], false, false)
+			copyLines(&buf, m.theirs[h.i2:h.i2+h.chg2], false, false)
+		default:
+			continue
+		}
+		i = h.i1 + h.chg1
+	}
+	copyLines(&buf, m.ours[i:], false, false)
+	return buf.Bytes(), conflicts
+}
+
+// writeConflict writes the lines of ours before the conflict, starting
+// at line i, and the conflict between markers.
+func (m *merger) writeConflict(buf *bytes.Buffer, i int, h mergeHunk) {
+	size := m.opts.MarkerSize
+	if size <= 0 {
+		size = DefaultMarkerSize
+	}
+	cr := m.needsCR(h)
+	marker := func(c byte, label string) {
+		buf.WriteString(strings.Repeat(string(c), size))
+		if label != "" {
+			buf.WriteByte(' ')
+			buf.WriteString(label)
+		}
+		if cr {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte('\n')
+	}
+	copyLines(buf, m.ours[i:h.i1], false, false)
+	marker('<', m.opts.OursLabel)
+	copyLines(buf, m.ours[h.i1:h.i1+h.chg1], cr, true)
+	if m.opts.Style == StyleDiff3 {
+		marker('|', m.opts.BaseLabel)
+		copyLines(buf, m.base[h.i0:h.i0+h.chg0], cr, true)
+	}
+	marker('=', "")
+	copyLines(buf, m.theirs[h.i2:h.i2+h.chg2], cr, true)
+	marker('>', m.opts.TheirsLabel)
+}
+
+// copyLines writes the lines, and if addNL is set, a line terminator
+// after the last one if it lacks one, which is CRLF if crlf is set.
+func copyLines(buf *bytes.Buffer, lines []string, crlf, addNL bool) {
+	for _, line := range lines {
+		buf.WriteString(line)
+	}
+	if addNL && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		if crlf {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte('\n')
+	}
+}
+
+// needsCR reports whether the lines added around the hunk should end in
+// CRLF: whether the lines before it on both sides do, and the first
+// line of the merge base does too.
+func (m *merger) needsCR(h mergeHunk) bool {
+	at := func(i int) int {
+		if i > 0 {
+			return i - 1
+		}
+		return 0
+	}
+	crlf := isCRLF(m.ours, at(h.i1))
+	if crlf != 0 {
+		crlf = isCRLF(m.theirs, at(h.i2))
+	}
+	if crlf != 0 {
+		crlf = isCRLF(m.base, 0)
+	}
+	return crlf > 0
+}
+
+// isCRLF returns 1 if line i of lines ends in CRLF, 0 if it ends in LF
+// alone, and -1 if that cannot be told.  A last line without a line
+// terminator takes after the line before it.
+func isCRLF(lines []string, i int) int {
+	crlf := func(line string) int {
+		if strings.HasSuffix(line, "\r\n") {
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case i < len(lines)-1:
+		return crlf(lines[i])
+	case len(lines) == 0:
+		return -1
+	case strings.HasSuffix(lines[i], "\n"):
+		return crlf(lines[i])
+	case i == 0:
+		return -1
+	default:
+		return crlf(lines[i-1])
+	}
+}
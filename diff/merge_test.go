@@ -0,0 +1,101 @@
+package diff
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// numbered returns the numbers from 1 to n as lines, with the given
+// lines replaced.
+func numbered(n int, replace map[int]string) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = strconv.Itoa(i+1) + "\n"
+		if s, ok := replace[i+1]; ok {
+			lines[i] = s + "\n"
+		}
+	}
+	return lines
+}
+
+// The expected results of these merges are those of git merge-file.
+var mergeTests = []struct {
+	desc               string
+	base, ours, theirs []string
+	opts               *MergeOptions
+	want               string
+	conflicts          int
+}{
+	{
+		desc:   "two conflicts",
+		base:   numbered(20, nil),
+		ours:   numbered(20, map[int]string{3: "three", 15: "fifteen"}),
+		theirs: numbered(20, map[int]string{3: "THREE", 15: "FIFTEEN"}),
+		opts:   &MergeOptions{OursLabel: "ours", TheirsLabel: "theirs", MarkerSize: 10},
+		want: "1\n2\n<<<<<<<<<< ours\nthree\n==========\nTHREE\n>>>>>>>>>> theirs\n" +
+			"4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n" +
+			"<<<<<<<<<< ours\nfifteen\n==========\nFIFTEEN\n>>>>>>>>>> theirs\n" +
+			"16\n17\n18\n19\n20\n",
+		conflicts: 2,
+	},
+	{
+		desc:   "deletion and addition",
+		base:   SplitLines([]byte("1\n2\n3\n4\n5\n")),
+		ours:   SplitLines([]byte("1\n4\n5\n")),
+		theirs: SplitLines([]byte("1\n4\n5\nsix\n")),
+		want:   "1\n4\n5\nsix\n",
+	},
+	{
+		desc:   "unchanged side",
+		base:   SplitLines([]byte("1\n2\n3\n")),
+		ours:   SplitLines([]byte("1\n2\n3\n")),
+		theirs: SplitLines([]byte("1\n2\n3")),
+		want:   "1\n2\n3",
+	},
+	{
+		desc:   "diff3",
+		base:   numbered(5, nil),
+		ours:   numbered(5, map[int]string{3: "three"}),
+		theirs: numbered(3, nil),
+		opts: &MergeOptions{
+			Style:       StyleDiff3,
+			OursLabel:   "ours",
+			TheirsLabel: "theirs",
+			BaseLabel:   "base",
+		},
+		want: "1\n2\n<<<<<<< ours\nthree\n4\n5\n||||||| base\n3\n4\n5\n" +
+			"=======\n3\n>>>>>>> theirs\n",
+		conflicts: 1,
+	},
+}
+
+func TestMerge(t *testing.T) {
+	for _, tt := range mergeTests {
+		got, conflicts := Merge(tt.base, tt.ours, tt.theirs, tt.opts)
+		if string(got) != tt.want || conflicts != tt.conflicts {
+			t.Errorf("%s: got %d conflicts in\n%s\nwant %d in\n%s", tt.desc,
+				conflicts, got, tt.conflicts, tt.want)
+		}
+	}
+}
+
+// TestMergeSymmetric checks that swapping the sides of a merge swaps
+// the sides of its conflicts.
+func TestMergeSymmetric(t *testing.T) {
+	for _, tt := range mergeTests {
+		opts := MergeOptions{OursLabel: "a", TheirsLabel: "b"}
+		ab, n := Merge(tt.base, tt.ours, tt.theirs, &opts)
+		opts.OursLabel, opts.TheirsLabel = "b", "a"
+		ba, m := Merge(tt.base, tt.theirs, tt.ours, &opts)
+		if n != m {
+			t.Errorf("%s: %d conflicts one way, %d the other", tt.desc, n, m)
+		}
+		if n == 0 && string(ab) != string(ba) {
+			t.Errorf("%s: clean merge depends on the order of the sides:\n%s\n%s", tt.desc, ab, ba)
+		}
+		if strings.Count(string(ab), "<<<<<<< a\n") != n {
+			t.Errorf("%s: merge with %d conflicts:\n%s", tt.desc, n, ab)
+		}
+	}
+}
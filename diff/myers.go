@@ -0,0 +1,356 @@
+package diff
+
+// The tuning parameters of the reference Git client's implementation of
+// the Myers algorithm, which gives up on finding the smallest diff of
+// large and very different texts in favour of finding one quickly.
+const (
+	maxCostMin    = 256  // least edit cost at which to settle for any split
+	heurMinCost   = 256  // least edit cost at which to look for long snakes
+	snakeCount    = 20   // length of a snake long enough to split at
+	kHeur         = 4    // how far ahead of the cost a snake must reach
+	maxEqLimit    = 1024 // most occurrences of a line before it is discarded
+	simscanWindow = 100  // lines around a line scanned before discarding it
+	kpdisRun      = 4    // share of discardable lines of a run to discard
+	lineMax       = int(^uint(0) >> 1)
+)
+
+// myers marks the lines that differ between the given ranges of a and
+// b, like the reference Git client does: after trimming the common
+// prefix and suffix, lines that do not occur in the other range at all,
+// or that occur in it so often that they are unlikely to be part of a
+// sensible alignment, are discarded as changed, and the rest are split
+// at the middle snake of their shortest edit script, which is found by
+// searching for it from both ends at once, and the halves diffed
+// recursively.  For large inputs, the search settles for a split that
+// is merely good.
+func (d *differ) myers(a0, a1, b0, b1 int) {
+	countA := make(map[int]int)
+	countB := make(map[int]int)
+	for i := a0; i < a1; i++ {
+		countA[d.a[i]]++
+	}
+	for j := b0; j < b1; j++ {
+		countB[d.b[j]]++
+	}
+	t0, t1, u0, u1 := d.trim(a0, a1, b0, b1)
+	m := &myersEnv{d: d}
+	m.ha, m.ia = discard(d.a, d.ca, a0, a1, t0, t1, countB)
+	m.hb, m.ib = discard(d.b, d.cb, b0, b1, u0, u1, countA)
+	n := len(m.ha) + len(m.hb) + 3
+	m.kvdf = make([]int, n)
+	m.kvdb = make([]int, n)
+	m.off = len(m.hb) + 1
+	if m.maxCost = bogoSqrt(n); m.maxCost < maxCostMin {
+		m.maxCost = maxCostMin
+	}
+	m.compare(0, len(m.ha), 0, len(m.hb), false)
+}
+
+// discard marks changed those of the lines [t0, t1) of the range
+// [r0, r1) of x that are not worth aligning with the lines of the other
+// range, whose classes occur as counted in other, and returns the
+// classes and indices of the rest.
+func discard(x []int, changed []bool, r0, r1, t0, t1 int, other map[int]int) ([]int, []int) {
+	limit := bogoSqrt(r1 - r0)
+	if limit > maxEqLimit {
+		limit = maxEqLimit
+	}
+	// 0 for lines not in the other range, 2 for lines in it too
+	// often, and 1 for the rest
+	dis := make([]byte, r1-r0)
+	for i := t0; i < t1; i++ {
+		switch n := other[x[i]]; {
+		case n == 0:
+		case n >= limit:
+			dis[i-r0] = 2
+		default:
+			dis[i-r0] = 1
+		}
+	}
+	var classes, index []int
+	for i := t0; i < t1; i++ {
+		switch dis[i-r0] {
+		case 2:
+			if discardMultimatch(dis, i-r0, t0-r0, t1-r0-1) {
+				break
+			}
+			fallthrough
+		case 1:
+			classes = append(classes, x[i])
+			index = append(index, i)
+			continue
+		}
+		changed[i+1] = true
+	}
+	return classes, index
+}
+
+// discardMultimatch reports whether the line i, which occurs too often
+// in the other range, is surrounded by enough lines that do not occur
+// in it at all within [s, e] that it should be discarded as well.
+func discardMultimatch(dis []byte, i, s, e int) bool {
+	if i-s > simscanWindow {
+		s = i - simscanWindow
+	}
+	if e-i > simscanWindow {
+		e = i + simscanWindow
+	}
+	var before, after int      // lines not in the other range
+	multiBefore, multi := 1, 1 // lines in it too often
+	for r := 1; i-r >= s; r++ {
+		if dis[i-r] == 0 {
+			before++
+		} else if dis[i-r] == 2 {
+			multiBefore++
+		} else {
+			break
+		}
+	}
+	if before == 0 {
+		return false
+	}
+	for r := 1; i+r <= e; r++ {
+		if dis[i+r] == 0 {
+			after++
+		} else if dis[i+r] == 2 {
+			multi++
+		} else {
+			break
+		}
+	}
+	if after == 0 {
+		return false
+	}
+	multi += multiBefore
+	return multi*kpdisRun < multi+before+after
+}
+
+// bogoSqrt returns a power of two roughly the square root of n.
+func bogoSqrt(n int) int {
+	i := 1
+	for ; n > 0; n >>= 2 {
+		i <<= 1
+	}
+	return i
+}
+
+// A myersEnv holds the state of a diff with the Myers algorithm: the
+// classes of the lines of a and b that were not discarded, their
+// indices, and the furthest reaching paths of the search.  The paths
+// are indexed by diagonal, offset by off.
+type myersEnv struct {
+	d          *differ
+	ha, hb     []int
+	ia, ib     []int
+	kvdf, kvdb []int
+	off        int
+	maxCost    int
+}
+
+// compare marks the lines that differ between the given ranges of the
+// undiscarded lines.  If needMin is set, the smallest diff is found
+// regardless of cost.
+func (m *myersEnv) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	for off1 < lim1 && off2 < lim2 && m.ha[off1] == m.hb[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && m.ha[lim1-1] == m.hb[lim2-1] {
+		lim1--
+		lim2--
+	}
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			m.d.cb[m.ib[off2]+1] = true
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			m.d.ca[m.ia[off1]+1] = true
+		}
+	default:
+		i1, i2, minLo, minHi := m.split(off1, lim1, off2, lim2, needMin)
+		m.compare(off1, i1, off2, i2, minLo)
+		m.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+// split returns the point at which to split the given ranges, and
+// whether the smallest diffs of the halves need to be found for the
+// diff to remain the smallest.
+func (m *myersEnv) split(off1, lim1, off2, lim2 int, needMin bool) (int, int, bool, bool) {
+	kvdf := func(d int) *int { return &m.kvdf[d+m.off] }
+	kvdb := func(d int) *int { return &m.kvdb[d+m.off] }
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+	*kvdf(fmid) = off1
+	*kvdb(bmid) = lim1
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		// extend the diagonals searched forward by one in each
+		// direction, or shrink them where they leave the box
+		if fmin > dmin {
+			fmin--
+			*kvdf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kvdf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kvdf(d - 1) >= *kvdf(d + 1) {
+				i1 = *kvdf(d - 1) + 1
+			} else {
+				i1 = *kvdf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && m.ha[i1] == m.hb[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > snakeCount {
+				gotSnake = true
+			}
+			*kvdf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kvdb(d) <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		// and the ones searched backward
+		if bmin > dmin {
+			bmin--
+			*kvdb(bmin - 1) = lineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kvdb(bmax + 1) = lineMax
+		} else {
+			bmax--
+		}
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kvdb(d - 1) < *kvdb(d + 1) {
+				i1 = *kvdb(d - 1)
+			} else {
+				i1 = *kvdb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && m.ha[i1-1] == m.hb[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > snakeCount {
+				gotSnake = true
+			}
+			*kvdb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kvdf(d) {
+				return i1, i2, true, true
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		// once the cost is high, split at a long enough snake
+		// that has gotten far enough from the corner
+		if gotSnake && ec > heurMinCost {
+			best, s1, s2 := 0, 0, 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+				if v > kHeur*ec && v > best &&
+					off1+snakeCount <= i1 && i1 < lim1 &&
+					off2+snakeCount <= i2 && i2 < lim2 {
+					for k := 1; m.ha[i1-k] == m.hb[i2-k]; k++ {
+						if k == snakeCount {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, true, false
+			}
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kvdb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+				if v > kHeur*ec && v > best &&
+					off1 < i1 && i1 <= lim1-snakeCount &&
+					off2 < i2 && i2 <= lim2-snakeCount {
+					for k := 0; m.ha[i1+k] == m.hb[i2+k]; k++ {
+						if k == snakeCount-1 {
+							best, s1, s2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return s1, s2, false, true
+			}
+		}
+
+		// once the cost is too high, split at the furthest
+		// reaching path
+		if ec >= m.maxCost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *kvdf(d)
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+			bbest, bbest1 := lineMax, lineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *kvdb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package diff
+
+import "sort"
+
+// patience marks the lines that differ between the given ranges of a
+// and b.  The lines that occur exactly once in both ranges are aligned
+// along their longest common subsequence, and the gaps between them,
+// less the equal lines at their ends, diffed recursively.  Ranges
+// without such lines are diffed with myers.
+func (d *differ) patience(a0, a1, b0, b1 int) {
+	switch {
+	case a0 == a1:
+		d.changeB(b0, b1)
+		return
+	case b0 == b1:
+		d.changeA(a0, a1)
+		return
+	}
+
+	// the positions of the lines unique to both ranges
+	type unique struct {
+		countA, countB int
+		posA, posB     int
+	}
+	lines := make(map[int]*unique)
+	for i := a0; i < a1; i++ {
+		u := lines[d.a[i]]
+		if u == nil {
+			u = &unique{posA: i}
+			lines[d.a[i]] = u
+		}
+		u.countA++
+	}
+	common := false
+	for j := b0; j < b1; j++ {
+		if u := lines[d.b[j]]; u != nil {
+			common = true
+			if u.countB++; u.countB == 1 {
+				u.posB = j
+			}
+		}
+	}
+	if !common {
+		d.changeA(a0, a1)
+		d.changeB(b0, b1)
+		return
+	}
+	var matches []match
+	for i := a0; i < a1; i++ {
+		if u := lines[d.a[i]]; u.countA == 1 && u.countB == 1 {
+			matches = append(matches, match{u.posA, u.posB})
+		}
+	}
+	if len(matches) == 0 {
+		d.myers(a0, a1, b0, b1)
+		return
+	}
+	seq := longestIncreasing(matches)
+	for k := 0; ; k++ {
+		// the gap before the next match, less the lines equal
+		// to the ones at its ends
+		next1, next2 := a1, b1
+		if k < len(seq) {
+			next1, next2 = seq[k].a, seq[k].b
+			for next1 > a0 && next2 > b0 && d.a[next1-1] == d.b[next2-1] {
+				next1--
+				next2--
+			}
+		}
+		for a0 < next1 && b0 < next2 && d.a[a0] == d.b[b0] {
+			a0++
+			b0++
+		}
+		if next1 > a0 || next2 > b0 {
+			d.patience(a0, next1, b0, next2)
+		}
+		if k == len(seq) {
+			return
+		}
+		for k+1 < len(seq) && seq[k+1].a == seq[k].a+1 && seq[k+1].b == seq[k].b+1 {
+			k++
+		}
+		a0, b0 = seq[k].a+1, seq[k].b+1
+	}
+}
+
+// A match is a pair of equal lines of a and b.
+type match struct {
+	a, b int
+}
+
+// longestIncreasing returns the longest subsequence of the matches,
+// which are in ascending order of a, that is also in ascending order
+// of b, found by patience sorting.
+func longestIncreasing(matches []match) []match {
+	// the top of each pile, and the top of the previous pile when
+	// each match was placed
+	var piles []int
+	prev := make([]int, len(matches))
+	for i, m := range matches {
+		k := sort.Search(len(piles), func(k int) bool {
+			return matches[piles[k]].b > m.b
+		})
+		if k > 0 {
+			prev[i] = piles[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(piles) {
+			piles = append(piles, i)
+		} else {
+			piles[k] = i
+		}
+	}
+	seq := make([]match, len(piles))
+	for i, k := piles[len(piles)-1], len(seq)-1; k >= 0; i, k = prev[i], k-1 {
+		seq[k] = matches[i]
+	}
+	return seq
+}
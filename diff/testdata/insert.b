@@ -0,0 +1,11 @@
+func f() {
+	if x {
+		foo()
+	}
+
+	if y {
+		foo()
+	}
+
+	bar()
+}
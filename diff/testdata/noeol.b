@@ -0,0 +1,3 @@
+1
+2
+three
@@ -0,0 +1,31 @@
+y--
+return nil
+x++
+b
+if err != nil {
+y--
+a
+b
+a
+b
+{
+
+y--
+a
+if err != nil {
+}
+b
+a
+x++
+if err != nil {
+a
+x++
+}
+return nil
+b
+x++
+}
+return nil
+
+if err != nil {
+
@@ -0,0 +1,10 @@
+1
+2
+a
+
+b
+a
+
+b
+3
+4
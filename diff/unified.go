@@ -0,0 +1,131 @@
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Hunk is a run of edits between two sequences of lines a and b,
+// together with the unchanged lines around them: the lines
+// a[OldStart:OldEnd] become the lines b[NewStart:NewEnd].
+type Hunk struct {
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+	Edits            []Edit
+}
+
+// Hunks groups the edits between the sequences of lines a and b into
+// hunks with the given number of unchanged lines of context around
+// each edit.  Edits whose contexts would touch or overlap share a hunk.
+func Hunks(a, b []string, edits []Edit, context int) []Hunk {
+	if context < 0 {
+		context = 0
+	}
+	var hunks []Hunk
+	for len(edits) > 0 {
+		n := 1
+		for n < len(edits) && edits[n].OldStart-edits[n-1].OldEnd <= 2*context {
+			n++
+		}
+		first, last := edits[0], edits[n-1]
+		h := Hunk{
+			OldStart: first.OldStart - context,
+			OldEnd:   last.OldEnd + context,
+			NewStart: first.NewStart - context,
+			NewEnd:   last.NewEnd + context,
+			Edits:    edits[:n],
+		}
+		if h.OldStart < 0 {
+			h.OldStart = 0
+		}
+		if h.NewStart < 0 {
+			h.NewStart = 0
+		}
+		if h.OldEnd > len(a) {
+			h.OldEnd = len(a)
+		}
+		if h.NewEnd > len(b) {
+			h.NewEnd = len(b)
+		}
+		hunks = append(hunks, h)
+		edits = edits[n:]
+	}
+	return hunks
+}
+
+// WriteUnified writes the hunks between the sequences of lines a and b
+// to w in the unified diff format, without the file headers.  Each hunk
+// header is followed, like the reference Git client's, by the last line
+// before the hunk that starts with a letter, an underscore or a dollar
+// sign, which in most languages is the start of the enclosing function.
+func WriteUnified(w io.Writer, a, b []string, hunks []Hunk) error {
+	bw := bufio.NewWriter(w)
+	funcName, searched := "", -1
+	for _, h := range hunks {
+		for i := h.OldStart - 1; i > searched; i-- {
+			if name, ok := funcLine(a[i]); ok {
+				funcName = name
+				break
+			}
+		}
+		searched = h.OldStart - 1
+		fmt.Fprintf(bw, "@@ -%s +%s @@", hunkRange(h.OldStart, h.OldEnd), hunkRange(h.NewStart, h.NewEnd))
+		if funcName != "" {
+			fmt.Fprintf(bw, " %s", funcName)
+		}
+		bw.WriteByte('\n')
+		i := h.OldStart
+		for _, e := range h.Edits {
+			writeLines(bw, ' ', a[i:e.OldStart])
+			writeLines(bw, '-', a[e.OldStart:e.OldEnd])
+			writeLines(bw, '+', b[e.NewStart:e.NewEnd])
+			i = e.OldEnd
+		}
+		writeLines(bw, ' ', a[i:h.OldEnd])
+	}
+	return bw.Flush()
+}
+
+// hunkRange formats the range [start, end) of lines for a hunk header:
+// the one-based number of its first line and its length, which is
+// omitted if it is one.  An empty range is numbered after the line
+// preceding it.
+func hunkRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, end-start)
+	}
+}
+
+// funcLine returns the given line, trimmed to 80 bytes and stripped of
+// trailing whitespace, if it looks like the start of a function.
+func funcLine(line string) (string, bool) {
+	if line == "" {
+		return "", false
+	}
+	if c := line[0]; c != '_' && c != '$' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+		return "", false
+	}
+	if len(line) > 80 {
+		line = line[:80]
+	}
+	return strings.TrimRight(line, " \t\n\v\f\r"), true
+}
+
+// writeLines writes the lines with the given prefix, and notes a
+// missing newline at the end of the last line.
+func writeLines(w *bufio.Writer, prefix byte, lines []string) {
+	for _, line := range lines {
+		w.WriteByte(prefix)
+		w.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			w.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
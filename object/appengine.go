@@ -109,6 +109,9 @@ func (s *Signature) Save() ([]datastore.Property, error) {
 		{Name: "Email", Value: s.Email},
 		{Name: "Date", Value: s.Date},
 		{Name: "TZ", Value: int64(offset)},
+		{Name: "RawTimestamp", Value: s.Timestamp, NoIndex: true},
+		{Name: "RawTZ", Value: s.TZ, NoIndex: true},
+		{Name: "RawSignature", Value: s.Raw, NoIndex: true},
 	}, nil
 }
 
@@ -121,6 +124,11 @@ func (s *Signature) Load(props []datastore.Property) (err error) {
 		Date: m["Date"].(time.Time).
 			In(time.FixedZone("", int(m["TZ"].(int64)))),
 	}
+	// signatures stored before RawTimestamp, RawTZ and RawSignature
+	// were introduced lack them
+	s.Timestamp, _ = m["RawTimestamp"].(string)
+	s.TZ, _ = m["RawTZ"].(string)
+	s.Raw, _ = m["RawSignature"].(string)
 	return nil
 }
 
@@ -189,6 +197,15 @@ func (c *Commit) Save() ([]datastore.Property, error) {
 	Committer, _ := c.Committer.Save()
 	props = append(props, prefixProps("Author", Author)...)
 	props = append(props, prefixProps("Committer", Committer)...)
+	var Extra []datastore.Property
+	for _, h := range c.Extra {
+		Extra = append(Extra, []datastore.Property{
+			{Name: "Key", Value: h.Key, Multiple: true},
+			{Name: "Value", Value: h.Value, NoIndex: true, Multiple: true},
+			{Name: "Space", Value: h.Space, NoIndex: true, Multiple: true},
+		}...)
+	}
+	props = append(props, prefixProps("Extra", Extra)...)
 	return props, nil
 }
 
@@ -209,6 +226,22 @@ func (c *Commit) Load(props []datastore.Property) (err error) {
 	if err := c.Committer.Load(m["Committer"].([]datastore.Property)); err != nil {
 		return err
 	}
+	extraProps, _ := m["Extra"].([]datastore.Property)
+	extra := parseProps(extraProps)
+	keys, _ := extra["Key"].([]interface{})
+	values, _ := extra["Value"].([]interface{})
+	// commits stored before Space was introduced lack it
+	spaces, _ := extra["Space"].([]interface{})
+	for i, key := range keys {
+		h := ExtraHeader{
+			Key:   key.(string),
+			Value: values[i].(string),
+		}
+		if i < len(spaces) {
+			h.Space = spaces[i].(bool)
+		}
+		c.Extra = append(c.Extra, h)
+	}
 	return nil
 }
 
@@ -241,3 +274,42 @@ func (t *Tree) Load(props []datastore.Property) (err error) {
 	}
 	return nil
 }
+
+// A Raw is saved as the properties of its parsed form, so that it can
+// be queried like the other object types, plus its exact binary
+// representation in the Raw property.  The Raw property is omitted for
+// blobs, whose binary representation is fully determined by their
+// contents.  If the Raw cannot be parsed, only the Raw property is
+// saved.
+func (r *Raw) Save() ([]datastore.Property, error) {
+	rawProp := datastore.Property{Name: "Raw", Value: r.data, NoIndex: true}
+	obj, err := r.Parse()
+	if err != nil {
+		return []datastore.Property{rawProp}, nil
+	}
+	props, err := obj.(datastore.PropertyLoadSaver).Save()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := obj.(*Blob); ok {
+		return props, nil
+	}
+	return append(props, rawProp), nil
+}
+
+// Load loads the Raw from the Raw property, or from the Contents
+// property of a blob if the Raw property is absent.
+func (r *Raw) Load(props []datastore.Property) (err error) {
+	defer panicHandler(&err)
+	m := parseProps(props)
+	if data, ok := m["Raw"].([]byte); ok {
+		return r.UnmarshalBinary(data)
+	}
+	b := Blob(m["Contents"].([]byte))
+	data, _, err := Marshal(&b)
+	if err != nil {
+		return err
+	}
+	*r = Raw{data: data}
+	return nil
+}
@@ -5,7 +5,14 @@ package object
 type Blob []byte
 
 func (b *Blob) MarshalBinary() ([]byte, error) {
-	return prependHeader(TypeBlob, *b)
+	return b.AppendBinary(nil)
+}
+
+// AppendBinary appends the binary representation of the blob to buf
+// and returns the extended slice.
+func (b *Blob) AppendBinary(buf []byte) ([]byte, error) {
+	buf = AppendHeader(buf, TypeBlob, int64(len(*b)))
+	return append(buf, *b...), nil
 }
 
 func (b *Blob) UnmarshalBinary(data []byte) error {
@@ -17,9 +24,13 @@ func (b *Blob) UnmarshalBinary(data []byte) error {
 }
 
 func (b *Blob) MarshalText() ([]byte, error) {
-	text := make([]byte, len(*b))
-	copy(text, *b)
-	return text, nil
+	return b.AppendText(make([]byte, 0, len(*b)))
+}
+
+// AppendText appends the contents of the blob to buf and returns the
+// extended slice.
+func (b *Blob) AppendText(buf []byte) ([]byte, error) {
+	return append(buf, *b...), nil
 }
 
 func (b *Blob) UnmarshalText(text []byte) error {
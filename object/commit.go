@@ -2,25 +2,58 @@ package object
 
 import (
 	"bytes"
-	"fmt"
+	"io"
 )
 
 // A Commit is a signed label for a Tree object, representing a snapshot
 // of the repository state at a particular point in time.
 type Commit struct {
-	Tree      ID        // ID of the commit's root tree
-	Parent    []ID      // the commit's parents
-	Author    Signature // author name and date
-	Committer Signature // committer name and date
-	Message   string    // a commit message
+	Tree      ID            // ID of the commit's root tree
+	Parent    []ID          // the commit's parents
+	Author    Signature     // author name and date
+	Committer Signature     // committer name and date
+	Extra     []ExtraHeader // additional headers, in order
+	Message   string        // a commit message
+}
+
+// An ExtraHeader is a commit header following the committer line, such
+// as encoding, mergetag or gpgsig.  Its value may span several lines;
+// in the commit's representations, every line of the value after the
+// first is prefixed with a space.
+type ExtraHeader struct {
+	Key   string
+	Value string // without a trailing newline
+
+	// Space tells whether the key is followed by a space when the
+	// first line of the value is empty.  The key is always followed
+	// by a space otherwise.
+	Space bool
+}
+
+// Header returns the value of the first extra header with the given
+// key and true, or "" and false if the commit has no such header.
+func (c *Commit) Header(key string) (string, bool) {
+	for _, h := range c.Extra {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return "", false
 }
 
 func (c *Commit) MarshalBinary() ([]byte, error) {
-	text, err := c.MarshalText()
+	return c.AppendBinary(nil)
+}
+
+// AppendBinary appends the binary representation of the commit to b
+// and returns the extended slice.
+func (c *Commit) AppendBinary(b []byte) ([]byte, error) {
+	start := len(b)
+	b, err := c.AppendText(b)
 	if err != nil {
 		return nil, err
 	}
-	return prependHeader(TypeCommit, text)
+	return insertHeader(b, start, TypeCommit), nil
 }
 
 func (c *Commit) UnmarshalBinary(data []byte) error {
@@ -32,33 +65,95 @@ func (c *Commit) UnmarshalBinary(data []byte) error {
 }
 
 func (c *Commit) MarshalText() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	fmt.Fprintln(buf, "tree", c.Tree)
+	return c.AppendText(nil)
+}
+
+// AppendText appends the textual representation of the commit to b
+// and returns the extended slice.
+func (c *Commit) AppendText(b []byte) ([]byte, error) {
+	b = append(b, "tree "...)
+	b = append(c.Tree.appendHex(b), '\n')
 	for _, parent := range c.Parent {
-		fmt.Fprintln(buf, "parent", parent)
+		b = append(b, "parent "...)
+		b = append(parent.appendHex(b), '\n')
+	}
+	b = append(b, "author "...)
+	b = append(appendSignature(b, c.Author), '\n')
+	b = append(b, "committer "...)
+	b = append(appendSignature(b, c.Committer), '\n')
+	for _, h := range c.Extra {
+		b = append(b, h.Key...)
+		if h.Space || h.Value != "" && h.Value[0] != '\n' {
+			b = append(b, ' ')
+		}
+		for i := 0; i < len(h.Value); i++ {
+			b = append(b, h.Value[i])
+			if h.Value[i] == '\n' {
+				b = append(b, ' ')
+			}
+		}
+		b = append(b, '\n')
 	}
-	fmt.Fprintln(buf, "author", c.Author)
-	fmt.Fprintln(buf, "committer", c.Committer)
-	fmt.Fprintln(buf)
-	buf.WriteString(c.Message)
-	return buf.Bytes(), nil
+	b = append(b, '\n')
+	return append(b, c.Message...), nil
 }
 
 func (c *Commit) UnmarshalText(text []byte) error {
-	buf := bytes.NewBuffer(text)
-	var err fmtErr
-	err.Check(fmt.Fscanf(buf, "tree %s\n", &c.Tree))
+	value, text, err := cutField(text, "tree")
+	if err != nil {
+		return err
+	}
+	if c.Tree, err = parseID(value); err != nil {
+		return err
+	}
 	c.Parent = nil
 	for {
-		var parent ID
-		if _, err := fmt.Fscanf(buf, "parent %s\n", &parent); err != nil {
+		value, rest, err := cutField(text, "parent")
+		if err != nil {
 			break
 		}
+		parent, err := parseID(value)
+		if err != nil {
+			return err
+		}
 		c.Parent = append(c.Parent, parent)
+		text = rest
+	}
+	if value, text, err = cutField(text, "author"); err != nil {
+		return err
+	}
+	if c.Author, err = parseSignature(value); err != nil {
+		return err
+	}
+	if value, text, err = cutField(text, "committer"); err != nil {
+		return err
+	}
+	if c.Committer, err = parseSignature(value); err != nil {
+		return err
+	}
+	c.Extra = nil
+	for {
+		line, rest, ok := cutLine(text)
+		if !ok {
+			return io.ErrUnexpectedEOF
+		}
+		text = rest
+		n := len(c.Extra)
+		switch {
+		case len(line) == 0:
+			c.Message = string(text)
+			return nil
+		case line[0] == ' ' && n > 0:
+			c.Extra[n-1].Value += "\n" + string(line[1:])
+		default:
+			var h ExtraHeader
+			if i := bytes.IndexByte(line, ' '); i >= 0 {
+				h.Key, h.Value = string(line[:i]), string(line[i+1:])
+				h.Space = h.Value == ""
+			} else {
+				h.Key = string(line)
+			}
+			c.Extra = append(c.Extra, h)
+		}
 	}
-	err.Check(fmt.Fscanf(buf, "author %s\n", &c.Author))
-	err.Check(fmt.Fscanf(buf, "committer %s\n", &c.Committer))
-	err.Check(fmt.Fscanf(buf, "\n"))
-	c.Message = buf.String()
-	return err.Err()
 }
@@ -0,0 +1,166 @@
+// The functions in this file recognize tree entry names that some
+// filesystems treat as equivalent to ".git" or ".gitmodules", which
+// malicious repositories can use to smuggle files into the Git
+// directory of whoever checks them out.  They are transliterated from
+// the is_hfs_dot* and is_ntfs_dot* functions of the reference Git
+// client's path.c and utf8.c.
+
+package object
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// isXplatformDirSep returns true if c is a directory separator on any
+// platform supported by the reference Git client.
+func isXplatformDirSep(c byte) bool {
+	return c == '/' || c == '\\'
+}
+
+// nextHFSRune returns the next rune of s that HFS+ does not ignore,
+// lowercased, and the rest of s following it.  It returns -1 on
+// malformed UTF-8 and 0 at the end of s.
+func nextHFSRune(s string) (rune, string) {
+	for {
+		if s == "" {
+			return 0, s
+		}
+		r, n := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && n <= 1 {
+			return -1, ""
+		}
+		s = s[n:]
+		switch r {
+		case 0x200c, 0x200d, 0x200e, 0x200f, // zero-width joiners and marks
+			0x202a, 0x202b, 0x202c, 0x202d, 0x202e, // directional formatting
+			0x206a, 0x206b, 0x206c, 0x206d, 0x206e, 0x206f, // deprecated formatting
+			0xfeff: // zero width no-break space
+			continue
+		}
+		if 'A' <= r && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		return r, s
+	}
+}
+
+// isHFSDot returns true if HFS+ would consider name equivalent to a dot
+// followed by needle, which must be lowercase ASCII.
+func isHFSDot(name, needle string) bool {
+	r, name := nextHFSRune(name)
+	if r != '.' {
+		return false
+	}
+	for i := 0; i < len(needle); i++ {
+		r, name = nextHFSRune(name)
+		if r != rune(needle[i]) {
+			return false
+		}
+	}
+	// NOTE(lor): Malformed UTF-8 following the needle counts as the
+	// end of the name, as in the reference Git client.
+	r, _ = nextHFSRune(name)
+	return r <= 0 || r == '/'
+}
+
+// onlySpacesAndPeriods returns true if s consists of spaces and periods
+// up to its end, a colon (which introduces an NTFS alternate data
+// stream) or a directory separator.
+func onlySpacesAndPeriods(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ':' || isXplatformDirSep(c):
+			return true
+		case c != ' ' && c != '.':
+			return false
+		}
+	}
+	return true
+}
+
+// isNTFSDotgit returns true if NTFS would consider name, or any of its
+// components separated by backslashes, equivalent to ".git", either
+// directly or through its 8.3 short name "GIT~1".
+func isNTFSDotgit(name string) bool {
+	for {
+		switch {
+		case len(name) >= 4 && name[0] == '.' && strings.EqualFold(name[1:4], "git"):
+			if onlySpacesAndPeriods(name[4:]) {
+				return true
+			}
+		case len(name) >= 5 && strings.EqualFold(name[:3], "git") && name[3:5] == "~1":
+			if onlySpacesAndPeriods(name[5:]) {
+				return true
+			}
+		}
+		// skip to the next component
+		i := strings.IndexAny(name, `\/`)
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
+
+// isNTFSDot returns true if NTFS would consider name equivalent to a
+// dot followed by needle, which must be lowercase ASCII and at least six
+// bytes long.  shortPrefix is the prefix of the fall-back 8.3 short name
+// NTFS generates for the name; see the reference Git client's path.c for
+// how it is derived.
+func isNTFSDot(name, needle, shortPrefix string) bool {
+	n := len(needle)
+	if len(name) > n && name[0] == '.' && strings.EqualFold(name[1:n+1], needle) {
+		return onlySpacesAndPeriods(name[n+1:])
+	}
+	// the regular short name: the needle cut to six characters
+	// followed by ~1 to ~4
+	if len(name) >= 8 && strings.EqualFold(name[:6], needle[:6]) &&
+		name[6] == '~' && '1' <= name[7] && name[7] <= '4' {
+		return onlySpacesAndPeriods(name[8:])
+	}
+	// the fall-back short name
+	sawTilde := false
+	for i := 0; i < 8; i++ {
+		if i >= len(name) {
+			return false
+		}
+		c := name[i]
+		switch {
+		case sawTilde:
+			if c < '0' || c > '9' {
+				return false
+			}
+		case c == '~':
+			i++
+			if i >= len(name) || name[i] < '1' || name[i] > '9' {
+				return false
+			}
+			sawTilde = true
+		case i >= 6 || c&0x80 != 0:
+			return false
+		case toLowerASCII(c) != shortPrefix[i]:
+			return false
+		}
+	}
+	return onlySpacesAndPeriods(name[8:])
+}
+
+func toLowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	return c
+}
+
+// isDotgit returns true if name is equivalent to ".git" on any
+// filesystem supported by the reference Git client.
+func isDotgit(name string) bool {
+	return isHFSDot(name, "git") || isNTFSDotgit(name)
+}
+
+// isDotgitmodules returns true if name is equivalent to ".gitmodules"
+// on any filesystem supported by the reference Git client.
+func isDotgitmodules(name string) bool {
+	return isHFSDot(name, "gitmodules") || isNTFSDot(name, "gitmodules", "gi7eba")
+}
@@ -0,0 +1,161 @@
+package object
+
+import "testing"
+
+// treeEntryMsgIDs returns the IDs of the messages Fsck reports for a
+// tree with a single blob entry of the given mode and name.
+func treeEntryMsgIDs(t *testing.T, mode TreeMode, name string) []MsgID {
+	blob := Blob("x\n")
+	blobID, err := Hash(&blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := new(Fsck).CheckObject(&Tree{name: {mode, blobID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []MsgID
+	for _, e := range errs {
+		msgs = append(msgs, e.ID)
+	}
+	return msgs
+}
+
+// dotgitTests are tree entry names and whether the reference Git
+// client's fsck reports them with MsgHasDotgit.
+var dotgitTests = []struct {
+	name   string
+	dotgit bool
+}{
+	{".git", true},
+	{".GIT", true},
+	{".Git", true},
+	{"git~1", true},
+	{"GIT~1", true},
+	{".git.", true},
+	{".git ", true},
+	{".git. . ", true},
+	{".git:stream", true},
+	{".git::$INDEX_ALLOCATION", true},
+	{`a\.git`, true},
+	{`a\b\.git`, true},
+	{`a\.git\b`, true},
+	{`git~1\x`, true},
+	{`x\git~1`, true},
+	{`.git\x`, true},
+	{`a\.git.`, true},
+	{`a:b\.git`, true},
+	{`a\.gitx`, false},
+	{".gitx", false},
+	{"git~10", false},
+	{"git~2", false},
+	{"git", false},
+	{"a.git", false},
+	{".git\u200c", true},
+	{"\u200c.git", true},
+	{".g\u200dit", true},
+	{".gi\ufefft", true},
+	{".\u206agit", true},
+	{".GI\u200eT", true},
+	{".git\u200f", true},
+	{".gi\u202et", true},
+	{".git\u00e0", false},
+	{"\u2068.git", false},
+	{`.git\`, true},
+	{`\.git`, true},
+	{`a\\.git`, true},
+	{"..git", false},
+	{". git", false},
+	{"a:.git", false},
+	{`.gitx\.git`, true},
+	{`a:\.git`, true},
+	{`git~1:x\y`, true},
+	{`a\git~1 .`, true},
+	{"x:git~1", false},
+}
+
+func TestDotgit(t *testing.T) {
+	for _, tt := range dotgitTests {
+		msgs := treeEntryMsgIDs(t, ModeBlob, tt.name)
+		found := false
+		for _, msg := range msgs {
+			if msg == MsgHasDotgit {
+				found = true
+			}
+		}
+		if found != tt.dotgit {
+			t.Errorf("entry %q: got %v, want %s=%v", tt.name, msgs, MsgHasDotgit, tt.dotgit)
+		}
+	}
+}
+
+// dotgitmodulesTests are the names of the reference Git client's
+// t0060-path-utils.sh, and whether its fsck reports symbolic links of
+// those names with MsgGitmodulesSymlink.
+var dotgitmodulesTests = []struct {
+	name       string
+	gitmodules bool
+}{
+	{".gitmodules", true},
+	{".git\u200cmodules", true},
+	{".Gitmodules", true},
+	{".gitmoduleS", true},
+	{".gitmodules ", true},
+	{".gitmodules.", true},
+	{".gitmodules  ", true},
+	{".gitmodules. ", true},
+	{".gitmodules .", true},
+	{".gitmodules..", true},
+	{".gitmodules   ", true},
+	{".gitmodules.  ", true},
+	{".gitmodules . ", true},
+	{".gitmodules  .", true},
+	{"GITMOD~1", true},
+	{"gitmod~1", true},
+	{"GITMOD~2", true},
+	{"gitmod~3", true},
+	{"GITMOD~4", true},
+	{"GITMOD~1 ", true},
+	{"gitmod~2.", true},
+	{"GITMOD~3  ", true},
+	{"gitmod~4. ", true},
+	{"GITMOD~1 .", true},
+	{"GI7EBA~1", true},
+	{"gi7eba~9", true},
+	{"GI7EB~10", true},
+	{"GI7EB~11", true},
+	{"GI7EB~99", true},
+	{"GI7E~100", true},
+	{"GI7E~101", true},
+	{"GI7E~999", true},
+	{"~1000000", true},
+	{"~9999999", true},
+	{".gitmodules:$DATA", true},
+	{".gitmodules x", false},
+	{".gitmodules .x", false},
+	{" .gitmodules", false},
+	{"..gitmodules", false},
+	{"gitmodules", false},
+	{".gitmodule", false},
+	{".gitmodules x ", false},
+	{"GI7EBA~", false},
+	{"GI7EBA~0", false},
+	{"GI7EBA~~1", false},
+	{"GI7EBA~X", false},
+	{"Gx7EBA~1", false},
+	{"GI7EBX~1", false},
+	{"GI7EB~1", false},
+	{"GI7EB~01", false},
+	{"GI7EB~1X", false},
+	{"GITMOD~5", false},
+}
+
+func TestDotgitmodules(t *testing.T) {
+	for _, tt := range dotgitmodulesTests {
+		msgs := treeEntryMsgIDs(t, ModeSymlink, tt.name)
+		found := len(msgs) == 1 && msgs[0] == MsgGitmodulesSymlink
+		if found != tt.gitmodules || !found && len(msgs) > 0 {
+			t.Errorf("symlink %q: got %v, want %s=%v", tt.name, msgs, MsgGitmodulesSymlink, tt.gitmodules)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// This file contains the byte-level encoders and decoders of the parts
+// shared by the Git object types.  They are written by hand instead of
+// with fmt, as decoding objects is on the hot path of repository walks.
+
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errBadHeader    = errors.New("object: malformed object header")
+	errBadSignature = errors.New("object: malformed signature")
+)
+
+// parseType returns the Type whose String method returns b.  It
+// returns a TypeError containing b as a string if there is none.
+func parseType(b []byte) (Type, error) {
+	switch string(b) {
+	case "commit":
+		return TypeCommit, nil
+	case "tree":
+		return TypeTree, nil
+	case "blob":
+		return TypeBlob, nil
+	case "tag":
+		return TypeTag, nil
+	default:
+		return TypeUnknown, &TypeError{string(b)}
+	}
+}
+
+// parseUint parses b as an unsigned integer in the given base, which is
+// at most 10.  It returns false if b is empty, contains other characters
+// than digits or overflows 63 bits.
+func parseUint(b []byte, base uint64) (uint64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	var n uint64
+	for _, c := range b {
+		d := uint64(c - '0')
+		if c < '0' || d >= base || n > (1<<63-1-d)/base {
+			return 0, false
+		}
+		n = n*base + d
+	}
+	return n, true
+}
+
+// AppendHeader appends the Git object header of an object of the given
+// type, whose binary representation without the header is size bytes
+// long, to b and returns the extended slice.
+func AppendHeader(b []byte, t Type, size int64) []byte {
+	b = append(b, t.String()...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, size, 10)
+	return append(b, 0)
+}
+
+// ParseHeader parses the Git object header at the start of data.  It
+// returns the type and size recorded in the header and the data
+// following it, which is not checked against the size, so that the
+// header of an object can be parsed before the whole object is read.
+// If the type is not one of the standard Git ones, it is returned as a
+// string inside a TypeError.
+func ParseHeader(data []byte) (Type, int64, []byte, error) {
+	sp := bytes.IndexByte(data, ' ')
+	if sp < 0 {
+		return TypeUnknown, 0, nil, errBadHeader
+	}
+	t, err := parseType(data[:sp])
+	if err != nil {
+		return t, 0, nil, err
+	}
+	data = data[sp+1:]
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return t, 0, nil, errBadHeader
+	}
+	size, ok := parseUint(data[:nul], 10)
+	if !ok {
+		return t, 0, nil, errBadHeader
+	}
+	return t, int64(size), data[nul+1:], nil
+}
+
+// insertHeader inserts the Git object header of an object of the given
+// type before b[start:], which holds the object's binary
+// representation without the header, and returns the extended slice.
+func insertHeader(b []byte, start int, t Type) []byte {
+	var buf [32]byte
+	header := AppendHeader(buf[:0], t, int64(len(b)-start))
+	b = append(b, header...)
+	copy(b[start+len(header):], b[start:len(b)-len(header)])
+	copy(b[start:], header)
+	return b
+}
+
+// parseID parses b as 40 or 64 hexadecimal digits, like DecodeID.
+func parseID(b []byte) (ID, error) {
+	var id ID
+	switch len(b) {
+	case 2 * SHA1.Size():
+		id.format = SHA1
+	case 2 * SHA256.Size():
+		id.format = SHA256
+	default:
+		return id, errBadIDLen
+	}
+	_, err := hex.Decode(id.sum[:], b)
+	return id, err
+}
+
+// cutLine returns the first line of b without its terminating newline
+// and the rest of b.  It returns false if b contains no newline.
+func cutLine(b []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil, b, false
+	}
+	return b[:i], b[i+1:], true
+}
+
+// cutField returns the value of the "name value" line at the start of
+// b and the rest of b.
+func cutField(b []byte, name string) (value, rest []byte, err error) {
+	line, rest, ok := cutLine(b)
+	n := len(name)
+	if !ok || len(line) <= n || string(line[:n]) != name || line[n] != ' ' {
+		return nil, b, fmt.Errorf("object: missing or malformed %s line", name)
+	}
+	return line[n+1:], rest, nil
+}
+
+// appendSignature appends the signature as returned by its String
+// method to b and returns the extended slice.
+func appendSignature(b []byte, s Signature) []byte {
+	start := len(b)
+	b = appendCanonicalSignature(b, s)
+	// NOTE(lor): Most signatures are recorded in the form they are
+	// formatted in, so s.Raw is only parsed if it differs from it.
+	if s.Raw == "" || s.Raw == string(b[start:]) || !s.isRaw() {
+		return b
+	}
+	return append(b[:start], s.Raw...)
+}
+
+// isRaw reports whether s.Raw parses to the name and e-mail address of
+// s and to a date formatted like that of s.
+func (s Signature) isRaw() bool {
+	r, err := parseSignature([]byte(s.Raw))
+	if err != nil || r.Name != s.Name || r.Email != s.Email {
+		return false
+	}
+	return string(appendCanonicalSignature(nil, r)) == string(appendCanonicalSignature(nil, s))
+}
+
+// appendCanonicalSignature appends the signature to b in the form
+// "Name <Email> Date", ignoring s.Raw, and returns the extended slice.
+func appendCanonicalSignature(b []byte, s Signature) []byte {
+	b = append(b, s.Name...)
+	b = append(b, " <"...)
+	b = append(b, s.Email...)
+	b = append(b, '>')
+	if s.Date.IsZero() && s.TZ == "" {
+		return b
+	}
+	b = append(b, ' ')
+	b = appendTimestamp(b, s)
+	b = append(b, ' ')
+	return appendTZ(b, s)
+}
+
+// appendTimestamp appends the Unix time of the signature's date to b
+// and returns the extended slice.  The time is s.Timestamp if it
+// denotes s.Date, and s.Date's Unix time in decimal otherwise.
+func appendTimestamp(b []byte, s Signature) []byte {
+	if unix, ok := parseTimestamp(s.Timestamp); ok && unix == s.Date.Unix() {
+		return append(b, s.Timestamp...)
+	}
+	return strconv.AppendInt(b, s.Date.Unix(), 10)
+}
+
+// parseTimestamp returns the Unix time denoted by the decimal digits
+// ts, which is zero if they overflow 63 bits, as in Git.  It returns
+// false if ts is empty or contains other characters than digits.
+func parseTimestamp(ts string) (int64, bool) {
+	if ts == "" || countDigits(ts) != len(ts) {
+		return 0, false
+	}
+	unix, _ := parseUint([]byte(ts), 10)
+	return int64(unix), true
+}
+
+// appendTZ appends the timezone offset of the signature's date to b and
+// returns the extended slice.  The offset is s.TZ if it matches that of
+// s.Date, and that of s.Date formatted as a sign and four digits
+// otherwise.
+func appendTZ(b []byte, s Signature) []byte {
+	_, offset := s.Date.Zone()
+	if tz, ok := parseTZ(s.TZ); ok && tz == offset {
+		return append(b, s.TZ...)
+	}
+	sign := byte('+')
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	offset /= 60
+	hhmm := offset/60*100 + offset%60
+	return append(b, sign,
+		byte('0'+hhmm/1000%10),
+		byte('0'+hhmm/100%10),
+		byte('0'+hhmm/10%10),
+		byte('0'+hhmm%10),
+	)
+}
+
+// identSpace holds the bytes Git considers whitespace in identities.
+const identSpace = " \t\n\r"
+
+// parseSignature parses a signature like the reference Git client's
+// split_ident_line: the name is everything before the first '<' less
+// trailing whitespace, the e-mail address everything between it and the
+// next '>', and the date and timezone follow the last '>'.  Anything
+// after the timezone is ignored.  If the date or timezone is missing or
+// malformed, the signature has a zero Date and empty Timestamp and TZ.
+// A date too large for the Unix time of a time.Time is taken to be the
+// epoch, as Git does when showing it.  The signature's Raw is b, of
+// which the other strings are substrings.
+func parseSignature(b []byte) (Signature, error) {
+	s := Signature{Raw: string(b)}
+	lt := strings.IndexByte(s.Raw, '<')
+	if lt < 0 {
+		return Signature{}, errBadSignature
+	}
+	gt := strings.IndexByte(s.Raw[lt+1:], '>')
+	if gt < 0 {
+		return Signature{}, errBadSignature
+	}
+	s.Name = strings.TrimRight(s.Raw[:lt], identSpace)
+	s.Email = s.Raw[lt+1 : lt+1+gt]
+
+	date := strings.TrimLeft(s.Raw[strings.LastIndexByte(s.Raw, '>')+1:], identSpace)
+	n := countDigits(date)
+	if n == 0 {
+		return s, nil
+	}
+	secs, tz := date[:n], strings.TrimLeft(date[n:], identSpace)
+	if len(tz) == 0 || (tz[0] != '+' && tz[0] != '-') {
+		return s, nil
+	}
+	n = countDigits(tz[1:])
+	if n == 0 {
+		return s, nil
+	}
+	s.Timestamp, s.TZ = secs, tz[:1+n]
+	unix, _ := parseTimestamp(s.Timestamp)
+	offset, _ := parseTZ(s.TZ)
+	s.Date = time.Unix(unix, 0).In(time.FixedZone("", offset))
+	return s, nil
+}
+
+// countDigits returns the number of decimal digits at the start of s.
+func countDigits(s string) int {
+	n := 0
+	for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// parseTZ returns the offset in seconds east of UTC of a timezone
+// "+hhmm" or "-hhmm".  Like Git, it interprets the digits as a decimal
+// number of hours times 100 plus minutes, so timezones with more or
+// fewer than four digits or more than 59 minutes are accepted.  It
+// returns false if tz is not a sign followed by digits, or if the
+// offset does not fit in 32 bits.
+func parseTZ(tz string) (int, bool) {
+	if len(tz) < 2 || (tz[0] != '+' && tz[0] != '-') {
+		return 0, false
+	}
+	hhmm, ok := parseUint([]byte(tz[1:]), 10)
+	if !ok || hhmm/100*60+hhmm%100 > (1<<31-1)/60 {
+		return 0, false
+	}
+	offset := int(hhmm/100*60+hhmm%100) * 60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return offset, true
+}
@@ -0,0 +1,547 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signatureTests are signatures that must keep their exact form when
+// parsed and formatted again.
+var signatureTests = []string{
+	"A U Thor <author@example.com> 1112911993 -0700",
+	"A U Thor <author@example.com> 1112911993 +0000",
+	"A U Thor <author@example.com> 1112911993 -0000",
+	"A U Thor <author@example.com> 1112911993 +05",
+	"A U Thor <author@example.com> 0001600000000 +0200",
+	"A U Thor <author@example.com> 0 +0000",
+	"A U Thor <author@example.com> 00 +0000",
+	"A U Thor <author@example.com> 9223372036854775807 +0000",
+	"A U Thor <author@example.com> 9223372036854775808 +0000",
+	"A U Thor <author@example.com> 99999999999999999999999 -1430",
+	"A U Thor <author@example.com>",
+	"A U Thor  <author@example.com> 1112911993 -0700",
+	"A U Thor<author@example.com> 1112911993 -0700",
+	"A U Thor <author@example.com>  1112911993  -0700",
+	"A U Thor <author@example.com> 1112911993 -0700 junk",
+	"<author@example.com> 1112911993 +0000",
+	"<author@example.com>  1112911993 +0000",
+	"<author@example.com> x",
+	"A U Thor <author@example.com> ",
+	"A U Thor <author@example.com> 1112911993",
+	"A U Thor <author@example.com> 1112911993 0700",
+	"A U Thor <a>b> 1112911993 -0700",
+}
+
+func TestSignatureRoundTrip(t *testing.T) {
+	for _, sig := range signatureTests {
+		s, err := parseSignature([]byte(sig))
+		if err != nil {
+			t.Errorf("parseSignature(%q): %v", sig, err)
+			continue
+		}
+		if got := s.String(); got != sig {
+			t.Errorf("parseSignature(%q).String() = %q", sig, got)
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Errorf("json.Marshal(%q): %v", sig, err)
+			continue
+		}
+		var js Signature
+		if err := json.Unmarshal(data, &js); err != nil {
+			t.Errorf("json.Unmarshal(%s): %v", data, err)
+			continue
+		}
+		if got := js.String(); got != sig {
+			t.Errorf("signature %q decoded from %s as %q", sig, data, got)
+		}
+	}
+}
+
+func TestSignatureCommitRoundTrip(t *testing.T) {
+	for _, sig := range signatureTests {
+		data := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+			"author " + sig + "\n" +
+			"committer " + sig + "\n\nmessage\n")
+		data = append(AppendHeader(nil, TypeCommit, int64(len(data))), data...)
+		obj, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%q: %v", sig, err)
+			continue
+		}
+		got, err := obj.MarshalBinary()
+		if err != nil {
+			t.Errorf("%q: %v", sig, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("commit by %q re-marshaled as %q", sig, got)
+		}
+	}
+}
+
+// extraHeaderTests are the extra header lines of commits that must keep
+// their exact form when parsed and formatted again.
+var extraHeaderTests = []string{
+	"key value\n",
+	"key\n",
+	"key \n",
+	"key  value\n",
+	"key value\n continued\n",
+	"key\n continued\n",
+	"key \n continued\n",
+	"key value\n \n continued\n",
+	"key value\n\x20\n",
+	"key\n \nother\n",
+}
+
+func TestExtraHeaderRoundTrip(t *testing.T) {
+	for _, extra := range extraHeaderTests {
+		data := []byte("tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+			"author " + benchSignature + "\n" +
+			"committer " + benchSignature + "\n" +
+			extra + "\nmessage\n")
+		data = append(AppendHeader(nil, TypeCommit, int64(len(data))), data...)
+		obj, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%q: %v", extra, err)
+			continue
+		}
+		got, err := obj.MarshalBinary()
+		if err != nil {
+			t.Errorf("%q: %v", extra, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("commit with extra headers %q re-marshaled as %q", extra, got)
+		}
+		jsonRoundTrip(t, obj)
+	}
+}
+
+func TestSignatureChangedName(t *testing.T) {
+	s, err := parseSignature([]byte("A U Thor  <author@example.com> 1112911993 -0700 junk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Name = "C O Mitter"
+	if got, want := s.String(), "C O Mitter <author@example.com> 1112911993 -0700"; got != want {
+		t.Errorf("signature with changed name: got %q, want %q", got, want)
+	}
+}
+
+func TestSignatureChangedDate(t *testing.T) {
+	s, err := parseSignature([]byte("A U Thor <author@example.com> 0001600000000 +0200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Date = s.Date.Add(time.Second)
+	if got, want := s.String(), "A U Thor <author@example.com> 1600000001 +0200"; got != want {
+		t.Errorf("signature with changed date: got %q, want %q", got, want)
+	}
+}
+
+// readFixtures returns the objects in testdata, which the reference
+// Git client wrote, by their IDs.
+func readFixtures(tb testing.TB) map[string][]byte {
+	fis, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	fixtures := make(map[string][]byte)
+	for _, fi := range fis {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", fi.Name()))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		fixtures[fi.Name()] = data
+	}
+	return fixtures
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, data := range readFixtures(t) {
+		obj, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := obj.MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: re-marshaled as\n%q\nwant\n%q", name, got, data)
+		}
+		if id, err := Hash(obj); err != nil || id.String() != name {
+			t.Errorf("%s: re-marshaled with ID %s, %v", name, id, err)
+		}
+	}
+}
+
+// The fixtures used in the benchmarks.
+const (
+	benchCommit    = "c0b6d345dd6b39849b8731cc32fce7917bc7185a" // merge with a mergetag header
+	benchTree      = "053470bc5708d67d8364cc7075a9819d6995a836"
+	benchTag       = "a882cee3567e8b8a68a257dee3fa8dd1abd08288" // signed tag
+	benchSignature = "A U Thor <author@example.com> 1112911993 -0700"
+)
+
+func BenchmarkParseCommit(b *testing.B) {
+	data := readFixtures(b)[benchCommit]
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var c Commit
+			if err := c.UnmarshalBinary(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var c Commit
+			if err := fmtUnmarshalCommit(&c, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppendCommit(b *testing.B) {
+	var c Commit
+	if err := c.UnmarshalBinary(readFixtures(b)[benchCommit]); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("bytes", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = c.AppendBinary(buf[:0])
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fmtMarshalCommit(&c)
+		}
+	})
+}
+
+func BenchmarkParseTree(b *testing.B) {
+	data := readFixtures(b)[benchTree]
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var t Tree
+			if err := t.UnmarshalBinary(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var t Tree
+			if err := fmtUnmarshalTree(&t, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppendTree(b *testing.B) {
+	var t Tree
+	if err := t.UnmarshalBinary(readFixtures(b)[benchTree]); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("bytes", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = t.AppendBinary(buf[:0])
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fmtMarshalTree(&t)
+		}
+	})
+}
+
+func BenchmarkParseTag(b *testing.B) {
+	data := readFixtures(b)[benchTag]
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var t Tag
+			if err := t.UnmarshalBinary(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var t Tag
+			if err := fmtUnmarshalTag(&t, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppendTag(b *testing.B) {
+	var t Tag
+	if err := t.UnmarshalBinary(readFixtures(b)[benchTag]); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("bytes", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = t.AppendBinary(buf[:0])
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			fmtMarshalTag(&t)
+		}
+	})
+}
+
+func BenchmarkParseSignature(b *testing.B) {
+	b.Run("bytes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := parseSignature([]byte(benchSignature)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var s fmtSignature
+			if _, err := fmt.Sscanf(benchSignature, "%s", &s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppendSignature(b *testing.B) {
+	s, err := parseSignature([]byte(benchSignature))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("bytes", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf = appendSignature(buf[:0], s)
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = fmtSignature(s).String()
+		}
+	})
+}
+
+// The functions below are the fmt-based encoders and decoders that the
+// byte-level ones replaced, kept for comparison in the benchmarks.
+
+// A fmtSafeString is a string that may not contain the bytes
+// [\x00\x0A<>] nor begin nor end with the bytes [ .,:;<>"'].
+type fmtSafeString string
+
+func (s *fmtSafeString) Scan(ss fmt.ScanState, verb rune) error {
+	tok, err := ss.Token(false, func(r rune) bool {
+		return !strings.ContainsRune("\x00\x0A<>", r)
+	})
+	if err != nil {
+		return err
+	}
+	*s = fmtSafeString(strings.Trim(string(tok), " .,:;<>\"'"))
+	return nil
+}
+
+type fmtSignature Signature
+
+func (s fmtSignature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s",
+		s.Name,
+		s.Email,
+		s.Date.Unix(),
+		s.Date.Format("-0700"),
+	)
+}
+
+func (s *fmtSignature) Scan(ss fmt.ScanState, verb rune) error {
+	var (
+		name   fmtSafeString
+		email  fmtSafeString
+		unix   int64
+		offset int
+	)
+	_, err := fmt.Fscanf(ss, "%s<%s> %d %05d", &name, &email, &unix, &offset)
+	if err != nil {
+		return err
+	}
+	offset = (offset/100)*60*60 + (offset%100)*60
+	s.Name = string(name)
+	s.Email = string(email)
+	s.Date = time.Unix(unix, 0).In(time.FixedZone("", offset))
+	return nil
+}
+
+func fmtPrependHeader(objType Type, data []byte) []byte {
+	header := []byte(fmt.Sprintf("%s %d\x00", objType, len(data)))
+	return append(header, data...)
+}
+
+func fmtStripHeader(objType Type, data []byte) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(data)
+	var bufType Type
+	var length int
+	if _, err := fmt.Fscanf(buf, "%s %d\x00", &bufType, &length); err != nil {
+		return nil, err
+	}
+	if bufType != objType || length != buf.Len() {
+		return nil, fmt.Errorf("bad header")
+	}
+	return buf, nil
+}
+
+func fmtMarshalCommit(c *Commit) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "tree", c.Tree)
+	for _, parent := range c.Parent {
+		fmt.Fprintln(buf, "parent", parent)
+	}
+	fmt.Fprintln(buf, "author", fmtSignature(c.Author))
+	fmt.Fprintln(buf, "committer", fmtSignature(c.Committer))
+	for _, h := range c.Extra {
+		buf.WriteString(h.Key)
+		if h.Value != "" {
+			buf.WriteString(" ")
+			buf.WriteString(strings.Replace(h.Value, "\n", "\n ", -1))
+		}
+		fmt.Fprintln(buf)
+	}
+	fmt.Fprintln(buf)
+	buf.WriteString(c.Message)
+	return fmtPrependHeader(TypeCommit, buf.Bytes())
+}
+
+func fmtUnmarshalCommit(c *Commit, data []byte) error {
+	buf, err := fmtStripHeader(TypeCommit, data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fscanf(buf, "tree %s\n", &c.Tree); err != nil {
+		return err
+	}
+	c.Parent = nil
+	for {
+		var parent ID
+		if _, err := fmt.Fscanf(buf, "parent %s\n", &parent); err != nil {
+			break
+		}
+		c.Parent = append(c.Parent, parent)
+	}
+	if _, err := fmt.Fscanf(buf, "author %s\n", (*fmtSignature)(&c.Author)); err != nil {
+		return err
+	}
+	if _, err := fmt.Fscanf(buf, "committer %s\n", (*fmtSignature)(&c.Committer)); err != nil {
+		return err
+	}
+	c.Extra = nil
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		line = strings.TrimSuffix(line, "\n")
+		n := len(c.Extra)
+		switch {
+		case line == "":
+			c.Message = buf.String()
+			return nil
+		case line[0] == ' ' && n > 0:
+			c.Extra[n-1].Value += "\n" + line[1:]
+		default:
+			var h ExtraHeader
+			if i := strings.IndexByte(line, ' '); i >= 0 {
+				h.Key, h.Value = line[:i], line[i+1:]
+			} else {
+				h.Key = line
+			}
+			c.Extra = append(c.Extra, h)
+		}
+	}
+}
+
+func fmtMarshalTree(t *Tree) []byte {
+	buf := new(bytes.Buffer)
+	for _, name := range t.Names() {
+		ti := (*t)[name]
+		fmt.Fprintf(buf, "%o %s\x00", ti.Mode, name)
+		buf.Write(ti.Object.Bytes())
+	}
+	return fmtPrependHeader(TypeTree, buf.Bytes())
+}
+
+func fmtUnmarshalTree(t *Tree, data []byte) error {
+	buf, err := fmtStripHeader(TypeTree, data)
+	if err != nil {
+		return err
+	}
+	data = buf.Bytes()
+	if *t == nil {
+		*t = make(Tree)
+	}
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			return errTreeEntry
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return errTreeEntry
+		}
+		data = data[sp+1:]
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 || len(data)-nul-1 < SHA1.Size() {
+			return errTreeEntry
+		}
+		name := string(data[:nul])
+		data = data[nul+1:]
+		id, _ := NewID(SHA1, data[:SHA1.Size()])
+		data = data[SHA1.Size():]
+		(*t)[name] = TreeInfo{TreeMode(mode), id}
+	}
+	return nil
+}
+
+func fmtMarshalTag(t *Tag) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "object", t.Object)
+	fmt.Fprintln(buf, "type", t.Type)
+	fmt.Fprintln(buf, "tag", t.Tag)
+	fmt.Fprintln(buf, "tagger", fmtSignature(t.Tagger))
+	fmt.Fprintln(buf)
+	buf.WriteString(t.Message)
+	return fmtPrependHeader(TypeTag, buf.Bytes())
+}
+
+func fmtUnmarshalTag(t *Tag, data []byte) error {
+	buf, err := fmtStripHeader(TypeTag, data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fscanf(buf, "object %s\ntype %s\ntag %s\ntagger %s\n\n",
+		&t.Object, &t.Type, &t.Tag, (*fmtSignature)(&t.Tagger)); err != nil {
+		return err
+	}
+	t.Message = buf.String()
+	return nil
+}
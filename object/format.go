@@ -0,0 +1,156 @@
+package object
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/pjbgf/sha1cd"
+)
+
+var (
+	errBadFormat  = errors.New("object: unknown object format")
+	errHasherSize = errors.New("object: hashed data length does not match object size")
+)
+
+// ErrCollision is returned when hashing data that contains a known
+// SHA-1 collision attack pattern, such as the SHAttered one.  Such data
+// is most likely crafted to have the same ID as some other data.
+var ErrCollision = errors.New("object: SHA-1 collision attack detected")
+
+// A Format is the hash algorithm a repository uses to compute the IDs
+// of its objects, as set by the reference Git client's
+// extensions.objectFormat configuration variable.  The zero value is
+// SHA1.
+type Format int
+
+// The supported object formats.
+const (
+	SHA1 Format = iota
+	SHA256
+)
+
+// ParseFormat returns the Format with the given name, as returned by
+// Format.String.
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "sha1":
+		return SHA1, nil
+	case "sha256":
+		return SHA256, nil
+	default:
+		return SHA1, errBadFormat
+	}
+}
+
+// String returns "sha1" or "sha256" depending on the value of the
+// format.
+func (f Format) String() string {
+	switch f {
+	case SHA1:
+		return "sha1"
+	case SHA256:
+		return "sha256"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// Size returns the length in bytes of the IDs of the format.
+func (f Format) Size() int {
+	switch f {
+	case SHA256:
+		return sha256.Size
+	default:
+		return sha1.Size
+	}
+}
+
+// New returns a new hash.Hash computing the format's hash algorithm.
+// SHA-1 hashes detect collision attacks, like the SHA-1DC
+// implementation used by the reference Git client; use CheckedSum to
+// find out if a hash has detected one.
+func (f Format) New() hash.Hash {
+	switch f {
+	case SHA256:
+		return sha256.New()
+	default:
+		return sha1cd.New()
+	}
+}
+
+// Sum returns the ID of the given data, which is normally the binary
+// representation of a Git object.  It returns the ID and ErrCollision
+// if the data contains a SHA-1 collision attack.
+func (f Format) Sum(data []byte) (ID, error) {
+	h := f.New()
+	h.Write(data)
+	id := ID{format: f}
+	_, err := CheckedSum(h, id.sum[:0])
+	return id, err
+}
+
+// collisionDetector is implemented by hashes that detect collision
+// attacks.
+type collisionDetector interface {
+	CollisionResistantSum(b []byte) ([]byte, bool)
+}
+
+// CheckedSum appends the current hash of h to b and returns the
+// resulting slice, like h.Sum.  If h is a hash returned by Format.New
+// that has detected a collision attack in the data written to it,
+// CheckedSum returns the slice and ErrCollision.
+func CheckedSum(h hash.Hash, b []byte) ([]byte, error) {
+	if cd, ok := h.(collisionDetector); ok {
+		sum, collision := cd.CollisionResistantSum(b)
+		if collision {
+			return sum, ErrCollision
+		}
+		return sum, nil
+	}
+	return h.Sum(b), nil
+}
+
+// ZeroID returns the all-zeroes ID of the format.
+func (f Format) ZeroID() ID {
+	return ID{format: f}
+}
+
+// A Hasher computes the ID of an object from its headerless
+// representation written to it piecemeal, so that the IDs of objects
+// too large to hold in memory, such as big blobs, can be computed as
+// they are streamed.
+type Hasher struct {
+	h    hash.Hash
+	f    Format
+	size int64 // number of bytes still to be written
+}
+
+// NewHasher returns a Hasher computing the ID of an object of the given
+// format, type and size.
+func NewHasher(f Format, t Type, size int64) *Hasher {
+	h := f.New()
+	var buf [32]byte
+	h.Write(AppendHeader(buf[:0], t, size))
+	return &Hasher{h, f, size}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	h.size -= int64(len(p))
+	return h.h.Write(p)
+}
+
+// ID returns the ID of the object written to the Hasher.  It returns
+// an error if the number of bytes written differs from the size passed
+// to NewHasher, and the ID and ErrCollision if the object contains a
+// SHA-1 collision attack.
+func (h *Hasher) ID() (ID, error) {
+	id := ID{format: h.f}
+	if h.size != 0 {
+		return id, errHasherSize
+	}
+	_, err := CheckedSum(h.h, id.sum[:0])
+	return id, err
+}
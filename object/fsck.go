@@ -0,0 +1,687 @@
+// The functions in this file check Git objects for malformed content.
+// They operate directly on the objects' binary representations instead
+// of their parsed forms, as many of the defects they look for (such as
+// duplicate or unsorted tree entries) cannot be represented by the
+// object types of this package.  The checks and their message IDs
+// follow those of the reference Git client's fsck.c.
+
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Severity tells how seriously an Fsck message should be taken.
+type Severity int
+
+// The recognized message severities, in increasing order of
+// seriousness.  Messages of severity SeverityIgnore are never reported.
+const (
+	SeverityIgnore Severity = iota
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+)
+
+// String returns "ignore", "info", "warning" or "error" depending on
+// the value of the severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityIgnore:
+		return "ignore"
+	case SeverityInfo:
+		return "info"
+	case SeverityWarn:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// A MsgID identifies a kind of defect found by Fsck.  Its value is the
+// camelCased message ID used by the reference Git client, e.g. in the
+// fsck.<msg-id> configuration variables.
+type MsgID string
+
+// The message IDs reported by Fsck.
+const (
+	MsgBadDate                 MsgID = "badDate"
+	MsgBadDateOverflow         MsgID = "badDateOverflow"
+	MsgBadEmail                MsgID = "badEmail"
+	MsgBadFilemode             MsgID = "badFilemode"
+	MsgBadName                 MsgID = "badName"
+	MsgBadObjectSha1           MsgID = "badObjectSha1"
+	MsgBadParentSha1           MsgID = "badParentSha1"
+	MsgBadTagName              MsgID = "badTagName"
+	MsgBadTimezone             MsgID = "badTimezone"
+	MsgBadTree                 MsgID = "badTree"
+	MsgBadTreeSha1             MsgID = "badTreeSha1"
+	MsgBadType                 MsgID = "badType"
+	MsgDuplicateEntries        MsgID = "duplicateEntries"
+	MsgEmptyName               MsgID = "emptyName"
+	MsgExtraHeaderEntry        MsgID = "extraHeaderEntry"
+	MsgFullPathname            MsgID = "fullPathname"
+	MsgGitmodulesBlob          MsgID = "gitmodulesBlob"
+	MsgGitmodulesMissing       MsgID = "gitmodulesMissing"
+	MsgGitmodulesName          MsgID = "gitmodulesName"
+	MsgGitmodulesParse         MsgID = "gitmodulesParse"
+	MsgGitmodulesPath          MsgID = "gitmodulesPath"
+	MsgGitmodulesSymlink       MsgID = "gitmodulesSymlink"
+	MsgGitmodulesURL           MsgID = "gitmodulesUrl"
+	MsgGitmodulesUpdate        MsgID = "gitmodulesUpdate"
+	MsgHasDot                  MsgID = "hasDot"
+	MsgHasDotdot               MsgID = "hasDotdot"
+	MsgHasDotgit               MsgID = "hasDotgit"
+	MsgMissingAuthor           MsgID = "missingAuthor"
+	MsgMissingCommitter        MsgID = "missingCommitter"
+	MsgMissingEmail            MsgID = "missingEmail"
+	MsgMissingNameBeforeEmail  MsgID = "missingNameBeforeEmail"
+	MsgMissingObject           MsgID = "missingObject"
+	MsgMissingSpaceBeforeDate  MsgID = "missingSpaceBeforeDate"
+	MsgMissingSpaceBeforeEmail MsgID = "missingSpaceBeforeEmail"
+	MsgMissingTagEntry         MsgID = "missingTagEntry"
+	MsgMissingTaggerEntry      MsgID = "missingTaggerEntry"
+	MsgMissingTree             MsgID = "missingTree"
+	MsgMissingTypeEntry        MsgID = "missingTypeEntry"
+	MsgMultipleAuthors         MsgID = "multipleAuthors"
+	MsgNulInCommit             MsgID = "nulInCommit"
+	MsgNulInHeader             MsgID = "nulInHeader"
+	MsgNullSha1                MsgID = "nullSha1"
+	MsgTreeNotSorted           MsgID = "treeNotSorted"
+	MsgTypeMismatch            MsgID = "typeMismatch"
+	MsgUnknownType             MsgID = "unknownType"
+	MsgUnterminatedHeader      MsgID = "unterminatedHeader"
+	MsgZeroPaddedDate          MsgID = "zeroPaddedDate"
+	MsgZeroPaddedFilemode      MsgID = "zeroPaddedFilemode"
+)
+
+// defaultSeverity maps message IDs to the severities they are reported
+// with unless overridden.
+//
+// NOTE(lor): MsgTypeMismatch has no counterpart in the reference Git
+// client, which notices such mismatches only when walking the object
+// graph.  MsgEmptyName is never reported: like the reference Git
+// client, Fsck cannot parse a tree entry with an empty name, and
+// reports MsgBadTree instead.
+var defaultSeverity = map[MsgID]Severity{
+	MsgBadDate:                 SeverityError,
+	MsgBadDateOverflow:         SeverityError,
+	MsgBadEmail:                SeverityError,
+	MsgBadFilemode:             SeverityInfo,
+	MsgBadName:                 SeverityError,
+	MsgBadObjectSha1:           SeverityError,
+	MsgBadParentSha1:           SeverityError,
+	MsgBadTagName:              SeverityInfo,
+	MsgBadTimezone:             SeverityError,
+	MsgBadTree:                 SeverityError,
+	MsgBadTreeSha1:             SeverityError,
+	MsgBadType:                 SeverityError,
+	MsgDuplicateEntries:        SeverityError,
+	MsgEmptyName:               SeverityWarn,
+	MsgExtraHeaderEntry:        SeverityIgnore,
+	MsgFullPathname:            SeverityWarn,
+	MsgGitmodulesBlob:          SeverityError,
+	MsgGitmodulesMissing:       SeverityError,
+	MsgGitmodulesName:          SeverityError,
+	MsgGitmodulesParse:         SeverityInfo,
+	MsgGitmodulesPath:          SeverityError,
+	MsgGitmodulesSymlink:       SeverityError,
+	MsgGitmodulesURL:           SeverityError,
+	MsgGitmodulesUpdate:        SeverityError,
+	MsgHasDot:                  SeverityWarn,
+	MsgHasDotdot:               SeverityWarn,
+	MsgHasDotgit:               SeverityWarn,
+	MsgMissingAuthor:           SeverityError,
+	MsgMissingCommitter:        SeverityError,
+	MsgMissingEmail:            SeverityError,
+	MsgMissingNameBeforeEmail:  SeverityError,
+	MsgMissingObject:           SeverityError,
+	MsgMissingSpaceBeforeDate:  SeverityError,
+	MsgMissingSpaceBeforeEmail: SeverityError,
+	MsgMissingTagEntry:         SeverityError,
+	MsgMissingTaggerEntry:      SeverityInfo,
+	MsgMissingTree:             SeverityError,
+	MsgMissingTypeEntry:        SeverityError,
+	MsgMultipleAuthors:         SeverityError,
+	MsgNulInCommit:             SeverityWarn,
+	MsgNulInHeader:             SeverityError,
+	MsgNullSha1:                SeverityWarn,
+	MsgTreeNotSorted:           SeverityError,
+	MsgTypeMismatch:            SeverityError,
+	MsgUnknownType:             SeverityError,
+	MsgUnterminatedHeader:      SeverityError,
+	MsgZeroPaddedDate:          SeverityError,
+	MsgZeroPaddedFilemode:      SeverityWarn,
+}
+
+// An FsckError describes a defect found in a Git object by Fsck.
+type FsckError struct {
+	Object   ID       // ID of the checked object
+	Type     Type     // type of the checked object
+	ID       MsgID    // kind of the defect
+	Severity Severity // seriousness of the defect
+	Detail   string   // human-readable description of the defect
+}
+
+// Error formats the message like the reference Git client does, e.g.
+// "error in tree <ID>: duplicateEntries: contains duplicate file
+// entries".
+func (e *FsckError) Error() string {
+	return fmt.Sprintf("%s in %s %s: %s: %s",
+		e.Severity, e.Type, e.Object, e.ID, e.Detail)
+}
+
+// Fsck checks Git objects for defects, in the manner of the reference
+// Git client's fsck command.  The zero value checks objects using the
+// default message severities.
+type Fsck struct {
+	// Severity overrides the default severities of the named
+	// messages, like the fsck.<msg-id> configuration variables of
+	// the reference Git client.
+	Severity map[MsgID]Severity
+
+	// If Strict is set, warnings are reported as errors and the
+	// group-writable file mode 100664 is considered bad, as with the
+	// transfer.fsckObjects configuration variable.
+	Strict bool
+
+	// Format is the object format of the checked objects.
+	Format Format
+
+	// Lookup, if non-nil, is used to retrieve the objects referenced
+	// by a checked object, so that their types can be compared with
+	// the ones implied by the references, and so that the contents
+	// of .gitmodules files in checked trees can be inspected.
+	// Objects that Lookup fails to retrieve are not reported, with
+	// the exception of .gitmodules blobs; checking the connectivity
+	// of the object graph is beyond the scope of Fsck.
+	Lookup func(id ID) (Interface, error)
+}
+
+// Check checks the canonical binary representation of a Git object and
+// returns a list of the defects found in it, in the order they were
+// encountered.  Defects whose severity is SeverityIgnore are not
+// included.
+func (f *Fsck) Check(data []byte) []*FsckError {
+	id, _ := f.Format.Sum(data)
+	s := &fsckState{f: f, id: id}
+	objType, body, err := splitHeader(data)
+	s.objType = objType
+	if err != nil {
+		s.report(MsgUnknownType, "%s", err)
+		return s.errs
+	}
+	switch objType {
+	case TypeCommit:
+		s.checkCommit(body)
+	case TypeTree:
+		s.checkTree(body)
+	case TypeBlob:
+		// a blob can contain anything
+	case TypeTag:
+		s.checkTag(body)
+	}
+	if f.Lookup != nil {
+		s.checkRefs()
+		s.checkGitmodulesBlobs()
+	}
+	return s.errs
+}
+
+// CheckObject marshals obj into its canonical binary representation and
+// calls Check on it.  It returns a non-nil error only if obj cannot be
+// marshaled.
+func (f *Fsck) CheckObject(obj Interface) ([]*FsckError, error) {
+	data, _, err := MarshalFormat(obj, f.Format)
+	if err != nil {
+		return nil, err
+	}
+	return f.Check(data), nil
+}
+
+// Validate calls CheckObject on obj and returns the first defect of
+// severity SeverityError found in it, if any.
+func (f *Fsck) Validate(obj Interface) error {
+	errs, err := f.CheckObject(obj)
+	if err != nil {
+		return err
+	}
+	for _, e := range errs {
+		if e.Severity == SeverityError {
+			return e
+		}
+	}
+	return nil
+}
+
+// A fsckRef is a reference to another object, recorded during checking
+// for later comparison with the actual type of the object.
+type fsckRef struct {
+	id      ID
+	objType Type
+}
+
+// fsckState holds the state of a single Fsck.Check call.
+type fsckState struct {
+	f          *Fsck
+	id         ID
+	objType    Type
+	refs       []fsckRef
+	gitmodules []ID // IDs of .gitmodules blobs in a checked tree
+	errs       []*FsckError
+}
+
+func (s *fsckState) severity(msg MsgID) Severity {
+	sev, ok := s.f.Severity[msg]
+	if !ok {
+		sev = defaultSeverity[msg]
+	}
+	if s.f.Strict && sev == SeverityWarn {
+		sev = SeverityError
+	}
+	return sev
+}
+
+// report records a defect of the given kind in the checked object
+// unless its severity is SeverityIgnore.  It returns true if the defect
+// was of severity SeverityError, so that callers can bail out early
+// like fsck.c does.
+func (s *fsckState) report(msg MsgID, format string, a ...interface{}) bool {
+	return s.reportObject(s.id, s.objType, msg, format, a...)
+}
+
+// reportObject is like report, but attributes the defect to the object
+// with the given ID and type.
+func (s *fsckState) reportObject(id ID, objType Type, msg MsgID, format string, a ...interface{}) bool {
+	sev := s.severity(msg)
+	if sev == SeverityIgnore {
+		return false
+	}
+	s.errs = append(s.errs, &FsckError{
+		Object:   id,
+		Type:     objType,
+		ID:       msg,
+		Severity: sev,
+		Detail:   fmt.Sprintf(format, a...),
+	})
+	return sev == SeverityError
+}
+
+func (s *fsckState) checkRefs() {
+	for _, ref := range s.refs {
+		obj, err := s.f.Lookup(ref.id)
+		if err != nil {
+			continue
+		}
+		if t := TypeOf(obj); t != ref.objType {
+			s.report(MsgTypeMismatch, "%s is a %s, not a %s",
+				ref.id, t, ref.objType)
+		}
+	}
+}
+
+func (s *fsckState) checkGitmodulesBlobs() {
+	for _, id := range s.gitmodules {
+		obj, err := s.f.Lookup(id)
+		if err == nil {
+			obj, err = Parse(obj)
+		}
+		if err != nil {
+			s.reportObject(id, TypeBlob, MsgGitmodulesMissing,
+				"unable to read .gitmodules blob: %s", err)
+			continue
+		}
+		blob, ok := obj.(*Blob)
+		if !ok {
+			s.reportObject(id, TypeOf(obj), MsgGitmodulesBlob,
+				"non-blob found at .gitmodules")
+			continue
+		}
+		s.checkGitmodules(id, *blob)
+	}
+}
+
+// verifyHeaders checks that the header of a commit or tag body is free
+// of NUL bytes and terminated by an empty line or the end of the body.
+func (s *fsckState) verifyHeaders(body []byte) bool {
+	end := bytes.Index(body, []byte("\n\n"))
+	if end < 0 {
+		end = len(body)
+	}
+	if bytes.IndexByte(body[:end], 0) >= 0 {
+		return !s.report(MsgNulInHeader, "unterminated header: NUL at offset %d",
+			bytes.IndexByte(body, 0))
+	}
+	if end == len(body) && (len(body) == 0 || body[len(body)-1] != '\n') {
+		return !s.report(MsgUnterminatedHeader, "unterminated header")
+	}
+	return true
+}
+
+// parseHexID parses a hexadecimal object ID of the given format
+// followed by a newline from the start of p and returns it and the rest
+// of p.
+func parseHexID(p []byte, f Format) (ID, []byte, bool) {
+	n := 2 * f.Size()
+	if len(p) < n+1 || p[n] != '\n' {
+		return f.ZeroID(), p, false
+	}
+	id, err := DecodeID(string(p[:n]))
+	if err != nil || strings.ToLower(string(p[:n])) != string(p[:n]) {
+		return id, p, false
+	}
+	return id, p[n+1:], true
+}
+
+func (s *fsckState) checkCommit(body []byte) {
+	if !s.verifyHeaders(body) {
+		return
+	}
+	p := body
+	if !bytes.HasPrefix(p, []byte("tree ")) {
+		s.report(MsgMissingTree, "invalid format - expected 'tree' line")
+		return
+	}
+	id, p, ok := parseHexID(p[len("tree "):], s.f.Format)
+	if !ok {
+		s.report(MsgBadTreeSha1, "invalid 'tree' line format - bad sha1")
+		return
+	}
+	s.refs = append(s.refs, fsckRef{id, TypeTree})
+	for bytes.HasPrefix(p, []byte("parent ")) {
+		id, p, ok = parseHexID(p[len("parent "):], s.f.Format)
+		if !ok {
+			s.report(MsgBadParentSha1, "invalid 'parent' line format - bad sha1")
+			return
+		}
+		s.refs = append(s.refs, fsckRef{id, TypeCommit})
+	}
+	authors := 0
+	for bytes.HasPrefix(p, []byte("author ")) {
+		authors++
+		var ok bool
+		if p, ok = s.checkIdent(p[len("author "):]); !ok {
+			return
+		}
+	}
+	switch {
+	case authors < 1:
+		if s.report(MsgMissingAuthor, "invalid format - expected 'author' line") {
+			return
+		}
+	case authors > 1:
+		if s.report(MsgMultipleAuthors, "invalid format - multiple 'author' lines") {
+			return
+		}
+	}
+	if !bytes.HasPrefix(p, []byte("committer ")) {
+		s.report(MsgMissingCommitter, "invalid format - expected 'committer' line")
+		return
+	}
+	if _, ok := s.checkIdent(p[len("committer "):]); !ok {
+		return
+	}
+	if bytes.IndexByte(body, 0) >= 0 {
+		s.report(MsgNulInCommit, "NUL byte in the commit object body")
+	}
+}
+
+func (s *fsckState) checkTag(body []byte) {
+	if !s.verifyHeaders(body) {
+		return
+	}
+	p := body
+	if !bytes.HasPrefix(p, []byte("object ")) {
+		s.report(MsgMissingObject, "invalid format - expected 'object' line")
+		return
+	}
+	id, p, ok := parseHexID(p[len("object "):], s.f.Format)
+	if !ok {
+		s.report(MsgBadObjectSha1, "invalid 'object' line format - bad sha1")
+		return
+	}
+	if !bytes.HasPrefix(p, []byte("type ")) {
+		s.report(MsgMissingTypeEntry, "invalid format - expected 'type' line")
+		return
+	}
+	p = p[len("type "):]
+	eol := bytes.IndexByte(p, '\n')
+	if eol < 0 {
+		s.report(MsgMissingTypeEntry, "invalid format - unexpected end after 'type' line")
+		return
+	}
+	var objType Type
+	if _, err := fmt.Sscan(string(p[:eol]), &objType); err != nil || objType.String() != string(p[:eol]) {
+		if s.report(MsgBadType, "invalid 'type' value") {
+			return
+		}
+	} else {
+		s.refs = append(s.refs, fsckRef{id, objType})
+	}
+	p = p[eol+1:]
+	if !bytes.HasPrefix(p, []byte("tag ")) {
+		s.report(MsgMissingTagEntry, "invalid format - expected 'tag' line")
+		return
+	}
+	p = p[len("tag "):]
+	eol = bytes.IndexByte(p, '\n')
+	if eol < 0 {
+		s.report(MsgMissingTagEntry, "invalid format - unexpected end after 'tag' line")
+		return
+	}
+	if name := string(p[:eol]); !isValidTagName(name) {
+		if s.report(MsgBadTagName, "invalid 'tag' name: %s", name) {
+			return
+		}
+	}
+	p = p[eol+1:]
+	if !bytes.HasPrefix(p, []byte("tagger ")) {
+		// early tags do not contain 'tagger' lines
+		if s.report(MsgMissingTaggerEntry, "invalid format - expected 'tagger' line") {
+			return
+		}
+	} else if p, ok = s.checkIdent(p[len("tagger "):]); !ok {
+		return
+	}
+	if len(p) > 0 && p[0] != '\n' {
+		s.report(MsgExtraHeaderEntry, "invalid format - extra header(s) after 'tagger'")
+	}
+}
+
+// checkIdent checks the signature line at the start of p, as in
+// fsck.c:fsck_ident.  It returns the rest of p after the line and false
+// if a defect of severity SeverityError was found.
+func (s *fsckState) checkIdent(p []byte) ([]byte, bool) {
+	eol := bytes.IndexByte(p, '\n')
+	if eol < 0 {
+		eol = len(p)
+	}
+	line, rest := p[:eol], p[eol:]
+	if len(rest) > 0 {
+		rest = rest[1:]
+	}
+	// at returns the byte at index i of line, or a newline if i is
+	// past its end, as the C code can rely on strings ending in one.
+	at := func(i int) byte {
+		if i < len(line) {
+			return line[i]
+		}
+		return '\n'
+	}
+	span := func(i int) int {
+		for i < len(line) && !strings.ContainsRune("<>\n", rune(line[i])) {
+			i++
+		}
+		return i
+	}
+	if at(0) == '<' {
+		return rest, !s.report(MsgMissingNameBeforeEmail, "invalid author/committer line - missing space before email")
+	}
+	i := span(0)
+	if at(i) == '>' {
+		return rest, !s.report(MsgBadName, "invalid author/committer line - bad name")
+	}
+	if at(i) != '<' {
+		return rest, !s.report(MsgMissingEmail, "invalid author/committer line - missing email")
+	}
+	if i == 0 || line[i-1] != ' ' {
+		return rest, !s.report(MsgMissingSpaceBeforeEmail, "invalid author/committer line - missing space before email")
+	}
+	i = span(i + 1)
+	if at(i) != '>' {
+		return rest, !s.report(MsgBadEmail, "invalid author/committer line - bad email")
+	}
+	i++
+	if at(i) != ' ' {
+		return rest, !s.report(MsgMissingSpaceBeforeDate, "invalid author/committer line - missing space before date")
+	}
+	i++
+	if at(i) == '0' && at(i+1) != ' ' {
+		return rest, !s.report(MsgZeroPaddedDate, "invalid author/committer line - zero-padded date")
+	}
+	j := i
+	for j < len(line) && '0' <= line[j] && line[j] <= '9' {
+		j++
+	}
+	if j == i || at(j) != ' ' {
+		return rest, !s.report(MsgBadDate, "invalid author/committer line - bad date")
+	}
+	if _, err := strconv.ParseInt(string(line[i:j]), 10, 64); err != nil {
+		return rest, !s.report(MsgBadDateOverflow, "invalid author/committer line - date causes integer overflow")
+	}
+	tz := line[j+1:]
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') ||
+		strings.IndexFunc(string(tz[1:]), func(r rune) bool {
+			return r < '0' || r > '9'
+		}) >= 0 {
+		return rest, !s.report(MsgBadTimezone, "invalid author/committer line - bad time zone")
+	}
+	return rest, true
+}
+
+func (s *fsckState) checkTree(body []byte) {
+	var (
+		prevName   string
+		prevMode   TreeMode
+		seen       = make(map[string]bool)
+		nullSha1   bool
+		fullPath   bool
+		hasDot     bool
+		hasDotdot  bool
+		hasDotgit  bool
+		zeroPadded bool
+		badModes   bool
+		dups       bool
+		unsorted   bool
+	)
+	for first := true; len(body) > 0; first = false {
+		sp := bytes.IndexByte(body, ' ')
+		nul := bytes.IndexByte(body, 0)
+		size := s.f.Format.Size()
+		if sp <= 0 || nul <= sp+1 || len(body) < nul+1+size {
+			s.report(MsgBadTree, "cannot be parsed as a tree")
+			return
+		}
+		modeStr, name := string(body[:sp]), string(body[sp+1:nul])
+		mode64, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil {
+			s.report(MsgBadTree, "cannot be parsed as a tree")
+			return
+		}
+		mode := TreeMode(mode64)
+		id, _ := NewID(s.f.Format, body[nul+1:nul+1+size])
+		body = body[nul+1+size:]
+
+		nullSha1 = nullSha1 || id.IsZero()
+		fullPath = fullPath || strings.IndexByte(name, '/') >= 0
+		hasDot = hasDot || name == "."
+		hasDotdot = hasDotdot || name == ".."
+		hasDotgit = hasDotgit || isDotgit(name)
+		if isDotgitmodules(name) {
+			if mode == ModeSymlink {
+				s.report(MsgGitmodulesSymlink, ".gitmodules is a symbolic link")
+			} else {
+				s.gitmodules = append(s.gitmodules, id)
+			}
+		}
+		zeroPadded = zeroPadded || modeStr[0] == '0'
+		switch mode {
+		case ModeBlob, ModeExec, ModeSymlink, ModeTree, ModeGitlink:
+		case 0100664:
+			badModes = badModes || s.f.Strict
+		default:
+			badModes = true
+		}
+		if t := mode.Type(); t != TypeUnknown && t != TypeCommit {
+			s.refs = append(s.refs, fsckRef{id, t})
+		}
+
+		if seen[name] {
+			dups = true
+		} else if !first && treeKey(prevName, prevMode) > treeKey(name, mode) {
+			unsorted = true
+		}
+		seen[name] = true
+		prevName, prevMode = name, mode
+	}
+
+	if nullSha1 && s.report(MsgNullSha1, "contains entries pointing to null sha1") {
+		return
+	}
+	if fullPath && s.report(MsgFullPathname, "contains full pathnames") {
+		return
+	}
+	if hasDot && s.report(MsgHasDot, "contains '.'") {
+		return
+	}
+	if hasDotdot && s.report(MsgHasDotdot, "contains '..'") {
+		return
+	}
+	if hasDotgit && s.report(MsgHasDotgit, "contains '.git'") {
+		return
+	}
+	if zeroPadded && s.report(MsgZeroPaddedFilemode, "contains zero-padded file modes") {
+		return
+	}
+	if badModes && s.report(MsgBadFilemode, "contains bad file modes") {
+		return
+	}
+	if dups && s.report(MsgDuplicateEntries, "contains duplicate file entries") {
+		return
+	}
+	if unsorted {
+		s.report(MsgTreeNotSorted, "not properly sorted")
+	}
+}
+
+// treeKey returns the key by which a tree entry is sorted in the Git
+// order; see Tree.Names.
+func treeKey(name string, mode TreeMode) string {
+	if mode&0170000 == ModeTree {
+		return name + "/"
+	}
+	return name
+}
+
+// isValidTagName returns true if "refs/tags/" followed by name is a
+// valid refname.  The rules are those of repository.IsValidRef, which
+// cannot be imported here.
+func isValidTagName(name string) bool {
+	name = "refs/tags/" + name
+	return !strings.Contains(name, "/.") &&
+		!strings.Contains(name, "..") &&
+		strings.IndexFunc(name, func(r rune) bool {
+			return r < 0x20 || r == 0x7F || strings.ContainsRune(" ~^:?[", r)
+		}) == -1 &&
+		!strings.HasSuffix(name, "/") &&
+		!strings.Contains(name, "//") &&
+		!strings.HasSuffix(name, ".") &&
+		!strings.HasSuffix(name, ".lock") &&
+		!strings.Contains(name, "@{") &&
+		!strings.Contains(name, `\`)
+}
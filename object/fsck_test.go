@@ -0,0 +1,122 @@
+package object
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// IDs used in the fsck tests.
+const (
+	emptyBlobID = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	emptyTreeID = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	nullID      = "0000000000000000000000000000000000000000"
+)
+
+// entry returns a tree entry in its binary representation.
+func entry(mode, name, id string) string {
+	hex, err := DecodeID(id)
+	if err != nil {
+		panic(err)
+	}
+	return mode + " " + name + "\x00" + string(hex.Bytes())
+}
+
+const ident = "Bugs Bunny <bugs@bunny.com> 1234567890 +0000"
+
+// commitWith returns a commit whose author line is as given.
+func commitWith(author string) string {
+	return "tree " + emptyTreeID + "\n" +
+		"author " + author + "\n" +
+		"committer " + ident + "\n\nmsg\n"
+}
+
+// tagWith returns a tag whose header lines are as given.
+func tagWith(header ...string) string {
+	return strings.Join(header, "\n") + "\n\nmsg\n"
+}
+
+const (
+	tagObject = "object " + emptyTreeID
+	tagType   = "type tree"
+	tagName   = "tag v1"
+	tagTagger = "tagger " + ident
+)
+
+// fsckTests are objects like those of the reference Git client's
+// t1450-fsck.sh, and the messages its fsck reports for them.
+var fsckTests = []struct {
+	typ  Type
+	body string
+	msgs []MsgID
+}{
+	// commits
+	{TypeCommit, commitWith(ident), nil},
+	{TypeCommit, "parent " + emptyTreeID + "\nauthor " + ident + "\ncommitter " + ident + "\n\n", []MsgID{MsgMissingTree}},
+	{TypeCommit, "tree " + emptyTreeID[:39] + "z\nauthor " + ident + "\ncommitter " + ident + "\n\n", []MsgID{MsgBadTreeSha1}},
+	{TypeCommit, "tree " + emptyTreeID + "\nparent 1234\nauthor " + ident + "\ncommitter " + ident + "\n\n", []MsgID{MsgBadParentSha1}},
+	{TypeCommit, "tree " + emptyTreeID + "\ncommitter " + ident + "\n\n", []MsgID{MsgMissingAuthor}},
+	{TypeCommit, "tree " + emptyTreeID + "\nauthor " + ident + "\nauthor " + ident + "\ncommitter " + ident + "\n\n", []MsgID{MsgMultipleAuthors}},
+	{TypeCommit, "tree " + emptyTreeID + "\nauthor " + ident + "\n\n", []MsgID{MsgMissingCommitter}},
+	{TypeCommit, commitWith("Bugs Bunny 1234567890 +0000"), []MsgID{MsgMissingEmail}},
+	{TypeCommit, commitWith("Bugs>Bunny <bugs@bunny.com> 1234567890 +0000"), []MsgID{MsgBadName}},
+	{TypeCommit, commitWith("<bugs@bunny.com> 1234567890 +0000"), []MsgID{MsgMissingNameBeforeEmail}},
+	{TypeCommit, commitWith("Bugs Bunny<bugs@bunny.com> 1234567890 +0000"), []MsgID{MsgMissingSpaceBeforeEmail}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com 1234567890 +0000"), []MsgID{MsgBadEmail}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs<@bunny.com> 1234567890 +0000"), []MsgID{MsgBadEmail}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com>1234567890 +0000"), []MsgID{MsgMissingSpaceBeforeDate}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com> 12345x7890 +0000"), []MsgID{MsgBadDate}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com> 18446744073709551617 +0000"), []MsgID{MsgBadDateOverflow}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com> 0001234567890 +0000"), []MsgID{MsgZeroPaddedDate}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com> 0 +0000"), nil},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com> 1234567890 +00000"), []MsgID{MsgBadTimezone}},
+	{TypeCommit, commitWith("Bugs Bunny <bugs@bunny.com> 1234567890 0000"), []MsgID{MsgBadTimezone}},
+	{TypeCommit, commitWith(ident) + "\x00nul\n", []MsgID{MsgNulInCommit}},
+	{TypeCommit, "tree " + emptyTreeID + "\nauthor " + ident + "\ncommitter " + ident, []MsgID{MsgUnterminatedHeader}},
+	{TypeCommit, "tree " + emptyTreeID + "\nauthor " + ident + "\ncommitter " + ident + "\nextra a\x00b\n\n", []MsgID{MsgNulInHeader}},
+
+	// tags
+	{TypeTag, tagWith(tagObject, tagType, tagName, tagTagger), nil},
+	{TypeTag, tagWith(tagType, tagName, tagTagger), []MsgID{MsgMissingObject}},
+	{TypeTag, tagWith("object 1234", tagType, tagName, tagTagger), []MsgID{MsgBadObjectSha1}},
+	{TypeTag, tagWith(tagObject, tagName, tagTagger), []MsgID{MsgMissingTypeEntry}},
+	{TypeTag, tagWith(tagObject, "type bogus", tagName, tagTagger), []MsgID{MsgBadType}},
+	{TypeTag, tagWith(tagObject, tagType, tagTagger), []MsgID{MsgMissingTagEntry}},
+	{TypeTag, tagWith(tagObject, tagType, "tag v1..2", tagTagger), []MsgID{MsgBadTagName}},
+	{TypeTag, tagWith(tagObject, tagType, tagName), []MsgID{MsgMissingTaggerEntry}},
+	{TypeTag, tagWith(tagObject, tagType, tagName, "tagger Bugs Bunny <bugs@bunny.com 1234567890 +0000"), []MsgID{MsgBadEmail}},
+
+	// trees
+	{TypeTree, entry("100644", "a", emptyBlobID) + entry("40000", "b", emptyTreeID), nil},
+	{TypeTree, entry("100644", "a", nullID), []MsgID{MsgNullSha1}},
+	{TypeTree, entry("100644", "a", emptyBlobID) + entry("100644", "a", emptyBlobID), []MsgID{MsgDuplicateEntries}},
+	{TypeTree, entry("100644", "a", emptyBlobID) + entry("40000", "a", emptyTreeID), []MsgID{MsgDuplicateEntries}},
+	{TypeTree, entry("100644", "b", emptyBlobID) + entry("100644", "a", emptyBlobID), []MsgID{MsgTreeNotSorted}},
+	{TypeTree, entry("40000", "a", emptyTreeID) + entry("100644", "a.b", emptyBlobID), []MsgID{MsgTreeNotSorted}},
+	{TypeTree, entry("0100644", "a", emptyBlobID), []MsgID{MsgZeroPaddedFilemode}},
+	{TypeTree, entry("100664", "a", emptyBlobID), nil},
+	{TypeTree, entry("100600", "a", emptyBlobID), []MsgID{MsgBadFilemode}},
+	{TypeTree, entry("100644", "", emptyBlobID), []MsgID{MsgBadTree}},
+	{TypeTree, entry("100644", "a/b", emptyBlobID), []MsgID{MsgFullPathname}},
+	{TypeTree, entry("40000", ".", emptyTreeID), []MsgID{MsgHasDot}},
+	{TypeTree, entry("40000", "..", emptyTreeID), []MsgID{MsgHasDotdot}},
+	{TypeTree, entry("40000", ".git", emptyTreeID), []MsgID{MsgHasDotgit}},
+	{TypeTree, entry("40000", ".GiT", emptyTreeID), []MsgID{MsgHasDotgit}},
+	{TypeTree, entry("120000", ".gitmodules", emptyBlobID), []MsgID{MsgGitmodulesSymlink}},
+	{TypeTree, entry("120000", "GITMOD~1", emptyBlobID), []MsgID{MsgGitmodulesSymlink}},
+	{TypeTree, entry("120000", ".gitmodules‌", emptyBlobID), []MsgID{MsgGitmodulesSymlink}},
+}
+
+func TestFsck(t *testing.T) {
+	for _, tt := range fsckTests {
+		data := AppendHeader(nil, tt.typ, int64(len(tt.body)))
+		data = append(data, tt.body...)
+		var msgs []MsgID
+		for _, e := range new(Fsck).Check(data) {
+			msgs = append(msgs, e.ID)
+		}
+		if !reflect.DeepEqual(msgs, tt.msgs) {
+			t.Errorf("%s %q: got %v, want %v", tt.typ, tt.body, msgs, tt.msgs)
+		}
+	}
+}
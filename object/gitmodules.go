@@ -0,0 +1,407 @@
+// The functions in this file check the contents of .gitmodules blobs
+// for submodule settings that would cause the reference Git client to
+// write outside the repository or run arbitrary commands when cloning
+// recursively.  As a prerequisite, they include a minimal parser for
+// the git-config(1) file format.
+
+package object
+
+import (
+	"errors"
+	"strings"
+)
+
+var errConfigSyntax = errors.New("object: bad config file syntax")
+
+// A configEntry is a single variable assignment in a config file.
+// Section and key names are lowercased; subsection names are not.
+type configEntry struct {
+	section    string
+	subsection string
+	key        string
+	value      string
+	hasValue   bool // false for "key" without "= value"
+}
+
+// configParser holds the state of a parseConfig call.
+type configParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *configParser) peek() int {
+	if p.pos >= len(p.data) {
+		return -1
+	}
+	return int(p.data[p.pos])
+}
+
+func (p *configParser) next() int {
+	c := p.peek()
+	if c >= 0 {
+		p.pos++
+	}
+	return c
+}
+
+func (p *configParser) skipSpace() {
+	for c := p.peek(); c == ' ' || c == '\t' || c == '\r'; c = p.peek() {
+		p.pos++
+	}
+}
+
+func (p *configParser) skipLine() {
+	for c := p.next(); c >= 0 && c != '\n'; c = p.next() {
+	}
+}
+
+func isConfigKeyChar(c int) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' || c == '-'
+}
+
+// parseConfig parses a config file into a list of its variable
+// assignments.  It returns errConfigSyntax if the file is malformed.
+func parseConfig(data []byte) ([]configEntry, error) {
+	p := &configParser{data: data}
+	var entries []configEntry
+	var section, subsection string
+	inSection := false
+	for {
+		p.skipSpace()
+		switch c := p.peek(); {
+		case c < 0:
+			return entries, nil
+		case c == '\n':
+			p.pos++
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			p.pos++
+			var err error
+			section, subsection, err = p.parseSectionHeader()
+			if err != nil {
+				return nil, err
+			}
+			inSection = true
+		case isConfigKeyChar(c) && inSection:
+			e, err := p.parseVariable()
+			if err != nil {
+				return nil, err
+			}
+			e.section, e.subsection = section, subsection
+			entries = append(entries, e)
+		default:
+			return nil, errConfigSyntax
+		}
+	}
+}
+
+// parseSectionHeader parses a section header following its opening
+// bracket.  Both the modern [section "subsection"] and the deprecated
+// [section.subsection] syntaxes are accepted.
+func (p *configParser) parseSectionHeader() (string, string, error) {
+	var name []byte
+	for {
+		c := p.next()
+		switch {
+		case c == ']':
+			s := strings.ToLower(string(name))
+			if i := strings.IndexByte(s, '.'); i >= 0 {
+				return s[:i], s[i+1:], nil
+			}
+			return s, "", nil
+		case c == ' ' || c == '\t':
+			p.skipSpace()
+			if p.next() != '"' {
+				return "", "", errConfigSyntax
+			}
+			var sub []byte
+			for {
+				c := p.next()
+				switch c {
+				case -1, '\n':
+					return "", "", errConfigSyntax
+				case '\\':
+					c = p.next()
+					if c < 0 || c == '\n' {
+						return "", "", errConfigSyntax
+					}
+				case '"':
+					if p.next() != ']' {
+						return "", "", errConfigSyntax
+					}
+					return strings.ToLower(string(name)), string(sub), nil
+				}
+				sub = append(sub, byte(c))
+			}
+		case isConfigKeyChar(c) || c == '.':
+			name = append(name, byte(c))
+		default:
+			return "", "", errConfigSyntax
+		}
+	}
+}
+
+// parseVariable parses a "key [= value]" line.
+func (p *configParser) parseVariable() (configEntry, error) {
+	var e configEntry
+	start := p.pos
+	for isConfigKeyChar(p.peek()) {
+		p.pos++
+	}
+	e.key = strings.ToLower(string(p.data[start:p.pos]))
+	p.skipSpace()
+	switch p.peek() {
+	case -1, '\n', '#', ';':
+		p.skipLine()
+		return e, nil
+	case '=':
+		p.pos++
+	default:
+		return e, errConfigSyntax
+	}
+	e.hasValue = true
+	var value []byte
+	quoted := false
+	trimmed := 0 // length of value without trailing unquoted space
+	p.skipSpace()
+	for {
+		c := p.next()
+		switch {
+		case c < 0 || c == '\n':
+			if quoted {
+				return e, errConfigSyntax
+			}
+			e.value = string(value[:trimmed])
+			return e, nil
+		case !quoted && (c == '#' || c == ';'):
+			p.skipLine()
+			e.value = string(value[:trimmed])
+			return e, nil
+		case c == '"':
+			quoted = !quoted
+			continue
+		case c == '\\':
+			switch c = p.next(); c {
+			case '\n':
+				continue
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case '\\', '"':
+			default:
+				return e, errConfigSyntax
+			}
+			value = append(value, byte(c))
+			trimmed = len(value)
+			continue
+		}
+		value = append(value, byte(c))
+		if quoted || !strings.ContainsRune(" \t\r", rune(c)) {
+			trimmed = len(value)
+		}
+	}
+}
+
+// checkGitmodules checks the contents of a .gitmodules blob, as in
+// fsck.c:fsck_gitmodules_fn.  The blob's ID is used for reporting.
+func (s *fsckState) checkGitmodules(id ID, data []byte) {
+	entries, err := parseConfig(data)
+	if err != nil {
+		s.reportObject(id, TypeBlob, MsgGitmodulesParse, "could not parse gitmodules blob")
+		return
+	}
+	for _, e := range entries {
+		if e.section != "submodule" || e.subsection == "" {
+			continue
+		}
+		if !isValidSubmoduleName(e.subsection) {
+			s.reportObject(id, TypeBlob, MsgGitmodulesName,
+				"disallowed submodule name: %s", e.subsection)
+		}
+		if !e.hasValue {
+			continue
+		}
+		switch e.key {
+		case "url":
+			if !isValidSubmoduleURL(e.value) {
+				s.reportObject(id, TypeBlob, MsgGitmodulesURL,
+					"disallowed submodule url: %s", e.value)
+			}
+		case "path":
+			if strings.HasPrefix(e.value, "-") {
+				s.reportObject(id, TypeBlob, MsgGitmodulesPath,
+					"disallowed submodule path: %s", e.value)
+			}
+		case "update":
+			if strings.HasPrefix(e.value, "!") {
+				s.reportObject(id, TypeBlob, MsgGitmodulesUpdate,
+					"disallowed submodule update setting: %s", e.value)
+			}
+		}
+	}
+}
+
+// isValidSubmoduleName returns false if name is empty or has ".." as
+// a path component, as the name is used as a path inside the Git
+// directory.
+func isValidSubmoduleName(name string) bool {
+	if name == "" {
+		return false
+	}
+	comps := strings.FieldsFunc(name, func(r rune) bool {
+		return r < 0x80 && isXplatformDirSep(byte(r))
+	})
+	for _, comp := range comps {
+		if comp == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidSubmoduleURL returns false if url could be mistaken for a
+// command-line option, could smuggle a newline into a credential helper
+// query, is a relative URL that escapes its base, or is a URL that the
+// reference Git client would pass to curl without a host, as in
+// submodule-config.c:check_submodule_url.
+func isValidSubmoduleURL(rawurl string) bool {
+	if strings.HasPrefix(rawurl, "-") {
+		return false
+	}
+	if isRelativeURL(rawurl) || strings.HasPrefix(rawurl, "git://") {
+		// the URL could be appended to an HTTP URL and decoded
+		if strings.IndexByte(urlDecode(rawurl), '\n') >= 0 {
+			return false
+		}
+		rest, dotdots := rawurl, 0
+		for {
+			if strings.HasPrefix(rest, "../") || strings.HasPrefix(rest, `..\`) {
+				rest = rest[3:]
+				dotdots++
+			} else if strings.HasPrefix(rest, "./") || strings.HasPrefix(rest, `.\`) {
+				rest = rest[2:]
+			} else {
+				break
+			}
+		}
+		return dotdots == 0 || !(strings.HasPrefix(rest, ":") || strings.HasPrefix(rest, "/"))
+	}
+	if curlURL, ok := urlToCurlURL(rawurl); ok {
+		return isValidCredentialURL(curlURL)
+	}
+	return true
+}
+
+// isRelativeURL reports whether rawurl begins with "./" or "../",
+// where the slash may also be a backslash, as in
+// submodule-config.c:submodule_url_is_relative.
+func isRelativeURL(rawurl string) bool {
+	for _, prefix := range []string{"./", `.\`, "../", `..\`} {
+		if strings.HasPrefix(rawurl, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlToCurlURL returns the URL that the reference Git client's remote
+// helpers pass to curl for rawurl, and whether rawurl is handled by
+// them at all, as in submodule-config.c:url_to_curl_url.
+func urlToCurlURL(rawurl string) (string, bool) {
+	for _, scheme := range []string{"http", "https", "ftp", "ftps"} {
+		if strings.HasPrefix(rawurl, scheme+"::") {
+			return rawurl[len(scheme)+2:], true
+		}
+		if strings.HasPrefix(rawurl, scheme+"://") {
+			return rawurl, true
+		}
+	}
+	return "", false
+}
+
+// isValidCredentialURL returns false if rawurl has no scheme, has no
+// host, or has a component that contains a newline once decoded, as in
+// credential.c:credential_from_url_gently.
+func isValidCredentialURL(rawurl string) bool {
+	i := strings.Index(rawurl, "://")
+	if i < 0 {
+		return false
+	}
+	comps := []string{rawurl[:i]}
+	rest := rawurl[i+3:]
+	slash := strings.IndexAny(rest, "/?#")
+	if slash < 0 {
+		slash = len(rest)
+	}
+	host := rest[:slash]
+	// NOTE(lor): The reference Git client looks for the first "@"
+	// and ":" anywhere in the rest of the URL, not just before the
+	// path, so we do too.
+	at := strings.IndexByte(rest, '@')
+	colon := strings.IndexByte(rest, ':')
+	if at >= 0 && at < slash {
+		if colon < 0 || at <= colon {
+			comps = append(comps, urlDecode(rest[:at]))
+		} else {
+			comps = append(comps, urlDecode(rest[:colon]), urlDecode(rest[colon+1:at]))
+		}
+		host = rest[at+1 : slash]
+	}
+	host = urlDecode(host)
+	if host == "" {
+		return false
+	}
+	comps = append(comps, host)
+	if path := strings.TrimLeft(rest[slash:], "/"); path != "" {
+		comps = append(comps, urlDecode(path))
+	}
+	for _, comp := range comps {
+		if strings.IndexByte(comp, '\n') >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// urlDecode decodes the percent-encoded bytes of s other than NUL, as
+// in url.c:url_decode_mem.  Like it, urlDecode leaves whatever comes
+// before the first colon in s undecoded, taking it to be the scheme,
+// and leaves malformed escapes as they are.
+func urlDecode(s string) string {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		i = 0
+	}
+	b := []byte(s[:i])
+	for ; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			hi, lo := unhex(s[i+1]), unhex(s[i+2])
+			if hi >= 0 && lo >= 0 && hi|lo != 0 {
+				b = append(b, byte(hi<<4|lo))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// unhex returns the value of the hexadecimal digit c, or -1 if c is
+// not one.
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
@@ -0,0 +1,329 @@
+// This file defines the JSON encodings of the Git objects, which take
+// precedence over their textual representations in encoding/json.
+
+package object
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+var errBlobEncoding = errors.New("object: unknown blob content encoding")
+
+// The JSON encodings of the Git objects are as follows, with IDs
+// encoded as hexadecimal strings, as by ID.MarshalText:
+//
+//	Commit:    {"tree": ID, "parents": [ID...], "author": Signature,
+//	            "committer": Signature,
+//	            "extra": [{"key": string, "value": string,
+//	                       "space": true}...],
+//	            "message": string}
+//	Tree:      [{"mode": "100644", "type": "blob", "object": ID,
+//	             "name": string}...]
+//	Blob:      {"encoding": "text" or "base64", "content": string}
+//	Tag:       {"object": ID, "type": "commit", "tag": string,
+//	            "tagger": Signature, "message": string}
+//	Signature: {"name": string, "email": string, "date": Unix time,
+//	            "timestamp": string, "timezone": "+0200", "raw": string}
+//
+// The entries of a tree are listed in the Git order, and their type is
+// ignored when decoding.  The contents of a blob are encoded as text if
+// they are valid UTF-8, and in base64 otherwise.  Likewise, a name,
+// e-mail address, message, tag name or extra header value that is not
+// valid UTF-8 is encoded in base64 in a field of the same name suffixed
+// with "_base64", such as "name_base64", and the field itself is empty.
+// The date and timezone of a signature are omitted if it has none; its
+// timezone is TZ if it matches the offset of Date, and its timestamp is
+// Timestamp, included only if it denotes Date but differs from the date
+// in decimal, as a date with leading zeros does.  The raw form of a
+// signature is included only if it is used in place of the formatted
+// one, and is encoded in base64 like the name.  The "extra" field of a
+// commit is omitted if it has no extra headers, and the "space" field
+// of an extra header if its Space is false.  A Raw is encoded as
+// its parsed form, and cannot be decoded from JSON.
+
+// encodeText returns s and "" if s is valid UTF-8, and "" and s
+// encoded in base64 otherwise, as the values of a JSON string field
+// and its "_base64" counterpart.
+func encodeText(s string) (string, string) {
+	if utf8.ValidString(s) {
+		return s, ""
+	}
+	return "", base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// decodeText returns the string encoded by encodeText as text and b64.
+func decodeText(text, b64 string) (string, error) {
+	if b64 == "" {
+		return text, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(b64)
+	return string(b), err
+}
+
+type jsonSignature struct {
+	Name        string `json:"name"`
+	NameBase64  string `json:"name_base64,omitempty"`
+	Email       string `json:"email"`
+	EmailBase64 string `json:"email_base64,omitempty"`
+	Date        *int64 `json:"date,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	Raw         string `json:"raw,omitempty"`
+	RawBase64   string `json:"raw_base64,omitempty"`
+}
+
+func (s Signature) MarshalJSON() ([]byte, error) {
+	var js jsonSignature
+	js.Name, js.NameBase64 = encodeText(s.Name)
+	js.Email, js.EmailBase64 = encodeText(s.Email)
+	if !s.Date.IsZero() || s.TZ != "" {
+		unix := s.Date.Unix()
+		js.Date, js.Timezone = &unix, string(appendTZ(nil, s))
+		if ts := string(appendTimestamp(nil, s)); ts != strconv.FormatInt(unix, 10) {
+			js.Timestamp = ts
+		}
+	}
+	if s.Raw != "" {
+		canonical := s
+		canonical.Raw = ""
+		if s.String() != canonical.String() {
+			js.Raw, js.RawBase64 = encodeText(s.Raw)
+		}
+	}
+	return json.Marshal(js)
+}
+
+func (s *Signature) UnmarshalJSON(data []byte) error {
+	var js jsonSignature
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	name, err := decodeText(js.Name, js.NameBase64)
+	if err != nil {
+		return err
+	}
+	email, err := decodeText(js.Email, js.EmailBase64)
+	if err != nil {
+		return err
+	}
+	raw, err := decodeText(js.Raw, js.RawBase64)
+	if err != nil {
+		return err
+	}
+	*s = Signature{Name: name, Email: email, Raw: raw}
+	if js.Date != nil || js.Timezone != "" {
+		var unix int64
+		if js.Date != nil {
+			unix = *js.Date
+		}
+		offset, _ := parseTZ(js.Timezone)
+		s.Date = time.Unix(unix, 0).In(time.FixedZone("", offset))
+		s.Timestamp, s.TZ = js.Timestamp, js.Timezone
+	}
+	return nil
+}
+
+type jsonExtraHeader struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ValueBase64 string `json:"value_base64,omitempty"`
+	Space       bool   `json:"space,omitempty"`
+}
+
+type jsonCommit struct {
+	Tree          ID                `json:"tree"`
+	Parents       []ID              `json:"parents"`
+	Author        Signature         `json:"author"`
+	Committer     Signature         `json:"committer"`
+	Extra         []jsonExtraHeader `json:"extra,omitempty"`
+	Message       string            `json:"message"`
+	MessageBase64 string            `json:"message_base64,omitempty"`
+}
+
+func (c *Commit) MarshalJSON() ([]byte, error) {
+	jc := jsonCommit{
+		Tree:      c.Tree,
+		Parents:   c.Parent,
+		Author:    c.Author,
+		Committer: c.Committer,
+	}
+	jc.Message, jc.MessageBase64 = encodeText(c.Message)
+	if jc.Parents == nil {
+		jc.Parents = []ID{}
+	}
+	for _, h := range c.Extra {
+		jh := jsonExtraHeader{Key: h.Key, Space: h.Space}
+		jh.Value, jh.ValueBase64 = encodeText(h.Value)
+		jc.Extra = append(jc.Extra, jh)
+	}
+	return json.Marshal(jc)
+}
+
+func (c *Commit) UnmarshalJSON(data []byte) error {
+	var jc jsonCommit
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return err
+	}
+	msg, err := decodeText(jc.Message, jc.MessageBase64)
+	if err != nil {
+		return err
+	}
+	*c = Commit{
+		Tree:      jc.Tree,
+		Parent:    jc.Parents,
+		Author:    jc.Author,
+		Committer: jc.Committer,
+		Message:   msg,
+	}
+	if len(c.Parent) == 0 {
+		c.Parent = nil
+	}
+	for _, h := range jc.Extra {
+		value, err := decodeText(h.Value, h.ValueBase64)
+		if err != nil {
+			return err
+		}
+		c.Extra = append(c.Extra, ExtraHeader{h.Key, value, h.Space})
+	}
+	return nil
+}
+
+type jsonTreeEntry struct {
+	Mode       string `json:"mode"`
+	Type       string `json:"type"`
+	Object     ID     `json:"object"`
+	Name       string `json:"name"`
+	NameBase64 string `json:"name_base64,omitempty"`
+}
+
+func (t *Tree) MarshalJSON() ([]byte, error) {
+	entries := make([]jsonTreeEntry, 0, len(*t))
+	for _, name := range t.Names() {
+		ti := (*t)[name]
+		e := jsonTreeEntry{
+			Mode:   string(appendMode(nil, ti.Mode)),
+			Type:   ti.Mode.Type().String(),
+			Object: ti.Object,
+		}
+		e.Name, e.NameBase64 = encodeText(name)
+		entries = append(entries, e)
+	}
+	return json.Marshal(entries)
+}
+
+func (t *Tree) UnmarshalJSON(data []byte) error {
+	var entries []jsonTreeEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	if *t == nil {
+		*t = make(Tree)
+	}
+	for _, e := range entries {
+		mode, ok := parseUint([]byte(e.Mode), 8)
+		if !ok || mode > 1<<32-1 {
+			return errTreeEntry
+		}
+		name, err := decodeText(e.Name, e.NameBase64)
+		if err != nil {
+			return err
+		}
+		(*t)[name] = TreeInfo{TreeMode(mode), e.Object}
+	}
+	return nil
+}
+
+type jsonBlob struct {
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+}
+
+func (b *Blob) MarshalJSON() ([]byte, error) {
+	jb := jsonBlob{Encoding: "text", Content: string(*b)}
+	if !utf8.Valid(*b) {
+		jb.Encoding = "base64"
+		jb.Content = base64.StdEncoding.EncodeToString(*b)
+	}
+	return json.Marshal(jb)
+}
+
+func (b *Blob) UnmarshalJSON(data []byte) error {
+	var jb jsonBlob
+	if err := json.Unmarshal(data, &jb); err != nil {
+		return err
+	}
+	switch jb.Encoding {
+	case "text":
+		*b = Blob(jb.Content)
+	case "base64":
+		content, err := base64.StdEncoding.DecodeString(jb.Content)
+		if err != nil {
+			return err
+		}
+		*b = Blob(content)
+	default:
+		return errBlobEncoding
+	}
+	return nil
+}
+
+type jsonTag struct {
+	Object        ID        `json:"object"`
+	Type          string    `json:"type"`
+	Tag           string    `json:"tag"`
+	TagBase64     string    `json:"tag_base64,omitempty"`
+	Tagger        Signature `json:"tagger"`
+	Message       string    `json:"message"`
+	MessageBase64 string    `json:"message_base64,omitempty"`
+}
+
+func (t *Tag) MarshalJSON() ([]byte, error) {
+	jt := jsonTag{
+		Object: t.Object,
+		Type:   t.Type.String(),
+		Tagger: t.Tagger,
+	}
+	jt.Tag, jt.TagBase64 = encodeText(t.Tag)
+	jt.Message, jt.MessageBase64 = encodeText(t.Message)
+	return json.Marshal(jt)
+}
+
+func (t *Tag) UnmarshalJSON(data []byte) error {
+	var jt jsonTag
+	if err := json.Unmarshal(data, &jt); err != nil {
+		return err
+	}
+	objType, err := parseType([]byte(jt.Type))
+	if err != nil {
+		return err
+	}
+	name, err := decodeText(jt.Tag, jt.TagBase64)
+	if err != nil {
+		return err
+	}
+	msg, err := decodeText(jt.Message, jt.MessageBase64)
+	if err != nil {
+		return err
+	}
+	*t = Tag{
+		Object:  jt.Object,
+		Type:    objType,
+		Tag:     name,
+		Tagger:  jt.Tagger,
+		Message: msg,
+	}
+	return nil
+}
+
+func (r *Raw) MarshalJSON() ([]byte, error) {
+	obj, err := r.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
@@ -0,0 +1,87 @@
+package object
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsonRoundTrip encodes obj as JSON and decodes it into a new object of
+// the same type, and reports whether it keeps its ID.
+func jsonRoundTrip(t *testing.T, obj Interface) {
+	want, err := Hash(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Errorf("%s: json.Marshal: %v", want, err)
+		return
+	}
+	got, err := New(TypeOf(obj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Errorf("%s: json.Unmarshal(%s): %v", want, data, err)
+		return
+	}
+	if id, err := Hash(got); err != nil || id != want {
+		t.Errorf("%s: decoded from %s with ID %s, %v", want, data, id, err)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for name, data := range readFixtures(t) {
+		obj, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		jsonRoundTrip(t, obj)
+	}
+}
+
+func TestJSONInvalidUTF8(t *testing.T) {
+	const latin1 = "Fr\xe9d\xe9ric"
+	sig := Signature{
+		Name:  latin1,
+		Email: latin1 + "@example.com",
+		Date:  time.Unix(1112911993, 0).UTC(),
+	}
+	blob := Blob("blob\n")
+	blobID, err := Hash(&blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := &Tree{latin1: {ModeBlob, blobID}, "\xff": {ModeBlob, blobID}}
+	treeID, err := Hash(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := &Commit{
+		Tree:      treeID,
+		Author:    sig,
+		Committer: sig,
+		Extra:     []ExtraHeader{{Key: "encoding", Value: "ISO-8859-1"}, {Key: "x-note", Value: latin1}},
+		Message:   latin1 + "\n",
+	}
+	tag := &Tag{
+		Object:  treeID,
+		Type:    TypeTree,
+		Tag:     latin1,
+		Tagger:  sig,
+		Message: latin1 + "\n",
+	}
+	for _, obj := range []Interface{tree, commit, tag} {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), `\ufffd`) {
+			t.Errorf("%s encoded with replacement characters: %s", TypeOf(obj), data)
+		}
+		jsonRoundTrip(t, obj)
+	}
+}
@@ -2,12 +2,13 @@
 package object
 
 import (
-	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var errBadIDLen = errors.New("object: invalid ID length")
@@ -15,16 +16,20 @@ var errBadIDLen = errors.New("object: invalid ID length")
 // Interface defines the functionality expected of a Git object.
 //
 // A Git object has a canonical binary representation (see
-// http://git.rsbx.net/Documents/Git_Data_Formats.txt), whose SHA-1
-// digest is the object's name.  The methods MarshalBinary and
-// UnmarshalBinary encode and decode Git objects to and from these
-// representations.  An object additionally has a human-readable
-// representation (returned by the reference Git client's "cat-file -p"
-// command), which is encoded and decoded with MarshalText and
-// UnmarshalText.  For all objects except Tree, the binary
+// http://git.rsbx.net/Documents/Git_Data_Formats.txt), whose SHA-1 or
+// SHA-256 digest (see Format) is the object's name.  The methods
+// MarshalBinary and UnmarshalBinary encode and decode Git objects to
+// and from these representations.  An object additionally has a
+// human-readable representation (returned by the reference Git client's
+// "cat-file -p" command), which is encoded and decoded with MarshalText
+// and UnmarshalText.  For all objects except Tree, the binary
 // representation is just the textual representation prefixed with the
 // Git object header.
 //
+// The Raw type holds any of the standard Git objects in its binary
+// representation, and is used by code that needs to preserve objects
+// exactly as they were received.
+//
 // Though it is possible for an external type to satisfy this interface,
 // functions operating on it should not be expected to work with
 // implementations other than the ones defined in this package.
@@ -46,14 +51,14 @@ type Interface interface {
 // input sanitization, so it is possible to unmarshal objects that the
 // standard Git implementation would never create, and even to create
 // objects that cannot be unmarshaled once marshaled.  Use care and
-// common sense when manipulating the objects.
+// common sense when manipulating the objects, and Fsck to check
+// objects received from untrusted sources.
 
-// BUG(lor): Interface probably shouldn't embed TextMarshaler and
-// -Unmarshaler, since the objects' textual representations aren't
-// canonical by any real measure, which the two methods imply.  For
-// instance, json.Marshal tries MarshalText before using reflection,
-// which probably isn't what you want if you want to serialize Git
-// objects in JSON.
+// NOTE(lor): The textual representations of the objects aren't
+// canonical by any real measure, which embedding TextMarshaler and
+// -Unmarshaler in Interface implies.  The objects therefore implement
+// json.Marshaler and -Unmarshaler too, as encoding/json would otherwise
+// use their textual representations; see json.go for the encodings.
 
 // New returns a pointer to a newly allocated zero value of a Git object
 // of the given type.  It returns a TypeError containing the objType
@@ -74,73 +79,183 @@ func New(objType Type) (Interface, error) {
 	}
 }
 
-// Marshal returns the canonical binary representation and the ID of the
-// given object.  It returns a TypeError containing obj if it is not one
-// of the standard Git objects.
+// Marshal returns the canonical binary representation and the SHA-1 ID
+// of the given object.  It returns a TypeError containing obj if it is
+// not one of the standard Git objects.
 func Marshal(obj Interface) ([]byte, ID, error) {
+	return MarshalFormat(obj, SHA1)
+}
+
+// MarshalFormat is like Marshal, but computes the object's ID in the
+// given format.  The IDs referenced by obj should be of the same format.
+// If the representation contains a SHA-1 collision attack, it is
+// returned along with its ID and ErrCollision.
+func MarshalFormat(obj Interface, f Format) ([]byte, ID, error) {
 	if TypeOf(obj) == TypeUnknown {
-		return nil, ZeroID, &TypeError{obj}
+		return nil, f.ZeroID(), &TypeError{obj}
 	}
 	data, err := obj.MarshalBinary()
-	return data, ID(sha1.Sum(data)), err
+	if err != nil {
+		return nil, f.ZeroID(), err
+	}
+	id, err := f.Sum(data)
+	return data, id, err
 }
 
 // Unmarshal decodes a Git object from its canonical binary
 // representation.  If the type recorded in the Git object header does
 // not match one of the standard Git ones, it is returned as a string
-// inside a TypeError.
+// inside a TypeError.  Trees are assumed to contain SHA-1 IDs.
 func Unmarshal(data []byte) (Interface, error) {
-	r := bytes.NewReader(data)
-	var objType Type
-	var length int
-	if _, err := fmt.Fscanf(r, "%s %d\x00", &objType, &length); err != nil {
+	return UnmarshalFormat(data, SHA1)
+}
+
+// UnmarshalFormat is like Unmarshal, but decodes the IDs of tree
+// entries in the given format.  (The IDs in commits and tags are
+// recognized by their length.)
+func UnmarshalFormat(data []byte, f Format) (Interface, error) {
+	objType, _, _, err := ParseHeader(data)
+	if err != nil {
 		return nil, err
 	}
 	obj, _ := New(objType)
+	if t, ok := obj.(*Tree); ok {
+		return t, t.unmarshalBinary(data, f)
+	}
 	return obj, obj.UnmarshalBinary(data)
 }
 
-// An ID is the name of a Git object.
-type ID [sha1.Size]byte
+// An ID is the name of a Git object: the digest of its binary
+// representation in one of the supported object formats.  IDs are
+// comparable, and IDs of different formats are never equal.  The zero
+// value is the all-zeroes SHA-1 ID.
+type ID struct {
+	sum    [sha256.Size]byte
+	format Format
+}
 
-// ZeroID (20 zero bytes) is used to designate a nonexistent object.
+// ZeroID (20 zero bytes) is used to designate a nonexistent object in
+// SHA-1 repositories.  Use Format.ZeroID and ID.IsZero for code that
+// needs to work with other formats.
 var ZeroID ID
 
-// Hash computes the ID of a Git object.  It returns a TypeError
+// Hash computes the SHA-1 ID of a Git object.  It returns a TypeError
 // containing obj if it is not one of the standard Git objects.
 func Hash(obj Interface) (ID, error) {
-	_, id, err := Marshal(obj)
+	return HashFormat(obj, SHA1)
+}
+
+// HashFormat is like Hash, but computes the ID in the given format.
+func HashFormat(obj Interface, f Format) (ID, error) {
+	_, id, err := MarshalFormat(obj, f)
 	return id, err
 }
 
-// DecodeID parses a 40-character hexadecimal string as a Git ID.
+// NewID returns the ID of the given format with the given binary value.
+// It returns an error if b is not of the length of the format's IDs.
+func NewID(f Format, b []byte) (ID, error) {
+	id := ID{format: f}
+	if len(b) != f.Size() {
+		return id, errBadIDLen
+	}
+	copy(id.sum[:], b)
+	return id, nil
+}
+
+// DecodeID parses a 40- or 64-character hexadecimal string as a SHA-1
+// or SHA-256 ID respectively.
 func DecodeID(s string) (id ID, err error) {
 	b, err := hex.DecodeString(s)
 	switch {
 	case err != nil:
 		return id, err
-	case len(b) != len(id):
-		return id, errBadIDLen
+	case len(b) == sha1.Size:
+		return NewID(SHA1, b)
+	case len(b) == sha256.Size:
+		return NewID(SHA256, b)
 	}
-	copy(id[:], b)
-	return id, err
+	return id, errBadIDLen
+}
+
+// Format returns the object format of the ID.
+func (id ID) Format() Format {
+	return id.format
 }
 
-// String returns the ID as a lowercase 40-digit hexadecimal string.
+// Bytes returns the binary value of the ID.
+func (id ID) Bytes() []byte {
+	return id.sum[:id.format.Size()]
+}
+
+// IsZero returns true if the ID is all zeroes, whatever its format.
+func (id ID) IsZero() bool {
+	return id == id.format.ZeroID()
+}
+
+// String returns the ID as a lowercase hexadecimal string of 40 or 64
+// digits.
 func (id ID) String() string {
-	return hex.EncodeToString(id[:])
+	return hex.EncodeToString(id.Bytes())
+}
+
+// HasPrefix reports whether the hexadecimal representation of the ID,
+// as returned by String, begins with the given prefix.  The prefix is
+// case-insensitive.
+func (id ID) HasPrefix(prefix string) bool {
+	if len(prefix) > 2*id.format.Size() {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		nibble := id.sum[i/2] >> 4
+		if i%2 == 1 {
+			nibble = id.sum[i/2] & 0xF
+		}
+		c := prefix[i]
+		if 'A' <= c && c <= 'F' {
+			c += 'a' - 'A'
+		}
+		if c != hexDigits[nibble] {
+			return false
+		}
+	}
+	return true
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendHex appends the ID to b as returned by String and returns the
+// extended slice.
+func (id ID) appendHex(b []byte) []byte {
+	n := len(b)
+	for i := 0; i < 2*id.format.Size(); i++ {
+		b = append(b, 0)
+	}
+	hex.Encode(b[n:], id.Bytes())
+	return b
 }
 
 // Scan is a support routine for fmt.Scanner.  The format verb is
-// ignored; Scan always attempts to read 40 hexadecimal digits from
-// the input.
+// ignored; Scan always attempts to read 40 or 64 hexadecimal digits
+// from the input.
 func (id *ID) Scan(ss fmt.ScanState, verb rune) error {
-	var p []byte
-	if _, err := fmt.Fscanf(ss, "%40x", &p); err != nil {
+	tok, err := ss.Token(true, func(r rune) bool {
+		return strings.ContainsRune("0123456789abcdefABCDEF", r)
+	})
+	if err != nil {
 		return err
 	}
-	if copy((*id)[:], p) != len(*id) {
-		return errBadIDLen
-	}
-	return nil
+	*id, err = DecodeID(string(tok))
+	return err
+}
+
+// MarshalText returns the ID as returned by String.
+func (id ID) MarshalText() ([]byte, error) {
+	return id.appendHex(nil), nil
+}
+
+// UnmarshalText parses an ID as with DecodeID.
+func (id *ID) UnmarshalText(text []byte) error {
+	var err error
+	*id, err = parseID(text)
+	return err
 }
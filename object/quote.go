@@ -0,0 +1,105 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var errBadQuote = errors.New("object: malformed quoted filename")
+
+// The bytes that QuotePath escapes with a letter, and the letters.
+const (
+	cEscapeBytes   = "\a\b\t\n\v\f\r\"\\"
+	cEscapeLetters = "abtnvfr\"\\"
+)
+
+// needsQuoteC reports whether QuotePath would quote the byte.
+func needsQuoteC(c byte) bool {
+	return c < 0x20 || c == '"' || c == '\\' || c >= 0x7F
+}
+
+// QuotePath returns name quoted the way the reference Git client quotes
+// filenames in its output (with core.quotePath set, as it is by
+// default): if name contains control characters, double quotes,
+// backslashes or non-ASCII bytes, it is enclosed in double quotes and
+// those bytes are escaped with C-style backslash sequences, and it is
+// returned unchanged otherwise.
+func QuotePath(name string) string {
+	i := 0
+	for i < len(name) && !needsQuoteC(name[i]) {
+		i++
+	}
+	if i == len(name) {
+		return name
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte('"')
+	buf.WriteString(name[:i])
+	for ; i < len(name); i++ {
+		c := name[i]
+		switch j := strings.IndexByte(cEscapeBytes, c); {
+		case j >= 0:
+			buf.WriteByte('\\')
+			buf.WriteByte(cEscapeLetters[j])
+		case needsQuoteC(c):
+			buf.WriteByte('\\')
+			buf.WriteByte('0' + c>>6)
+			buf.WriteByte('0' + c>>3&7)
+			buf.WriteByte('0' + c&7)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// unquoteC reverses QuotePath.  Names that do not begin with a double
+// quote are returned unchanged.
+func unquoteC(s string) (string, error) {
+	if len(s) == 0 || s[0] != '"' {
+		return s, nil
+	}
+	if len(s) < 2 || s[len(s)-1] != '"' {
+		return "", errBadQuote
+	}
+	s = s[1 : len(s)-1]
+	buf := new(bytes.Buffer)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return "", errBadQuote
+		case c != '\\':
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errBadQuote
+		}
+		c = s[i]
+		if '0' <= c && c <= '3' {
+			if i+2 >= len(s) {
+				return "", errBadQuote
+			}
+			b := c - '0'
+			for _, d := range []byte(s[i+1 : i+3]) {
+				if d < '0' || d > '7' {
+					return "", errBadQuote
+				}
+				b = b<<3 | (d - '0')
+			}
+			buf.WriteByte(b)
+			i += 2
+			continue
+		}
+		j := strings.IndexByte(cEscapeLetters, c)
+		if j < 0 {
+			return "", errBadQuote
+		}
+		buf.WriteByte(cEscapeBytes[j])
+	}
+	return buf.String(), nil
+}
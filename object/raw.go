@@ -0,0 +1,117 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+)
+
+var errRawText = errors.New("object: a Raw cannot be unmarshaled from text")
+
+// A Raw is a Git object held in its canonical binary representation.
+// Unlike the other object types of this package, a Raw reproduces the
+// representation it was created from exactly, even if the
+// representation is one the other types could not produce (e.g. a tree
+// with zero-padded modes), so its ID never changes.  The object's
+// parsed form is decoded only when requested with Parse.
+//
+// A Raw has no textual representation of its own: MarshalText returns
+// that of its parsed form, and UnmarshalText always fails.
+type Raw struct {
+	data   []byte
+	format Format
+}
+
+// NewRaw returns a Raw holding the given binary representation of a
+// SHA-1 Git object.  It returns an error if data does not begin with a
+// valid Git object header.  The new Raw takes ownership of data, and
+// the caller should not use it after this call.
+func NewRaw(data []byte) (*Raw, error) {
+	return NewRawFormat(data, SHA1)
+}
+
+// NewRawFormat is like NewRaw, but the object is of the given format.
+func NewRawFormat(data []byte, f Format) (*Raw, error) {
+	if _, _, err := splitHeader(data); err != nil {
+		return nil, err
+	}
+	return &Raw{data: data, format: f}, nil
+}
+
+// Format returns the object format of the Raw, which determines how the
+// IDs of its tree entries are decoded.
+func (r *Raw) Format() Format {
+	return r.format
+}
+
+// Type returns the type recorded in the object's header, or
+// TypeUnknown if the Raw is empty.
+func (r *Raw) Type() Type {
+	if r.data == nil {
+		return TypeUnknown
+	}
+	t, _, _ := splitHeader(r.data)
+	return t
+}
+
+// Size returns the length of the object's binary representation
+// without the Git object header.
+func (r *Raw) Size() int {
+	return len(r.data) - bytes.IndexByte(r.data, 0) - 1
+}
+
+// Parse decodes and returns the parsed form of the object: a *Commit,
+// *Tree, *Blob or *Tag.  The parsed form is decoded anew on every call,
+// so changes made to it do not affect the Raw or later calls.
+func (r *Raw) Parse() (Interface, error) {
+	return UnmarshalFormat(r.data, r.format)
+}
+
+func (r *Raw) MarshalBinary() ([]byte, error) {
+	return r.AppendBinary(make([]byte, 0, len(r.data)))
+}
+
+// AppendBinary appends the binary representation of the object to b
+// and returns the extended slice.
+func (r *Raw) AppendBinary(b []byte) ([]byte, error) {
+	return append(b, r.data...), nil
+}
+
+func (r *Raw) UnmarshalBinary(data []byte) error {
+	if _, _, err := splitHeader(data); err != nil {
+		return err
+	}
+	r.data = make([]byte, len(data))
+	copy(r.data, data)
+	return nil
+}
+
+func (r *Raw) MarshalText() ([]byte, error) {
+	obj, err := r.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return obj.MarshalText()
+}
+
+// AppendText appends the textual representation of the object's parsed
+// form to b and returns the extended slice.
+func (r *Raw) AppendText(b []byte) ([]byte, error) {
+	text, err := r.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, text...), nil
+}
+
+func (r *Raw) UnmarshalText(text []byte) error {
+	return errRawText
+}
+
+// Parse returns the parsed form of obj: if obj is a *Raw, the result of
+// its Parse method, and otherwise obj itself.
+func Parse(obj Interface) (Interface, error) {
+	if r, ok := obj.(*Raw); ok {
+		return r.Parse()
+	}
+	return obj, nil
+}
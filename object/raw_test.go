@@ -0,0 +1,31 @@
+package object
+
+import (
+	"testing"
+)
+
+func TestRawParse(t *testing.T) {
+	data := readFixtures(t)[benchCommit]
+	r, err := NewRaw(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := r.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj.(*Commit).Message = "changed\n"
+	again, err := r.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == obj {
+		t.Error("Parse returned the same object twice")
+	}
+	if msg := again.(*Commit).Message; msg == "changed\n" {
+		t.Error("change to a parsed Raw seen by a later Parse")
+	}
+	if id, err := Hash(r); err != nil || id.String() != benchCommit {
+		t.Errorf("Raw changed to ID %s, %v", id, err)
+	}
+}
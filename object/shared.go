@@ -3,114 +3,68 @@
 package object
 
 import (
-	"bytes"
 	"fmt"
-	"strings"
 	"time"
 )
 
-// A safeString is a string that may not contain the bytes [\x00\x0A<>]
-// nor begin nor end with the bytes [ .,:;<>"'].  safeStrings are used
-// as author names and e-mail addresses in Git.
-type safeString string
-
-func (s *safeString) Scan(ss fmt.ScanState, verb rune) error {
-	safe, err := getToken(ss, "\x00\x0A<>", " .,:;<>\"'")
-	if err != nil {
-		return err
-	}
-	*s = safeString(safe)
-	return nil
-}
-
-// getToken finds the longest prefix of ss that contains no runes in
-// delimset and returns it trimmed of all leading and trailing runes in
-// trimset.
-func getToken(ss fmt.ScanState, delimset, trimset string) (string, error) {
-	p := func(r rune) bool { return !strings.ContainsRune(delimset, r) }
-	tok, err := ss.Token(false, p)
-	if err != nil {
-		return "", err
-	}
-	return strings.Trim(string(tok), trimset), nil
-}
-
 // A Signature tells the author and date of a Git commit or tag.
 type Signature struct {
 	Name  string
 	Email string
 	Date  time.Time
+
+	// Timestamp is the Unix time of Date as recorded in the object,
+	// in decimal.  Git accepts dates with leading zeros, and dates
+	// too large for a time.Time, which it takes to be the epoch.
+	// String writes Timestamp in place of Date's Unix time if the
+	// two denote the same time, as it does with TZ.
+	Timestamp string
+
+	// TZ is the timezone offset of Date as recorded in the object,
+	// such as "+0200".  Git writes offsets with a sign and four
+	// digits, but accepts others, such as "-0000" or "+05", which a
+	// time.Location cannot tell apart from their canonical forms.
+	// String writes TZ in place of Date's offset if the two denote
+	// the same offset, so that signatures keep their exact form when
+	// an object is decoded and encoded again.
+	TZ string
+
+	// Raw is the signature as recorded in the object.  Git accepts
+	// signatures that String would not produce, such as ones with
+	// several spaces between their parts, text after the timezone,
+	// or a malformed date, which is ignored.  String returns Raw if
+	// it parses to the signature's Name and Email and to a date that
+	// String formats like the signature's, so that such signatures
+	// are not rewritten either.
+	Raw string
 }
 
 // String returns the Signature in the format "Name <Email> Date",
-// where Date is formatted as the Unix time followed by a space and
-// a four-digits-plus-sign timezone offset.
+// where Date is formatted as a Unix time followed by a space and a
+// timezone offset.  The Unix time is Timestamp, or if it does not
+// denote Date, Date's Unix time in decimal; the offset is TZ, or if it
+// does not match Date's offset, a sign followed by four digits.  If
+// Date is the zero time and TZ is empty, the date is omitted.  If Raw
+// denotes the signature, String returns it instead.
 func (s Signature) String() string {
-	return fmt.Sprintf("%s <%s> %d %s",
-		s.Name,
-		s.Email,
-		s.Date.Unix(),
-		s.Date.Format("-0700"),
-	)
+	return string(appendSignature(nil, s))
 }
 
 // Scan is a support routine for fmt.Scanner.  The format verb is
 // ignored; Scan always attempts to read a signature string as returned
-// by String from the input.
+// by String from the rest of the input line, parsing it like the
+// reference Git client does.
 func (s *Signature) Scan(ss fmt.ScanState, verb rune) error {
-	var (
-		name   safeString
-		email  safeString
-		unix   int64
-		offset int
-	)
-	// XXX(lor): There should be a space between %s and <%s> in the
-	// format string, but unfortunately scanning a safeString stops
-	// only at an angle bracket, at which point it's too late to
-	// unread the preceding space back into the scanner.
-	_, err := fmt.Fscanf(ss, "%s<%s> %d %05d",
-		&name,
-		&email,
-		&unix,
-		&offset,
-	)
+	tok, err := ss.Token(false, func(r rune) bool { return r != '\n' })
 	if err != nil {
 		return err
 	}
-	offset = (offset/100)*60*60 + (offset%100)*60
-	s.Name = string(name)
-	s.Email = string(email)
-	s.Date = time.Unix(unix, 0).In(time.FixedZone("", offset))
-	return nil
-}
-
-// A fmtErr can be used to cache errors from fmt function calls for
-// later inspection, while passing the byte/argument counts they return
-// through unmolested.
-type fmtErr struct {
-	err error
-}
-
-func (e *fmtErr) Check(n int, err error) int {
-	if e.err == nil {
-		e.err = err
-	}
-	return n
-}
-
-func (e *fmtErr) Err() error {
-	return e.err
-}
-
-// prependHeader prepends a Git object header to an object's binary
-// representation.  It returns a TypeError containing the objType
-// argument if it is not one of the standard Git ones.
-func prependHeader(objType Type, data []byte) ([]byte, error) {
-	if objType.String() == "" {
-		return nil, &TypeError{objType}
+	sig, err := parseSignature(tok)
+	if err != nil {
+		return err
 	}
-	header := []byte(fmt.Sprintf("%s %d\x00", objType, len(data)))
-	return append(header, data...), nil
+	*s = sig
+	return nil
 }
 
 // stripHeader strips the Git object header from an object's binary
@@ -122,18 +76,29 @@ func stripHeader(objType Type, data []byte) ([]byte, error) {
 	if objType.String() == "" {
 		return nil, &TypeError{objType}
 	}
-	buf := bytes.NewBuffer(data)
-	var bufType Type
-	var length int
-	_, err := fmt.Fscanf(buf, "%s %d\x00", &bufType, &length)
+	bufType, data, err := splitHeader(data)
 	switch {
 	case err != nil:
 		return nil, err
 	case bufType != objType:
 		return nil, fmt.Errorf("object: expected type %s, got %s", objType, bufType)
-	case length != buf.Len():
-		return nil, fmt.Errorf("object: expected length %d, got %d", length, buf.Len())
 	default:
-		return buf.Bytes(), err
+		return data, err
+	}
+}
+
+// splitHeader splits an object's binary representation into the type
+// recorded in its Git object header and the data following the header.
+// It returns an error if the header is malformed or the length recorded
+// in it does not match that of the data.
+func splitHeader(data []byte) (Type, []byte, error) {
+	objType, length, data, err := ParseHeader(data)
+	switch {
+	case err != nil:
+		return objType, nil, err
+	case length != int64(len(data)):
+		return objType, nil, fmt.Errorf("object: expected length %d, got %d", length, len(data))
+	default:
+		return objType, data, nil
 	}
 }
@@ -1,9 +1,6 @@
 package object
 
-import (
-	"bytes"
-	"fmt"
-)
+import "io"
 
 // A Tag is a named label for another Git object, usually a Commit.
 type Tag struct {
@@ -15,11 +12,18 @@ type Tag struct {
 }
 
 func (t *Tag) MarshalBinary() ([]byte, error) {
-	text, err := t.MarshalText()
+	return t.AppendBinary(nil)
+}
+
+// AppendBinary appends the binary representation of the tag to b and
+// returns the extended slice.
+func (t *Tag) AppendBinary(b []byte) ([]byte, error) {
+	start := len(b)
+	b, err := t.AppendText(b)
 	if err != nil {
 		return nil, err
 	}
-	return prependHeader(TypeTag, text)
+	return insertHeader(b, start, TypeTag), nil
 }
 
 func (t *Tag) UnmarshalBinary(data []byte) error {
@@ -31,24 +35,50 @@ func (t *Tag) UnmarshalBinary(data []byte) error {
 }
 
 func (t *Tag) MarshalText() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	fmt.Fprintln(buf, "object", t.Object)
-	fmt.Fprintln(buf, "type", t.Type)
-	fmt.Fprintln(buf, "tag", t.Tag)
-	fmt.Fprintln(buf, "tagger", t.Tagger)
-	fmt.Fprintln(buf)
-	buf.WriteString(t.Message)
-	return buf.Bytes(), nil
+	return t.AppendText(nil)
+}
+
+// AppendText appends the textual representation of the tag to b and
+// returns the extended slice.
+func (t *Tag) AppendText(b []byte) ([]byte, error) {
+	b = append(b, "object "...)
+	b = append(t.Object.appendHex(b), '\n')
+	b = append(b, "type "...)
+	b = append(b, t.Type.String()...)
+	b = append(b, "\ntag "...)
+	b = append(b, t.Tag...)
+	b = append(b, "\ntagger "...)
+	b = append(appendSignature(b, t.Tagger), '\n', '\n')
+	return append(b, t.Message...), nil
 }
 
 func (t *Tag) UnmarshalText(text []byte) error {
-	buf := bytes.NewBuffer(text)
-	var err fmtErr
-	err.Check(fmt.Fscanf(buf, "object %s\n", &t.Object))
-	err.Check(fmt.Fscanf(buf, "type %s\n", &t.Type))
-	err.Check(fmt.Fscanf(buf, "tag %s\n", &t.Tag))
-	err.Check(fmt.Fscanf(buf, "tagger %s\n", &t.Tagger))
-	err.Check(fmt.Fscanf(buf, "\n"))
-	t.Message = buf.String()
-	return err.Err()
+	value, text, err := cutField(text, "object")
+	if err != nil {
+		return err
+	}
+	if t.Object, err = parseID(value); err != nil {
+		return err
+	}
+	if value, text, err = cutField(text, "type"); err != nil {
+		return err
+	}
+	if t.Type, err = parseType(value); err != nil {
+		return err
+	}
+	if value, text, err = cutField(text, "tag"); err != nil {
+		return err
+	}
+	t.Tag = string(value)
+	if value, text, err = cutField(text, "tagger"); err != nil {
+		return err
+	}
+	if t.Tagger, err = parseSignature(value); err != nil {
+		return err
+	}
+	if len(text) == 0 || text[0] != '\n' {
+		return io.ErrUnexpectedEOF
+	}
+	t.Message = string(text[1:])
+	return nil
 }
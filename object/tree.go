@@ -2,25 +2,13 @@ package object
 
 import (
 	"bytes"
-	"fmt"
-	"io"
+	"errors"
 	"sort"
+	"strconv"
 	"strings"
 )
 
-// A filename is a string with the distinction that, when scanned, it
-// is only broken on newlines and null bytes.
-type filename string
-
-func (f *filename) Scan(ss fmt.ScanState, verb rune) error {
-	// BUG(lor): Filenames with newlines are not supported in trees.
-	name, err := getToken(ss, "\x00\x0A/", "")
-	if err != nil {
-		return err
-	}
-	*f = filename(name)
-	return nil
-}
+var errTreeEntry = errors.New("object: malformed tree entry")
 
 // A Tree is a mapping from filenames to tree metadata (most
 // importantly, object IDs), analogous to a filesystem directory.
@@ -86,16 +74,31 @@ func (t *Tree) Names() []string {
 }
 
 func (t *Tree) MarshalBinary() ([]byte, error) {
-	buf := new(bytes.Buffer)
+	return t.AppendBinary(nil)
+}
+
+// AppendBinary appends the binary representation of the tree to b and
+// returns the extended slice.
+func (t *Tree) AppendBinary(b []byte) ([]byte, error) {
+	start := len(b)
 	for _, name := range t.Names() {
 		ti := (*t)[name]
-		fmt.Fprintf(buf, "%o %s\x00", ti.Mode, name)
-		buf.Write(ti.Object[:])
+		b = strconv.AppendUint(b, uint64(ti.Mode), 8)
+		b = append(b, ' ')
+		b = append(b, name...)
+		b = append(b, 0)
+		b = append(b, ti.Object.Bytes()...)
 	}
-	return prependHeader(TypeTree, buf.Bytes())
+	return insertHeader(b, start, TypeTree), nil
 }
 
+// UnmarshalBinary decodes a tree with SHA-1 IDs.  Use UnmarshalFormat
+// to decode trees of other formats.
 func (t *Tree) UnmarshalBinary(data []byte) error {
+	return t.unmarshalBinary(data, SHA1)
+}
+
+func (t *Tree) unmarshalBinary(data []byte, f Format) error {
 	data, err := stripHeader(TypeTree, data)
 	if err != nil {
 		return err
@@ -103,54 +106,108 @@ func (t *Tree) UnmarshalBinary(data []byte) error {
 	if *t == nil {
 		*t = make(Tree)
 	}
-	buf := bytes.NewBuffer(data)
-	for buf.Len() > 0 {
-		var ti TreeInfo
-		var name filename
-		if _, err := fmt.Fscanf(buf, "%o %s\x00", &ti.Mode, &name); err != nil {
-			return err
+	for len(data) > 0 {
+		// an entry is an octal mode, a space, a filename of any
+		// bytes but NUL, a NUL and a binary object ID
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			return errTreeEntry
 		}
-		if _, err := io.ReadFull(buf, ti.Object[:]); err != nil {
-			return err
+		mode, ok := parseUint(data[:sp], 8)
+		if !ok || mode > 1<<32-1 {
+			return errTreeEntry
+		}
+		data = data[sp+1:]
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 || len(data)-nul-1 < f.Size() {
+			return errTreeEntry
 		}
-		(*t)[string(name)] = ti
+		name := string(data[:nul])
+		data = data[nul+1:]
+		id, _ := NewID(f, data[:f.Size()])
+		data = data[f.Size():]
+		(*t)[name] = TreeInfo{TreeMode(mode), id}
 	}
 	return nil
 }
 
+// MarshalText returns the tree in the format of the reference Git
+// client's "ls-tree" command.  Filenames containing control characters,
+// double quotes, backslashes or non-ASCII bytes are quoted and escaped
+// like ls-tree does by default.
 func (t *Tree) MarshalText() ([]byte, error) {
-	buf := new(bytes.Buffer)
+	return t.AppendText(nil)
+}
+
+// AppendText appends the textual representation of the tree to b and
+// returns the extended slice.
+func (t *Tree) AppendText(b []byte) ([]byte, error) {
 	for _, name := range t.Names() {
 		ti := (*t)[name]
-		fmt.Fprintf(buf, "%06o %s %s\t%s\n",
-			ti.Mode,
-			ti.Mode.Type(),
-			ti.Object,
-			name,
-		)
+		b = appendMode(b, ti.Mode)
+		b = append(b, ' ')
+		b = append(b, ti.Mode.Type().String()...)
+		b = append(b, ' ')
+		b = append(ti.Object.appendHex(b), '\t')
+		b = append(b, QuotePath(name)...)
+		b = append(b, '\n')
 	}
-	return buf.Bytes(), nil
+	return b, nil
+}
+
+// appendMode appends the mode to b as an octal number zero-padded to
+// six digits.
+func appendMode(b []byte, mode TreeMode) []byte {
+	var buf [11]byte
+	digits := strconv.AppendUint(buf[:0], uint64(mode), 8)
+	for i := len(digits); i < 6; i++ {
+		b = append(b, '0')
+	}
+	return append(b, digits...)
 }
 
 func (t *Tree) UnmarshalText(text []byte) error {
 	if *t == nil {
 		*t = make(Tree)
 	}
-	buf := bytes.NewBuffer(text)
-	for buf.Len() > 0 {
-		var ti TreeInfo
-		var objType Type
-		var name filename
-		_, err := fmt.Fscanf(buf, "%06o %s %s\t%s\n",
-			&ti.Mode,
-			&objType,
-			&ti.Object,
-			&name,
-		)
+	for len(text) > 0 {
+		// quoted filenames contain no newlines, so each entry is
+		// on a line of its own
+		line, rest, ok := cutLine(text)
+		if !ok {
+			line, rest = text, nil
+		}
+		text = rest
+		// an entry is a mode, a type, an ID and a filename; the
+		// type field is implied by the mode, and is empty for
+		// unknown modes
+		tab := bytes.IndexByte(line, '\t')
+		if tab < 0 {
+			return errTreeEntry
+		}
+		head, name := line[:tab], line[tab+1:]
+		sp := bytes.IndexByte(head, ' ')
+		if sp < 0 {
+			return errTreeEntry
+		}
+		mode, ok := parseUint(head[:sp], 8)
+		if !ok || mode > 1<<32-1 {
+			return errTreeEntry
+		}
+		head = head[sp+1:]
+		sp = bytes.IndexByte(head, ' ')
+		if sp < 0 {
+			return errTreeEntry
+		}
+		id, err := parseID(head[sp+1:])
+		if err != nil {
+			return err
+		}
+		unquoted, err := unquoteC(string(name))
 		if err != nil {
 			return err
 		}
-		(*t)[string(name)] = ti
+		(*t)[unquoted] = TreeInfo{TreeMode(mode), id}
 	}
 	return nil
 }
@@ -35,9 +35,12 @@ func (e *TypeError) Error() string {
 }
 
 // TypeOf returns the type of the given object, or TypeUnknown if it is
-// not one of the standard Git object types.
+// not one of the standard Git object types.  The type of a *Raw is the
+// one recorded in its header.
 func TypeOf(obj Interface) Type {
-	switch obj.(type) {
+	switch obj := obj.(type) {
+	case *Raw:
+		return obj.Type()
 	case *Commit:
 		return TypeCommit
 	case *Tree:
@@ -81,17 +84,6 @@ func (t *Type) Scan(ss fmt.ScanState, verb rune) error {
 	case len(tok) == 0:
 		return io.ErrUnexpectedEOF
 	}
-	switch string(tok) {
-	case "commit":
-		*t = TypeCommit
-	case "tree":
-		*t = TypeTree
-	case "blob":
-		*t = TypeBlob
-	case "tag":
-		*t = TypeTag
-	default:
-		return &TypeError{string(tok)}
-	}
-	return nil
+	*t, err = parseType(tok)
+	return err
 }
@@ -1,8 +1,8 @@
-// Git packfiles end with an SHA-1 checksum of their contents.  The
-// starting offsets of objects within the packfile also need to be
-// recorded for delta resolution.  This file defines convenience types
-// for reading from and writing to files while maintaining this
-// information.
+// Git packfiles end with a checksum of their contents.  The starting
+// offsets of objects within the packfile also need to be recorded for
+// delta resolution, and the offsets and CRC-32 checksums of the objects
+// for pack indexes.  This file defines convenience types for reading
+// from and writing to files while maintaining this information.
 
 package packfile
 
@@ -10,20 +10,25 @@ import (
 	"bufio"
 	"compress/flate"
 	"hash"
+	"hash/crc32"
 	"io"
+
+	"github.com/lxr/go.git-scm/object"
 )
 
 // A digestReader tracks the number and checksum of bytes read from an
-// underlying io.Reader.  As a local convenience, it also implements
-// io.ByteReader.  If the underlying io.Reader does not implement the
-// interface natively, it is wrapped in a bufio.Reader.  Note that this
-// may cause more bytes to be read from the io.Reader than digestReader
-// will report.
+// underlying io.Reader, as well as the CRC-32 checksum of the bytes
+// read since the last call to ResetCRC.  As a local convenience, it
+// also implements io.ByteReader.  If the underlying io.Reader does not
+// implement the interface natively, it is wrapped in a bufio.Reader.
+// Note that this may cause more bytes to be read from the io.Reader
+// than digestReader will report.
 type digestReader struct {
 	r      flate.Reader
 	pos    int64
 	bufDig *bufio.Writer // WriteByte wrapper for digest
 	digest hash.Hash
+	crc    uint32
 }
 
 func newDigestReader(r io.Reader, h hash.Hash) *digestReader {
@@ -31,13 +36,14 @@ func newDigestReader(r io.Reader, h hash.Hash) *digestReader {
 	if !ok {
 		fr = bufio.NewReader(r)
 	}
-	return &digestReader{fr, 0, bufio.NewWriterSize(h, h.BlockSize()), h}
+	return &digestReader{fr, 0, bufio.NewWriterSize(h, h.BlockSize()), h, 0}
 }
 
 func (r *digestReader) Read(p []byte) (int, error) {
 	n, err := r.r.Read(p)
 	r.pos += int64(n)
 	r.bufDig.Write(p[:n])
+	r.crc = crc32.Update(r.crc, crc32.IEEETable, p[:n])
 	return n, err
 }
 
@@ -48,41 +54,65 @@ func (r *digestReader) ReadByte() (byte, error) {
 	}
 	r.pos++
 	r.bufDig.WriteByte(c)
+	r.crc = crc32.Update(r.crc, crc32.IEEETable, []byte{c})
 	return c, err
 }
 
-func (r *digestReader) Sum(b []byte) []byte {
+// Sum returns the checksum of the bytes read so far, or
+// object.ErrCollision if they contain a SHA-1 collision attack.
+func (r *digestReader) Sum(b []byte) ([]byte, error) {
 	r.bufDig.Flush()
-	return r.digest.Sum(b)
+	return object.CheckedSum(r.digest, b)
 }
 
 func (r *digestReader) Tell() int64 {
 	return r.pos
 }
 
+func (r *digestReader) CRC() uint32 {
+	return r.crc
+}
+
+func (r *digestReader) ResetCRC() {
+	r.crc = 0
+}
+
 // A digestWriter tracks the number and checksum of bytes written to an
-// underlying io.Writer.
+// underlying io.Writer, as well as the CRC-32 checksum of the bytes
+// written since the last call to ResetCRC.
 type digestWriter struct {
 	w      io.Writer
 	pos    int64
 	digest hash.Hash
+	crc    uint32
 }
 
 func newDigestWriter(w io.Writer, h hash.Hash) *digestWriter {
-	return &digestWriter{w, 0, h}
+	return &digestWriter{w, 0, h, 0}
 }
 
-func (w *digestWriter) Sum(b []byte) []byte {
-	return w.digest.Sum(b)
+// Sum returns the checksum of the bytes written so far, or
+// object.ErrCollision if they contain a SHA-1 collision attack.
+func (w *digestWriter) Sum(b []byte) ([]byte, error) {
+	return object.CheckedSum(w.digest, b)
 }
 
 func (w *digestWriter) Tell() int64 {
 	return w.pos
 }
 
+func (w *digestWriter) CRC() uint32 {
+	return w.crc
+}
+
+func (w *digestWriter) ResetCRC() {
+	w.crc = 0
+}
+
 func (w *digestWriter) Write(p []byte) (int, error) {
 	n, err := w.w.Write(p)
 	w.pos += int64(n)
 	w.digest.Write(p[:n])
+	w.crc = crc32.Update(w.crc, crc32.IEEETable, p[:n])
 	return n, err
 }
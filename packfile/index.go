@@ -0,0 +1,264 @@
+package packfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// ErrIndex is returned when reading malformed pack index data.
+var ErrIndex = errors.New("packfile: invalid index")
+
+var indexSignature = [4]byte{'\377', 't', 'O', 'c'}
+
+// An Index is a version 2 pack index (.idx file), which maps the IDs of
+// the objects in a packfile to their offsets within it.  Pack indexes
+// of SHA-1 and SHA-256 packfiles differ only in the length of the IDs
+// and checksums stored in them.
+type Index struct {
+	Format   object.Format
+	Entries  []IndexEntry // in ascending order by ID
+	Checksum []byte       // checksum of the indexed packfile
+}
+
+// An IndexEntry records the position of an object in a packfile.
+type IndexEntry struct {
+	ID     object.ID
+	Offset int64  // offset of the object's header from the start of the packfile
+	CRC32  uint32 // CRC-32 checksum of the object's packed representation
+}
+
+// newIndex returns an Index of the given entries, which are sorted.
+func newIndex(f object.Format, entries []IndexEntry, checksum []byte) *Index {
+	sort.Sort(indexEntrySlice(entries))
+	return &Index{
+		Format:   f,
+		Entries:  entries,
+		Checksum: checksum,
+	}
+}
+
+// Find returns the entry of the object with the given ID.
+func (idx *Index) Find(id object.ID) (IndexEntry, bool) {
+	b := id.Bytes()
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return bytes.Compare(idx.Entries[i].ID.Bytes(), b) >= 0
+	})
+	if i < len(idx.Entries) && idx.Entries[i].ID == id {
+		return idx.Entries[i], true
+	}
+	return IndexEntry{}, false
+}
+
+// FindPrefix returns the entries of the objects whose hexadecimal IDs
+// begin with the given prefix, in ascending order by ID.
+func (idx *Index) FindPrefix(prefix string) []IndexEntry {
+	// the matching IDs follow the prefix padded with zeros
+	n := 2 * idx.Format.Size()
+	if len(prefix) > n {
+		return nil
+	}
+	lower, err := hex.DecodeString(prefix + strings.Repeat("0", n-len(prefix)))
+	if err != nil {
+		return nil
+	}
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return bytes.Compare(idx.Entries[i].ID.Bytes(), lower) >= 0
+	})
+	j := i
+	for j < len(idx.Entries) && idx.Entries[j].ID.HasPrefix(prefix) {
+		j++
+	}
+	return idx.Entries[i:j]
+}
+
+// WriteTo writes the index to w in the version 2 pack index format.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	dw := newDigestWriter(w, idx.Format.New())
+	var fanout [256]uint32
+	for _, e := range idx.Entries {
+		fanout[e.ID.Bytes()[0]]++
+	}
+	for i := 1; i < len(fanout); i++ {
+		fanout[i] += fanout[i-1]
+	}
+	var large []uint64
+	offsets := make([]uint32, len(idx.Entries))
+	for i, e := range idx.Entries {
+		if e.Offset < 0x80000000 {
+			offsets[i] = uint32(e.Offset)
+		} else {
+			offsets[i] = 0x80000000 | uint32(len(large))
+			large = append(large, uint64(e.Offset))
+		}
+	}
+
+	write := func(data interface{}) error {
+		return binary.Write(dw, binary.BigEndian, data)
+	}
+	if err := write(indexSignature); err != nil {
+		return dw.Tell(), err
+	}
+	if err := write(uint32(2)); err != nil {
+		return dw.Tell(), err
+	}
+	if err := write(fanout); err != nil {
+		return dw.Tell(), err
+	}
+	for _, e := range idx.Entries {
+		if _, err := dw.Write(e.ID.Bytes()); err != nil {
+			return dw.Tell(), err
+		}
+	}
+	for _, e := range idx.Entries {
+		if err := write(e.CRC32); err != nil {
+			return dw.Tell(), err
+		}
+	}
+	if err := write(offsets); err != nil {
+		return dw.Tell(), err
+	}
+	if err := write(large); err != nil {
+		return dw.Tell(), err
+	}
+	if _, err := dw.Write(idx.Checksum); err != nil {
+		return dw.Tell(), err
+	}
+	sum, err := dw.Sum(nil)
+	if err != nil {
+		return dw.Tell(), err
+	}
+	_, err = w.Write(sum)
+	return dw.Tell() + int64(len(sum)), err
+}
+
+// ReadIndex reads a version 2 pack index of the given object format
+// from r.  It returns ErrIndex if the index is malformed and
+// ErrChecksum if its checksum is not valid.
+func ReadIndex(r io.Reader, f object.Format) (*Index, error) {
+	dr := newDigestReader(r, f.New())
+	read := func(data interface{}) error {
+		return binary.Read(dr, binary.BigEndian, data)
+	}
+	var (
+		sig     [4]byte
+		version uint32
+		fanout  [256]uint32
+	)
+	if err := read(&sig); err != nil {
+		return nil, err
+	}
+	if err := read(&version); err != nil {
+		return nil, err
+	}
+	switch {
+	case sig != indexSignature:
+		return nil, ErrIndex
+	case version != 2:
+		return nil, ErrVersion
+	}
+	if err := read(&fanout); err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(fanout); i++ {
+		if fanout[i] < fanout[i-1] {
+			return nil, ErrIndex
+		}
+	}
+
+	// NOTE(lor): The entries are appended as they are read rather than
+	// allocated up front, as the fanout table comes from the input and
+	// the number of objects it claims need not be backed by any data.
+	n := int(fanout[255])
+	var entries []IndexEntry
+	b := make([]byte, f.Size())
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(dr, b); err != nil {
+			return nil, err
+		}
+		var lo uint32
+		if b[0] > 0 {
+			lo = fanout[b[0]-1]
+		}
+		if uint32(i) < lo || uint32(i) >= fanout[b[0]] {
+			return nil, ErrIndex
+		}
+		if i > 0 && bytes.Compare(entries[i-1].ID.Bytes(), b) >= 0 {
+			return nil, ErrIndex
+		}
+		id, _ := object.NewID(f, b)
+		entries = append(entries, IndexEntry{ID: id})
+	}
+	for i := range entries {
+		if err := read(&entries[i].CRC32); err != nil {
+			return nil, err
+		}
+	}
+	var nlarge int
+	for i := range entries {
+		var ofs uint32
+		if err := read(&ofs); err != nil {
+			return nil, err
+		}
+		if ofs&0x80000000 != 0 {
+			nlarge++
+		}
+		entries[i].Offset = int64(ofs)
+	}
+	large := make([]uint64, nlarge)
+	if err := read(large); err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		ofs := entries[i].Offset
+		if ofs&0x80000000 == 0 {
+			continue
+		}
+		j := int(ofs &^ 0x80000000)
+		if j >= len(large) || int64(large[j]) < 0 {
+			return nil, ErrIndex
+		}
+		entries[i].Offset = int64(large[j])
+	}
+
+	checksum := make([]byte, f.Size())
+	if _, err := io.ReadFull(dr, checksum); err != nil {
+		return nil, err
+	}
+	expected, err := dr.Sum(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(dr, b); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b, expected) {
+		return nil, ErrChecksum
+	}
+	return &Index{
+		Format:   f,
+		Entries:  entries,
+		Checksum: checksum,
+	}, nil
+}
+
+// indexEntrySlice sorts index entries in ascending order by ID.
+type indexEntrySlice []IndexEntry
+
+func (s indexEntrySlice) Len() int {
+	return len(s)
+}
+
+func (s indexEntrySlice) Less(i, j int) bool {
+	return bytes.Compare(s[i].ID.Bytes(), s[j].ID.Bytes()) < 0
+}
+
+func (s indexEntrySlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -1,17 +1,15 @@
 // Git objects are stored in packfiles with a special type of header.
-// The functions in this file read and write these headers and marshal
-// and unmarshal objects to and from headerless representations.  The
-// reason the header processing is separate from the marshaling -
-// unlike in object.Interface - is because the bodies of Git objects
-// are zlibbed in packfiles, while their headers are not.
+// The functions in this file read and write these headers and convert
+// objects to and from headerless representations.  The reason the
+// header processing is separate from the marshaling - unlike in
+// object.Interface - is because the bodies of Git objects are zlibbed
+// in packfiles, while their headers are not.
 
 package packfile
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"errors"
-	"fmt"
 	"io"
 
 	"github.com/lxr/go.git-scm/object"
@@ -45,23 +43,34 @@ func writeObjHeader(w io.Writer, objType object.Type, size int64) error {
 }
 
 func marshalObj(obj object.Interface) ([]byte, error) {
-	data, _, err := object.Marshal(obj)
+	if object.TypeOf(obj) == object.TypeUnknown {
+		return nil, &object.TypeError{obj}
+	}
+	data, err := obj.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 	return data[bytes.IndexByte(data, 0)+1:], nil
 }
 
-func unmarshalObj(obj object.Interface, data []byte) error {
-	objType := object.TypeOf(obj)
-	if objType == object.TypeUnknown {
-		return &object.TypeError{obj}
+// rawObj returns the object of the given type and format with the
+// given headerless representation as an *object.Raw.  data is copied.
+func rawObj(objType object.Type, data []byte, f object.Format) (*object.Raw, error) {
+	switch objType {
+	case object.TypeCommit, object.TypeTree, object.TypeBlob, object.TypeTag:
+	default:
+		return nil, &object.TypeError{objType}
 	}
-	header := []byte(fmt.Sprintf("%s %d\x00", objType, len(data)))
-	return obj.UnmarshalBinary(append(header, data...))
+	buf := make([]byte, 0, len(data)+32)
+	buf = object.AppendHeader(buf, objType, int64(len(data)))
+	return object.NewRawFormat(append(buf, data...), f)
 }
 
-func hashObj(objType object.Type, data []byte) object.ID {
-	header := []byte(fmt.Sprintf("%s %d\x00", objType, len(data)))
-	return object.ID(sha1.Sum(append(header, data...)))
+// hashObj returns the ID of the object of the given type and format
+// with the given headerless representation.  It returns
+// object.ErrCollision if the object contains a SHA-1 collision attack.
+func hashObj(objType object.Type, data []byte, f object.Format) (object.ID, error) {
+	h := object.NewHasher(f, objType, int64(len(data)))
+	h.Write(data)
+	return h.ID()
 }
@@ -10,7 +10,6 @@ package packfile
 import (
 	"bytes"
 	"compress/zlib"
-	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"io"
@@ -63,12 +62,15 @@ type header struct {
 
 // A Reader reads Git objects from a packfile stream.
 type Reader struct {
-	r    *digestReader
-	zr   io.ReadCloser
-	n    int64
-	ofs  map[int64]object.ID
-	repo repository.Interface
-	buf  bytes.Buffer
+	r       *digestReader
+	zr      io.ReadCloser
+	n       int64
+	ofs     map[int64]object.ID
+	repo    repository.Interface
+	format  object.Format
+	buf     bytes.Buffer
+	entries []IndexEntry
+	sum     []byte
 }
 
 // newZlibReader resets the cached io.ReadCloser to read from rr and
@@ -87,13 +89,21 @@ func (r *Reader) newZlibReader(rr io.Reader) (io.ReadCloser, error) {
 // unsupported, or if trying to read the header failed.  The repository
 // repo is used as working storage: every read object is put to it, and
 // the base object of every delta is looked up from it.  If repo is nil,
-// a temporary in-memory database is used.  It is the caller's
-// responsibility to call Close on the Reader after all objects have
-// been read.
+// a temporary in-memory database is used.  The packfile is expected to
+// be of the object format of repo (see repository.ObjectFormat).  It is
+// the caller's responsibility to call Close on the Reader after all
+// objects have been read.
 func NewReader(r io.Reader, repo repository.Interface) (*Reader, error) {
-	dr := newDigestReader(r, sha1.New())
+	if repo == nil {
+		repo = mem.NewRepository()
+	}
+	format, err := repository.ObjectFormat(repo)
+	if err != nil {
+		return nil, err
+	}
+	dr := newDigestReader(r, format.New())
 	var h header
-	err := binary.Read(dr, binary.BigEndian, &h)
+	err = binary.Read(dr, binary.BigEndian, &h)
 	switch {
 	case err != nil:
 		return nil, err
@@ -102,15 +112,13 @@ func NewReader(r io.Reader, repo repository.Interface) (*Reader, error) {
 	case h.Version < 2 || h.Version > 3:
 		return nil, ErrVersion
 	}
-	if repo == nil {
-		repo = mem.NewRepository()
-	}
 	return &Reader{
-		r:    dr,
-		zr:   nil,
-		n:    int64(h.Nobjects),
-		ofs:  make(map[int64]object.ID),
-		repo: repo,
+		r:      dr,
+		zr:     nil,
+		n:      int64(h.Nobjects),
+		ofs:    make(map[int64]object.ID),
+		repo:   repo,
+		format: format,
 	}, nil
 }
 
@@ -120,7 +128,9 @@ func (r *Reader) Len() int64 {
 }
 
 // ReadObject returns the next object in the stream.  Clients should
-// use Len to detect end-of-file.
+// use Len to detect end-of-file.  The object is returned as an
+// *object.Raw holding its exact binary representation; its contents
+// are not parsed or validated, for which see object.Fsck.
 //
 // When err is one of the following values, the Reader is guaranteed to
 // be in a consistent state, i.e. it is safe to continue reading objects
@@ -133,29 +143,56 @@ func (r *Reader) Len() int64 {
 //  - any errors returned by the GetObject and PutObject methods of the
 //    repo passed to the NewReader call
 //  - any object.TypeError instance
-func (r *Reader) ReadObject() (obj object.Interface, err error) {
+//  - object.ErrCollision
+func (r *Reader) ReadObject() (object.Interface, error) {
+	obj, _, _, err := r.readObject(false)
+	return obj, err
+}
+
+// StoreObject reads the next object in the stream and stores it in the
+// working repository like ReadObject, but returns only the object's ID
+// and type.  Blobs not stored as deltas are streamed into the
+// repository with repository.PutBlob instead of being read into
+// memory, so StoreObject should be preferred to ReadObject when
+// receiving packfiles that may contain large files.  The errors
+// returned by the PutBlob method of the working repository leave the
+// Reader in a consistent state; the other errors are as for ReadObject.
+func (r *Reader) StoreObject() (object.ID, object.Type, error) {
+	_, id, objType, err := r.readObject(true)
+	return id, objType, err
+}
+
+// readObject reads the next object in the stream.  If stream is true
+// and the object is a non-delta blob, it is streamed into the working
+// repository and the returned object is nil.
+func (r *Reader) readObject(stream bool) (obj object.Interface, id object.ID, objType object.Type, err error) {
 	// check if there are objects to read, and if so, record the
 	// current position as the start of a new object
+	id = r.format.ZeroID()
 	if r.n == 0 {
-		return nil, io.EOF
+		err = io.EOF
+		return
 	}
 	pos := r.r.Tell()
+	r.r.ResetCRC()
 
 	// read object header
-	objType, size, err := readObjHeader(r.r)
+	var size int64
+	objType, size, err = readObjHeader(r.r)
 	if err != nil {
 		return
 	}
 
 	// if object is a delta, read its base object reference
-	var baseID object.ID
+	baseID := r.format.ZeroID()
 	var errBase error
 	switch objType {
 	case offsetDelta:
-		negOfs, err := readBase128MBE(r.r)
+		var negOfs uint64
+		negOfs, err = readBase128MBE(r.r)
 		switch {
 		case err != nil:
-			return nil, err
+			return
 		case int64(negOfs) < 0:
 			// XXX(lor): The stream is technically in a
 			// consistent state when this error happens, so
@@ -163,7 +200,8 @@ func (r *Reader) ReadObject() (obj object.Interface, err error) {
 			// any use of it I'd have to document and
 			// probably make it a separate error variable,
 			// which I would prefer not to.
-			return nil, errors.New("packfile: delta offset overflows int64")
+			err = errors.New("packfile: delta offset overflows int64")
+			return
 		}
 		var ok bool
 		baseID, ok = r.ofs[pos-int64(negOfs)]
@@ -173,9 +211,11 @@ func (r *Reader) ReadObject() (obj object.Interface, err error) {
 			errBase = ErrBadOffset
 		}
 	case refDelta:
-		if _, err = io.ReadFull(r.r, baseID[:]); err != nil {
+		b := make([]byte, r.format.Size())
+		if _, err = io.ReadFull(r.r, b); err != nil {
 			return
 		}
+		baseID, _ = object.NewID(r.format, b)
 	}
 
 	// read object body
@@ -184,6 +224,10 @@ func (r *Reader) ReadObject() (obj object.Interface, err error) {
 		return
 	}
 	defer zr.Close()
+	if stream && objType == object.TypeBlob {
+		id, err = r.storeBlob(zr, pos, size)
+		return
+	}
 	r.buf.Reset()
 	if _, err = io.CopyN(&r.buf, zr, size); err != nil {
 		return
@@ -196,19 +240,21 @@ func (r *Reader) ReadObject() (obj object.Interface, err error) {
 	// the underlying stream isn't read after this point, so
 	// decrement the number of remaining objects
 	r.n--
+	crc := r.r.CRC()
 
-	// XXX(lor): The marshalObj and unmarshalObj calls in the
-	// following code can return IO errors on malformed object
-	// data.  The functions don't touch r.r, so they can't throw
-	// it out of sync, but no effort has been made to make this
-	// distinction visible to the user.
+	// XXX(lor): The marshalObj and rawObj calls in the following
+	// code can return IO errors on malformed object data.  The
+	// functions don't touch r.r, so they can't throw it out of
+	// sync, but no effort has been made to make this distinction
+	// visible to the user.
 
 	// if object is a delta, retrieve its base object and apply
 	// the delta to it
 	if errBase != nil {
-		return nil, errBase
+		err = errBase
+		return
 	}
-	if baseID != object.ZeroID {
+	if !baseID.IsZero() {
 		var (
 			base     object.Interface
 			baseData []byte
@@ -228,53 +274,105 @@ func (r *Reader) ReadObject() (obj object.Interface, err error) {
 		objType = object.TypeOf(base)
 	}
 
-	// unmarshal the object
-	obj, err = object.New(objType)
+	// wrap the object data verbatim, so that the object keeps its
+	// ID even if it is not in canonical form
+	raw, err := rawObj(objType, data, r.format)
 	if err != nil {
 		return
 	}
-	err = unmarshalObj(obj, data)
+
+	// refuse objects crafted to collide with others
+	id, err = hashObj(objType, data, r.format)
 	if err != nil {
 		return
 	}
 
-	// add the object to the working repo and offset map and return
-	id, err := r.repo.PutObject(obj)
+	// add the object to the offset map and the working repo and
+	// return.  Even if PutObject fails, we know that obj is a valid
+	// object, so its ID is kept in r.ofs.  Any deltas using it as a
+	// base will fail (as the object doesn't exist in r.repo), but at
+	// least they won't do so with an incorrect ErrBadOffset error.
+	r.ofs[pos] = id
+	r.entries = append(r.entries, IndexEntry{id, pos, crc})
+	obj = raw
+	_, err = r.repo.PutObject(obj)
+	return
+}
+
+// storeBlob streams the body of a non-delta blob of the given size
+// from zr into the working repository and returns its ID.  pos is the
+// offset of the blob's header in the packfile.
+func (r *Reader) storeBlob(zr io.Reader, pos, size int64) (object.ID, error) {
+	h := object.NewHasher(r.format, object.TypeBlob, size)
+	body := &io.LimitedReader{R: zr, N: size}
+	_, errPut := repository.PutBlob(r.repo, io.TeeReader(body, h), size)
+
+	// consume whatever PutBlob left unread, so that the stream is
+	// in sync even if PutBlob failed
+	if _, err := io.Copy(h, body); err != nil {
+		return r.format.ZeroID(), err
+	}
+	if body.N > 0 {
+		return r.format.ZeroID(), io.ErrUnexpectedEOF
+	}
+	if err := flushZlib(zr); err != nil {
+		return r.format.ZeroID(), err
+	}
+	r.n--
+	crc := r.r.CRC()
+
+	id, err := h.ID()
 	if err != nil {
-		// Even if PutObject fails, we know that obj is a valid
-		// object, so compute and store its ID in r.ofs.  Any
-		// deltas using it as a base will fail (as the object
-		// doesn't exist in r.repo), but at least they won't do
-		// so with an incorrect ErrBadOffset error.
-		id = hashObj(objType, data)
+		return id, err
 	}
 	r.ofs[pos] = id
-	return
+	r.entries = append(r.entries, IndexEntry{id, pos, crc})
+	return id, errPut
 }
 
-// Close reads and verifies the packfile SHA-1 footer from the stream.
-// It returns ErrChecksum if the checksum is not valid.  It does not
-// close the underlying reader.  This method should only be called after
-// all objects have been read.
+// Close reads and verifies the packfile checksum footer from the
+// stream.  It returns ErrChecksum if the checksum is not valid, and
+// object.ErrCollision if the packfile contains a SHA-1 collision
+// attack.  It does not close the underlying reader.  This method should
+// only be called after all objects have been read.
 func (r *Reader) Close() error {
-	var read, expected [sha1.Size]byte
-	copy(expected[:], r.r.Sum(nil))
-	_, err := io.ReadFull(r.r, read[:])
+	expected, err := r.r.Sum(nil)
+	if err != nil {
+		return err
+	}
+	read := make([]byte, len(expected))
+	_, err = io.ReadFull(r.r, read)
 	switch {
 	case err != nil:
 		return err
-	case read != expected:
+	case !bytes.Equal(read, expected):
 		return ErrChecksum
 	}
+	r.sum = read
 	return nil
 }
 
+// Index returns a pack index of the objects read from the packfile.
+// It returns nil if Close has not successfully verified the packfile's
+// checksum.
+func (r *Reader) Index() *Index {
+	if r.sum == nil {
+		return nil
+	}
+	entries := make([]IndexEntry, len(r.entries))
+	copy(entries, r.entries)
+	return newIndex(r.format, entries, r.sum)
+}
+
 // A Writer writes Git objects to a packfile stream.
 type Writer struct {
-	w    *digestWriter
-	zw   *zlib.Writer
-	n    int64
-	prev [object.TypeReserved][]byte
+	w       *digestWriter
+	zw      *zlib.Writer
+	n       int64
+	prev    [object.TypeReserved][]byte
+	format  object.Format
+	entries []IndexEntry
+	sum     []byte
 }
 
 // newZlibWriter resets the cached *zlib.Writer to write to ww and
@@ -290,18 +388,25 @@ func (w *Writer) newZlibWriter(ww io.Writer) *zlib.Writer {
 // of an unsigned 32-bit integer.  It is the caller's responsibility to
 // call Close on the Writer after all objects have been written.
 func NewWriter(w io.Writer, n int64) (*Writer, error) {
+	return NewWriterFormat(w, n, object.SHA1)
+}
+
+// NewWriterFormat is like NewWriter, but writes a packfile of the given
+// object format.
+func NewWriterFormat(w io.Writer, n int64, f object.Format) (*Writer, error) {
 	if int64(uint32(n)) != n {
 		return nil, ErrTooManyObjects
 	}
-	dw := newDigestWriter(w, sha1.New())
+	dw := newDigestWriter(w, f.New())
 	h := header{signature, 3, uint32(n)}
 	if err := binary.Write(dw, binary.BigEndian, h); err != nil {
 		return nil, err
 	}
 	return &Writer{
-		w:  dw,
-		zw: zlib.NewWriter(nil),
-		n:  n,
+		w:      dw,
+		zw:     zlib.NewWriter(nil),
+		n:      n,
+		format: f,
 	}, nil
 }
 
@@ -332,6 +437,12 @@ func (w *Writer) WriteObject(obj object.Interface) error {
 	if err != nil {
 		return err
 	}
+	objID, err := hashObj(object.TypeOf(obj), data, w.format)
+	if err != nil {
+		return err
+	}
+	pos := w.w.Tell()
+	w.w.ResetCRC()
 
 	// if the object's difference from the last one of the same
 	// type (if any) takes less space than the object's binary
@@ -355,8 +466,8 @@ func (w *Writer) WriteObject(obj object.Interface) error {
 
 	// if object is a delta, write the ID of its base object
 	if objType == refDelta {
-		id := hashObj(object.TypeOf(obj), base)
-		if _, err := w.w.Write(id[:]); err != nil {
+		id, _ := hashObj(object.TypeOf(obj), base, w.format)
+		if _, err := w.w.Write(id.Bytes()); err != nil {
 			return err
 		}
 	}
@@ -370,13 +481,81 @@ func (w *Writer) WriteObject(obj object.Interface) error {
 
 	// update bookkeeping information and return
 	w.n--
-	return z.Close()
+	if err := z.Close(); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, IndexEntry{objID, pos, w.w.CRC()})
+	return nil
 }
 
-// Close writes the packfile SHA-1 footer to the stream.  It does not
+// WriteBlob writes a blob whose contents of the given size are read
+// from r to the stream.  Unlike WriteObject, it never writes the blob
+// as a delta, and streams the contents through zlib without holding
+// them in memory.  It returns ErrTooManyObjects if trying to write more
+// objects than were specified in the call to NewWriter, and
+// io.ErrUnexpectedEOF if r ends before size bytes have been read.  As
+// the blob's ID is only known once it has been written, a blob
+// containing a SHA-1 collision attack is detected only after the fact,
+// with object.ErrCollision; the packfile should then be discarded.
+func (w *Writer) WriteBlob(r io.Reader, size int64) error {
+	// check if there are still objects to write
+	if w.n == 0 {
+		return ErrTooManyObjects
+	}
+	pos := w.w.Tell()
+	w.w.ResetCRC()
+
+	// write object header
+	if err := writeObjHeader(w.w, object.TypeBlob, size); err != nil {
+		return err
+	}
+
+	// write object body, hashing it on the way
+	h := object.NewHasher(w.format, object.TypeBlob, size)
+	z := w.newZlibWriter(w.w)
+	if _, err := io.CopyN(io.MultiWriter(z, h), r, size); err != nil {
+		z.Close()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	// update bookkeeping information and return
+	w.n--
+	if err := z.Close(); err != nil {
+		return err
+	}
+	id, err := h.ID()
+	if err != nil {
+		return err
+	}
+	w.entries = append(w.entries, IndexEntry{id, pos, w.w.CRC()})
+	return nil
+}
+
+// Close writes the packfile checksum footer to the stream.  It does not
 // close the underlying writer.  This method should only be called after
 // all objects have been written.
 func (w *Writer) Close() error {
-	_, err := w.w.Write(w.w.Sum(nil))
-	return err
+	sum, err := w.w.Sum(nil)
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(sum); err != nil {
+		return err
+	}
+	w.sum = sum
+	return nil
+}
+
+// Index returns a pack index of the objects written to the packfile.
+// It returns nil if Close has not been successfully called.
+func (w *Writer) Index() *Index {
+	if w.sum == nil {
+		return nil
+	}
+	entries := make([]IndexEntry, len(w.entries))
+	copy(entries, w.entries)
+	return newIndex(w.format, entries, w.sum)
 }
@@ -16,9 +16,15 @@ import (
 
 // AdvertiseRefs writes Capabilities and a list of available refs in
 // repo to w in pkt-line format.  It returns a non-nil error only if it
-// could not list the references; in particular errors writing to w or
-// peeling annotated tags are ignored.
+// could not list the references or determine the object format of
+// repo; in particular errors writing to w or peeling annotated tags are
+// ignored.
 func AdvertiseRefs(repo repository.Interface, w io.Writer) error {
+	caps, err := repoCapabilities(repo)
+	if err != nil {
+		return err
+	}
+	format, _ := repository.ObjectFormat(repo)
 	names, ids, err := repo.ListRefs()
 	if err != nil {
 		return err
@@ -31,12 +37,12 @@ func AdvertiseRefs(repo repository.Interface, w io.Writer) error {
 	}
 	if len(names) == 0 {
 		names = []string{"capabilities^{}"}
-		ids = []object.ID{object.ZeroID}
+		ids = []object.ID{format.ZeroID()}
 	}
 	for i := range names {
 		name, id := names[i], ids[i]
 		if i == 0 {
-			fmtLprintf(pktw, "%s %s\x00%s\n", id, name, Capabilities)
+			fmtLprintf(pktw, "%s %s\x00%s\n", id, name, caps)
 		} else {
 			fmtLprintf(pktw, "%s %s\n", id, name)
 		}
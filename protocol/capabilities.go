@@ -3,10 +3,13 @@ package protocol
 import (
 	"fmt"
 	"strings"
+
+	"github.com/lxr/go.git-scm/repository"
 )
 
 // Capabilities is the set of protocol capabilities supported by this
-// implementation.
+// implementation.  In addition, the object-format capability naming the
+// object format of the repository is advertised and accepted.
 var Capabilities = CapList{
 	"delete-refs":        true,
 	"multi_ack_detailed": true,
@@ -15,6 +18,21 @@ var Capabilities = CapList{
 	"report-status":      true,
 }
 
+// repoCapabilities returns Capabilities with the object-format
+// capability of repo added.
+func repoCapabilities(repo repository.Interface) (CapList, error) {
+	f, err := repository.ObjectFormat(repo)
+	if err != nil {
+		return nil, err
+	}
+	c := make(CapList)
+	for cap, ok := range Capabilities {
+		c[cap] = ok
+	}
+	c["object-format="+f.String()] = true
+	return c, nil
+}
+
 // A CapList represents a set of Git protocol capabilities.
 type CapList map[string]bool
 
@@ -10,6 +10,7 @@ import (
 	"github.com/lxr/go.git-scm/packfile"
 	"github.com/lxr/go.git-scm/pktline"
 	"github.com/lxr/go.git-scm/repository"
+	"github.com/lxr/go.git-scm/repository/mem"
 )
 
 // A refName is a string representing the name of a Git ref.  Its Scan
@@ -27,6 +28,27 @@ func (r *refName) Scan(ss fmt.ScanState, verb rune) error {
 	return nil
 }
 
+// ReceiveFsck, if non-nil, is used by ReceivePack to check every object
+// it receives, like the receive.fsckObjects configuration variable of
+// the reference Git client.  Its Format and Lookup fields are ignored;
+// the format of the repository being pushed to is used, and referenced
+// objects are looked up from it.  If any object has a defect of
+// severity object.SeverityError, unpacking is reported as failed and no
+// refs are updated.
+//
+// By default, in addition to the errors detected by object.Fsck, trees
+// with entries that could escape or overwrite the Git directory of a
+// client checking them out (such as ".git" or its case-folded, HFS+ and
+// NTFS equivalents, ".." or names containing slashes) are rejected.
+var ReceiveFsck = &object.Fsck{
+	Severity: map[object.MsgID]object.Severity{
+		object.MsgFullPathname: object.SeverityError,
+		object.MsgHasDot:       object.SeverityError,
+		object.MsgHasDotdot:    object.SeverityError,
+		object.MsgHasDotgit:    object.SeverityError,
+	},
+}
+
 // BUG(lor): ReceivePack does not understand push certificates or
 // shallow refs.
 
@@ -35,7 +57,8 @@ func (r *refName) Scan(ss fmt.ScanState, verb rune) error {
 // capability is set in r, the progress of the task is written in
 // pkt-lines to w.  ReceivePack returns a non-nil error only if it fails
 // to read the ref update commands; failures to unpack the packfile or
-// update individual refs are merely logged to w.
+// update individual refs are merely logged to w.  If unpacking fails,
+// none of the refs are updated.
 func ReceivePack(repo repository.Interface, w io.Writer, r io.Reader) error {
 	type receiveCmd struct {
 		oldID object.ID
@@ -56,14 +79,18 @@ func ReceivePack(repo repository.Interface, w io.Writer, r io.Reader) error {
 			return err
 		}
 		cmds = append(cmds, cmd)
-		if cmd.newID != object.ZeroID {
+		if !cmd.newID.IsZero() {
 			deleteCommandsOnly = false
 		}
 	}
 	if len(cmds) == 0 {
 		return nil
 	}
-	if d := caps.sub(Capabilities); len(d) > 0 {
+	repoCaps, err := repoCapabilities(repo)
+	if err != nil {
+		return err
+	}
+	if d := caps.sub(repoCaps); len(d) > 0 {
 		return fmt.Errorf("unrecognized capabilities: %s", d)
 	}
 
@@ -72,7 +99,6 @@ func ReceivePack(repo repository.Interface, w io.Writer, r io.Reader) error {
 	}
 	pktw := pktline.NewWriter(w)
 
-	var err error
 	if !deleteCommandsOnly {
 		err = unpack(repo, r)
 	}
@@ -85,6 +111,10 @@ func ReceivePack(repo repository.Interface, w io.Writer, r io.Reader) error {
 	}
 
 	for _, c := range cmds {
+		if err != nil {
+			fmtLprintf(pktw, "ng %s unpacker error\n", c.name)
+			continue
+		}
 		if err := repo.UpdateRef(string(c.name), c.oldID, c.newID); err != nil {
 			fmtLprintf(pktw, "ng %s %s\n", c.name, err)
 			continue
@@ -97,16 +127,119 @@ func ReceivePack(repo repository.Interface, w io.Writer, r io.Reader) error {
 }
 
 // unpack reads a packfile from r (with repo as reference) and stores
-// all its objects in repo.
+// all its objects in repo.  If ReceiveFsck is set, the commits, trees
+// and tags are first unpacked into a quarantine and only stored in repo
+// once they have all been checked, so that a rejected push leaves
+// nothing behind that a later push could refer to without it being
+// checked.  Blobs are stored in repo as they are read, as there is
+// nothing to check in a blob on its own: a blob is only checked as a
+// .gitmodules file, which is done whenever a tree referring to it as
+// one is received.
 func unpack(repo repository.Interface, r io.Reader) error {
+	if ReceiveFsck == nil {
+		return unpackInto(repo, r)
+	}
+	format, err := repository.ObjectFormat(repo)
+	if err != nil {
+		return err
+	}
+	q := &quarantine{repo, mem.NewRepositoryFormat(format)}
+	if err := unpackInto(q, r); err != nil {
+		return err
+	}
+	ids, err := q.objects.(repository.ObjectLister).ListObjects()
+	if err != nil {
+		return err
+	}
+	if err := fsckObjects(q, format, ids); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := q.release(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackInto reads a packfile from r and stores all its objects in
+// repo.
+func unpackInto(repo repository.Interface, r io.Reader) error {
 	pfr, err := packfile.NewReader(r, repo)
 	if err != nil {
 		return err
 	}
 	for pfr.Len() > 0 {
-		if _, err := pfr.ReadObject(); err != nil {
+		if _, _, err := pfr.StoreObject(); err != nil {
 			return err
 		}
 	}
 	return pfr.Close()
 }
+
+// fsckObjects checks the objects with the given IDs in repo, which is
+// of the given object format, using ReceiveFsck.  The check is done
+// only once all objects have been unpacked, as objects may refer to
+// ones appearing later in the packfile.
+func fsckObjects(repo repository.Interface, format object.Format, ids []object.ID) error {
+	f := *ReceiveFsck
+	f.Format = format
+	f.Lookup = repo.GetObject
+	for _, id := range ids {
+		obj, err := repo.GetObject(id)
+		if err != nil {
+			return err
+		}
+		if err := f.Validate(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A quarantine holds the commits, trees and tags received by
+// ReceivePack in memory until they have been checked.  Blobs are
+// passed through to the repository pushed to, and objects are looked
+// up first from the quarantine and then from that repository.
+type quarantine struct {
+	repository.Interface
+	objects repository.Interface
+}
+
+func (q *quarantine) GetObject(id object.ID) (object.Interface, error) {
+	obj, err := q.objects.GetObject(id)
+	if err == repository.ErrObjectNotExist {
+		return q.Interface.GetObject(id)
+	}
+	return obj, err
+}
+
+func (q *quarantine) PutObject(obj object.Interface) (object.ID, error) {
+	if object.TypeOf(obj) == object.TypeBlob {
+		return q.Interface.PutObject(obj)
+	}
+	return q.objects.PutObject(obj)
+}
+
+func (q *quarantine) OpenBlob(id object.ID) (io.ReadCloser, int64, error) {
+	return repository.OpenBlob(q.Interface, id)
+}
+
+func (q *quarantine) PutBlob(r io.Reader, size int64) (object.ID, error) {
+	return repository.PutBlob(q.Interface, r, size)
+}
+
+func (q *quarantine) ObjectFormat() (object.Format, error) {
+	return repository.ObjectFormat(q.Interface)
+}
+
+// release stores the quarantined object with the given ID in the
+// repository pushed to.
+func (q *quarantine) release(id object.ID) error {
+	obj, err := q.objects.GetObject(id)
+	if err != nil {
+		return err
+	}
+	_, err = q.Interface.PutObject(obj)
+	return err
+}
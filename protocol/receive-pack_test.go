@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/packfile"
+	"github.com/lxr/go.git-scm/pktline"
+	"github.com/lxr/go.git-scm/repository"
+	"github.com/lxr/go.git-scm/repository/mem"
+)
+
+// push returns a receive-pack request creating the ref with the given
+// name at the first of the objects, followed by a packfile of them.
+func push(t *testing.T, ref string, objs ...object.Interface) []byte {
+	id, err := object.Hash(objs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	pktw := pktline.NewWriter(&buf)
+	zero := object.SHA1.ZeroID()
+	pktw.WriteLine(zero.String() + " " + id.String() + " " + ref + "\x00report-status")
+	pktw.Flush()
+	pfw, err := packfile.NewWriter(&buf, int64(len(objs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if err := pfw.WriteObject(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pfw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func commitOf(t *testing.T, tree object.Interface) *object.Commit {
+	id, err := object.Hash(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := object.Signature{
+		Name:  "A U Thor",
+		Email: "author@example.com",
+		Date:  time.Unix(1112911993, 0).UTC(),
+	}
+	return &object.Commit{
+		Tree:      id,
+		Author:    sig,
+		Committer: sig,
+		Message:   "commit\n",
+	}
+}
+
+func TestReceivePackFsck(t *testing.T) {
+	repo := mem.NewRepository()
+	blob := object.Blob("[core]\n\tworktree = ../../..\n")
+	blobID, err := object.Hash(&blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := &object.Tree{".git": {object.ModeBlob, blobID}}
+	badID, err := object.Hash(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	req := push(t, "refs/heads/bad", commitOf(t, bad), bad, &blob)
+	if err := ReceivePack(repo, &out, bytes.NewReader(req)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "unpack ok") {
+		t.Errorf("tree with .git entry accepted: %q", out.String())
+	}
+	// the blob is stored as it is read, but nothing referring to it is
+	if _, err := repo.GetObject(badID); err != repository.ErrObjectNotExist {
+		t.Errorf("GetObject(%s) = %v after rejected push, want ErrObjectNotExist", badID, err)
+	}
+	if _, err := repo.GetRef("refs/heads/bad"); err == nil {
+		t.Error("ref updated after rejected push")
+	}
+
+	good := &object.Tree{"config": {object.ModeBlob, blobID}}
+	goodID, err := object.Hash(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	req = push(t, "refs/heads/good", commitOf(t, good), good, &blob)
+	if err := ReceivePack(repo, &out, bytes.NewReader(req)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "unpack ok") {
+		t.Errorf("clean push rejected: %q", out.String())
+	}
+	for _, id := range []object.ID{goodID, blobID} {
+		if _, err := repo.GetObject(id); err != nil {
+			t.Errorf("GetObject(%s) = %v after accepted push", id, err)
+		}
+	}
+}
+
+func TestReceivePackFsckStoredBlob(t *testing.T) {
+	repo := mem.NewRepository()
+	blob := object.Blob("[submodule \"sub\"]\n\tpath = -sub\n")
+	blobID, err := object.Hash(&blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := &object.Tree{"modules": {object.ModeBlob, blobID}}
+	var out bytes.Buffer
+	req := push(t, "refs/heads/plain", commitOf(t, plain), plain, &blob)
+	if err := ReceivePack(repo, &out, bytes.NewReader(req)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "unpack ok") {
+		t.Fatalf("blob pushed as a regular file rejected: %q", out.String())
+	}
+
+	// a blob already in the repository is checked when a pushed tree
+	// refers to it as a .gitmodules file
+	modules := &object.Tree{".gitmodules": {object.ModeBlob, blobID}}
+	out.Reset()
+	req = push(t, "refs/heads/modules", commitOf(t, modules), modules)
+	if err := ReceivePack(repo, &out, bytes.NewReader(req)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "unpack ok") {
+		t.Errorf("tree with disallowed .gitmodules accepted: %q", out.String())
+	}
+	if _, err := repo.GetRef("refs/heads/modules"); err == nil {
+		t.Error("ref updated after rejected push")
+	}
+}
@@ -17,6 +17,13 @@ import (
 // BUG(lor): UploadPack's support for non-multi_ack_detailed operation
 // is experimental.
 
+// BigFileThreshold is the size in bytes above which UploadPack streams
+// blobs into the packfile with repository.OpenBlob instead of reading
+// them into memory, like the core.bigFileThreshold configuration
+// variable of the reference Git client.  Such blobs are never sent as
+// deltas.
+var BigFileThreshold = 512 << 20
+
 // UploadPack reads from r a pkt-line stream of refs that the client
 // wants and has and writes a packfile bridging the two sets to w.
 func UploadPack(repo repository.Interface, w io.Writer, r io.Reader) error {
@@ -37,7 +44,11 @@ func UploadPack(repo repository.Interface, w io.Writer, r io.Reader) error {
 	if len(want) == 0 {
 		return nil
 	}
-	if d := caps.sub(Capabilities); len(d) > 0 {
+	repoCaps, err := repoCapabilities(repo)
+	if err != nil {
+		return err
+	}
+	if d := caps.sub(repoCaps); len(d) > 0 {
 		return fmt.Errorf("unrecognized capabilities: %s", d)
 	}
 
@@ -75,8 +86,8 @@ func UploadPack(repo repository.Interface, w io.Writer, r io.Reader) error {
 				// point at trees, blobs or Git
 				// submodules, so avoid recursing deeper
 				// into non-commit and non-tag objects.
-				switch obj.(type) {
-				case *object.Commit, *object.Tag:
+				switch object.TypeOf(obj) {
+				case object.TypeCommit, object.TypeTag:
 					return nil
 				default:
 					return repository.SkipObject
@@ -124,28 +135,27 @@ func UploadPack(repo repository.Interface, w io.Writer, r io.Reader) error {
 		}
 	}
 
-	var hdrs objHeaderSlice
-	err := repository.Walk(repo, start, end, func(id object.ID, obj object.Interface, err error) error {
-		if err != nil {
-			return err
-		}
-		hdrs = append(hdrs, objHeader{
-			ID:   id,
-			Type: object.TypeOf(obj),
-			Size: objectSizeOf(obj),
-		})
-		return nil
-	})
+	hdrs, err := listObjects(repo, start, end)
 	if err != nil {
 		return err
 	}
 	sort.Sort(hdrs)
 
-	pfw, err := packfile.NewWriter(w, int64(len(hdrs)))
+	format, err := repository.ObjectFormat(repo)
+	if err != nil {
+		return err
+	}
+	pfw, err := packfile.NewWriterFormat(w, int64(len(hdrs)), format)
 	if err != nil {
 		return err
 	}
 	for _, hdr := range hdrs {
+		if hdr.Type == object.TypeBlob && hdr.Size > BigFileThreshold {
+			if err := writeBlob(pfw, repo, hdr.ID); err != nil {
+				return err
+			}
+			continue
+		}
 		obj, err := repo.GetObject(hdr.ID)
 		if err != nil {
 			return err
@@ -157,6 +167,81 @@ func UploadPack(repo repository.Interface, w io.Writer, r io.Reader) error {
 	return pfw.Close()
 }
 
+// listObjects walks the repository graph from the start objects
+// (inclusive) to the end objects (exclusive) like repository.Walk, and
+// returns the headers of the objects encountered.  Tree entries that
+// are blobs are not retrieved but opened with repository.OpenBlob,
+// which tells their size without reading them into memory if the
+// repository is a repository.BlobStreamer.
+func listObjects(repo repository.Interface, start, end []object.ID) (objHeaderSlice, error) {
+	visited := make(map[object.ID]bool)
+	for _, id := range end {
+		visited[id] = true
+	}
+	blobs := make(map[object.ID]bool)
+	pending := make([]object.ID, len(start))
+	copy(pending, start)
+	var hdrs objHeaderSlice
+	for len(pending) > 0 {
+		var id object.ID
+		n := len(pending) - 1
+		id, pending = pending[n], pending[:n]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		if blobs[id] {
+			rc, size, err := repository.OpenBlob(repo, id)
+			if err == nil {
+				rc.Close()
+				hdrs = append(hdrs, objHeader{id, object.TypeBlob, int(size)})
+				continue
+			}
+			// a tree entry of the wrong type is sent as what
+			// it is
+			if _, ok := err.(*object.TypeError); !ok {
+				return nil, err
+			}
+		}
+		obj, err := repo.GetObject(id)
+		if err != nil {
+			return nil, err
+		}
+		hdrs = append(hdrs, objHeader{id, object.TypeOf(obj), objectSizeOf(obj)})
+
+		parsed, err := object.Parse(obj)
+		if err != nil {
+			continue
+		}
+		switch obj := parsed.(type) {
+		case *object.Commit:
+			pending = append(pending, obj.Tree)
+			pending = append(pending, obj.Parent...)
+		case *object.Tree:
+			for _, ti := range *obj {
+				if ti.Mode.Type() == object.TypeBlob {
+					blobs[ti.Object] = true
+				}
+				pending = append(pending, ti.Object)
+			}
+		case *object.Tag:
+			pending = append(pending, obj.Object)
+		}
+	}
+	return hdrs, nil
+}
+
+// writeBlob streams the blob with the given ID from repo to pfw.
+func writeBlob(pfw *packfile.Writer, repo repository.Interface, id object.ID) error {
+	rc, size, err := repository.OpenBlob(repo, id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return pfw.WriteBlob(rc, size)
+}
+
 // readHaveLines reads a flush-pkt-or-"done"-terminated sequence of
 // "have obj-id" lines from pktr and returns the obj-ids as a boolean
 // map.  The map values are false, so as to allow client code to mark
@@ -191,6 +276,8 @@ func readHaveLines(pktr *pktline.Reader) (map[object.ID]bool, error) {
 // standard Git types.
 func objectSizeOf(obj object.Interface) int {
 	switch obj := obj.(type) {
+	case *object.Raw:
+		return obj.Size()
 	case *object.Commit:
 		n := 6 + 40
 		for range obj.Parent {
@@ -198,6 +285,9 @@ func objectSizeOf(obj object.Interface) int {
 		}
 		n += 28 + len(obj.Author.Name) + len(obj.Author.Email)
 		n += 31 + len(obj.Committer.Name) + len(obj.Committer.Email)
+		for _, h := range obj.Extra {
+			n += 2 + len(h.Key) + len(h.Value)
+		}
 		n += 1 + len(obj.Message)
 		return n
 	case *object.Tree:
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/packfile"
+	"github.com/lxr/go.git-scm/pktline"
+	"github.com/lxr/go.git-scm/repository"
+	"github.com/lxr/go.git-scm/repository/mem"
+)
+
+// A blobCounter counts the blobs retrieved from a repository with
+// GetObject.
+type blobCounter struct {
+	repository.BlobStreamer
+	n int
+}
+
+func (r *blobCounter) GetObject(id object.ID) (object.Interface, error) {
+	obj, err := r.BlobStreamer.GetObject(id)
+	if object.TypeOf(obj) == object.TypeBlob {
+		r.n++
+	}
+	return obj, err
+}
+
+func TestUploadPackBigBlob(t *testing.T) {
+	defer func(n int) { BigFileThreshold = n }(BigFileThreshold)
+	BigFileThreshold = 16
+
+	repo := &blobCounter{BlobStreamer: mem.NewRepository().(repository.BlobStreamer)}
+	big := object.Blob(bytes.Repeat([]byte("big blob\n"), 100))
+	small := object.Blob("small\n")
+	var objs []object.Interface
+	tree := make(object.Tree)
+	for name, blob := range map[string]*object.Blob{"big": &big, "small": &small} {
+		id, err := repo.PutObject(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree[name] = object.TreeInfo{object.ModeBlob, id}
+		objs = append(objs, blob)
+	}
+	commit := commitOf(t, &tree)
+	objs = append(objs, &tree, commit)
+	if _, err := repo.PutObject(&tree); err != nil {
+		t.Fatal(err)
+	}
+	commitID, err := repo.PutObject(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateRef("refs/heads/master", object.SHA1.ZeroID(), commitID); err != nil {
+		t.Fatal(err)
+	}
+
+	var req, out bytes.Buffer
+	pktw := pktline.NewWriter(&req)
+	pktw.WriteLine("want " + commitID.String() + "\n")
+	pktw.Flush()
+	pktw.WriteLine("done\n")
+	if err := UploadPack(repo, &out, &req); err != nil {
+		t.Fatal(err)
+	}
+	if repo.n > 1 {
+		t.Errorf("%d blobs retrieved with GetObject, want at most the small one", repo.n)
+	}
+
+	pktr := pktline.NewReader(&out)
+	if line, err := pktr.ReadLine(); line != "NAK\n" {
+		t.Fatalf("got %q, %v before the packfile, want NAK", line, err)
+	}
+	fetched := mem.NewRepository()
+	pfr, err := packfile.NewReader(&out, fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pfr.Len() > 0 {
+		if _, _, err := pfr.StoreObject(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pfr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		id, err := object.Hash(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fetched.GetObject(id); err != nil {
+			t.Errorf("%s %s not fetched: %v", object.TypeOf(obj), id, err)
+		}
+	}
+}
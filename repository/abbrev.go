@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+var errBadPrefix = errors.New("repository: malformed object ID prefix")
+
+// MinAbbrev is the minimum length of an abbreviated object ID accepted
+// by RevParse, and the minimum length Abbreviate abbreviates IDs to.
+const MinAbbrev = 4
+
+// An AmbiguousError is returned when an abbreviated object ID matches
+// several objects.
+type AmbiguousError struct {
+	Prefix     string      // the abbreviated ID
+	Candidates []object.ID // the matching objects
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("repository: short object ID %s is ambiguous", e.Prefix)
+}
+
+// A PrefixLister is a repository that can efficiently find the objects
+// whose IDs begin with a given prefix.  ResolvePrefix and Abbreviate
+// use this to look up abbreviated object IDs.
+type PrefixLister interface {
+	Interface
+
+	// ListPrefix returns the IDs of the objects in the repository
+	// whose hexadecimal representations begin with the given
+	// prefix, in no particular order.  The prefix consists of
+	// hexadecimal digits of either case.
+	ListPrefix(prefix string) ([]object.ID, error)
+}
+
+// listPrefix returns the IDs of the objects in r beginning with the
+// given prefix, using r's ListPrefix method if r is a PrefixLister.
+// Otherwise all objects are listed if r is an ObjectLister, and only
+// the ones reachable from the refs and HEAD if not.
+func listPrefix(r Interface, prefix string) ([]object.ID, error) {
+	if !isHex(prefix) {
+		return nil, errBadPrefix
+	}
+	if pl, ok := r.(PrefixLister); ok {
+		return pl.ListPrefix(prefix)
+	}
+	var ids []object.ID
+	if ol, ok := r.(ObjectLister); ok {
+		all, err := ol.ListObjects()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range all {
+			if id.HasPrefix(prefix) {
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+	starts, err := allRefs(r)
+	if err != nil {
+		return nil, err
+	}
+	err = Walk(r, starts, nil, func(id object.ID, obj object.Interface, err error) error {
+		if err != nil {
+			return SkipObject
+		}
+		if id.HasPrefix(prefix) {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// ResolvePrefix returns the ID of the only object in the repository
+// whose hexadecimal ID begins with the given prefix.  It returns
+// ErrObjectNotExist if there is no such object, and an *AmbiguousError
+// if there are several.  If r is not a PrefixLister, the objects are
+// searched for as described for ObjectLister, which may be slow.
+func ResolvePrefix(r Interface, prefix string) (object.ID, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	ids, err := listPrefix(r, prefix)
+	switch {
+	case err != nil:
+		return format.ZeroID(), err
+	case len(ids) == 0:
+		return format.ZeroID(), ErrObjectNotExist
+	case len(ids) > 1:
+		return format.ZeroID(), &AmbiguousError{prefix, ids}
+	default:
+		return ids[0], nil
+	}
+}
+
+// Abbreviate returns the shortest prefix of the hexadecimal
+// representation of the ID that is at least n digits long, or
+// MinAbbrev digits if n is less than that, and that no other object in
+// the repository begins with.  The reference Git client abbreviates IDs
+// to at least seven digits by default.
+func Abbreviate(r Interface, id object.ID, n int) (string, error) {
+	hex := id.String()
+	if n < MinAbbrev {
+		n = MinAbbrev
+	}
+	if n >= len(hex) {
+		return hex, nil
+	}
+	ids, err := listPrefix(r, hex[:n])
+	if err != nil {
+		return "", err
+	}
+	length := n
+	for _, other := range ids {
+		if other == id {
+			continue
+		}
+		// the abbreviation must extend past the digits the IDs
+		// have in common
+		s := other.String()
+		i := n
+		for i < len(hex) && hex[i] == s[i] {
+			i++
+		}
+		if i >= length {
+			length = i + 1
+		}
+	}
+	if length > len(hex) {
+		length = len(hex)
+	}
+	return hex[:length], nil
+}
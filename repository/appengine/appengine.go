@@ -6,8 +6,9 @@ package appengine
 
 // BUG(lor): The datastore limits
 // (https://cloud.google.com/datastore/docs/concepts/limits) apply to
-// package appengine too, which restricts its usefulness as a large-file
-// repository backend.
+// package appengine too.  Large blobs are stored in chunks to stay
+// within the maximum entity size, but other objects, such as huge
+// trees, are not.
 
 // BUG(lor): The datastore schema does not distinguish objects between
 // repositories; all objects effectively belong to the same giant object
@@ -19,16 +20,18 @@ import (
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/memcache"
 
+	"github.com/lxr/go.git-scm/object"
 	"github.com/lxr/go.git-scm/repository"
 )
 
 // InitRepository initializes a new Git repository in the App Engine
 // datastore using the given context.  A repository is stored in the
-// datastore using six kinds of entities: HEADs, refs, commits, trees,
-// blobs and tags, with the following schema:
+// datastore using eight kinds of entities: HEADs, refs, commits, trees,
+// blobs, chunks, tags and commit-graphs, with the following schema:
 //
 // 	// actual kind depends on the kind of the root key:
 // 		HEAD (string)
+// 		ObjectFormat (string) // "sha256"; absent for SHA-1
 //
 // 	<prefix>ref:
 // 		ID (string)
@@ -40,19 +43,35 @@ import (
 // 		Author.Email (string)
 // 		Author.Name (string)
 // 		Author.TZ (int) // offset from GMT in seconds
+// 		Author.RawTimestamp (string) // Unix time as recorded; not indexed
+// 		Author.RawTZ (string) // offset as recorded; not indexed
+// 		Author.RawSignature (string) // signature as recorded; not indexed
 // 		Committer.Date (datetime)
 // 		Committer.Email (string)
 // 		Committer.Name (string)
 // 		Committer.TZ (int) // offset from GMT in seconds
+// 		Committer.RawTimestamp (string) // Unix time as recorded; not indexed
+// 		Committer.RawTZ (string) // offset as recorded; not indexed
+// 		Committer.RawSignature (string) // signature as recorded; not indexed
+// 		Extra.Key (list(string)) // extra header keys
+// 		Extra.Value (list(Text)) // extra header values; not indexed
+// 		Extra.Space (list(bool)) // see object.ExtraHeader; not indexed
 // 		Message (Text) // not indexed
+// 		Raw (Blob) // binary representation; not indexed
 //
 // 	<prefix>tree:
 // 		Name (list(string))
 // 		Mode (list(int))
 // 		Object (list(string)) // object IDs
+// 		Raw (Blob) // binary representation; not indexed
 //
 // 	<prefix>blob:
-// 		Contents (Blob) // not indexed
+// 		Contents (Blob) // not indexed; absent if chunked
+// 		Size (int) // not indexed; present only if chunked
+// 		Chunk (list(string)) // chunk key names; not indexed
+//
+// 	<prefix>chunk:
+// 		Data (Blob) // not indexed
 //
 // 	<prefix>tag:
 // 		Object (string) // object ID
@@ -62,7 +81,28 @@ import (
 // 		Tagger.Email (string)
 // 		Tagger.Name (string)
 // 		Tagger.TZ (int) // offset from GMT in seconds
+// 		Tagger.RawTimestamp (string) // Unix time as recorded; not indexed
+// 		Tagger.RawTZ (string) // offset as recorded; not indexed
+// 		Tagger.RawSignature (string) // signature as recorded; not indexed
 // 		Message (Text) // not indexed
+// 		Raw (Blob) // binary representation; not indexed
+//
+// 	<prefix>commitgraph:
+// 		Size (int) // not indexed
+// 		Chunk (list(string)) // chunk key names; not indexed
+//
+// Objects are served from their Raw property, so that they keep their
+// IDs even if they are not in canonical form.  The other properties
+// exist for querying, and are absent if the object could not be parsed
+// when stored.  Objects without a Raw property, such as blobs, are
+// reconstructed from their other properties.
+//
+// Blobs larger than 512 KiB are chunked: their contents are split into
+// chunk entities of at most that size, whose key names are the
+// hexadecimal SHA-256 digests of their data, and the blob entity
+// records the blob's size and its chunks in order.  Chunked blobs can
+// be streamed to and from the repository, which is also a
+// repository.BlobStreamer.
 //
 // The prefix string is prepended to each kind name in order to provide
 // a means of avoiding kind name collisions with other applications
@@ -70,7 +110,9 @@ import (
 // key to avoid memcache collisions.
 //
 // In addition to storing the repository HEAD, the root key is used as
-// the parent of all ref keys, whose names are the names of the refs.
+// the parent of all ref keys, whose names are the names of the refs,
+// and of the key of the commit-graph entity, which records the
+// commit-graph file of the repository in chunks like a chunked blob.
 //
 // For performance, all objects are stored without a parent key (i.e.
 // without an entity group).  The name of an object's key is the
@@ -79,12 +121,31 @@ import (
 // Re-initializing an already initialized repository does not clear it;
 // it merely re-points its HEAD to refs/heads/master.
 func InitRepository(ctx context.Context, root *datastore.Key, prefix string) (repository.Interface, error) {
+	return InitRepositoryFormat(ctx, root, prefix, object.SHA1)
+}
+
+// InitRepositoryFormat is like InitRepository, but the new repository
+// uses the given object format.  It is an error to re-initialize a
+// repository with a different object format.
+func InitRepositoryFormat(ctx context.Context, root *datastore.Key, prefix string, f object.Format) (repository.Interface, error) {
 	r := &repo{
 		ctx:    ctx,
 		root:   root,
 		prefix: prefix,
+		format: new(formatCache),
+	}
+	err := r.updateRoot(func(rt *rootEntity) error {
+		if rt.HEAD != "" && rt.Format != f {
+			return errFormatMismatch
+		}
+		rt.HEAD = "refs/heads/master"
+		rt.Format = f
+		return nil
+	})
+	if err == nil {
+		r.format.set(f)
 	}
-	return r, r.SetHEAD("refs/heads/master")
+	return r, err
 }
 
 // OpenRepository returns a Git repository interface to the App Engine
@@ -93,12 +154,17 @@ func InitRepository(ctx context.Context, root *datastore.Key, prefix string) (re
 // repository.
 //
 // The behavior of the returned repository.Interface is undefined if the
-// root key and prefix do not indicate an initialized repository.
+// root key and prefix do not indicate an initialized repository.  The
+// repositories returned by InitRepository and OpenRepository are also
+// repository.ObjectListers, repository.PrefixListers,
+// repository.ObjectFormatters, repository.BlobStreamers and
+// repository.CommitGraphers.
 func OpenRepository(ctx context.Context, root *datastore.Key, prefix string) repository.Interface {
 	return &repo{
 		ctx:    ctx,
 		root:   root,
 		prefix: prefix,
+		format: new(formatCache),
 	}
 }
 
@@ -106,6 +172,7 @@ type repo struct {
 	ctx    context.Context
 	root   *datastore.Key
 	prefix string
+	format *formatCache // shared by the copies made for transactions
 }
 
 func (r *repo) memkey(key *datastore.Key) string {
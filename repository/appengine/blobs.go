@@ -0,0 +1,189 @@
+package appengine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// chunkSize is the size of the chunks that blobs larger than it are
+// stored in, which keeps the entities well below the datastore's
+// maximum entity size.
+const chunkSize = 512 << 10
+
+// A chunkedBlob is a blob entity whose contents are stored in chunk
+// entities.
+type chunkedBlob struct {
+	Size  int64    `datastore:",noindex"`
+	Chunk []string `datastore:",noindex"`
+}
+
+// A chunk is a piece of the contents of a chunked blob.  Its key name
+// is the hexadecimal SHA-256 digest of its data, so that chunks common
+// to several blobs are stored only once.
+type chunk struct {
+	Data []byte `datastore:",noindex"`
+}
+
+func (r *repo) chunkKey(name string) *datastore.Key {
+	return datastore.NewKey(r.ctx, r.prefix+"chunk", name, 0, nil)
+}
+
+// loadChunkedBlob returns the chunked blob described by props, and
+// false if the blob is not chunked.
+func loadChunkedBlob(props datastore.PropertyList) (*chunkedBlob, bool) {
+	isChunked := false
+	for _, prop := range props {
+		isChunked = isChunked || prop.Name == "Size"
+	}
+	if !isChunked {
+		return nil, false
+	}
+	b := new(chunkedBlob)
+	if err := datastore.LoadStruct(b, props); err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// OpenBlob returns a reader of the blob that retrieves its chunks one
+// at a time.
+func (r *repo) OpenBlob(id object.ID) (io.ReadCloser, int64, error) {
+	var props datastore.PropertyList
+	err := datastore.Get(r.ctx, r.objKey(object.TypeBlob, id), &props)
+	switch err {
+	case nil:
+	case datastore.ErrNoSuchEntity:
+		// the object is either of another type or missing
+		obj, err := r.GetObject(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, &object.TypeError{obj}
+	default:
+		return nil, 0, err
+	}
+	if b, ok := loadChunkedBlob(props); ok {
+		return &chunkReader{repo: r, chunks: b.Chunk}, b.Size, nil
+	}
+	for _, prop := range props {
+		if data, ok := prop.Value.([]byte); ok && prop.Name == "Contents" {
+			return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+		}
+	}
+	return nil, 0, datastore.ErrInvalidEntityType
+}
+
+// PutBlob stores blobs larger than chunkSize in chunks as they are
+// read.  Smaller blobs are stored whole, like by PutObject.
+func (r *repo) PutBlob(rd io.Reader, size int64) (object.ID, error) {
+	format, err := r.ObjectFormat()
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	switch {
+	case size < 0:
+		return format.ZeroID(), repository.ErrBlobSize
+	case size > chunkSize:
+		return r.putChunkedBlob(rd, size, format)
+	}
+	blob := make(object.Blob, size)
+	if _, err := io.ReadFull(rd, blob); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return format.ZeroID(), err
+	}
+	return r.PutObject(&blob)
+}
+
+// BUG(lor): The chunks of a blob are stored before the blob itself, so
+// a failure to store a large blob can leave behind chunks that belong
+// to no blob.
+
+// putChunkedBlob stores a blob of the given size and object format
+// read from rd in chunks.
+func (r *repo) putChunkedBlob(rd io.Reader, size int64, format object.Format) (object.ID, error) {
+	h := object.NewHasher(format, object.TypeBlob, size)
+	b := &chunkedBlob{Size: size}
+	buf := make([]byte, chunkSize)
+	for n := size; n > 0; {
+		data := buf
+		if n < int64(len(data)) {
+			data = data[:n]
+		}
+		if _, err := io.ReadFull(rd, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return format.ZeroID(), err
+		}
+		h.Write(data)
+		sum := sha256.Sum256(data)
+		name := hex.EncodeToString(sum[:])
+		if _, err := datastore.Put(r.ctx, r.chunkKey(name), &chunk{data}); err != nil {
+			return format.ZeroID(), err
+		}
+		b.Chunk = append(b.Chunk, name)
+		n -= int64(len(data))
+	}
+	id, err := h.ID()
+	if err != nil {
+		return id, err
+	}
+	_, err = datastore.Put(r.ctx, r.objKey(object.TypeBlob, id), b)
+	return id, err
+}
+
+// getChunkedBlob reassembles a chunked blob of the given object format.
+func (r *repo) getChunkedBlob(b *chunkedBlob, format object.Format) (*object.Raw, error) {
+	header := object.AppendHeader(nil, object.TypeBlob, b.Size)
+	buf := bytes.NewBuffer(make([]byte, 0, len(header)+int(b.Size)))
+	buf.Write(header)
+	if _, err := io.Copy(buf, &chunkReader{repo: r, chunks: b.Chunk}); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()-len(header)) != b.Size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return object.NewRawFormat(buf.Bytes(), format)
+}
+
+// A chunkReader reads the contents of a chunked blob, retrieving its
+// chunks as they are needed.
+type chunkReader struct {
+	repo   *repo
+	chunks []string // names of the chunks not yet retrieved
+	buf    []byte   // unread data of the current chunk
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if len(cr.chunks) == 0 {
+			return 0, io.EOF
+		}
+		var c chunk
+		if err := datastore.Get(cr.repo.ctx, cr.repo.chunkKey(cr.chunks[0]), &c); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				err = repository.ErrObjectNotExist
+			}
+			return 0, err
+		}
+		cr.buf, cr.chunks = c.Data, cr.chunks[1:]
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+func (cr *chunkReader) Close() error {
+	cr.chunks, cr.buf = nil, nil
+	return nil
+}
@@ -0,0 +1,60 @@
+package appengine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"google.golang.org/appengine/datastore"
+
+	"github.com/lxr/go.git-scm/commitgraph"
+)
+
+func (r *repo) commitGraphKey() *datastore.Key {
+	return datastore.NewKey(r.ctx, r.prefix+"commitgraph", "commitgraph", 0, r.root)
+}
+
+// CommitGraph reads the commit-graph file from its chunks.
+func (r *repo) CommitGraph() (*commitgraph.Graph, error) {
+	var b chunkedBlob
+	switch err := datastore.Get(r.ctx, r.commitGraphKey(), &b); err {
+	case nil:
+	case datastore.ErrNoSuchEntity:
+		return nil, nil
+	default:
+		return nil, err
+	}
+	return commitgraph.Read(&chunkReader{repo: r, chunks: b.Chunk})
+}
+
+// BUG(lor): The chunks of a replaced or deleted commit-graph file are
+// left behind in the datastore.
+
+// SetCommitGraph stores the commit-graph in the commit-graph file
+// format, split into chunks like large blobs.
+func (r *repo) SetCommitGraph(g *commitgraph.Graph) error {
+	key := r.commitGraphKey()
+	if g == nil {
+		err := datastore.Delete(r.ctx, key)
+		if err == datastore.ErrNoSuchEntity {
+			err = nil
+		}
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := g.WriteTo(&buf); err != nil {
+		return err
+	}
+	b := &chunkedBlob{Size: int64(buf.Len())}
+	for buf.Len() > 0 {
+		data := buf.Next(chunkSize)
+		sum := sha256.Sum256(data)
+		name := hex.EncodeToString(sum[:])
+		if _, err := datastore.Put(r.ctx, r.chunkKey(name), &chunk{data}); err != nil {
+			return err
+		}
+		b.Chunk = append(b.Chunk, name)
+	}
+	_, err := datastore.Put(r.ctx, key, b)
+	return err
+}
@@ -1,36 +1,125 @@
 package appengine
 
 import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
+
+	"github.com/lxr/go.git-scm/object"
 )
 
-type head string
+var errFormatMismatch = errors.New("appengine: repository already initialized with a different object format")
 
-func (h *head) Save() ([]datastore.Property, error) {
-	return []datastore.Property{
-		{Name: "HEAD", Value: string(*h)},
-	}, nil
+// A rootEntity is the entity stored under the root key of a repository.
+type rootEntity struct {
+	HEAD   string
+	Format object.Format
 }
 
-func (h *head) Load(props []datastore.Property) error {
-	if len(props) != 1 || props[0].Name != "HEAD" {
-		return datastore.ErrInvalidEntityType
+func (rt *rootEntity) Save() ([]datastore.Property, error) {
+	props := []datastore.Property{
+		{Name: "HEAD", Value: rt.HEAD},
+	}
+	if rt.Format != object.SHA1 {
+		props = append(props, datastore.Property{
+			Name:  "ObjectFormat",
+			Value: rt.Format.String(),
+		})
+	}
+	return props, nil
+}
+
+func (rt *rootEntity) Load(props []datastore.Property) error {
+	*rt = rootEntity{}
+	hasHEAD := false
+	for _, prop := range props {
+		s, ok := prop.Value.(string)
+		if !ok {
+			return datastore.ErrInvalidEntityType
+		}
+		switch prop.Name {
+		case "HEAD":
+			rt.HEAD, hasHEAD = s, true
+		case "ObjectFormat":
+			f, err := object.ParseFormat(s)
+			if err != nil {
+				return err
+			}
+			rt.Format = f
+		default:
+			return datastore.ErrInvalidEntityType
+		}
 	}
-	target, ok := props[0].Value.(string)
-	if !ok {
+	if !hasHEAD {
 		return datastore.ErrInvalidEntityType
 	}
-	*h = head(target)
 	return nil
 }
 
+// updateRoot atomically applies fn to the root entity of the
+// repository.  The entity passed to fn is the zero value if the
+// repository has not been initialized.
+func (r *repo) updateRoot(fn func(rt *rootEntity) error) error {
+	return datastore.RunInTransaction(r.ctx, func(tc context.Context) error {
+		var rt rootEntity
+		tr := *r
+		tr.ctx = tc
+		if err := tr.get(r.root, &rt); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err := fn(&rt); err != nil {
+			return err
+		}
+		return tr.put(r.root, &rt)
+	}, nil)
+}
+
 func (r *repo) GetHEAD() (string, error) {
-	var head head
-	err := r.get(r.root, &head)
-	return string(head), err
+	var rt rootEntity
+	err := r.get(r.root, &rt)
+	return rt.HEAD, err
 }
 
 func (r *repo) SetHEAD(name string) error {
-	s := head(name)
-	return r.put(r.root, &s)
+	return r.updateRoot(func(rt *rootEntity) error {
+		rt.HEAD = name
+		return nil
+	})
+}
+
+// ObjectFormat reads the object format from the root entity only the
+// first time it is called, as every object access needs it and the
+// format of an initialized repository never changes.
+func (r *repo) ObjectFormat() (object.Format, error) {
+	if f, ok := r.format.get(); ok {
+		return f, nil
+	}
+	var rt rootEntity
+	if err := r.get(r.root, &rt); err != nil {
+		return rt.Format, err
+	}
+	r.format.set(rt.Format)
+	return rt.Format, nil
+}
+
+// A formatCache holds the object format of a repository once it is
+// known.
+type formatCache struct {
+	mu    sync.Mutex
+	f     object.Format
+	known bool
+}
+
+func (c *formatCache) get() (object.Format, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f, c.known
+}
+
+func (c *formatCache) set(f object.Format) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.f, c.known = f, true
 }
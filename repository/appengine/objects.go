@@ -1,6 +1,9 @@
 package appengine
 
 import (
+	"bytes"
+	"strings"
+
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/memcache"
 
@@ -9,12 +12,16 @@ import (
 )
 
 func (r *repo) GetObject(id object.ID) (object.Interface, error) {
+	format, err := r.ObjectFormat()
+	if err != nil {
+		return nil, err
+	}
 	if data, err := r.getObjectMemcache(id); err == nil {
-		return object.Unmarshal(data)
+		return object.NewRawFormat(data, format)
 	} else if err != memcache.ErrCacheMiss {
 		return nil, err
 	}
-	obj, err := r.getObject(id)
+	obj, err := r.getObject(id, format)
 	if err == nil {
 		data, err := obj.MarshalBinary()
 		if err == nil {
@@ -25,24 +32,85 @@ func (r *repo) GetObject(id object.ID) (object.Interface, error) {
 }
 
 func (r *repo) PutObject(obj object.Interface) (object.ID, error) {
-	id, data, err := r.putObject(obj)
+	format, err := r.ObjectFormat()
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	id, data, err := r.putObject(obj, format)
 	if err == nil {
 		r.putObjectMemcache(id, data)
 	}
 	return id, err
 }
 
+// BUG(lor): As all objects effectively belong to the same object
+// store, ListObjects lists the objects of every repository sharing the
+// same prefix, which makes them appear unreachable to
+// repository.Fsck.
+
+func (r *repo) ListObjects() ([]object.ID, error) {
+	var ids []object.ID
+	for t := object.TypeCommit; t < object.TypeReserved; t++ {
+		keys, err := datastore.NewQuery(r.prefix + t.String()).
+			KeysOnly().
+			GetAll(r.ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			id, err := object.DecodeID(key.StringID())
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// ListPrefix queries the range of object keys beginning with the
+// prefix.  Like ListObjects, it finds the objects of every repository
+// sharing the same prefix.
+func (r *repo) ListPrefix(prefix string) ([]object.ID, error) {
+	// key names are lowercase hexadecimal, so the names beginning
+	// with prefix sort before prefix followed by any other letter
+	prefix = strings.ToLower(prefix)
+	var ids []object.ID
+	for t := object.TypeCommit; t < object.TypeReserved; t++ {
+		kind := r.prefix + t.String()
+		keys, err := datastore.NewQuery(kind).
+			Filter("__key__ >=", datastore.NewKey(r.ctx, kind, prefix, 0, nil)).
+			Filter("__key__ <", datastore.NewKey(r.ctx, kind, prefix+"g", 0, nil)).
+			KeysOnly().
+			GetAll(r.ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			id, err := object.DecodeID(key.StringID())
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
 func (r *repo) objKey(objType object.Type, id object.ID) *datastore.Key {
 	return datastore.NewKey(r.ctx, r.prefix+objType.String(), id.String(), 0, nil)
 }
 
-func (r *repo) getObject(id object.ID) (object.Interface, error) {
+func (r *repo) getObject(id object.ID, format object.Format) (object.Interface, error) {
 	for t := object.TypeCommit; t < object.TypeReserved; t++ {
-		obj, _ := object.New(t)
-		err := datastore.Get(r.ctx, r.objKey(t, id), obj)
+		var props datastore.PropertyList
+		err := datastore.Get(r.ctx, r.objKey(t, id), &props)
 		switch err {
 		case nil:
-			return obj, nil
+			if b, ok := loadChunkedBlob(props); ok && t == object.TypeBlob {
+				return r.getChunkedBlob(b, format)
+			}
+			return loadRaw(t, format, props)
 		case datastore.ErrNoSuchEntity:
 			// try the next object type
 		default:
@@ -52,12 +120,48 @@ func (r *repo) getObject(id object.ID) (object.Interface, error) {
 	return nil, repository.ErrObjectNotExist
 }
 
-func (r *repo) putObject(obj object.Interface) (object.ID, []byte, error) {
-	data, id, err := object.Marshal(obj)
-	if err == nil {
-		t := object.TypeOf(obj)
-		_, err = datastore.Put(r.ctx, r.objKey(t, id), obj)
+// loadRaw loads an object of the given type and format from its
+// datastore properties.  Objects stored without a Raw property are
+// reconstructed from their parsed form.
+func loadRaw(t object.Type, format object.Format, props datastore.PropertyList) (*object.Raw, error) {
+	hasRaw := t == object.TypeBlob
+	for _, prop := range props {
+		hasRaw = hasRaw || prop.Name == "Raw"
+	}
+	if hasRaw {
+		raw := new(object.Raw)
+		if err := raw.Load(props); err != nil {
+			return nil, err
+		}
+		data, _ := raw.MarshalBinary()
+		return object.NewRawFormat(data, format)
+	}
+	obj, _ := object.New(t)
+	if err := obj.(datastore.PropertyLoadSaver).Load(props); err != nil {
+		return nil, err
+	}
+	data, _, err := object.MarshalFormat(obj, format)
+	if err != nil {
+		return nil, err
+	}
+	return object.NewRawFormat(data, format)
+}
+
+func (r *repo) putObject(obj object.Interface, format object.Format) (object.ID, []byte, error) {
+	data, id, err := object.MarshalFormat(obj, format)
+	if err != nil {
+		return id, nil, err
+	}
+	raw, err := object.NewRawFormat(data, format)
+	if err != nil {
+		return id, data, err
+	}
+	if raw.Type() == object.TypeBlob && raw.Size() > chunkSize {
+		body := data[len(data)-raw.Size():]
+		id, err = r.putChunkedBlob(bytes.NewReader(body), int64(len(body)), format)
+		return id, data, err
 	}
+	_, err = datastore.Put(r.ctx, r.objKey(raw.Type(), id), raw)
 	return id, data, err
 }
 
@@ -41,19 +41,22 @@ func (r *repo) UpdateRef(name string, oldID, newID object.ID) error {
 		if err := tr.get(key, &id); err != nil && err != datastore.ErrNoSuchEntity {
 			return err
 		}
+		if id.IsZero() {
+			id = oldID.Format().ZeroID()
+		}
 		if id != oldID {
-			switch object.ZeroID {
-			case id:
+			switch {
+			case id.IsZero():
 				return repository.ErrRefNotExist
-			case oldID:
+			case oldID.IsZero():
 				return repository.ErrRefExist
 			default:
 				return repository.ErrRefMismatch
 			}
 		}
-		switch newID {
-		case object.ZeroID:
-			if oldID == object.ZeroID {
+		switch {
+		case newID.IsZero():
+			if oldID.IsZero() {
 				return nil
 			}
 			return mapRefErr(tr.del(key))
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// ErrBlobSize is returned by PutBlob if the size of the blob is
+// negative.
+var ErrBlobSize = errors.New("repository: negative blob size")
+
+// A BlobStreamer is a repository that can read and write the contents
+// of blobs as streams, without holding them in memory in their
+// entirety.  OpenBlob and PutBlob use this to handle large files.
+type BlobStreamer interface {
+	Interface
+
+	// OpenBlob returns a reader of the contents of the blob with
+	// the given ID, and the size of the contents.  It returns an
+	// *object.TypeError containing the object if the object is not a
+	// blob.  The caller must close the reader.
+	OpenBlob(id object.ID) (io.ReadCloser, int64, error)
+
+	// PutBlob stores a blob whose contents of the given size are
+	// read from r, and returns its ID.  It returns
+	// io.ErrUnexpectedEOF if r ends before size bytes have been
+	// read; no further bytes are read from r.  It returns
+	// ErrBlobSize if size is negative.  Like PutObject, it is
+	// idempotent.
+	PutBlob(r io.Reader, size int64) (object.ID, error)
+}
+
+// OpenBlob returns a reader of the contents of the blob with the given
+// ID and their size, using r's OpenBlob method if r is a BlobStreamer.
+// Otherwise the blob is retrieved with GetObject.  It returns an
+// *object.TypeError containing the object if the object is not a blob.
+// The caller must close the reader.
+func OpenBlob(r Interface, id object.ID) (io.ReadCloser, int64, error) {
+	if bs, ok := r.(BlobStreamer); ok {
+		return bs.OpenBlob(id)
+	}
+	obj, err := r.GetObject(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if object.TypeOf(obj) != object.TypeBlob {
+		return nil, 0, &object.TypeError{obj}
+	}
+	data, err := obj.MarshalBinary()
+	if err != nil {
+		return nil, 0, err
+	}
+	data = data[bytes.IndexByte(data, 0)+1:]
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// PutBlob stores a blob whose contents of the given size are read from
+// rd and returns its ID, using r's PutBlob method if r is a
+// BlobStreamer.  Otherwise the contents are read into memory and stored
+// with PutObject.  It returns io.ErrUnexpectedEOF if rd ends before
+// size bytes have been read, and ErrBlobSize if size is negative.
+func PutBlob(r Interface, rd io.Reader, size int64) (object.ID, error) {
+	if bs, ok := r.(BlobStreamer); ok {
+		return bs.PutBlob(rd, size)
+	}
+	format, _ := ObjectFormat(r)
+	if size < 0 {
+		return format.ZeroID(), ErrBlobSize
+	}
+	// NOTE(lor): The size often comes from a packfile, so the buffer
+	// grows as the contents are read instead of being allocated up
+	// front, lest a bogus size exhaust memory before rd runs out.
+	var buf bytes.Buffer
+	if n, err := io.CopyN(&buf, rd, size); n < size {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return format.ZeroID(), err
+	}
+	blob := object.Blob(buf.Bytes())
+	return r.PutObject(&blob)
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"github.com/lxr/go.git-scm/commitgraph"
+	"github.com/lxr/go.git-scm/object"
+)
+
+// A CommitGrapher is a repository that can store a commit-graph of its
+// commits.  RevList, MergeBases and the other commit walks consult the
+// stored commit-graph for the parents, dates and generations of the
+// commits in it instead of retrieving the commits themselves, and order
+// their walks by generation where the walks of the reference Git
+// client do.  The commit-graph needs to be rebuilt with
+// BuildCommitGraph for new commits to benefit.
+type CommitGrapher interface {
+	Interface
+
+	// CommitGraph returns the stored commit-graph, or nil if there
+	// is none.  The returned commit-graph must not be modified.
+	CommitGraph() (*commitgraph.Graph, error)
+
+	// SetCommitGraph stores the commit-graph, replacing the
+	// previous one.  A nil commit-graph deletes it.
+	SetCommitGraph(g *commitgraph.Graph) error
+}
+
+// BuildCommitGraph returns a commit-graph of the commits reachable from
+// the refs and HEAD of r.  If changedPaths is set, the commits are
+// given changed-path Bloom filters, which requires comparing their
+// trees to those of their first parents.  If r is a CommitGrapher, the
+// commits and Bloom filters in its commit-graph are reused, so that
+// only the new commits are retrieved.
+func BuildCommitGraph(r Interface, changedPaths bool) (*commitgraph.Graph, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	var old *commitgraph.Graph
+	if cg, ok := r.(CommitGrapher); ok {
+		if old, err = cg.CommitGraph(); err != nil {
+			return nil, err
+		}
+	}
+	pending, err := allRefs(r)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitgraph.Commit
+	index := make(map[object.ID]int)
+	for refs := len(pending); len(pending) > 0; {
+		n := len(pending) - 1
+		id := pending[n]
+		pending = pending[:n]
+		isRef := n < refs
+		if isRef {
+			refs--
+		}
+		if _, ok := index[id]; ok {
+			continue
+		}
+		var c commitgraph.Commit
+		if oc, ok := findCommit(old, id); ok {
+			c = *oc
+		} else {
+			commit, cid, err := GetCommit(r, id)
+			if _, ok := err.(*object.TypeError); ok && isRef {
+				continue // refs may point to non-commits
+			} else if err != nil {
+				return nil, err
+			}
+			if _, ok := index[cid]; ok {
+				continue
+			}
+			c = commitgraph.Commit{
+				ID:      cid,
+				Tree:    commit.Tree,
+				Parents: commit.Parent,
+				Time:    commit.Committer.Date.Unix(),
+			}
+		}
+		if !changedPaths {
+			c.Bloom = nil
+		}
+		index[c.ID] = len(commits)
+		commits = append(commits, c)
+		pending = append(pending, c.Parents...)
+	}
+
+	if changedPaths {
+		for i := range commits {
+			c := &commits[i]
+			if c.Bloom != nil {
+				continue
+			}
+			base := format.ZeroID()
+			if len(c.Parents) > 0 {
+				base = commits[index[c.Parents[0]]].Tree
+			}
+			paths, err := diffPaths(r, base, c.Tree)
+			if err != nil {
+				return nil, err
+			}
+			c.Bloom = commitgraph.NewBloomFilter(paths)
+		}
+	}
+	return commitgraph.New(format, commits)
+}
+
+// findCommit returns the commit with the given ID in the commit-graph g,
+// which may be nil.
+func findCommit(g *commitgraph.Graph, id object.ID) (*commitgraph.Commit, bool) {
+	if g == nil {
+		return nil, false
+	}
+	return g.Find(id)
+}
+
+// diffPaths returns the paths of the files that differ between the
+// trees with the given IDs, the first of which may be zero for an empty
+// tree.  It stops comparing once more than commitgraph.MaxChangedPaths
+// paths differ.
+func diffPaths(r Interface, a, b object.ID) ([]string, error) {
+	changes, err := diffTree(r, "", a, b, commitgraph.MaxChangedPaths, nil)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(changes))
+	for i, c := range changes {
+		paths[i] = c.NewPath
+	}
+	return paths, nil
+}
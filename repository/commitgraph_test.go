@@ -0,0 +1,167 @@
+package repository_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/lxr/go.git-scm/commitgraph"
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// commitGraphHistory returns a repository with an octopus merge, a
+// commit dated long before its parent, a commit that changes no files
+// and one that changes too many for its Bloom filter.
+func commitGraphHistory(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("a", map[string]string{"a": "a\n", "d/e/f": "f\n"})
+	r.commit("b", map[string]string{"a": "a\n", "d/e/f": "f\n", "d/g": "g\n"}, "a")
+	r.commit("c", map[string]string{"a": "c\n", "d/e/f": "f\n"}, "a")
+	r.commit("d", map[string]string{"d/e/f": "d\n"}, "a")
+	r.commit("octopus", nil, "b", "c", "d")
+	r.commitAt("future", 5000000000, nil, "octopus")
+	r.commitAt("past", 1000000000, nil, "future")
+	r.commit("empty", nil, "past")
+	big := make(map[string]string)
+	for i := 0; i < commitgraph.MaxChangedPaths+1; i++ {
+		big[fmt.Sprintf("big/%d", i)] = fmt.Sprintf("%d\n", i)
+	}
+	r.commit("big", big, "empty", "b")
+	return r
+}
+
+// The golden files hold the commit-graph files git commit-graph write
+// --reachable writes for commitGraphHistory, without and with
+// --changed-paths.  The reference Git client writes version 1 Bloom
+// filters, which are the same as version 2 ones for paths of ASCII
+// characters.
+func TestBuildCommitGraph(t *testing.T) {
+	r := commitGraphHistory(t)
+	r.writeCommitGraph()
+	g, err := r.repo.(repository.CommitGrapher).CommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := g.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := readTestdata(t, "commit-graph")
+	if buf.String() != want {
+		t.Error("commit-graph differs from git's")
+	}
+	if gg, err := commitgraph.Read(bytes.NewBufferString(want)); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(gg, g) {
+		t.Errorf("read\n%+v\nfrom git's commit-graph, want\n%+v", gg, g)
+	}
+
+	g, err = repository.BuildCommitGraph(r.repo, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := g.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want = readTestdata(t, "commit-graph-changed-paths")
+	if got := bloomVersion1(t, buf.Bytes()); !bytes.Equal(got, []byte(want)) {
+		t.Error("commit-graph with Bloom filters differs from git's")
+	}
+}
+
+// bloomVersion1 returns the commit-graph file with the version of its
+// Bloom filters set to 1, and its checksum updated to match.
+func bloomVersion1(t *testing.T, file []byte) []byte {
+	file = append([]byte(nil), file...)
+	for i := 0; i < int(file[6]); i++ {
+		entry := file[8+12*i:]
+		if string(entry[:4]) == "BDAT" {
+			binary.BigEndian.PutUint32(file[binary.BigEndian.Uint64(entry[4:]):], 1)
+		}
+	}
+	body := file[:len(file)-object.SHA1.Size()]
+	sum, err := object.SHA1.Sum(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(body, sum.Bytes()...)
+}
+
+// TestBuildCommitGraphReuse checks that BuildCommitGraph takes the
+// commits of the stored commit-graph from it, and that it computes
+// only missing Bloom filters.
+func TestBuildCommitGraphReuse(t *testing.T) {
+	r := commitGraphHistory(t)
+	r.writeCommitGraph()
+	cg := r.repo.(repository.CommitGrapher)
+	old, err := cg.CommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := r.commit("new", nil, "big")
+	if err := r.repo.UpdateRef("refs/heads/new", object.ZeroID, id); err != nil {
+		t.Fatal(err)
+	}
+	g, err := repository.BuildCommitGraph(r.repo, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Commits) != len(old.Commits)+1 {
+		t.Fatalf("%d commits after adding one to %d", len(g.Commits), len(old.Commits))
+	}
+
+	// the commits of the commit-graph are taken as they are, even
+	// if they do not match those of the repository
+	var commits []commitgraph.Commit
+	for _, c := range g.Commits {
+		c.Bloom = commitgraph.BloomFilter{0xff}
+		if c.ID == r.id("past") {
+			c.Time = 0
+		}
+		commits = append(commits, c)
+	}
+	stale, err := commitgraph.New(g.Format, commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cg.SetCommitGraph(stale); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repository.BuildCommitGraph(r.repo, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, stale) {
+		t.Errorf("commit-graph rebuilt as\n%+v\nwant\n%+v", got, stale)
+	}
+	if got, err = repository.BuildCommitGraph(r.repo, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range got.Commits {
+		if c.Bloom != nil {
+			t.Fatalf("Bloom filter of %s kept", r.nameList([]object.ID{c.ID}))
+		}
+	}
+
+	// missing Bloom filters are computed
+	if err := cg.SetCommitGraph(nil); err != nil {
+		t.Fatal(err)
+	}
+	want, err := repository.BuildCommitGraph(r.repo, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cg.SetCommitGraph(g); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = repository.BuildCommitGraph(r.repo, true); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commit-graph rebuilt as\n%+v\nwant\n%+v", got, want)
+	}
+}
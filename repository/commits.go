@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// ErrConflict is returned by CommitChanges if the ref it commits to
+// has moved and the new commits change the same paths as the changes.
+var ErrConflict = errors.New("repository: conflicting changes to the same path")
+
+// commitRetries is the number of times CommitChanges retries updating a
+// ref that keeps moving under it.
+const commitRetries = 10
+
+// A Change is an edit to the file at a path, made by CommitChanges.
+// If Object is zero, the file is deleted; otherwise it is set to the
+// object with the given ID and mode, as by TreeEditor.Set.  Renames are
+// expressed as a deletion and an addition.
+type Change struct {
+	Path   string
+	Mode   object.TreeMode
+	Object object.ID
+}
+
+// entry returns the tree entry the change results in, which is the
+// zero TreeInfo if the change is a deletion.
+func (c Change) entry() object.TreeInfo {
+	if c.Object.IsZero() {
+		return object.TreeInfo{}
+	}
+	return object.TreeInfo{c.Mode, c.Object}
+}
+
+// CommitChanges commits the changes on top of the commit the named ref
+// points to, and advances the ref to the new commit, whose ID it
+// returns.  If the ref does not exist, it is created and the commit has
+// no parents.  Deleting a path that does not exist is not an error.
+//
+// If the ref moves between the time CommitChanges reads it and updates
+// it, the changes are applied anew on top of the commit it has moved
+// to, unless the commits it has moved past change any of the paths
+// differently than the changes do, in which case CommitChanges returns
+// ErrConflict.  If the ref keeps moving, CommitChanges gives up after a
+// number of retries and returns ErrRefMismatch.
+func CommitChanges(r Interface, ref string, changes []Change, author, committer object.Signature, message string) (object.ID, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	base, err := r.GetRef(ref)
+	switch err {
+	case nil:
+	case ErrRefNotExist:
+		base = format.ZeroID()
+	default:
+		return format.ZeroID(), err
+	}
+	orig, err := changedEntries(r, base, changes)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	cur := orig
+	for i := 0; ; i++ {
+		id, err := commitChanges(r, base, changes, cur, author, committer, message)
+		if err != nil {
+			return id, err
+		}
+		err = r.UpdateRef(ref, base, id)
+		if (err != ErrRefMismatch && err != ErrRefExist) || i == commitRetries {
+			return id, err
+		}
+
+		// the ref has moved; check that the commits it has moved
+		// past leave the changed paths alone
+		if base, err = r.GetRef(ref); err == ErrRefNotExist {
+			base = format.ZeroID()
+		} else if err != nil {
+			return format.ZeroID(), err
+		}
+		if cur, err = changedEntries(r, base, changes); err != nil {
+			return format.ZeroID(), err
+		}
+		for j, c := range changes {
+			if cur[j] != orig[j] && cur[j] != c.entry() {
+				return format.ZeroID(), ErrConflict
+			}
+		}
+	}
+}
+
+// commitChanges stores a commit making the changes on top of the commit
+// with the given ID, or a root commit if the ID is zero, and returns its
+// ID.  cur holds the entries at the changed paths in the base commit.
+func commitChanges(r Interface, base object.ID, changes []Change, cur []object.TreeInfo, author, committer object.Signature, message string) (object.ID, error) {
+	commit := &object.Commit{
+		Author:    author,
+		Committer: committer,
+		Message:   message,
+	}
+	var root object.ID
+	if !base.IsZero() {
+		c, id, err := GetCommit(r, base)
+		if err != nil {
+			return id, err
+		}
+		commit.Parent = []object.ID{id}
+		root = c.Tree
+	}
+	e, err := NewTreeEditor(r, root)
+	if err != nil {
+		return root, err
+	}
+	for i, c := range changes {
+		switch {
+		case !c.Object.IsZero():
+			err = e.Set(c.Path, c.Mode, c.Object)
+		case cur[i] != object.TreeInfo{}:
+			err = e.Delete(c.Path)
+		}
+		if err != nil {
+			return root, err
+		}
+	}
+	if commit.Tree, err = e.Write(); err != nil {
+		return commit.Tree, err
+	}
+	return r.PutObject(commit)
+}
+
+// changedEntries returns the tree entries at the paths of the changes in
+// the tree of the commit with the given ID, with the zero TreeInfo for
+// paths that do not exist.  If the ID is zero, all the entries are
+// zero.
+func changedEntries(r Interface, id object.ID, changes []Change) ([]object.TreeInfo, error) {
+	entries := make([]object.TreeInfo, len(changes))
+	if id.IsZero() {
+		return entries, nil
+	}
+	root, _, err := GetTree(r, id)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range changes {
+		dirs, base, err := splitPath(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		if entries[i], err = lookupEntry(r, root, dirs, base); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// lookupEntry returns the tree entry with the given directory path and
+// name in the tree hierarchy rooted at tree, or the zero TreeInfo if
+// there is none.
+func lookupEntry(r Interface, tree *object.Tree, dirs []string, name string) (object.TreeInfo, error) {
+	for _, dir := range dirs {
+		ti, ok := (*tree)[dir]
+		if !ok || ti.Mode != object.ModeTree {
+			return object.TreeInfo{}, nil
+		}
+		var err error
+		if tree, err = getTree(r, ti.Object); err != nil {
+			return object.TreeInfo{}, err
+		}
+	}
+	return (*tree)[name], nil
+}
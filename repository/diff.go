@@ -0,0 +1,723 @@
+package repository
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"sort"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// A DiffStatus is the kind of a TreeChange.  Its value is the letter
+// the reference Git client uses for it in diff --raw output.
+type DiffStatus byte
+
+// The kinds of TreeChanges.
+const (
+	DiffAdded       DiffStatus = 'A'
+	DiffDeleted     DiffStatus = 'D'
+	DiffModified    DiffStatus = 'M' // contents or executable bit
+	DiffTypeChanged DiffStatus = 'T' // e.g. file replaced by symlink
+	DiffRenamed     DiffStatus = 'R'
+	DiffCopied      DiffStatus = 'C'
+)
+
+func (s DiffStatus) String() string {
+	return string(rune(s))
+}
+
+// A TreeChange is a difference between two tree hierarchies in the
+// entry of a single file, symlink or submodule.  OldPath and NewPath
+// are slash-separated paths relative to the roots of the hierarchies,
+// and differ only for renames and copies.  Old is the zero TreeInfo for
+// additions, and New for deletions.
+type TreeChange struct {
+	Status  DiffStatus
+	OldPath string
+	NewPath string
+	Old     object.TreeInfo
+	New     object.TreeInfo
+
+	// Score is the similarity of the old and new contents of a
+	// renamed or copied file in percent.
+	Score int
+}
+
+// DiffOptions control the rename and copy detection of DiffTrees.  A
+// nil *DiffOptions detects neither.
+type DiffOptions struct {
+	// DetectRenames pairs deleted files with added files of
+	// similar contents, and reports the pairs as renames.  Like in
+	// the reference Git client, a deleted file paired with several
+	// added files is reported copied to all but the last of them.
+	DetectRenames bool
+
+	// DetectCopies, which implies DetectRenames, also pairs
+	// modified files with added files, and reports the pairs as
+	// copies.
+	DetectCopies bool
+
+	// FindCopiesHarder, which implies DetectCopies, also pairs
+	// unmodified files with added files.  This requires comparing
+	// every file in the old hierarchy with the added files.
+	FindCopiesHarder bool
+
+	// RenameThreshold is the similarity in percent that files need
+	// to be paired.  If zero, the threshold is 50 percent.
+	// Identical files are paired regardless.
+	RenameThreshold int
+
+	// RenameLimit is the number of files on either side of the
+	// pairing above which files are paired only if identical, as
+	// comparing the contents of all pairs would take too long.  If
+	// zero, the limit is 1000.
+	RenameLimit int
+}
+
+// The defaults of the DiffOptions.
+const (
+	defaultRenameThreshold = 50
+	defaultRenameLimit     = 1000
+)
+
+// DiffTrees compares the tree hierarchies rooted at the given IDs, which
+// may point to tree, commit or tag objects as with GetTree, and returns
+// their differences in the order the reference Git client reports
+// them.  A zero ID denotes the empty tree.  Subtrees with identical IDs
+// are not compared.  Files replaced by directories and vice versa are
+// reported as deleted and added.
+func DiffTrees(r Interface, a, b object.ID, opts *DiffOptions) ([]TreeChange, error) {
+	var err error
+	if a, err = diffRoot(r, a); err != nil {
+		return nil, err
+	}
+	if b, err = diffRoot(r, b); err != nil {
+		return nil, err
+	}
+	changes, err := diffTree(r, "", a, b, 0, nil)
+	if err != nil || opts == nil {
+		return changes, err
+	}
+	if opts.DetectRenames || opts.DetectCopies || opts.FindCopiesHarder {
+		return detectRenames(r, a, changes, opts)
+	}
+	return changes, nil
+}
+
+// DiffCommit compares the tree of the commit the ID dereferences to
+// with the trees of its parents as with DiffTrees, and returns the
+// differences to each parent in order.  The tree of a root commit is
+// compared with the empty tree.
+func DiffCommit(r Interface, id object.ID, opts *DiffOptions) ([][]TreeChange, error) {
+	c, _, err := GetCommit(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Parent) == 0 {
+		format, err := ObjectFormat(r)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := DiffTrees(r, format.ZeroID(), c.Tree, opts)
+		if err != nil {
+			return nil, err
+		}
+		return [][]TreeChange{changes}, nil
+	}
+	diffs := make([][]TreeChange, len(c.Parent))
+	for i, parent := range c.Parent {
+		if diffs[i], err = DiffTrees(r, parent, c.Tree, opts); err != nil {
+			return nil, err
+		}
+	}
+	return diffs, nil
+}
+
+// diffRoot returns the ID of the tree the ID dereferences to, or the
+// zero ID if it is zero.
+func diffRoot(r Interface, id object.ID) (object.ID, error) {
+	if id.IsZero() {
+		return id, nil
+	}
+	_, id, err := GetTree(r, id)
+	return id, err
+}
+
+// A treeEntry is a named entry of a tree.
+type treeEntry struct {
+	name string
+	object.TreeInfo
+}
+
+// key returns the name by which the entry is sorted in a tree.
+func (e treeEntry) key() string {
+	if e.Mode == object.ModeTree {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+// treeEntries returns the entries of the tree with the given ID in the
+// Git order, or none if the ID is zero.
+func treeEntries(r Interface, id object.ID) ([]treeEntry, error) {
+	if id.IsZero() {
+		return nil, nil
+	}
+	tree, err := getTree(r, id)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]treeEntry, 0, len(*tree))
+	for _, name := range tree.Names() {
+		entries = append(entries, treeEntry{name, (*tree)[name]})
+	}
+	return entries, nil
+}
+
+// diffTree appends to changes the differences between the tree
+// hierarchies rooted at the trees with the given IDs, either of which
+// may be zero, and returns the result.  The paths are prefixed with
+// prefix.  If limit is positive, it stops comparing once there are
+// more than limit changes.
+func diffTree(r Interface, prefix string, a, b object.ID, limit int, changes []TreeChange) ([]TreeChange, error) {
+	as, err := treeEntries(r, a)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := treeEntries(r, b)
+	if err != nil {
+		return nil, err
+	}
+	zero := a
+	if zero.IsZero() {
+		zero = b
+	}
+	zero = zero.Format().ZeroID()
+	for len(as) > 0 || len(bs) > 0 {
+		if limit > 0 && len(changes) > limit {
+			break
+		}
+		// entries of the same name and kind are compared with
+		// each other, others with nothing
+		var ea, eb treeEntry
+		switch {
+		case len(bs) == 0 || len(as) > 0 && as[0].key() < bs[0].key():
+			ea, as = as[0], as[1:]
+		case len(as) == 0 || bs[0].key() < as[0].key():
+			eb, bs = bs[0], bs[1:]
+		default:
+			ea, eb, as, bs = as[0], bs[0], as[1:], bs[1:]
+		}
+		if ea.TreeInfo == eb.TreeInfo {
+			continue
+		}
+		name := prefix + ea.name
+		if ea.name == "" {
+			name = prefix + eb.name
+		}
+		if ea.Mode == object.ModeTree || eb.Mode == object.ModeTree {
+			sa, sb := zero, zero
+			if ea.Mode == object.ModeTree {
+				sa = ea.Object
+			}
+			if eb.Mode == object.ModeTree {
+				sb = eb.Object
+			}
+			changes, err = diffTree(r, name+"/", sa, sb, limit, changes)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c := TreeChange{OldPath: name, NewPath: name, Old: ea.TreeInfo, New: eb.TreeInfo}
+		switch {
+		case ea.name == "":
+			c.Status = DiffAdded
+		case eb.name == "":
+			c.Status = DiffDeleted
+		case ea.Mode.Type() != eb.Mode.Type() || isSymlink(ea.Mode) != isSymlink(eb.Mode):
+			c.Status = DiffTypeChanged
+		default:
+			c.Status = DiffModified
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// isSymlink and isRegular report whether the mode is that of a symlink
+// or a regular file.
+func isSymlink(mode object.TreeMode) bool {
+	return mode == object.ModeSymlink
+}
+
+func isRegular(mode object.TreeMode) bool {
+	return mode == object.ModeBlob || mode == object.ModeExec
+}
+
+// maxScore is the similarity score of identical files.  Scores are kept
+// in these units internally so that they round like in the reference
+// Git client.
+const maxScore = 60000
+
+// candidatesPerDest is the number of the most similar sources recorded
+// for each added file when pairing files by similarity.
+const candidatesPerDest = 4
+
+// A renameSource is a file that added files may be paired with.
+type renameSource struct {
+	path  string
+	entry object.TreeInfo
+	used  int // the number of pairs, plus one if the file remains
+}
+
+// A renameDest is an added file, and the source it is paired with.
+type renameDest struct {
+	change *TreeChange
+	source int // index of the source, or -1 if not paired
+	score  int
+}
+
+// A renameCandidate is a possible pairing of an added file with a
+// source.
+type renameCandidate struct {
+	dest, source int // indexes, dest -1 if none
+	score        int
+	nameScore    int // 1 if the basenames are the same
+}
+
+// detectRenames pairs the added files in the changes between the tree
+// hierarchy rooted at the tree with the given ID and another with
+// deleted and, as the options request, modified or unmodified files in
+// the former, in the same way as the reference Git client, and returns
+// the changes with the pairs as renames and copies.
+func detectRenames(r Interface, old object.ID, changes []TreeChange, opts *DiffOptions) ([]TreeChange, error) {
+	copies := opts.DetectCopies || opts.FindCopiesHarder
+	threshold := opts.RenameThreshold
+	if threshold <= 0 {
+		threshold = defaultRenameThreshold
+	}
+	minScore := threshold * maxScore / 100
+	if minScore > maxScore {
+		minScore = maxScore
+	}
+	limit := opts.RenameLimit
+	if limit <= 0 {
+		limit = defaultRenameLimit
+	}
+
+	var dests []renameDest
+	for i := range changes {
+		if changes[i].Status == DiffAdded {
+			dests = append(dests, renameDest{&changes[i], -1, 0})
+		}
+	}
+	if len(dests) == 0 {
+		return changes, nil
+	}
+
+	// the sources in the order of their paths, and whether each is
+	// unmodified
+	var sources []renameSource
+	var unmodified []bool
+	if opts.FindCopiesHarder {
+		status := make(map[string]DiffStatus)
+		for _, c := range changes {
+			if c.Status != DiffAdded {
+				status[c.OldPath] = c.Status
+			}
+		}
+		all, err := diffTree(r, "", old.Format().ZeroID(), old, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range all {
+			s := renameSource{c.NewPath, c.New, 1}
+			if status[c.NewPath] == DiffDeleted {
+				s.used = 0
+			}
+			sources = append(sources, s)
+			unmodified = append(unmodified, status[c.NewPath] == 0)
+		}
+	} else {
+		for _, c := range changes {
+			switch {
+			case c.Status == DiffDeleted:
+				sources = append(sources, renameSource{c.OldPath, c.Old, 0})
+			case c.Status != DiffAdded && copies:
+				sources = append(sources, renameSource{c.OldPath, c.Old, 1})
+			default:
+				continue
+			}
+			unmodified = append(unmodified, false)
+		}
+	}
+	if len(sources) == 0 {
+		return changes, nil
+	}
+
+	sim := &similarity{repo: r, blobs: make(map[object.ID]*blobSketch)}
+	pair := func(d, s, score int) {
+		dests[d].source, dests[d].score = s, score
+		sources[s].used++
+	}
+	remaining := len(dests)
+
+	// identical files first, preferring unused sources and sources
+	// of the same basename
+	byID := make(map[object.ID][]int)
+	for i, s := range sources {
+		byID[s.entry.Object] = append(byID[s.entry.Object], i)
+	}
+	for d := range dests {
+		target := dests[d].change.New
+		best, bestScore, tries := -1, -1, 100
+		for _, i := range byID[target.Object] {
+			s := sources[i]
+			if (!isRegular(s.entry.Mode) || !isRegular(target.Mode)) && s.entry.Mode != target.Mode {
+				continue
+			}
+			if s.used > 0 && !copies {
+				continue
+			}
+			score := 0
+			if s.used == 0 {
+				score++
+			}
+			score += sameBasename(s.path, dests[d].change.NewPath)
+			if score > bestScore {
+				best, bestScore = i, score
+				if score == 2 {
+					break
+				}
+			}
+			if tries--; tries == 0 {
+				break // too many identical files; pick one
+			}
+		}
+		if best >= 0 {
+			pair(d, best, maxScore)
+			remaining--
+		}
+	}
+	if minScore == maxScore {
+		return pairRenames(changes, dests, sources), nil
+	}
+
+	// active lists the sources that may still be paired
+	var active []int
+	cull := func() {
+		active = active[:0]
+		for i, s := range sources {
+			if copies || s.used == 0 {
+				active = append(active, i)
+			}
+		}
+	}
+	cull()
+
+	// unless looking for copies, files with a basename unique among
+	// both the sources and the added files are paired if they are
+	// considerably similar
+	if !copies {
+		minBasenameScore := minScore + (maxScore-minScore)/2
+		srcNames := make(map[string]int)
+		for _, i := range active {
+			name := path.Base(sources[i].path)
+			if _, ok := srcNames[name]; ok {
+				srcNames[name] = -1
+			} else {
+				srcNames[name] = i
+			}
+		}
+		destNames := make(map[string]int)
+		for d := range dests {
+			if dests[d].source >= 0 {
+				continue
+			}
+			name := path.Base(dests[d].change.NewPath)
+			if _, ok := destNames[name]; ok {
+				destNames[name] = -1
+			} else {
+				destNames[name] = d
+			}
+		}
+		for _, i := range active {
+			name := path.Base(sources[i].path)
+			d, ok := destNames[name]
+			if srcNames[name] < 0 || !ok || d < 0 || dests[d].source >= 0 {
+				continue
+			}
+			score, err := sim.score(sources[i].entry, dests[d].change.New, minScore)
+			if err != nil {
+				return nil, err
+			}
+			if score >= minBasenameScore {
+				pair(d, i, score)
+				remaining--
+			}
+		}
+		cull()
+	}
+
+	if remaining == 0 || len(active) == 0 {
+		return pairRenames(changes, dests, sources), nil
+	}
+	tooMany := func(sources int) bool {
+		return (remaining > limit && sources > limit) || remaining*sources > limit*limit
+	}
+	if tooMany(len(active)) {
+		if !opts.FindCopiesHarder {
+			return pairRenames(changes, dests, sources), nil
+		}
+		modified := active[:0]
+		for _, i := range active {
+			if !unmodified[i] {
+				modified = append(modified, i)
+			}
+		}
+		if active = modified; tooMany(len(active)) {
+			return pairRenames(changes, dests, sources), nil
+		}
+	}
+
+	// the best candidate sources of each unpaired file, paired best
+	// first
+	var candidates []renameCandidate
+	for d := range dests {
+		if dests[d].source >= 0 {
+			continue
+		}
+		var best [candidatesPerDest]renameCandidate
+		for k := range best {
+			best[k].dest = -1
+		}
+		for _, i := range active {
+			score, err := sim.score(sources[i].entry, dests[d].change.New, minScore)
+			if err != nil {
+				return nil, err
+			}
+			c := renameCandidate{d, i, score, sameBasename(sources[i].path, dests[d].change.NewPath)}
+			worst := 0
+			for k := 1; k < len(best); k++ {
+				if best[worst].better(best[k]) {
+					worst = k
+				}
+			}
+			if c.better(best[worst]) {
+				best[worst] = c
+			}
+		}
+		candidates = append(candidates, best[:]...)
+	}
+	sort.Stable(candidateSlice(candidates))
+	pass := func(copies bool) {
+		for _, c := range candidates {
+			if c.dest < 0 || c.score < minScore {
+				break
+			}
+			if dests[c.dest].source >= 0 || !copies && sources[c.source].used > 0 {
+				continue
+			}
+			pair(c.dest, c.source, c.score)
+		}
+	}
+	pass(false)
+	if copies {
+		pass(true)
+	}
+	return pairRenames(changes, dests, sources), nil
+}
+
+// pairRenames returns the changes with the added files of the paired
+// dests replaced by renames or copies, and the deleted files that have
+// been renamed removed.  A source paired with several dests is reported
+// copied to all but the last of them, and renamed to the last unless it
+// remains.
+func pairRenames(changes []TreeChange, dests []renameDest, sources []renameSource) []TreeChange {
+	paired := make(map[*TreeChange]renameDest)
+	for _, d := range dests {
+		if d.source >= 0 {
+			paired[d.change] = d
+		}
+	}
+	renamed := make(map[string]bool)
+	for _, s := range sources {
+		if s.used > 0 {
+			renamed[s.path] = true
+		}
+	}
+	var result []TreeChange
+	for i := range changes {
+		c := changes[i]
+		switch {
+		case c.Status == DiffDeleted && renamed[c.OldPath]:
+			continue
+		case c.Status == DiffAdded:
+			d, ok := paired[&changes[i]]
+			if !ok {
+				break
+			}
+			s := &sources[d.source]
+			c.Old, c.OldPath = s.entry, s.path
+			c.Score = d.score * 100 / maxScore
+			if s.used--; s.used > 0 {
+				c.Status = DiffCopied
+			} else {
+				c.Status = DiffRenamed
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// sameBasename returns 1 if the paths have the same last component and
+// 0 otherwise.
+func sameBasename(a, b string) int {
+	if path.Base(a) == path.Base(b) {
+		return 1
+	}
+	return 0
+}
+
+// better reports whether c is a better candidate than d.  Candidates
+// with a dest come before those without.
+func (c renameCandidate) better(d renameCandidate) bool {
+	switch {
+	case c.dest < 0 || d.dest < 0:
+		return c.dest >= 0 && d.dest < 0
+	case c.score != d.score:
+		return c.score > d.score
+	default:
+		return c.nameScore > d.nameScore
+	}
+}
+
+// candidateSlice sorts rename candidates best first.
+type candidateSlice []renameCandidate
+
+func (s candidateSlice) Len() int {
+	return len(s)
+}
+
+func (s candidateSlice) Less(i, j int) bool {
+	return s[i].better(s[j])
+}
+
+func (s candidateSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// A similarity estimates the similarity of blobs, caching their sizes
+// and sketches.
+type similarity struct {
+	repo  Interface
+	blobs map[object.ID]*blobSketch
+}
+
+// A blobSketch records the size of a blob and, once needed, the number
+// of bytes in each class of its spans.
+type blobSketch struct {
+	size  int64
+	spans map[uint32]int64
+}
+
+// blob returns the sketch of the blob with the given ID, computing its
+// spans if spans is set.
+func (s *similarity) blob(id object.ID, spans bool) (*blobSketch, error) {
+	b, ok := s.blobs[id]
+	if ok && (!spans || b.spans != nil) {
+		return b, nil
+	}
+	rc, size, err := OpenBlob(s.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b = &blobSketch{size: size}
+	if spans {
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, err
+		}
+		b.spans = hashSpans(data)
+	}
+	s.blobs[id] = b
+	return b, nil
+}
+
+// score returns the similarity score of the files with the given tree
+// entries: the share of the contents of the larger that the smaller
+// has too.  Files other than regular ones are not similar, and neither
+// are files whose sizes differ too much to reach minScore.
+func (s *similarity) score(src, dst object.TreeInfo, minScore int) (int, error) {
+	if !isRegular(src.Mode) || !isRegular(dst.Mode) {
+		return 0, nil
+	}
+	a, err := s.blob(src.Object, false)
+	if err != nil {
+		return 0, err
+	}
+	b, err := s.blob(dst.Object, false)
+	if err != nil {
+		return 0, err
+	}
+	maxSize, baseSize := a.size, b.size
+	if maxSize < baseSize {
+		maxSize, baseSize = baseSize, maxSize
+	}
+	if maxSize*int64(maxScore-minScore) < (maxSize-baseSize)*maxScore || b.size == 0 {
+		return 0, nil
+	}
+	if a, err = s.blob(src.Object, true); err != nil {
+		return 0, err
+	}
+	if b, err = s.blob(dst.Object, true); err != nil {
+		return 0, err
+	}
+	var copied int64
+	for h, n := range a.spans {
+		if m := b.spans[h]; m < n {
+			copied += m
+		} else {
+			copied += n
+		}
+	}
+	return int(copied * maxScore / maxSize), nil
+}
+
+// hashSpans splits data into spans that end at newlines or are 64 bytes
+// long, and returns the number of bytes in the spans of each hash
+// value, as the reference Git client does to estimate similarity.  In
+// text, carriage returns before newlines are ignored.
+func hashSpans(data []byte) map[uint32]int64 {
+	const hashBase = 107927
+	head := data
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	isText := bytes.IndexByte(head, 0) < 0
+	spans := make(map[uint32]int64)
+	var accum1, accum2 uint32
+	n := 0
+	for i, c := range data {
+		if isText && c == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			continue
+		}
+		old := accum1
+		accum1 = accum1<<7 ^ accum2>>25
+		accum2 = accum2<<7 ^ old>>25
+		accum1 += uint32(c)
+		if n++; n < 64 && c != '\n' {
+			continue
+		}
+		spans[(accum1+accum2*0x61)%hashBase] += int64(n)
+		n, accum1, accum2 = 0, 0, 0
+	}
+	if n > 0 {
+		spans[(accum1+accum2*0x61)%hashBase] += int64(n)
+	}
+	return spans
+}
@@ -0,0 +1,136 @@
+package repository_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// The expected changes are those git diff --name-status lists between
+// the trees of the files, with the options corresponding to the given
+// ones.
+var renameTests = []struct {
+	desc     string
+	old, new map[string]string
+	opts     *repository.DiffOptions
+	want     string
+}{
+	{
+		desc: "no detection",
+		old:  map[string]string{"a": seq(20)},
+		new:  map[string]string{"b": seq(20)},
+		want: "D a, A b",
+	},
+	{
+		desc: "renames",
+		old:  map[string]string{"a": seq(20), "b": seq(20, "1", "one"), "c": "c\n"},
+		new:  map[string]string{"x": seq(20), "y": seq(20, "1", "one", "2", "two"), "c": "c\n"},
+		opts: &repository.DiffOptions{DetectRenames: true},
+		want: "R100 a x, R092 b y",
+	},
+	{
+		desc: "below default threshold",
+		old:  map[string]string{"a": seq(20)},
+		new:  map[string]string{"b": seq(20, "1", "one", "2", "two", "3", "three", "4", "four", "5", "five", "6", "six", "7", "seven", "8", "eight", "9", "nine", "10", "ten", "11", "eleven")},
+		opts: &repository.DiffOptions{DetectRenames: true},
+		want: "D a, A b",
+	},
+	{
+		desc: "above threshold",
+		old:  map[string]string{"a": seq(20)},
+		new:  map[string]string{"b": seq(20, "1", "one", "2", "two", "3", "three", "4", "four", "5", "five", "6", "six")},
+		opts: &repository.DiffOptions{DetectRenames: true},
+		want: "R058 a b",
+	},
+	{
+		desc: "below given threshold",
+		old:  map[string]string{"a": seq(20)},
+		new:  map[string]string{"b": seq(20, "1", "one", "2", "two", "3", "three", "4", "four", "5", "five", "6", "six")},
+		opts: &repository.DiffOptions{DetectRenames: true, RenameThreshold: 80},
+		want: "D a, A b",
+	},
+	{
+		desc: "exact only",
+		old:  map[string]string{"a": seq(20), "b": seq(20, "1", "one")},
+		new:  map[string]string{"x": seq(20), "y": seq(20, "1", "one", "2", "two")},
+		opts: &repository.DiffOptions{DetectRenames: true, RenameThreshold: 100},
+		want: "D b, R100 a x, A y",
+	},
+	{
+		desc: "over rename limit",
+		old:  map[string]string{"a": seq(20), "b": seq(20, "1", "one"), "c": seq(30)},
+		new:  map[string]string{"x": seq(20), "y": seq(20, "1", "one", "2", "two"), "z": seq(30, "1", "one")},
+		opts: &repository.DiffOptions{DetectRenames: true, RenameLimit: 1},
+		want: "D b, D c, R100 a x, A y, A z",
+	},
+	{
+		desc: "same basename",
+		old:  map[string]string{"d/f": seq(20), "e/g": seq(20)},
+		new:  map[string]string{"x/g": seq(20)},
+		opts: &repository.DiffOptions{DetectRenames: true},
+		want: "D d/f, R100 e/g x/g",
+	},
+	{
+		desc: "rename to several files",
+		old:  map[string]string{"a": seq(20)},
+		new:  map[string]string{"b": seq(20), "c": seq(20, "20", "twenty")},
+		opts: &repository.DiffOptions{DetectRenames: true},
+		want: "R100 a b, A c",
+	},
+	{
+		desc: "copies of deleted file",
+		old:  map[string]string{"a": seq(20)},
+		new:  map[string]string{"b": seq(20), "c": seq(20, "20", "twenty")},
+		opts: &repository.DiffOptions{DetectCopies: true},
+		want: "C100 a b, R087 a c",
+	},
+	{
+		desc: "copy of modified file",
+		old:  map[string]string{"m": seq(20), "u": seq(30)},
+		new:  map[string]string{"m": seq(20, "1", "one"), "m2": seq(20, "2", "two"), "u": seq(30), "u2": seq(30)},
+		opts: &repository.DiffOptions{DetectCopies: true},
+		want: "M m, C092 m m2, C062 m u2",
+	},
+	{
+		desc: "copy of unmodified file",
+		old:  map[string]string{"m": seq(20), "u": seq(30)},
+		new:  map[string]string{"m": seq(20, "1", "one"), "m2": seq(20, "2", "two"), "u": seq(30), "u2": seq(30)},
+		opts: &repository.DiffOptions{FindCopiesHarder: true},
+		want: "M m, C092 m m2, C100 u u2",
+	},
+	{
+		desc: "copies over rename limit",
+		old:  map[string]string{"m": seq(20), "u": seq(30)},
+		new:  map[string]string{"m": seq(20, "1", "one"), "m2": seq(20, "2", "two"), "u": seq(30), "u2": seq(30, "3", "three")},
+		opts: &repository.DiffOptions{FindCopiesHarder: true, RenameLimit: 1},
+		want: "M m, A m2, A u2",
+	},
+}
+
+func TestDiffTreesRenames(t *testing.T) {
+	for _, tt := range renameTests {
+		r := newTestRepo(t)
+		r.commit("old", tt.old)
+		r.commit("new", tt.new)
+		changes, err := repository.DiffTrees(r.repo, r.id("old"), r.id("new"), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range changes {
+			switch c.Status {
+			case repository.DiffRenamed, repository.DiffCopied:
+				got = append(got, fmt.Sprintf("%s%03d %s %s", c.Status, c.Score, c.OldPath, c.NewPath))
+			case repository.DiffDeleted:
+				got = append(got, fmt.Sprintf("%s %s", c.Status, c.OldPath))
+			default:
+				got = append(got, fmt.Sprintf("%s %s", c.Status, c.NewPath))
+			}
+		}
+		if strings.Join(got, ", ") != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.desc, strings.Join(got, ", "), tt.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// An ObjectLister is a repository that can enumerate the objects it
+// stores.  Fsck uses this to find unreachable and dangling objects.
+type ObjectLister interface {
+	Interface
+
+	// ListObjects returns the IDs of all objects in the repository
+	// in no particular order.
+	ListObjects() ([]object.ID, error)
+}
+
+// A Link is a reference from a ref or an object to another object.
+type Link struct {
+	Ref  string      // name of the referring ref, if any
+	From object.ID   // ID of the referring object, if not a ref
+	To   object.ID   // ID of the referenced object
+	Type object.Type // type implied by the reference, or TypeUnknown
+}
+
+// A TypeMismatch is a link whose target object is of a different type
+// than the link implies, e.g. a tree entry with a blob mode pointing to
+// a tree object.
+type TypeMismatch struct {
+	Link
+	Actual object.Type // actual type of the referenced object
+}
+
+// A MalformedObject is a reachable object that cannot be parsed, so
+// that the objects it references cannot be found.
+type MalformedObject struct {
+	ID  object.ID
+	Err error // the error from parsing the object
+}
+
+// An FsckReport lists the problems found by Fsck.
+type FsckReport struct {
+	// Missing lists the links whose target objects do not exist in
+	// the repository.  Links to submodule commits are not followed.
+	Missing []Link
+
+	// Mismatched lists the links whose target objects are of the
+	// wrong type.
+	Mismatched []TypeMismatch
+
+	// Malformed lists the reachable objects that cannot be parsed.
+	// They are listed whether or not Fsck is passed an object.Fsck,
+	// which may report their defects in more detail in Errors.
+	Malformed []MalformedObject
+
+	// Errors lists the defects found in reachable objects by the
+	// object.Fsck passed to Fsck.
+	Errors []*object.FsckError
+
+	// Unreachable lists the objects that cannot be reached from any
+	// ref or HEAD, in ascending order by ID, and Dangling the subset
+	// of them not referenced by any other unreachable object.  Both
+	// are nil unless the repository is an ObjectLister.
+	Unreachable []object.ID
+	Dangling    []object.ID
+}
+
+// OK returns true if the report lists no problems other than
+// unreachable objects and defects of lesser severity than
+// object.SeverityError.
+func (rep *FsckReport) OK() bool {
+	for _, e := range rep.Errors {
+		if e.Severity == object.SeverityError {
+			return false
+		}
+	}
+	return len(rep.Missing) == 0 && len(rep.Mismatched) == 0 &&
+		len(rep.Malformed) == 0
+}
+
+// Fsck checks the connectivity and validity of the repository, in the
+// manner of the reference Git client's fsck command.  It walks the
+// repository graph from every ref and HEAD, recording links to missing
+// objects or objects of the wrong type and reachable objects that
+// cannot be parsed.  If f is non-nil, every reachable object is
+// additionally checked with it; note that f needs a Lookup function for
+// .gitmodules blobs to be checked, and that its Format must be that of
+// r.  If r is an ObjectLister, Fsck also reports the objects that could
+// not be reached.
+//
+// Fsck returns a non-nil error only if it fails to access the
+// repository; problems in the repository are only listed in the report.
+func Fsck(r Interface, f *object.Fsck) (*FsckReport, error) {
+	rep := new(FsckReport)
+	names, ids, err := r.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	var links []Link
+	for i, name := range names {
+		links = append(links, Link{Ref: name, To: ids[i]})
+	}
+	if HEAD, err := r.GetHEAD(); err == nil {
+		if id, err := r.GetRef(HEAD); err == nil {
+			links = append(links, Link{Ref: "HEAD", To: id})
+		}
+	}
+	start := make([]object.ID, len(links))
+	for i, link := range links {
+		start[i] = link.To
+	}
+
+	types := make(map[object.ID]object.Type)
+	err = Walk(r, start, nil, func(id object.ID, obj object.Interface, err error) error {
+		switch err {
+		case nil:
+		case ErrObjectNotExist:
+			return SkipObject
+		default:
+			return err
+		}
+		types[id] = object.TypeOf(obj)
+		objLinks, err := objectLinks(id, obj)
+		if err != nil {
+			rep.Malformed = append(rep.Malformed, MalformedObject{id, err})
+		}
+		links = append(links, objLinks...)
+		if f != nil {
+			errs, err := f.CheckObject(obj)
+			if err != nil {
+				return err
+			}
+			rep.Errors = append(rep.Errors, errs...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		t, ok := types[link.To]
+		switch {
+		case !ok:
+			rep.Missing = append(rep.Missing, link)
+		case link.Type != object.TypeUnknown && link.Type != t:
+			rep.Mismatched = append(rep.Mismatched, TypeMismatch{link, t})
+		}
+	}
+
+	if lister, ok := r.(ObjectLister); ok {
+		if err := findUnreachable(lister, types, rep); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+// findUnreachable fills in the Unreachable and Dangling fields of rep.
+// reachable must contain the IDs of all reachable objects.
+func findUnreachable(r ObjectLister, reachable map[object.ID]object.Type, rep *FsckReport) error {
+	all, err := r.ListObjects()
+	if err != nil {
+		return err
+	}
+	referenced := make(map[object.ID]bool)
+	for _, id := range all {
+		if _, ok := reachable[id]; ok {
+			continue
+		}
+		rep.Unreachable = append(rep.Unreachable, id)
+		obj, err := r.GetObject(id)
+		if err != nil {
+			return err
+		}
+		objLinks, _ := objectLinks(id, obj)
+		for _, link := range objLinks {
+			referenced[link.To] = true
+		}
+	}
+	sort.Sort(idSlice(rep.Unreachable))
+	for _, id := range rep.Unreachable {
+		if !referenced[id] {
+			rep.Dangling = append(rep.Dangling, id)
+		}
+	}
+	return nil
+}
+
+// objectLinks returns the links from the object with the given ID to
+// the objects it references.  Links to submodule commits are omitted.
+// It returns an error if the object cannot be parsed.
+func objectLinks(id object.ID, obj object.Interface) ([]Link, error) {
+	var links []Link
+	obj, err := object.Parse(obj)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := obj.(type) {
+	case *object.Commit:
+		links = append(links, Link{From: id, To: obj.Tree, Type: object.TypeTree})
+		for _, parent := range obj.Parent {
+			links = append(links, Link{From: id, To: parent, Type: object.TypeCommit})
+		}
+	case *object.Tree:
+		for _, name := range obj.Names() {
+			ti := (*obj)[name]
+			if ti.Mode == object.ModeGitlink {
+				continue
+			}
+			links = append(links, Link{From: id, To: ti.Object, Type: ti.Mode.Type()})
+		}
+	case *object.Tag:
+		links = append(links, Link{From: id, To: obj.Object, Type: obj.Type})
+	}
+	return links, nil
+}
+
+// idSlice sorts object IDs in ascending order.
+type idSlice []object.ID
+
+func (ids idSlice) Len() int {
+	return len(ids)
+}
+
+func (ids idSlice) Less(i, j int) bool {
+	return bytes.Compare(ids[i].Bytes(), ids[j].Bytes()) < 0
+}
+
+func (ids idSlice) Swap(i, j int) {
+	ids[i], ids[j] = ids[j], ids[i]
+}
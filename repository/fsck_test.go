@@ -0,0 +1,106 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+func TestFsck(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("A", nil)
+	r.commit("B", nil, "A")
+	if err := r.repo.UpdateRef("refs/heads/master", object.ZeroID, r.id("B")); err != nil {
+		t.Fatal(err)
+	}
+	gone := r.commit("C", nil, "B")
+	goneTree := r.tree(map[string]string{"C": "C\n"})
+	goneBlob := r.blob("C\n")
+	dangling := r.blob("dangling\n")
+
+	rep, err := repository.Fsck(r.repo, new(object.Fsck))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || rep.Missing != nil || rep.Mismatched != nil || rep.Malformed != nil || rep.Errors != nil {
+		t.Errorf("clean repository reported as %+v", rep)
+	}
+	unreachable := map[object.ID]bool{gone: true, goneTree: true, goneBlob: true, dangling: true}
+	if len(rep.Unreachable) != len(unreachable) {
+		t.Errorf("got unreachable objects %v, want %d", rep.Unreachable, len(unreachable))
+	}
+	for _, id := range rep.Unreachable {
+		if !unreachable[id] {
+			t.Errorf("reachable object %s listed as unreachable", id)
+		}
+	}
+	if len(rep.Dangling) != 2 || !(rep.Dangling[0] == gone && rep.Dangling[1] == dangling ||
+		rep.Dangling[0] == dangling && rep.Dangling[1] == gone) {
+		t.Errorf("got dangling objects %v, want %s and %s", rep.Dangling, gone, dangling)
+	}
+}
+
+func TestFsckLinks(t *testing.T) {
+	r := newTestRepo(t)
+	b := object.Blob("missing\n")
+	missing, err := object.Hash(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := r.tree(map[string]string{"file": "file\n"})
+	root := r.entries(map[string]object.TreeInfo{
+		"missing":   {object.ModeBlob, missing},
+		"notablob":  {object.ModeBlob, sub},
+		"submodule": {object.ModeGitlink, missing},
+	})
+	if err := r.repo.UpdateRef("refs/heads/master", object.ZeroID, root); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := repository.Fsck(r.repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.OK() {
+		t.Error("report of broken links is OK")
+	}
+	want := repository.Link{From: root, To: missing, Type: object.TypeBlob}
+	if len(rep.Missing) != 1 || rep.Missing[0] != want {
+		t.Errorf("got missing links %+v, want %+v", rep.Missing, want)
+	}
+	mismatch := repository.TypeMismatch{
+		Link:   repository.Link{From: root, To: sub, Type: object.TypeBlob},
+		Actual: object.TypeTree,
+	}
+	if len(rep.Mismatched) != 1 || rep.Mismatched[0] != mismatch {
+		t.Errorf("got mismatched links %+v, want %+v", rep.Mismatched, mismatch)
+	}
+}
+
+func TestFsckMalformed(t *testing.T) {
+	r := newTestRepo(t)
+	raw, err := object.NewRaw([]byte("commit 9\x00garbage!\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := r.put(raw)
+	if err := r.repo.UpdateRef("refs/heads/master", object.ZeroID, id); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*object.Fsck{nil, new(object.Fsck)} {
+		rep, err := repository.Fsck(r.repo, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.OK() {
+			t.Errorf("Fsck(%v): report of malformed commit is OK", f)
+		}
+		if len(rep.Malformed) != 1 || rep.Malformed[0].ID != id || rep.Malformed[0].Err == nil {
+			t.Errorf("Fsck(%v): got malformed objects %+v, want %s", f, rep.Malformed, id)
+		}
+		if rep.Missing != nil || rep.Unreachable != nil {
+			t.Errorf("Fsck(%v): malformed commit reported as %+v", f, rep)
+		}
+	}
+}
@@ -37,13 +37,19 @@ type Interface interface {
 	// data.
 
 	// GetObject returns the object with the given ID.
+	// Implementations may return the object as an *object.Raw,
+	// which reproduces the stored object exactly; use object.Parse
+	// to access its fields.  The implementations in this module
+	// always do so.
 	GetObject(id object.ID) (object.Interface, error)
 
 	// PutObject stores the given object in the repository and
 	// calculates and returns its ID.  Storing the same object
 	// multiple times is idempotent.  Behavior is undefined if a
 	// different object that hashes to the same ID is stored;
-	// implementations may document their own behavior.
+	// implementations may document their own behavior.  The
+	// implementations in this module refuse objects containing
+	// SHA-1 collision attacks with object.ErrCollision.
 	PutObject(obj object.Interface) (object.ID, error)
 
 	// GetRef returns the ID of the object the named ref points to.
@@ -74,3 +80,24 @@ type Interface interface {
 	// SetHEAD sets HEAD to point to the named ref.
 	SetHEAD(name string) error
 }
+
+// An ObjectFormatter is a repository that records the object format it
+// uses.  Repositories that do not implement the interface use SHA-1.
+type ObjectFormatter interface {
+	Interface
+
+	// ObjectFormat returns the object format of the repository.
+	// The IDs of all objects stored in and retrieved from the
+	// repository are of the format.
+	ObjectFormat() (object.Format, error)
+}
+
+// ObjectFormat returns the object format of r: the result of its
+// ObjectFormat method if r is an ObjectFormatter, and object.SHA1
+// otherwise.
+func ObjectFormat(r Interface) (object.Format, error) {
+	if f, ok := r.(ObjectFormatter); ok {
+		return f.ObjectFormat()
+	}
+	return object.SHA1, nil
+}
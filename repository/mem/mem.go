@@ -2,57 +2,151 @@
 package mem
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"sort"
 	"sync"
 
+	"github.com/lxr/go.git-scm/commitgraph"
 	"github.com/lxr/go.git-scm/object"
 	"github.com/lxr/go.git-scm/repository"
 )
 
 // NewRepository initializes and returns a new in-memory Git repository.
+// The repository is also a repository.ObjectLister, a
+// repository.PrefixLister, a repository.ObjectFormatter, a
+// repository.BlobStreamer and a repository.CommitGrapher.  Objects
+// are stored in their binary representation and retrieved as
+// *object.Raw values.
 func NewRepository() repository.Interface {
+	return NewRepositoryFormat(object.SHA1)
+}
+
+// NewRepositoryFormat is like NewRepository, but the new repository
+// uses the given object format.
+func NewRepositoryFormat(f object.Format) repository.Interface {
 	return &repo{
-		objects: make(map[object.ID]object.Interface),
+		format:  f,
+		objects: make(map[object.ID][]byte),
 		refs:    make(map[string]object.ID),
 		HEAD:    "refs/heads/master",
 	}
 }
 
 type repo struct {
+	format object.Format
+
 	objectsLock sync.RWMutex
-	objects     map[object.ID]object.Interface
+	objects     map[object.ID][]byte
 
 	refsLock sync.RWMutex
 	refs     map[string]object.ID
 
 	HEADLock sync.RWMutex
 	HEAD     string
+
+	graphLock sync.RWMutex
+	graph     *commitgraph.Graph
 }
 
 func (r *repo) GetObject(id object.ID) (object.Interface, error) {
 	r.objectsLock.RLock()
 	defer r.objectsLock.RUnlock()
-	obj, ok := r.objects[id]
+	data, ok := r.objects[id]
 	if !ok {
 		return nil, repository.ErrObjectNotExist
 	}
-	return obj, nil
+	// NOTE(lor): A Raw never modifies the data it holds, so the
+	// stored slice can be shared between all Raws returned.
+	return object.NewRawFormat(data, r.format)
 }
 
 func (r *repo) PutObject(obj object.Interface) (object.ID, error) {
-	id, err := object.Hash(obj)
+	data, id, err := object.MarshalFormat(obj, r.format)
 	if err != nil {
 		return id, err
 	}
 	r.objectsLock.Lock()
 	defer r.objectsLock.Unlock()
-	r.objects[id] = obj
+	r.objects[id] = data
 	return id, nil
 }
 
+// OpenBlob returns a reader of the stored blob, which is shared rather
+// than copied.
+func (r *repo) OpenBlob(id object.ID) (io.ReadCloser, int64, error) {
+	r.objectsLock.RLock()
+	defer r.objectsLock.RUnlock()
+	data, ok := r.objects[id]
+	if !ok {
+		return nil, 0, repository.ErrObjectNotExist
+	}
+	raw, err := object.NewRawFormat(data, r.format)
+	if err != nil {
+		return nil, 0, err
+	}
+	if raw.Type() != object.TypeBlob {
+		return nil, 0, &object.TypeError{raw}
+	}
+	data = data[bytes.IndexByte(data, 0)+1:]
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// PutBlob reads the blob directly into its stored representation,
+// which grows as the contents are read rather than being allocated for
+// the claimed size up front.
+func (r *repo) PutBlob(rd io.Reader, size int64) (object.ID, error) {
+	if size < 0 {
+		return r.format.ZeroID(), repository.ErrBlobSize
+	}
+	buf := bytes.NewBuffer(object.AppendHeader(nil, object.TypeBlob, size))
+	if n, err := io.CopyN(buf, rd, size); n < size {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return r.format.ZeroID(), err
+	}
+	data := buf.Bytes()
+	id, err := r.format.Sum(data)
+	if err != nil {
+		return id, err
+	}
+	r.objectsLock.Lock()
+	defer r.objectsLock.Unlock()
+	r.objects[id] = data
+	return id, nil
+}
+
+func (r *repo) ObjectFormat() (object.Format, error) {
+	return r.format, nil
+}
+
+func (r *repo) ListObjects() ([]object.ID, error) {
+	r.objectsLock.RLock()
+	defer r.objectsLock.RUnlock()
+	ids := make([]object.ID, 0, len(r.objects))
+	for id := range r.objects {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (r *repo) ListPrefix(prefix string) ([]object.ID, error) {
+	r.objectsLock.RLock()
+	defer r.objectsLock.RUnlock()
+	var ids []object.ID
+	for id := range r.objects {
+		if id.HasPrefix(prefix) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
 func (r *repo) GetRef(name string) (object.ID, error) {
 	if !repository.IsValidRef(name) {
-		return object.ZeroID, repository.ErrInvalidRef
+		return r.format.ZeroID(), repository.ErrInvalidRef
 	}
 	r.refsLock.RLock()
 	defer r.refsLock.RUnlock()
@@ -71,22 +165,25 @@ func (r *repo) UpdateRef(name string, oldID, newID object.ID) error {
 	r.refsLock.Lock()
 	defer r.refsLock.Unlock()
 
-	id := r.refs[name]
+	id, ok := r.refs[name]
+	if !ok {
+		id = oldID.Format().ZeroID()
+	}
 	if id != oldID {
-		switch object.ZeroID {
-		case id:
+		switch {
+		case id.IsZero():
 			return repository.ErrRefNotExist
-		case oldID:
+		case oldID.IsZero():
 			return repository.ErrRefExist
 		default:
 			return repository.ErrRefMismatch
 		}
 	}
 
-	switch newID {
-	case object.ZeroID:
+	switch {
+	case newID.IsZero():
 		// This is a no-op when r.refs[name] does not exist,
-		// i.e. when id == oldID == object.ZeroID.
+		// i.e. when id and oldID are zero.
 		delete(r.refs, name)
 		return nil
 	default:
@@ -125,3 +222,16 @@ func (r *repo) SetHEAD(name string) error {
 	r.HEAD = name
 	return nil
 }
+
+func (r *repo) CommitGraph() (*commitgraph.Graph, error) {
+	r.graphLock.RLock()
+	defer r.graphLock.RUnlock()
+	return r.graph, nil
+}
+
+func (r *repo) SetCommitGraph(g *commitgraph.Graph) error {
+	r.graphLock.Lock()
+	defer r.graphLock.Unlock()
+	r.graph = g
+	return nil
+}
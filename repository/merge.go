@@ -0,0 +1,678 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxr/go.git-scm/diff"
+	"github.com/lxr/go.git-scm/object"
+)
+
+// A ConflictKind is the kind of a MergeConflict.
+type ConflictKind int
+
+// The kinds of MergeConflicts.
+const (
+	ConflictContent       ConflictKind = iota // contents changed differently
+	ConflictAddAdd                            // different files added at a path
+	ConflictModifyDelete                      // file changed on one side, deleted on the other
+	ConflictRenameDelete                      // file renamed on one side, deleted on the other
+	ConflictRenameRename                      // file renamed to different paths
+	ConflictFileDirectory                     // file added where there is a directory
+	ConflictDistinctTypes                     // file changed to different types, e.g. symlink and file
+	ConflictSubmodule                         // submodule moved to different commits
+)
+
+var conflictKinds = []string{
+	ConflictContent:       "content",
+	ConflictAddAdd:        "add/add",
+	ConflictModifyDelete:  "modify/delete",
+	ConflictRenameDelete:  "rename/delete",
+	ConflictRenameRename:  "rename/rename",
+	ConflictFileDirectory: "file/directory",
+	ConflictDistinctTypes: "distinct types",
+	ConflictSubmodule:     "submodule",
+}
+
+func (k ConflictKind) String() string {
+	if 0 <= k && int(k) < len(conflictKinds) {
+		return conflictKinds[k]
+	}
+	return "ConflictKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// A MergeConflict is a file that MergeTrees could not merge cleanly.
+// BasePath, OursPath and TheirsPath are the paths of the file in the
+// merge base and on the two sides, and Base, Ours and Theirs its
+// entries there, which are zero where the file does not exist.  Path
+// is the path at which the merged tree records the file, and Result its
+// entry there.  For conflicting changes to the contents of a regular
+// file, Result is a blob with the conflicting lines between conflict
+// markers.  For rename/rename conflicts, the file is recorded at both
+// OursPath and TheirsPath, and Path is the former.  For file/directory
+// and distinct types conflicts, Path is the path to which the file was
+// moved out of the way.
+type MergeConflict struct {
+	Kind   ConflictKind
+	Path   string
+	Result object.TreeInfo
+
+	BasePath, OursPath, TheirsPath string
+	Base, Ours, Theirs             object.TreeInfo
+}
+
+// String returns a description of the conflict like the one the
+// reference Git client prints for it.
+func (c *MergeConflict) String() string {
+	switch c.Kind {
+	case ConflictModifyDelete:
+		if c.Ours.Mode == 0 {
+			return fmt.Sprintf("CONFLICT (%s): %s deleted in ours and modified in theirs", c.Kind, c.Path)
+		}
+		return fmt.Sprintf("CONFLICT (%s): %s deleted in theirs and modified in ours", c.Kind, c.Path)
+	case ConflictRenameDelete:
+		if c.Ours.Mode == 0 {
+			return fmt.Sprintf("CONFLICT (%s): %s renamed to %s in theirs, but deleted in ours", c.Kind, c.BasePath, c.TheirsPath)
+		}
+		return fmt.Sprintf("CONFLICT (%s): %s renamed to %s in ours, but deleted in theirs", c.Kind, c.BasePath, c.OursPath)
+	case ConflictRenameRename:
+		return fmt.Sprintf("CONFLICT (%s): %s renamed to %s in ours and to %s in theirs", c.Kind, c.BasePath, c.OursPath, c.TheirsPath)
+	case ConflictFileDirectory, ConflictDistinctTypes:
+		from := c.OursPath
+		if c.Ours.Mode == 0 {
+			from = c.TheirsPath
+		}
+		return fmt.Sprintf("CONFLICT (%s): %s moved to %s", c.Kind, from, c.Path)
+	}
+	return fmt.Sprintf("CONFLICT (%s): Merge conflict in %s", c.Kind, c.Path)
+}
+
+// A MergeResult is the outcome of MergeTrees.
+type MergeResult struct {
+	// Tree is the ID of the merged tree.  Conflicting changes are
+	// recorded in it as described by the conflicts.
+	Tree object.ID
+
+	// Conflicts are the conflicts of the merge in path order.  The
+	// merge is clean if there are none.
+	Conflicts []MergeConflict
+}
+
+// MergeOptions control MergeTrees.  A nil *MergeOptions uses the
+// defaults.
+type MergeOptions struct {
+	// OursLabel, TheirsLabel and BaseLabel name the sides of the
+	// merge in conflict markers, and the first two also in the
+	// paths to which files are moved out of the way.  If empty,
+	// they are "ours", "theirs" and "base".
+	OursLabel   string
+	TheirsLabel string
+	BaseLabel   string
+
+	// Algorithm is the algorithm with which the contents of files
+	// are compared.
+	Algorithm diff.Algorithm
+
+	// Style is the way conflicting lines are shown.
+	Style diff.ConflictStyle
+
+	// NoRenames turns off the detection of renamed files.
+	NoRenames bool
+
+	// RenameThreshold and RenameLimit are as in DiffOptions.
+	RenameThreshold int
+	RenameLimit     int
+}
+
+// BUG(lor): MergeTrees does not detect renamed directories, and so
+// does not move the files the other side adds to a directory into the
+// directory's new location, as the reference Git client does.
+
+// MergeTrees merges the changes from the tree hierarchy base to the
+// hierarchies ours and theirs, all of which may point to tree, commit
+// or tag objects as with GetTree, and stores the merged hierarchy in
+// the repository.  A zero base denotes the empty tree.  The merge
+// follows the rules of the reference Git client's ort merge strategy:
+// renamed files are detected, the changes to files changed on both
+// sides are merged line by line as by diff.Merge, and the conflicts
+// that remain are recorded in the merged tree and reported.
+func MergeTrees(r Interface, base, ours, theirs object.ID, opts *MergeOptions) (*MergeResult, error) {
+	if opts == nil {
+		opts = new(MergeOptions)
+	}
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	m := &treeMerger{
+		repo:   r,
+		opts:   opts,
+		format: format,
+		ours:   label(opts.OursLabel, "ours"),
+		theirs: label(opts.TheirsLabel, "theirs"),
+		base:   label(opts.BaseLabel, "base"),
+		edits:  make(map[string]mergeEdit),
+	}
+	if base, err = diffRoot(r, base); err != nil {
+		return nil, err
+	}
+	if ours, err = diffRoot(r, ours); err != nil {
+		return nil, err
+	}
+	if theirs, err = diffRoot(r, theirs); err != nil {
+		return nil, err
+	}
+	switch {
+	case theirs == base || theirs == ours:
+		tree, err := m.treeID(ours)
+		return &MergeResult{Tree: tree}, err
+	case ours == base:
+		tree, err := m.treeID(theirs)
+		return &MergeResult{Tree: tree}, err
+	}
+
+	dopts := &DiffOptions{
+		DetectRenames:   !opts.NoRenames,
+		RenameThreshold: opts.RenameThreshold,
+		RenameLimit:     opts.RenameLimit,
+	}
+	oursChanges, err := DiffTrees(r, base, ours, dopts)
+	if err != nil {
+		return nil, err
+	}
+	theirsChanges, err := DiffTrees(r, base, theirs, dopts)
+	if err != nil {
+		return nil, err
+	}
+	m.changed = make(map[string]*TreeChange)
+	m.added = make(map[string]*TreeChange)
+	for i := range oursChanges {
+		c := &oursChanges[i]
+		switch c.Status {
+		case DiffAdded, DiffCopied:
+			m.added[c.NewPath] = c
+		case DiffRenamed:
+			m.added[c.NewPath] = c
+			m.changed[c.OldPath] = c
+		default:
+			m.changed[c.OldPath] = c
+		}
+	}
+	if m.root = new(object.Tree); !ours.IsZero() {
+		if m.root, err = getTree(r, ours); err != nil {
+			return nil, err
+		}
+	}
+	for i := range theirsChanges {
+		if err := m.mergeChange(&theirsChanges[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.resolveDirectories(); err != nil {
+		return nil, err
+	}
+	tree, err := m.write(ours)
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(conflictSlice(m.conflicts))
+	return &MergeResult{Tree: tree, Conflicts: m.conflicts}, nil
+}
+
+// label returns s, or def if s is empty.
+func label(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// A treeMerger holds the state of a merge of trees: the changes ours
+// made, the tree they resulted in, and the edits to it that merge the
+// changes theirs made.
+type treeMerger struct {
+	repo               Interface
+	opts               *MergeOptions
+	format             object.Format
+	ours, theirs, base string // labels
+	root               *object.Tree
+	changed            map[string]*TreeChange // ours changes by old path
+	added              map[string]*TreeChange // ours additions by new path
+	edits              map[string]mergeEdit
+	conflicts          []MergeConflict
+}
+
+// A mergeEdit is an entry to record at a path of the merged tree, and
+// the label of the side it comes from.  The zero entry deletes the
+// file at the path.
+type mergeEdit struct {
+	entry object.TreeInfo
+	side  string
+}
+
+// treeID returns the ID of the tree, storing the empty tree if the ID
+// is zero.
+func (m *treeMerger) treeID(id object.ID) (object.ID, error) {
+	if !id.IsZero() {
+		return id, nil
+	}
+	return m.repo.PutObject(&object.Tree{})
+}
+
+func (m *treeMerger) set(name string, entry object.TreeInfo, side string) {
+	m.edits[name] = mergeEdit{entry, side}
+}
+
+func (m *treeMerger) conflict(c MergeConflict) {
+	m.conflicts = append(m.conflicts, c)
+}
+
+// mergeChange merges a change theirs made with the changes ours made.
+func (m *treeMerger) mergeChange(c *TreeChange) error {
+	var none object.TreeInfo
+	switch c.Status {
+	case DiffAdded, DiffCopied:
+		var ours object.TreeInfo
+		if oc := m.added[c.NewPath]; oc != nil {
+			ours = oc.New
+		}
+		return m.mergeFile(c.NewPath, none, ours, c.New, c.NewPath, c.NewPath, c.NewPath)
+	case DiffRenamed:
+		return m.mergeRename(c)
+	}
+	oc := m.changed[c.OldPath]
+	switch {
+	case oc == nil:
+		return m.mergeFile(c.OldPath, c.Old, c.Old, c.New, c.OldPath, c.OldPath, c.OldPath)
+	case oc.Status != DiffRenamed:
+		return m.mergeFile(c.OldPath, c.Old, oc.New, c.New, c.OldPath, c.OldPath, c.OldPath)
+	case c.Status == DiffDeleted:
+		// the file stays renamed
+		m.conflict(MergeConflict{
+			Kind:     ConflictRenameDelete,
+			Path:     oc.NewPath,
+			Result:   oc.New,
+			BasePath: c.OldPath,
+			OursPath: oc.NewPath,
+			Base:     c.Old,
+			Ours:     oc.New,
+		})
+		return nil
+	default:
+		return m.mergeFile(oc.NewPath, c.Old, oc.New, c.New, c.OldPath, oc.NewPath, c.OldPath)
+	}
+}
+
+// mergeRename merges a rename theirs made with the changes ours made.
+func (m *treeMerger) mergeRename(c *TreeChange) error {
+	var none object.TreeInfo
+	from, to := c.OldPath, c.NewPath
+	oc := m.changed[from]
+	switch {
+	case oc == nil || oc.Status == DiffModified || oc.Status == DiffTypeChanged:
+		ours := c.Old
+		if oc != nil {
+			ours = oc.New
+		}
+		m.set(from, none, m.theirs)
+		if ac := m.added[to]; ac != nil {
+			// NOTE(lor): The changes ours made to the renamed
+			// file are lost in favour of the one it added.
+			return m.mergeFile(to, none, ac.New, c.New, to, to, to)
+		}
+		return m.mergeFile(to, c.Old, ours, c.New, from, from, to)
+	case oc.Status == DiffDeleted:
+		m.set(to, c.New, m.theirs)
+		m.conflict(MergeConflict{
+			Kind:       ConflictRenameDelete,
+			Path:       to,
+			Result:     c.New,
+			BasePath:   from,
+			TheirsPath: to,
+			Base:       c.Old,
+			Theirs:     c.New,
+		})
+		return nil
+	case oc.NewPath == to:
+		return m.mergeFile(to, c.Old, oc.New, c.New, from, to, to)
+	}
+
+	// renamed to different paths; the merged file is recorded at both
+	result := oc.New
+	if sameType(oc.New, c.New) {
+		var err error
+		if result, _, err = m.mergeContents(c.Old, oc.New, c.New, from, oc.NewPath, to); err != nil {
+			return err
+		}
+	}
+	m.set(oc.NewPath, result, m.ours)
+	m.set(to, result, m.theirs)
+	m.conflict(MergeConflict{
+		Kind:       ConflictRenameRename,
+		Path:       oc.NewPath,
+		Result:     result,
+		BasePath:   from,
+		OursPath:   oc.NewPath,
+		TheirsPath: to,
+		Base:       c.Old,
+		Ours:       oc.New,
+		Theirs:     c.New,
+	})
+	return nil
+}
+
+// mergeFile merges the entries of a file in the merge base and on the
+// two sides, at the given paths, any of which may be zero, and records
+// the result at the given path.
+func (m *treeMerger) mergeFile(name string, base, ours, theirs object.TreeInfo, basePath, oursPath, theirsPath string) error {
+	c := MergeConflict{
+		Path:       name,
+		BasePath:   basePath,
+		OursPath:   oursPath,
+		TheirsPath: theirsPath,
+		Base:       base,
+		Ours:       ours,
+		Theirs:     theirs,
+	}
+	switch {
+	case ours == theirs:
+		m.set(name, ours, m.ours)
+		return nil
+	case ours == base:
+		m.set(name, theirs, m.theirs)
+		return nil
+	case theirs == base:
+		m.set(name, ours, m.ours)
+		return nil
+	case ours.Mode == 0 || theirs.Mode == 0:
+		// the changed file is kept
+		c.Kind, c.Result = ConflictModifyDelete, ours
+		side := m.ours
+		if ours.Mode == 0 {
+			c.Result, side = theirs, m.theirs
+		}
+		if basePath != oursPath || basePath != theirsPath {
+			c.Kind = ConflictRenameDelete
+		}
+		if ours.Mode == 0 {
+			c.OursPath = ""
+		} else {
+			c.TheirsPath = ""
+		}
+		m.set(name, c.Result, side)
+		m.conflict(c)
+		return nil
+	case !sameType(ours, theirs):
+		// like in the reference Git client, a regular file is
+		// moved out of the way of a file of another type, and if
+		// neither is one, both are moved
+		c.Kind = ConflictDistinctTypes
+		moveOurs := isRegular(ours.Mode) || !isRegular(theirs.Mode)
+		moveTheirs := !isRegular(ours.Mode)
+		m.set(name, object.TreeInfo{}, m.ours)
+		if moveOurs {
+			c.Path, c.Result = m.uniquePath(name, m.ours), ours
+			m.set(c.Path, ours, m.ours)
+			m.conflict(c)
+		} else {
+			m.set(name, ours, m.ours)
+		}
+		if moveTheirs {
+			c.Path, c.Result = m.uniquePath(name, m.theirs), theirs
+			m.set(c.Path, theirs, m.theirs)
+			m.conflict(c)
+		} else {
+			m.set(name, theirs, m.theirs)
+		}
+		return nil
+	}
+	result, clean, err := m.mergeContents(base, ours, theirs, basePath, oursPath, theirsPath)
+	if err != nil {
+		return err
+	}
+	m.set(name, result, m.ours)
+	if !clean {
+		switch {
+		case ours.Mode == object.ModeGitlink:
+			c.Kind = ConflictSubmodule
+		case base.Mode == 0:
+			c.Kind = ConflictAddAdd
+		default:
+			c.Kind = ConflictContent
+		}
+		c.Result = result
+		m.conflict(c)
+	}
+	return nil
+}
+
+// sameType reports whether the entries are of the same type of file:
+// both regular files, symlinks or submodules.
+func sameType(a, b object.TreeInfo) bool {
+	return a.Mode.Type() == b.Mode.Type() && isSymlink(a.Mode) == isSymlink(b.Mode)
+}
+
+// mergeContents merges the modes and contents of a file of the same
+// type on both sides, and reports whether they merged cleanly.  The
+// contents of regular files are merged line by line, unless any of
+// them is binary; those of symlinks and submodules are not merged, and
+// ours are kept.
+func (m *treeMerger) mergeContents(base, ours, theirs object.TreeInfo, basePath, oursPath, theirsPath string) (object.TreeInfo, bool, error) {
+	result, clean := ours, true
+	if ours.Mode == theirs.Mode || ours.Mode == base.Mode {
+		result.Mode = theirs.Mode
+	} else {
+		clean = theirs.Mode == base.Mode
+	}
+	switch {
+	case ours.Object == theirs.Object || ours.Object == base.Object:
+		result.Object = theirs.Object
+		return result, clean, nil
+	case theirs.Object == base.Object:
+		return result, clean, nil
+	case !isRegular(ours.Mode):
+		return result, false, nil
+	}
+
+	var texts [3][]byte
+	for i, ti := range []object.TreeInfo{base, ours, theirs} {
+		var err error
+		if texts[i], err = fileContents(m.repo, ti); err != nil {
+			return result, false, err
+		}
+		if diff.IsBinary(texts[i]) {
+			return result, false, nil
+		}
+	}
+	opts := &diff.MergeOptions{
+		Algorithm:   m.opts.Algorithm,
+		Style:       m.opts.Style,
+		OursLabel:   m.ours,
+		TheirsLabel: m.theirs,
+		BaseLabel:   m.base,
+	}
+	if basePath != oursPath || basePath != theirsPath {
+		opts.OursLabel += ":" + oursPath
+		opts.TheirsLabel += ":" + theirsPath
+		opts.BaseLabel += ":" + basePath
+	}
+	merged, conflicts := diff.Merge(diff.SplitLines(texts[0]), diff.SplitLines(texts[1]), diff.SplitLines(texts[2]), opts)
+	blob := object.Blob(merged)
+	var err error
+	if result.Object, err = m.repo.PutObject(&blob); err != nil {
+		return result, false, err
+	}
+	return result, clean && conflicts == 0, nil
+}
+
+// entry returns the entry at the given path of the merged tree, and the
+// label of the side it comes from.
+func (m *treeMerger) entry(name string) (object.TreeInfo, string, error) {
+	if e, ok := m.edits[name]; ok {
+		return e.entry, e.side, nil
+	}
+	ti, err := m.oursEntry(name)
+	return ti, m.ours, err
+}
+
+// oursEntry returns the entry at the given path of the tree of ours.
+func (m *treeMerger) oursEntry(name string) (object.TreeInfo, error) {
+	dirs, base, err := splitPath(name)
+	if err != nil {
+		return object.TreeInfo{}, err
+	}
+	return lookupEntry(m.repo, m.root, dirs, base)
+}
+
+// uniquePath returns a path, not taken in the merged tree, to which to
+// move the file at the given path that comes from the given side.
+func (m *treeMerger) uniquePath(name, side string) string {
+	prefix := name + "~" + strings.Replace(side, "/", "_", -1)
+	p := prefix
+	for i := 0; ; i++ {
+		if ti, _, err := m.entry(p); err == nil && ti.Mode == 0 {
+			return p
+		}
+		p = prefix + "_" + strconv.Itoa(i)
+	}
+}
+
+// resolveDirectories moves the files recorded at paths where the
+// merged tree has a directory, or in whose paths the merged tree has a
+// file, out of the way.
+func (m *treeMerger) resolveDirectories() error {
+	var paths []string
+	for name, e := range m.edits {
+		if e.entry.Mode != 0 {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+	for _, name := range paths {
+		if m.edits[name].entry.Mode == 0 {
+			continue // moved already
+		}
+		for i := 0; i < len(name); i++ {
+			if name[i] != '/' {
+				continue
+			}
+			ti, side, err := m.entry(name[:i])
+			if err != nil {
+				return err
+			}
+			if ti.Mode != 0 && ti.Mode != object.ModeTree {
+				m.moveAside(name[:i], ti, side)
+			}
+		}
+		isDir, err := m.isDir(name, paths)
+		if err != nil {
+			return err
+		}
+		if isDir {
+			e := m.edits[name]
+			m.moveAside(name, e.entry, e.side)
+		}
+	}
+	return nil
+}
+
+// isDir reports whether the merged tree has a directory at the given
+// path, given the sorted paths recorded in it.
+func (m *treeMerger) isDir(name string, paths []string) (bool, error) {
+	prefix := name + "/"
+	for i := sort.SearchStrings(paths, prefix); i < len(paths) && strings.HasPrefix(paths[i], prefix); i++ {
+		if m.edits[paths[i]].entry.Mode != 0 {
+			return true, nil
+		}
+	}
+	ti, err := m.oursEntry(name)
+	if err != nil || ti.Mode != object.ModeTree {
+		return false, err
+	}
+	files, err := diffTree(m.repo, prefix, ti.Object, m.format.ZeroID(), 0, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range files {
+		if e, ok := m.edits[f.OldPath]; !ok || e.entry.Mode != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// moveAside moves the file at the given path of the merged tree, which
+// comes from the given side, out of the way of a directory.
+func (m *treeMerger) moveAside(name string, ti object.TreeInfo, side string) {
+	to := m.uniquePath(name, side)
+	m.set(name, object.TreeInfo{}, side)
+	m.set(to, ti, side)
+	for i := range m.conflicts {
+		if m.conflicts[i].Path == name {
+			m.conflicts[i].Path = to
+		}
+	}
+	c := MergeConflict{Kind: ConflictFileDirectory, Path: to, Result: ti}
+	if side == m.ours {
+		c.OursPath, c.Ours = name, ti
+	} else {
+		c.TheirsPath, c.Theirs = name, ti
+	}
+	m.conflict(c)
+}
+
+// write stores the merged tree, made by editing the tree of ours with
+// the given ID, and returns its ID.
+func (m *treeMerger) write(ours object.ID) (object.ID, error) {
+	e, err := NewTreeEditor(m.repo, ours)
+	if err != nil {
+		return m.format.ZeroID(), err
+	}
+	var paths []string
+	for name := range m.edits {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+
+	// files are deleted first, so that directories can replace them
+	for _, name := range paths {
+		if m.edits[name].entry.Mode != 0 {
+			continue
+		}
+		ti, err := m.oursEntry(name)
+		if err != nil {
+			return m.format.ZeroID(), err
+		}
+		if ti.Mode == 0 || ti.Mode == object.ModeTree {
+			continue
+		}
+		if err := e.Delete(name); err != nil {
+			return m.format.ZeroID(), err
+		}
+	}
+	for _, name := range paths {
+		if ti := m.edits[name].entry; ti.Mode != 0 {
+			if err := e.Set(name, ti.Mode, ti.Object); err != nil {
+				return m.format.ZeroID(), err
+			}
+		}
+	}
+	return e.Write()
+}
+
+// conflictSlice sorts conflicts by path.
+type conflictSlice []MergeConflict
+
+func (s conflictSlice) Len() int {
+	return len(s)
+}
+
+func (s conflictSlice) Less(i, j int) bool {
+	return s[i].Path < s[j].Path
+}
+
+func (s conflictSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,426 @@
+package repository_test
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lxr/go.git-scm/diff"
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// lines returns the space-separated words as lines of text.
+func lines(words string) string {
+	return strings.Join(strings.Fields(words), "\n") + "\n"
+}
+
+// seq returns the numbers from 1 to n as lines of text, with the given
+// lines replaced.
+func seq(n int, replace ...string) string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = strconv.Itoa(i + 1)
+	}
+	for i := 0; i < len(replace); i += 2 {
+		n, _ := strconv.Atoi(replace[i])
+		words[n-1] = replace[i+1]
+	}
+	return lines(strings.Join(words, " "))
+}
+
+// files returns the contents of the regular files of the tree
+// hierarchy, keyed by path.
+func (r *testRepo) files(tree object.ID) map[string]string {
+	changes, err := repository.DiffTrees(r.repo, object.ZeroID, tree, nil)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	files := make(map[string]string, len(changes))
+	for _, c := range changes {
+		rc, _, err := repository.OpenBlob(r.repo, c.New.Object)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		files[c.NewPath] = string(data)
+	}
+	return files
+}
+
+// The expected results of these merges are those of git merge-tree
+// --write-tree, with the merge base labelled "base", except as noted.
+var mergeTests = []struct {
+	desc               string
+	base, ours, theirs map[string]string
+	opts               *repository.MergeOptions
+	want               map[string]string
+	conflicts          []string
+}{
+	{
+		desc:   "clean",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"a": seq(10, "2", "two"), "b": "b\n"},
+		theirs: map[string]string{"a": seq(10, "9", "nine"), "b": "b\n", "c": "c\n"},
+		want: map[string]string{
+			"a": seq(10, "2", "two", "9", "nine"),
+			"b": "b\n",
+			"c": "c\n",
+		},
+	},
+	{
+		desc:   "same change",
+		base:   map[string]string{"a": seq(10)},
+		ours:   map[string]string{"a": seq(10, "5", "five")},
+		theirs: map[string]string{"a": seq(10, "5", "five"), "c": "c\n"},
+		want: map[string]string{
+			"a": seq(10, "5", "five"),
+			"c": "c\n",
+		},
+	},
+	{
+		desc:   "content",
+		base:   map[string]string{"a": seq(10)},
+		ours:   map[string]string{"a": seq(10, "5", "five")},
+		theirs: map[string]string{"a": seq(10, "5", "FIVE")},
+		want: map[string]string{
+			"a": "1\n2\n3\n4\n<<<<<<< ours\nfive\n=======\nFIVE\n>>>>>>> theirs\n6\n7\n8\n9\n10\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "refined",
+		base:   map[string]string{"a": seq(10)},
+		ours:   map[string]string{"a": seq(10, "4", "x", "5", "five", "6", "y")},
+		theirs: map[string]string{"a": seq(10, "4", "x", "5", "FIVE", "6", "y")},
+		want: map[string]string{
+			"a": "1\n2\n3\nx\n<<<<<<< ours\nfive\n=======\nFIVE\n>>>>>>> theirs\ny\n7\n8\n9\n10\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "refined diff3",
+		base:   map[string]string{"a": seq(10)},
+		ours:   map[string]string{"a": seq(10, "4", "x", "5", "five", "6", "y")},
+		theirs: map[string]string{"a": seq(10, "4", "x", "5", "FIVE", "6", "y")},
+		opts:   &repository.MergeOptions{Style: diff.StyleDiff3},
+		want: map[string]string{
+			"a": "1\n2\n3\n<<<<<<< ours\nx\nfive\ny\n||||||| base\n4\n5\n6\n=======\nx\nFIVE\ny\n>>>>>>> theirs\n7\n8\n9\n10\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "nearby conflicts",
+		base:   map[string]string{"a": seq(20)},
+		ours:   map[string]string{"a": seq(20, "3", "three", "6", "six", "15", "fifteen")},
+		theirs: map[string]string{"a": seq(20, "3", "THREE", "6", "SIX", "15", "FIFTEEN")},
+		want: map[string]string{
+			"a": "1\n2\n<<<<<<< ours\nthree\n4\n5\nsix\n=======\nTHREE\n4\n5\nSIX\n>>>>>>> theirs\n7\n8\n9\n10\n11\n12\n13\n14\n<<<<<<< ours\nfifteen\n=======\nFIFTEEN\n>>>>>>> theirs\n16\n17\n18\n19\n20\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "no newline at end",
+		base:   map[string]string{"a": "1\n2\n3"},
+		ours:   map[string]string{"a": "1\nX\n3"},
+		theirs: map[string]string{"a": "1\n2\n3\n4"},
+		want: map[string]string{
+			"a": "1\n<<<<<<< ours\nX\n3\n=======\n2\n3\n4\n>>>>>>> theirs\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "crlf",
+		base:   map[string]string{"a": "1\r\n2\r\n3\r\n"},
+		ours:   map[string]string{"a": "1\r\ntwo\r\n3\r\n"},
+		theirs: map[string]string{"a": "1\r\nTWO\r\n3\r\n"},
+		want: map[string]string{
+			"a": "1\r\n<<<<<<< ours\r\ntwo\r\n=======\r\nTWO\r\n>>>>>>> theirs\r\n3\r\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "labels",
+		base:   map[string]string{"a": seq(10)},
+		ours:   map[string]string{"a": seq(10, "5", "five")},
+		theirs: map[string]string{"a": seq(10, "5", "FIVE")},
+		opts: &repository.MergeOptions{
+			OursLabel:   "HEAD",
+			TheirsLabel: "topic",
+			BaseLabel:   "merged common ancestors",
+			Style:       diff.StyleDiff3,
+		},
+		want: map[string]string{
+			"a": "1\n2\n3\n4\n<<<<<<< HEAD\nfive\n||||||| merged common ancestors\n5\n=======\nFIVE\n>>>>>>> topic\n6\n7\n8\n9\n10\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "add/add",
+		base:   map[string]string{"b": "b\n"},
+		ours:   map[string]string{"a": seq(5), "b": "b\n"},
+		theirs: map[string]string{"a": seq(5, "1", "one", "5", "five"), "b": "b\n"},
+		want: map[string]string{
+			"a": "<<<<<<< ours\n1\n2\n3\n4\n5\n=======\none\n2\n3\n4\nfive\n>>>>>>> theirs\n",
+			"b": "b\n",
+		},
+		conflicts: []string{
+			"CONFLICT (add/add): Merge conflict in a",
+		},
+	},
+	{
+		desc:   "modify/delete",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"a": seq(10, "5", "five"), "b": "b\n"},
+		theirs: map[string]string{"b": "b\n"},
+		want: map[string]string{
+			"a": seq(10, "5", "five"),
+			"b": "b\n",
+		},
+		conflicts: []string{
+			"CONFLICT (modify/delete): a deleted in theirs and modified in ours",
+		},
+	},
+	{
+		desc:   "delete/modify",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"b": "b\n"},
+		theirs: map[string]string{"a": seq(10, "5", "five"), "b": "b\n"},
+		want: map[string]string{
+			"a": seq(10, "5", "five"),
+			"b": "b\n",
+		},
+		conflicts: []string{
+			"CONFLICT (modify/delete): a deleted in ours and modified in theirs",
+		},
+	},
+	{
+		desc:   "subdirectories",
+		base:   map[string]string{"s/t/a": seq(10), "s/b": "b\n"},
+		ours:   map[string]string{"s/t/a": seq(10, "1", "one")},
+		theirs: map[string]string{"s/t/a": seq(10, "10", "ten"), "s/b": "b\n", "s/t/n": "n\n"},
+		want: map[string]string{
+			"s/t/a": seq(10, "1", "one", "10", "ten"),
+			"s/t/n": "n\n",
+		},
+	},
+	{
+		desc:   "rename and modify",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"r": seq(10), "b": "b\n"},
+		theirs: map[string]string{"a": seq(10, "5", "five"), "b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": seq(10, "5", "five"),
+		},
+	},
+	{
+		desc:   "modify and rename",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"a": seq(10, "5", "five"), "b": "b\n"},
+		theirs: map[string]string{"r": seq(10), "b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": seq(10, "5", "five"),
+		},
+	},
+	{
+		desc:   "rename and modify conflict",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"r": seq(10, "5", "FIVE"), "b": "b\n"},
+		theirs: map[string]string{"a": seq(10, "5", "five"), "b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": "1\n2\n3\n4\n<<<<<<< ours:r\nFIVE\n=======\nfive\n>>>>>>> theirs:a\n6\n7\n8\n9\n10\n",
+		},
+		conflicts: []string{
+			"CONFLICT (content): Merge conflict in r",
+		},
+	},
+	{
+		// as git merge -s recursive -X no-renames; the ort strategy
+		// detects renames regardless
+		desc:   "rename without detection",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"r": seq(10), "b": "b\n"},
+		theirs: map[string]string{"a": seq(10, "5", "five"), "b": "b\n"},
+		opts:   &repository.MergeOptions{NoRenames: true},
+		want: map[string]string{
+			"a": seq(10, "5", "five"),
+			"b": "b\n",
+			"r": seq(10),
+		},
+		conflicts: []string{
+			"CONFLICT (modify/delete): a deleted in ours and modified in theirs",
+		},
+	},
+	{
+		desc:   "rename/delete",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"r": seq(10), "b": "b\n"},
+		theirs: map[string]string{"b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": seq(10),
+		},
+		conflicts: []string{
+			"CONFLICT (rename/delete): a renamed to r in ours, but deleted in theirs",
+		},
+	},
+	{
+		desc:   "delete/rename",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"b": "b\n"},
+		theirs: map[string]string{"r": seq(10), "b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": seq(10),
+		},
+		conflicts: []string{
+			"CONFLICT (rename/delete): a renamed to r in theirs, but deleted in ours",
+		},
+	},
+	{
+		desc:   "same rename",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"r": seq(10, "2", "two"), "b": "b\n"},
+		theirs: map[string]string{"r": seq(10, "9", "nine"), "b": "b\n", "c": "c\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"c": "c\n",
+			"r": seq(10, "2", "two", "9", "nine"),
+		},
+	},
+	{
+		desc:   "rename/rename",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"r": seq(10, "2", "two"), "b": "b\n"},
+		theirs: map[string]string{"s": seq(10, "9", "nine"), "b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": seq(10, "2", "two", "9", "nine"),
+			"s": seq(10, "2", "two", "9", "nine"),
+		},
+		conflicts: []string{
+			"CONFLICT (rename/rename): a renamed to r in ours and to s in theirs",
+		},
+	},
+	{
+		desc:   "rename onto added file",
+		base:   map[string]string{"a": seq(10), "b": "b\n"},
+		ours:   map[string]string{"a": seq(10), "b": "b\n", "r": lines("x y z")},
+		theirs: map[string]string{"r": seq(10), "b": "b\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"r": "<<<<<<< ours\nx\ny\nz\n=======\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n>>>>>>> theirs\n",
+		},
+		conflicts: []string{
+			"CONFLICT (add/add): Merge conflict in r",
+		},
+	},
+	{
+		desc:   "file/directory",
+		base:   map[string]string{"b": "b\n"},
+		ours:   map[string]string{"b": "b\n", "d": "d\n"},
+		theirs: map[string]string{"b": "b\n", "d/x": "x\n"},
+		want: map[string]string{
+			"b":      "b\n",
+			"d/x":    "x\n",
+			"d~ours": "d\n",
+		},
+		conflicts: []string{
+			"CONFLICT (file/directory): d moved to d~ours",
+		},
+	},
+	{
+		desc:   "directory/file",
+		base:   map[string]string{"b": "b\n"},
+		ours:   map[string]string{"b": "b\n", "d/x": "x\n"},
+		theirs: map[string]string{"b": "b\n", "d": "d\n"},
+		want: map[string]string{
+			"b":        "b\n",
+			"d/x":      "x\n",
+			"d~theirs": "d\n",
+		},
+		conflicts: []string{
+			"CONFLICT (file/directory): d moved to d~theirs",
+		},
+	},
+	{
+		desc:   "directory replaced by file",
+		base:   map[string]string{"b": "b\n", "d/x": "x\n"},
+		ours:   map[string]string{"b": "b\n", "d/x": "x\n", "c": "c\n"},
+		theirs: map[string]string{"b": "b\n", "d": "d\n"},
+		want: map[string]string{
+			"b": "b\n",
+			"c": "c\n",
+			"d": "d\n",
+		},
+	},
+	{
+		desc:   "modified file in replaced directory",
+		base:   map[string]string{"b": "b\n", "d/x": seq(10)},
+		ours:   map[string]string{"b": "b\n", "d/x": seq(10, "5", "five")},
+		theirs: map[string]string{"b": "b\n", "d": "d\n"},
+		want: map[string]string{
+			"b":        "b\n",
+			"d/x":      seq(10, "5", "five"),
+			"d~theirs": "d\n",
+		},
+		conflicts: []string{
+			"CONFLICT (modify/delete): d/x deleted in theirs and modified in ours",
+			"CONFLICT (file/directory): d moved to d~theirs",
+		},
+	},
+}
+
+func TestMergeTrees(t *testing.T) {
+	for _, tt := range mergeTests {
+		r := newTestRepo(t)
+		r.commit("base", tt.base)
+		r.commit("ours", tt.ours, "base")
+		r.commit("theirs", tt.theirs, "base")
+		res, err := repository.MergeTrees(r.repo, r.id("base"), r.id("ours"), r.id("theirs"), tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.desc, err)
+			continue
+		}
+		got := r.files(res.Tree)
+		for name, contents := range tt.want {
+			if got[name] != contents {
+				t.Errorf("%s: got %s as %q, want %q", tt.desc, name, got[name], contents)
+			}
+		}
+		for name := range got {
+			if _, ok := tt.want[name]; !ok {
+				t.Errorf("%s: unexpected file %s in merged tree", tt.desc, name)
+			}
+		}
+		var conflicts []string
+		for _, c := range res.Conflicts {
+			conflicts = append(conflicts, c.String())
+		}
+		if strings.Join(conflicts, "\n") != strings.Join(tt.conflicts, "\n") {
+			t.Errorf("%s: got conflicts\n%s\nwant\n%s", tt.desc, strings.Join(conflicts, "\n"), strings.Join(tt.conflicts, "\n"))
+		}
+	}
+}
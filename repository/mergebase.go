@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"container/heap"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// The flags with which paint paints commits.
+const (
+	paintOne    = 1 << iota // reachable from the first commit
+	paintTwo                // reachable from one of the other commits
+	paintStale              // reachable from a common ancestor
+	paintResult             // a common ancestor
+)
+
+// BUG(lor): Like the reference Git client, the merge base and ancestry
+// queries walk the commits not in the commit-graph of the repository in
+// order of their committer dates, and may give wrong answers in
+// histories with badly skewed clocks.  Keeping the commit-graph up to
+// date avoids this.
+
+// MergeBases returns the IDs of the best common ancestors of the commit
+// one and a hypothetical merge of the others, like the reference Git
+// client's merge-base --all command: the common ancestors that are not
+// ancestors of other common ancestors.  Two commits can have several
+// merge bases in a history with criss-cross merges.  The merge bases
+// are returned youngest first; the first one is the one the merge-base
+// command shows without --all.  Tags are dereferenced to commits.
+func MergeBases(r Interface, one object.ID, others ...object.ID) ([]object.ID, error) {
+	w := newRevWalker(r)
+	n, err := w.node(one)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := w.nodesOf(others)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := w.mergeBases(n, nodes)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIDs(bases), nil
+}
+
+// OctopusMergeBases returns the IDs of the best common ancestors of all
+// the commits, for use in an n-way merge, like the reference Git
+// client's merge-base --octopus command.
+func OctopusMergeBases(r Interface, ids ...object.ID) ([]object.ID, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	w := newRevWalker(r)
+	nodes, err := w.nodesOf(ids)
+	if err != nil {
+		return nil, err
+	}
+	bases := nodes[:1]
+	for _, n := range nodes[1:] {
+		var next []*revNode
+		for _, base := range bases {
+			b, err := w.mergeBases(n, []*revNode{base})
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, b...)
+		}
+		bases = next
+	}
+	bases, err = w.removeRedundant(unique(bases))
+	if err != nil {
+		return nil, err
+	}
+	return nodeIDs(bases), nil
+}
+
+// IsAncestor reports whether the commit a is an ancestor of the commit
+// b.  A commit is its own ancestor.
+func IsAncestor(r Interface, a, b object.ID) (bool, error) {
+	w := newRevWalker(r)
+	nodes, err := w.nodesOf([]object.ID{a, b})
+	if err != nil {
+		return false, err
+	}
+	// a commit cannot reach commits of greater generation
+	if nodes[0].gen > nodes[1].gen {
+		return false, nil
+	}
+	if _, err := w.paint(nodes[0], nodes[1:], nodes[0].gen); err != nil {
+		return false, err
+	}
+	return nodes[0].paint&paintTwo != 0, nil
+}
+
+// Independent returns the IDs of the commits that are not ancestors of
+// any of the others, in the order given, like the reference Git
+// client's merge-base --independent command.  Duplicates are removed.
+func Independent(r Interface, ids ...object.ID) ([]object.ID, error) {
+	w := newRevWalker(r)
+	nodes, err := w.nodesOf(ids)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err = w.removeRedundant(unique(nodes))
+	if err != nil {
+		return nil, err
+	}
+	return nodeIDs(nodes), nil
+}
+
+// AheadBehind returns the number of commits reachable from the commit a
+// but not from the commit b, and vice versa, like the reference Git
+// client's rev-list --left-right --count a...b command.  Like the
+// command, it counts the commits by walking them from each commit as
+// RevList does, excluding the merge bases of the two.
+func AheadBehind(r Interface, a, b object.ID) (ahead, behind int, err error) {
+	bases, err := MergeBases(r, a, b)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ahead, err = countRevs(r, a, bases); err != nil {
+		return 0, 0, err
+	}
+	if behind, err = countRevs(r, b, bases); err != nil {
+		return 0, 0, err
+	}
+	return ahead, behind, nil
+}
+
+// countRevs returns the number of commits reachable from the commit
+// include but not from the exclude commits.
+func countRevs(r Interface, include object.ID, exclude []object.ID) (int, error) {
+	n := 0
+	err := RevList(r, []object.ID{include}, exclude, nil, func(object.ID, *object.Commit, bool) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// nodesOf returns the nodes of the commits the IDs dereference to.
+func (w *revWalker) nodesOf(ids []object.ID) ([]*revNode, error) {
+	nodes := make([]*revNode, len(ids))
+	for i, id := range ids {
+		n, err := w.node(id)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+// unique returns the nodes with duplicates removed, in order.
+func unique(nodes []*revNode) []*revNode {
+	var result []*revNode
+	seen := make(map[*revNode]bool)
+	for _, n := range nodes {
+		if !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// nodeIDs returns the IDs of the nodes.
+func nodeIDs(nodes []*revNode) []object.ID {
+	ids := make([]object.ID, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.id
+	}
+	return ids
+}
+
+// paint paints one with paintOne and the others with paintTwo, and
+// walks their ancestors youngest first, painting each with the flags
+// of its children, until only the ancestors of common ancestors, or
+// commits of lesser generation than minGen, are left to walk.  It
+// returns the common ancestors found, youngest first; those not painted
+// with paintStale are the merge base candidates.  Any previous paint
+// is cleared first.
+func (w *revWalker) paint(one *revNode, others []*revNode, minGen int64) ([]*revNode, error) {
+	for _, n := range w.nodes {
+		n.paint = 0
+	}
+	w.queue = w.queue[:0]
+	w.byGeneration = true
+	one.paint |= paintOne
+	w.enqueue(one)
+	for _, n := range others {
+		n.paint |= paintTwo
+		w.enqueue(n)
+	}
+	var common []*revNode
+	for w.queue.hasUnstale() {
+		n := heap.Pop(&w.queue).(queueEntry).node
+		if n.gen < minGen {
+			break
+		}
+		flags := n.paint & (paintOne | paintTwo | paintStale)
+		if flags == paintOne|paintTwo {
+			if n.paint&paintResult == 0 {
+				n.paint |= paintResult
+				common = insertByDate(common, n)
+			}
+			// the ancestors of a common ancestor are not merge
+			// bases
+			flags |= paintStale
+		}
+		for _, id := range n.parents {
+			p, err := w.node(id)
+			if err != nil {
+				return nil, err
+			}
+			if p.paint&flags == flags {
+				continue
+			}
+			p.paint |= flags
+			w.enqueue(p)
+		}
+	}
+	return common, nil
+}
+
+// insertByDate inserts n into the list of nodes sorted youngest first
+// by date after the nodes of the same age.
+func insertByDate(list []*revNode, n *revNode) []*revNode {
+	i := len(list)
+	for i > 0 && list[i-1].date.Before(n.date) {
+		i--
+	}
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = n
+	return list
+}
+
+// mergeBases returns the merge bases of one and the others, as
+// described for MergeBases.
+func (w *revWalker) mergeBases(one *revNode, others []*revNode) ([]*revNode, error) {
+	for _, n := range others {
+		if n == one {
+			return []*revNode{one}, nil
+		}
+	}
+	common, err := w.paint(one, others, 0)
+	if err != nil {
+		return nil, err
+	}
+	var bases []*revNode
+	for _, n := range common {
+		if n.paint&paintStale == 0 {
+			bases = append(bases, n)
+		}
+	}
+	if len(bases) <= 1 {
+		return bases, nil
+	}
+	return w.removeRedundant(bases)
+}
+
+// removeRedundant returns the nodes that are not ancestors of the
+// others, in order.  The nodes must be distinct.
+func (w *revWalker) removeRedundant(nodes []*revNode) ([]*revNode, error) {
+	redundant := make([]bool, len(nodes))
+	for i, n := range nodes {
+		if redundant[i] {
+			continue
+		}
+		var others []*revNode
+		var index []int
+		for j, m := range nodes {
+			if j != i && !redundant[j] {
+				others = append(others, m)
+				index = append(index, j)
+			}
+		}
+		if _, err := w.paint(n, others, 0); err != nil {
+			return nil, err
+		}
+		if n.paint&paintTwo != 0 {
+			redundant[i] = true
+		}
+		for k, m := range others {
+			if m.paint&paintOne != 0 {
+				redundant[index[k]] = true
+			}
+		}
+	}
+	var result []*revNode
+	for i, n := range nodes {
+		if !redundant[i] {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// hasUnstale reports whether any of the commits in the queue are not
+// painted with paintStale.
+func (q commitQueue) hasUnstale() bool {
+	for _, e := range q {
+		if e.node.paint&paintStale == 0 {
+			return true
+		}
+	}
+	return false
+}
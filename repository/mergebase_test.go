@@ -0,0 +1,165 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// crissCrossHistory returns a repository in which L2 and R2 both merge
+// L1 and R1, so that L3 and R3 have two merge bases, and O is an
+// octopus merge of L3, R3 and S1.
+func crissCrossHistory(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("root", nil)
+	r.commit("L1", nil, "root")
+	r.commit("R1", nil, "root")
+	r.commit("L2", nil, "L1", "R1")
+	r.commit("R2", nil, "R1", "L1")
+	r.commit("L3", nil, "L2")
+	r.commit("R3", nil, "R2")
+	r.commit("S1", nil, "root")
+	r.commit("O", nil, "L3", "R3", "S1")
+	return r
+}
+
+// mergeBaseTests are sets of commits and their merge bases as the
+// reference Git client's merge-base command finds them: the first commit
+// against the others, and with the --octopus and --independent options.
+var mergeBaseTests = []struct {
+	history                     func(*testing.T) *testRepo
+	commits                     string
+	bases, octopus, independent string
+}{
+	{crissCrossHistory, "L3 R3", "R1 L1", "R1 L1", "L3 R3"},
+	{crissCrossHistory, "L2 R2", "R1 L1", "R1 L1", "L2 R2"},
+	{crissCrossHistory, "L1 R1", "root", "root", "L1 R1"},
+	{crissCrossHistory, "L3 L1", "L1", "L1", "L3"},
+	{crissCrossHistory, "L1 L3", "L1", "L1", "L3"},
+	{crissCrossHistory, "O L3", "L3", "L3", "O"},
+	{crissCrossHistory, "S1 L3 R3", "root", "root", "S1 L3 R3"},
+	{crissCrossHistory, "L3 R3 S1", "R1 L1", "root", "L3 R3 S1"},
+	{crissCrossHistory, "L2 R1 L1", "R1 L1", "root", "L2"},
+	{crissCrossHistory, "L3 L3", "L3", "L3", "L3"},
+	{skewedHistory, "m10 x3", "x2", "x2", "m10 x3"},
+	{skewedHistory, "m8 x3", "m2", "m2", "m8 x3"},
+	{skewedHistory, "m1 m8", "m1", "m1", "m8"},
+	{skewedHistory, "m3 m9 x3", "m3", "m2", "m9 x3"},
+}
+
+// aheadBehindTests are pairs of commits and the numbers of commits
+// reachable from only one of them, as counted by the reference Git
+// client's rev-list --left-right --count command.
+var aheadBehindTests = []struct {
+	history       func(*testing.T) *testRepo
+	a, b          string
+	ahead, behind int
+}{
+	{crissCrossHistory, "L3", "R3", 2, 2},
+	{crissCrossHistory, "O", "L1", 7, 0},
+	{crissCrossHistory, "L1", "R1", 1, 1},
+	{crissCrossHistory, "S1", "S1", 0, 0},
+	{skewedHistory, "m10", "m9", 1, 0},
+	{skewedHistory, "m9", "m10", 0, 1},
+	{skewedHistory, "x2", "m10", 0, 8},
+	{skewedHistory, "x3", "m10", 1, 8},
+	{skewedHistory, "m8", "m3", 5, 0},
+	{skewedHistory, "m3", "m8", 0, 5},
+	{skewedHistory, "m8", "m9", 0, 3},
+	{skewedHistory, "m9", "m5", 6, 0},
+	{skewedHistory, "m1", "m8", 0, 7},
+	{skewedHistory, "m4", "x3", 2, 3},
+}
+
+func TestMergeBases(t *testing.T) {
+	for _, graph := range []bool{false, true} {
+		for _, tt := range mergeBaseTests {
+			r := tt.history(t)
+			if graph {
+				r.writeCommitGraph()
+			}
+			ids := r.idList(tt.commits)
+			bases, err := repository.MergeBases(r.repo, ids[0], ids[1:]...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.nameList(bases); got != tt.bases {
+				t.Errorf("MergeBases(%s) with commit-graph %v = %s, want %s", tt.commits, graph, got, tt.bases)
+			}
+			octopus, err := repository.OctopusMergeBases(r.repo, ids...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.nameList(octopus); got != tt.octopus {
+				t.Errorf("OctopusMergeBases(%s) with commit-graph %v = %s, want %s", tt.commits, graph, got, tt.octopus)
+			}
+			independent, err := repository.Independent(r.repo, ids...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.nameList(independent); got != tt.independent {
+				t.Errorf("Independent(%s) with commit-graph %v = %s, want %s", tt.commits, graph, got, tt.independent)
+			}
+		}
+	}
+}
+
+func TestIsAncestor(t *testing.T) {
+	for _, history := range []func(*testing.T) *testRepo{crissCrossHistory, skewedHistory} {
+		for _, graph := range []bool{false, true} {
+			r := history(t)
+			if graph {
+				r.writeCommitGraph()
+			}
+			for a, aid := range r.ids {
+				for b, bid := range r.ids {
+					got, err := repository.IsAncestor(r.repo, aid, bid)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if want := isAncestor(r, aid, bid); got != want {
+						t.Errorf("IsAncestor(%s, %s) with commit-graph %v = %v, want %v", a, b, graph, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// isAncestor reports whether a is an ancestor of b by searching all the
+// ancestors of b.
+func isAncestor(r *testRepo, a, b object.ID) bool {
+	pending := []object.ID{b}
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if id == a {
+			return true
+		}
+		c, _, err := repository.GetCommit(r.repo, id)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		pending = append(pending, c.Parent...)
+	}
+	return false
+}
+
+func TestAheadBehind(t *testing.T) {
+	for _, graph := range []bool{false, true} {
+		for _, tt := range aheadBehindTests {
+			r := tt.history(t)
+			if graph {
+				r.writeCommitGraph()
+			}
+			ahead, behind, err := repository.AheadBehind(r.repo, r.id(tt.a), r.id(tt.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ahead != tt.ahead || behind != tt.behind {
+				t.Errorf("AheadBehind(%s, %s) with commit-graph %v = %d, %d, want %d, %d", tt.a, tt.b, graph, ahead, behind, tt.ahead, tt.behind)
+			}
+		}
+	}
+}
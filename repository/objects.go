@@ -23,12 +23,22 @@ func HasObject(r Interface, id object.ID) (bool, error) {
 	}
 }
 
+// getParsed retrieves the object with the given ID from the repository
+// and returns its parsed form.
+func getParsed(r Interface, id object.ID) (object.Interface, error) {
+	obj, err := r.GetObject(id)
+	if err != nil {
+		return nil, err
+	}
+	return object.Parse(obj)
+}
+
 // GetCommit recursively dereferences the given ID to a commit object
 // and returns its ID.  If an object cannot be dereferenced into a
 // commit, GetCommit returns its ID and an *object.TypeError containing
 // the object.
 func GetCommit(r Interface, id object.ID) (*object.Commit, object.ID, error) {
-	obj, err := r.GetObject(id)
+	obj, err := getParsed(r, id)
 	if err != nil {
 		return nil, id, err
 	}
@@ -52,7 +62,7 @@ func GetCommit(r Interface, id object.ID) (*object.Commit, object.ID, error) {
 // a tag, GetTag returns its ID and an *object.TypeError containing the
 // retrieved object.
 func GetTag(r Interface, id object.ID) (*object.Tag, object.ID, error) {
-	obj, err := r.GetObject(id)
+	obj, err := getParsed(r, id)
 	if err != nil {
 		return nil, id, err
 	}
@@ -72,7 +82,7 @@ func GetTag(r Interface, id object.ID) (*object.Tag, object.ID, error) {
 // GetTree returns its ID and an *object.TypeError containing the
 // object.
 func GetTree(r Interface, id object.ID) (*object.Tree, object.ID, error) {
-	obj, err := r.GetObject(id)
+	obj, err := getParsed(r, id)
 	if err != nil {
 		return nil, id, err
 	}
@@ -93,11 +103,11 @@ func GetTree(r Interface, id object.ID) (*object.Tree, object.ID, error) {
 	}
 }
 
-// GetPath retrieves the object with the given filename in the tree
-// hierarchy rooted at the given ID.  The ID of the object is also
-// retrieved.  The root ID may point to a tree, commit or tag object.
-// If name resolves to "/", GetPath returns the first tree object
-// derived from the root ID.  If a path component is missing from
+// GetPath retrieves the parsed form of the object with the given
+// filename in the tree hierarchy rooted at the given ID.  The ID of the
+// object is also retrieved.  The root ID may point to a tree, commit or
+// tag object.  If name resolves to "/", GetPath returns the first tree
+// object derived from the root ID.  If a path component is missing from
 // a tree object during the walk, GetPath returns the tree object and
 // its ID and an error designating the missing component.  If an
 // intermediate object cannot be dereferenced into a tree, GetPath
@@ -133,7 +143,7 @@ func GetPath(r Interface, id object.ID, name string) (object.Interface, object.I
 		if !ok {
 			return tree, id, fmt.Errorf("no such tree entry: %s", comp)
 		}
-		obj, err = r.GetObject(ti.Object)
+		obj, err = getParsed(r, ti.Object)
 		if err != nil {
 			return nil, id, err
 		}
@@ -159,9 +169,11 @@ type WalkFunc func(id object.ID, obj object.Interface, err error) error
 // Walk continues without searching the subgraph rooted at the current
 // object.
 //
-// Walk traverses the repository in depth-first order.  Non-standard Git
-// objects are treated as having no references for the purposes of the
-// traversal; they are still passed to walkFn.
+// Walk traverses the repository in depth-first order.  Objects are
+// passed to walkFn as retrieved from the repository.  Non-standard Git
+// objects and objects that cannot be parsed are treated as having no
+// references for the purposes of the traversal; they are still passed
+// to walkFn.
 func Walk(r Interface, start, end []object.ID, walkFn WalkFunc) error {
 	visited := make(map[object.ID]bool)
 	for _, id := range end {
@@ -186,7 +198,11 @@ func Walk(r Interface, start, end []object.ID, walkFn WalkFunc) error {
 			return err
 		}
 
-		switch obj := obj.(type) {
+		parsed, err := object.Parse(obj)
+		if err != nil {
+			continue
+		}
+		switch obj := parsed.(type) {
 		case *object.Commit:
 			pending = append(pending, obj.Tree)
 			for _, parent := range obj.Parent {
@@ -0,0 +1,458 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/lxr/go.git-scm/diff"
+	"github.com/lxr/go.git-scm/object"
+)
+
+// PatchOptions control the output of WritePatch and DiffStat.  A nil
+// *PatchOptions uses the defaults of the reference Git client.
+type PatchOptions struct {
+	// Algorithm is the algorithm with which changed files are
+	// compared.
+	Algorithm diff.Algorithm
+
+	// Context is the number of unchanged lines shown around each
+	// change.  If zero, three lines are shown; if negative, none.
+	Context int
+
+	// FullIndex shows the full object IDs of the files on the
+	// index line of each patch rather than abbreviated ones.
+	FullIndex bool
+}
+
+// The default number of lines of context.
+const defaultContext = 3
+
+// A fileDiff is the contents of the two sides of a changed file and
+// their line-based differences.
+type fileDiff struct {
+	old, new []byte
+	binary   bool
+	a, b     []string
+	edits    []diff.Edit
+}
+
+// diffFile compares the contents of the two sides of a change.  Binary
+// files are not compared line by line.  If context is zero, the common
+// tail of the contents is left out of the comparison, as the reference
+// Git client does when no context is shown; this can change where
+// changes that could be slid up or down end up.
+func diffFile(r Interface, a, b object.TreeInfo, opts *PatchOptions, context int) (*fileDiff, error) {
+	var err error
+	fd := new(fileDiff)
+	if fd.old, err = fileContents(r, a); err != nil {
+		return nil, err
+	}
+	if fd.new, err = fileContents(r, b); err != nil {
+		return nil, err
+	}
+	if fd.binary = diff.IsBinary(fd.old) || diff.IsBinary(fd.new); fd.binary {
+		return fd, nil
+	}
+	alg := diff.Myers
+	if opts != nil {
+		alg = opts.Algorithm
+	}
+	x, y := fd.old, fd.new
+	if context == 0 {
+		x, y = trimCommonTail(x, y)
+	}
+	fd.a, fd.b = diff.SplitLines(x), diff.SplitLines(y)
+	if a.Object != b.Object {
+		fd.edits = diff.Diff(fd.a, fd.b, alg)
+	}
+	return fd, nil
+}
+
+// trimCommonTail removes the longest common suffix of a and b that is a
+// multiple of 1024 bytes long, save for its first line.
+func trimCommonTail(a, b []byte) ([]byte, []byte) {
+	const block = 1024
+	trimmed := 0
+	for trimmed+block <= len(a) && trimmed+block <= len(b) &&
+		bytes.Equal(a[len(a)-trimmed-block:len(a)-trimmed], b[len(b)-trimmed-block:len(b)-trimmed]) {
+		trimmed += block
+	}
+	if i := bytes.IndexByte(a[len(a)-trimmed:], '\n'); i >= 0 {
+		trimmed -= i + 1
+	} else {
+		trimmed = 0
+	}
+	return a[:len(a)-trimmed], b[:len(b)-trimmed]
+}
+
+// fileContents returns the contents of the file with the given tree
+// entry: none for the zero entry, and a line naming the commit for a
+// submodule, as in the output of the reference Git client.
+func fileContents(r Interface, info object.TreeInfo) ([]byte, error) {
+	switch info.Mode {
+	case 0:
+		return nil, nil
+	case object.ModeGitlink:
+		return []byte("Subproject commit " + info.Object.String() + "\n"), nil
+	}
+	rc, _, err := OpenBlob(r, info.Object)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// WritePatch writes the changes to w as a patch in the format of the
+// reference Git client's diff command, which git apply accepts.  Files
+// replaced by files of another type are written as a deletion followed
+// by an addition, and changes to binary files only noted.
+func WritePatch(w io.Writer, r Interface, changes []TreeChange, opts *PatchOptions) error {
+	if opts == nil {
+		opts = new(PatchOptions)
+	}
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, c := range changes {
+		if c.Status == DiffTypeChanged {
+			del, add := c, c
+			del.Status, del.New = DiffDeleted, object.TreeInfo{}
+			add.Status, add.Old = DiffAdded, object.TreeInfo{}
+			if err := writeFilePatch(bw, r, format, del, opts); err != nil {
+				return err
+			}
+			err = writeFilePatch(bw, r, format, add, opts)
+		} else {
+			err = writeFilePatch(bw, r, format, c, opts)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeFilePatch writes the patch of a single change.
+func writeFilePatch(w *bufio.Writer, r Interface, format object.Format, c TreeChange, opts *PatchOptions) error {
+	context := opts.Context
+	switch {
+	case context == 0:
+		context = defaultContext
+	case context < 0:
+		context = 0
+	}
+	fd, err := diffFile(r, c.Old, c.New, opts, context)
+	if err != nil {
+		return err
+	}
+	oldName := object.QuotePath("a/" + c.OldPath)
+	newName := object.QuotePath("b/" + c.NewPath)
+	fmt.Fprintf(w, "diff --git %s %s\n", oldName, newName)
+	switch {
+	case c.Old.Mode == 0:
+		fmt.Fprintf(w, "new file mode %06o\n", c.New.Mode)
+		oldName = "/dev/null"
+	case c.New.Mode == 0:
+		fmt.Fprintf(w, "deleted file mode %06o\n", c.Old.Mode)
+		newName = "/dev/null"
+	case c.Old.Mode != c.New.Mode:
+		fmt.Fprintf(w, "old mode %06o\nnew mode %06o\n", c.Old.Mode, c.New.Mode)
+	}
+	switch c.Status {
+	case DiffRenamed:
+		fmt.Fprintf(w, "similarity index %d%%\nrename from %s\nrename to %s\n",
+			c.Score, object.QuotePath(c.OldPath), object.QuotePath(c.NewPath))
+	case DiffCopied:
+		fmt.Fprintf(w, "similarity index %d%%\ncopy from %s\ncopy to %s\n",
+			c.Score, object.QuotePath(c.OldPath), object.QuotePath(c.NewPath))
+	}
+	if c.Old.Object != c.New.Object {
+		n := 7
+		if opts.FullIndex {
+			n = 2 * format.Size()
+		}
+		oldID, err := abbreviateIndex(r, format, c.Old, n)
+		if err != nil {
+			return err
+		}
+		newID, err := abbreviateIndex(r, format, c.New, n)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "index %s..%s", oldID, newID)
+		if c.Old.Mode == c.New.Mode {
+			fmt.Fprintf(w, " %06o", c.Old.Mode)
+		}
+		w.WriteByte('\n')
+	}
+	switch {
+	case fd.binary:
+		if c.Old.Object != c.New.Object {
+			fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		}
+		return nil
+	case len(fd.edits) == 0:
+		return nil
+	}
+	// names containing spaces are terminated with a tab, so that
+	// patch(1) does not take anything after them for a timestamp
+	fmt.Fprintf(w, "--- %s%s\n+++ %s%s\n", oldName, nameTab(oldName), newName, nameTab(newName))
+	return diff.WriteUnified(w, fd.a, fd.b, diff.Hunks(fd.a, fd.b, fd.edits, context))
+}
+
+// abbreviateIndex abbreviates the object ID of the tree entry for the
+// index line of a patch, where a missing side is all zeros.
+func abbreviateIndex(r Interface, format object.Format, info object.TreeInfo, n int) (string, error) {
+	if info.Mode == 0 {
+		return format.ZeroID().String()[:n], nil
+	}
+	return Abbreviate(r, info.Object, n)
+}
+
+func nameTab(name string) string {
+	if strings.IndexByte(name, ' ') >= 0 {
+		return "\t"
+	}
+	return ""
+}
+
+// A FileStat summarizes the change to a single file.
+type FileStat struct {
+	OldPath string
+	NewPath string
+
+	// Added and Deleted are the numbers of lines added and deleted,
+	// or zero for binary files.
+	Added, Deleted int
+
+	// Binary is set if either side of the change is binary, in
+	// which case OldSize and NewSize are the sizes of the sides.
+	Binary           bool
+	OldSize, NewSize int64
+}
+
+// DiffStat returns the summaries of the changes as shown by the
+// reference Git client's diff --stat.
+func DiffStat(r Interface, changes []TreeChange, opts *PatchOptions) ([]FileStat, error) {
+	stats := make([]FileStat, len(changes))
+	for i, c := range changes {
+		fd, err := diffFile(r, c.Old, c.New, opts, 0)
+		if err != nil {
+			return nil, err
+		}
+		s := FileStat{OldPath: c.OldPath, NewPath: c.NewPath, Binary: fd.binary}
+		if fd.binary {
+			if c.Old.Object != c.New.Object {
+				s.OldSize, s.NewSize = int64(len(fd.old)), int64(len(fd.new))
+			}
+		} else {
+			s.Added, s.Deleted = diff.Stat(fd.edits)
+		}
+		stats[i] = s
+	}
+	return stats, nil
+}
+
+// WriteDiffStat writes the summaries to w in the format of the
+// reference Git client's diff --stat: a line per file with the number
+// of changed lines and a graph of + and - signs, fitted to the given
+// width in columns, followed by a line of totals.  If width is not
+// positive, it is 80 columns.
+func WriteDiffStat(w io.Writer, stats []FileStat, width int) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	if width <= 0 {
+		width = 80
+	}
+	bw := bufio.NewWriter(w)
+	names := make([]string, len(stats))
+	maxLen, maxChange, numberWidth, binWidth := 0, 0, 0, 0
+	for i, s := range stats {
+		if s.OldPath != s.NewPath {
+			names[i] = renameName(s.OldPath, s.NewPath)
+		} else {
+			names[i] = object.QuotePath(s.NewPath)
+		}
+		if len(names[i]) > maxLen {
+			maxLen = len(names[i])
+		}
+		if s.Binary {
+			// "Bin XXX -> YYY bytes"
+			if n := 14 + decimalWidth(s.OldSize) + decimalWidth(s.NewSize); n > binWidth {
+				binWidth = n
+			}
+			numberWidth = 3
+			continue
+		}
+		if n := s.Added + s.Deleted; n > maxChange {
+			maxChange = n
+		}
+	}
+	if n := decimalWidth(int64(maxChange)); n > numberWidth {
+		numberWidth = n
+	}
+	// leave room for at least a six-column graph and a ten-column
+	// name
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	var added, deleted int
+	for i, s := range stats {
+		// names too long are cut at a slash from the left
+		name, prefix := names[i], ""
+		n := nameWidth
+		if n < len(name) {
+			prefix = "..."
+			if n -= 3; n < 0 {
+				n = 0
+			}
+			name = name[len(name)-n:]
+			if j := strings.IndexByte(name, '/'); j >= 0 {
+				name = name[j:]
+			}
+		}
+		padding := n - len(name)
+		if padding < 0 {
+			padding = 0
+		}
+		if s.Binary {
+			fmt.Fprintf(bw, " %s%s%*s | %*s", prefix, name, padding, "", numberWidth, "Bin")
+			if s.OldSize != 0 || s.NewSize != 0 {
+				fmt.Fprintf(bw, " %d -> %d bytes", s.OldSize, s.NewSize)
+			}
+			bw.WriteByte('\n')
+			continue
+		}
+		added += s.Added
+		deleted += s.Deleted
+		plus, minus := s.Added, s.Deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(plus+minus, graphWidth, maxChange)
+			if total < 2 && plus != 0 && minus != 0 {
+				total = 2
+			}
+			if plus < minus {
+				plus = scaleLinear(plus, graphWidth, maxChange)
+				minus = total - plus
+			} else {
+				minus = scaleLinear(minus, graphWidth, maxChange)
+				plus = total - minus
+			}
+		}
+		fmt.Fprintf(bw, " %s%s%*s | %*d", prefix, name, padding, "", numberWidth, s.Added+s.Deleted)
+		if s.Added+s.Deleted != 0 {
+			bw.WriteByte(' ')
+		}
+		bw.WriteString(strings.Repeat("+", plus))
+		bw.WriteString(strings.Repeat("-", minus))
+		bw.WriteByte('\n')
+	}
+
+	if len(stats) == 1 {
+		bw.WriteString(" 1 file changed")
+	} else {
+		fmt.Fprintf(bw, " %d files changed", len(stats))
+	}
+	if added != 0 || deleted == 0 {
+		fmt.Fprintf(bw, ", %d insertion%s(+)", added, plural(added))
+	}
+	if deleted != 0 || added == 0 {
+		fmt.Fprintf(bw, ", %d deletion%s(-)", deleted, plural(deleted))
+	}
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
+
+// renameName returns the name of a renamed or copied file in a diffstat,
+// in which the common leading and trailing directories of the old and
+// new paths are written only once, as in "a/{b => c}/d".
+func renameName(a, b string) string {
+	qa, qb := object.QuotePath(a), object.QuotePath(b)
+	if qa != a || qb != b {
+		return qa + " => " + qb
+	}
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			prefix = i + 1
+		}
+	}
+	// the suffix may share the slash that ends the prefix
+	suffix := 0
+	limit := prefix
+	if prefix > 0 {
+		limit--
+	}
+	for i, j := len(a), len(b); i >= limit && j >= limit && byteAt(a, i) == byteAt(b, j); i, j = i-1, j-1 {
+		if byteAt(a, i) == '/' {
+			suffix = len(a) - i
+		}
+	}
+	aMid := len(a) - prefix - suffix
+	bMid := len(b) - prefix - suffix
+	if aMid < 0 {
+		aMid = 0
+	}
+	if bMid < 0 {
+		bMid = 0
+	}
+	if prefix+suffix == 0 {
+		return a + " => " + b
+	}
+	return a[:prefix] + "{" + a[prefix:prefix+aMid] + " => " + b[prefix:prefix+bMid] + "}" + a[len(a)-suffix:]
+}
+
+// byteAt returns the byte of s at i, or NUL past its end.
+func byteAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return 0
+}
+
+// scaleLinear scales the number of changed lines n to the width of the
+// graph, rounding up so that every change shows.
+func scaleLinear(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+func decimalWidth(n int64) int {
+	return len(fmt.Sprint(n))
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,117 @@
+package repository_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// patchTrees stores two tree hierarchies that differ in most of the
+// ways WritePatch distinguishes, and returns their IDs.
+func patchTrees(r *testRepo) (object.ID, object.ID) {
+	file := func(contents string) object.TreeInfo {
+		return object.TreeInfo{object.ModeBlob, r.blob(contents)}
+	}
+	sub := func(name string) object.TreeInfo {
+		return object.TreeInfo{object.ModeGitlink, r.commit(name, nil)}
+	}
+	a := r.entries(map[string]object.TreeInfo{
+		"binary":  file("a\x00b"),
+		"deleted": file("gone\n"),
+		"file":    file(seq(40, "20", "twenty")),
+		"link":    {object.ModeSymlink, r.blob("file")},
+		"a/rather/long/path/to/a/file/in/a/deep/directory": file(seq(10)),
+		"old name":   file(seq(20)),
+		"script":     file("#!/bin/sh\n"),
+		"submodule":  sub("sub1"),
+		"with space": file("1\n2\n3"),
+	})
+	b := r.entries(map[string]object.TreeInfo{
+		"big":    file(seq(100)),
+		"binary": file("a\x00c"),
+		"café":   file(""),
+		"file":   file(seq(40, "3", "three", "9", "nine", "30", "thirty")),
+		"link":   file("file\n"),
+		"a/rather/long/path/to/a/file/in/a/deep/directory": file(seq(10, "1", "one", "2", "two")),
+		"new/name":   file(seq(20, "10", "ten")),
+		"script":     {object.ModeExec, r.blob("#!/bin/sh\n")},
+		"submodule":  sub("sub2"),
+		"with space": file("1\n2\nthree\n"),
+		"zz added":   file("new\n"),
+	})
+	return a, b
+}
+
+// The golden files hold the output of git diff -M, with the options
+// corresponding to the given ones, between the trees of patchTrees.
+var patchTests = []struct {
+	opts   *repository.PatchOptions
+	golden string
+}{
+	{nil, "patch.diff"},
+	{&repository.PatchOptions{Context: 1, FullIndex: true}, "patch-u1-index.diff"},
+	{&repository.PatchOptions{Context: -1}, "patch-u0.diff"},
+}
+
+func readTestdata(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWritePatch(t *testing.T) {
+	r := newTestRepo(t)
+	from, to := patchTrees(r)
+	changes, err := repository.DiffTrees(r.repo, from, to, &repository.DiffOptions{DetectRenames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range patchTests {
+		var buf bytes.Buffer
+		if err := repository.WritePatch(&buf, r.repo, changes, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), readTestdata(t, tt.golden); got != want {
+			t.Errorf("WritePatch(%+v): got\n%s\nwant\n%s", tt.opts, got, want)
+		}
+	}
+}
+
+// The golden files hold the output of git diff -M --stat=width between
+// the trees of patchTrees.
+var diffStatTests = []struct {
+	width  int
+	golden string
+}{
+	{0, "stat.txt"},
+	{40, "stat-40.txt"},
+	{200, "stat-200.txt"},
+}
+
+func TestWriteDiffStat(t *testing.T) {
+	r := newTestRepo(t)
+	from, to := patchTrees(r)
+	changes, err := repository.DiffTrees(r.repo, from, to, &repository.DiffOptions{DetectRenames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := repository.DiffStat(r.repo, changes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range diffStatTests {
+		var buf bytes.Buffer
+		if err := repository.WriteDiffStat(&buf, stats, tt.width); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), readTestdata(t, tt.golden); got != want {
+			t.Errorf("WriteDiffStat(%d): got\n%s\nwant\n%s", tt.width, got, want)
+		}
+	}
+}
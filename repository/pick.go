@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// ErrEmptyCommit is returned by CherryPick and Revert if the commit
+// they would store would not change the tree of its parent.
+var ErrEmptyCommit = errors.New("repository: commit would be empty")
+
+// ErrNoMainline is returned by CherryPick and Revert if they are given
+// a merge commit but no mainline parent to compare it with.
+var ErrNoMainline = errors.New("repository: merge commit without a mainline parent")
+
+// A ConflictError reports the conflicts that stopped CherryPick, Revert
+// or Rebase from applying a commit.
+type ConflictError struct {
+	Commit object.ID // the commit that could not be applied
+	Onto   object.ID // the commit it was being applied onto
+
+	// Result is the outcome of merging the changes of the commit
+	// into the tree of Onto, with the conflicts recorded in its
+	// tree.
+	Result *MergeResult
+}
+
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("repository: could not apply %s", e.Commit)
+	if len(e.Result.Conflicts) > 0 {
+		msg += ": " + e.Result.Conflicts[0].String()
+	}
+	if n := len(e.Result.Conflicts); n > 1 {
+		msg += fmt.Sprintf(" and %d more", n-1)
+	}
+	return msg
+}
+
+// PickOptions control CherryPick, Revert and Rebase.  A nil
+// *PickOptions uses the defaults.
+type PickOptions struct {
+	// Mainline is the number, starting from 1, of the parent of a
+	// merge commit whose changes are picked or reverted.  It is
+	// ignored by Rebase, which skips merge commits.
+	Mainline int
+
+	// KeepEmpty keeps commits that do not change the tree of their
+	// parent.  Otherwise, CherryPick and Revert return
+	// ErrEmptyCommit for them, and Rebase drops the commits whose
+	// changes are already in the new base.
+	KeepEmpty bool
+
+	// Merge controls the merges of the changes.  Its empty labels
+	// are set as the reference Git client sets them: HEAD for the
+	// commit applied onto, and the abbreviated ID and subject of the
+	// commit, or its parent, for the others.
+	Merge *MergeOptions
+
+	// Sign, if not nil, is called with every new commit before it
+	// is stored, so that it can be signed, for example with
+	// signature.SignCommitFormat.
+	Sign func(c *object.Commit) error
+}
+
+// CherryPick applies the changes the commit with the given ID made to
+// the tree of its parent to the tree of the commit onto, and stores a
+// commit of the result with onto as its parent, the author and message
+// of the original commit, and the given committer.  Both IDs may point
+// to tags of the commits, as with GetCommit.  A zero onto is the empty
+// tree, and the new commit then has no parents.  Merge commits are
+// compared with the parent numbered by the Mainline option.
+//
+// The new commit does not keep the extra headers of the original, such
+// as its signature.  No refs are updated.  If the changes conflict with
+// the tree of onto, CherryPick stores no commit and returns a
+// *ConflictError.
+func CherryPick(r Interface, id, onto object.ID, committer object.Signature, opts *PickOptions) (object.ID, error) {
+	p, err := newPicker(r, opts)
+	if err != nil {
+		return p.zero, err
+	}
+	c, id, err := GetCommit(r, id)
+	if err != nil {
+		return id, err
+	}
+	if !onto.IsZero() {
+		if _, onto, err = GetCommit(r, onto); err != nil {
+			return onto, err
+		}
+	}
+	parent, err := p.mainline(id, c)
+	if err != nil {
+		return p.zero, err
+	}
+	desc, err := p.describe(id, c)
+	if err != nil {
+		return p.zero, err
+	}
+	commit := &object.Commit{
+		Author:    c.Author,
+		Committer: committer,
+		Message:   c.Message,
+	}
+	return p.apply(id, onto, parent, c.Tree, "parent of "+desc, desc, commit, p.opts.KeepEmpty)
+}
+
+// Revert applies the reverse of the changes the commit with the given
+// ID made to the tree of its parent to the tree of the commit onto, as
+// CherryPick applies changes, and stores a commit of the result
+// authored by the committer, with a message like the one the reference
+// Git client's revert command writes.
+func Revert(r Interface, id, onto object.ID, committer object.Signature, opts *PickOptions) (object.ID, error) {
+	p, err := newPicker(r, opts)
+	if err != nil {
+		return p.zero, err
+	}
+	c, id, err := GetCommit(r, id)
+	if err != nil {
+		return id, err
+	}
+	if !onto.IsZero() {
+		if _, onto, err = GetCommit(r, onto); err != nil {
+			return onto, err
+		}
+	}
+	parent, err := p.mainline(id, c)
+	if err != nil {
+		return p.zero, err
+	}
+	desc, err := p.describe(id, c)
+	if err != nil {
+		return p.zero, err
+	}
+	msg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s", subject(c.Message), id)
+	if len(c.Parent) > 1 {
+		msg += fmt.Sprintf(", reversing\nchanges made to %s", parent)
+	}
+	commit := &object.Commit{
+		Author:    committer,
+		Committer: committer,
+		Message:   msg + ".\n",
+	}
+	base, err := p.treeOf(id)
+	if err != nil {
+		return p.zero, err
+	}
+	return p.apply(id, onto, base, parent, desc, "parent of "+desc, commit, p.opts.KeepEmpty)
+}
+
+// BUG(lor): Rebase does not skip the commits whose changes are already
+// in upstream by comparing their patches, as the reference Git client
+// does, but drops them if applying them changes nothing, unless the
+// KeepEmpty option is set.
+
+// Rebase applies the changes of the commits reachable from head but not
+// from upstream to the commit onto, oldest first, as CherryPick does,
+// and returns the ID of the last commit, like the reference Git
+// client's non-interactive rebase --onto command.  If onto is zero, it
+// is upstream.  Merge commits are skipped, and commits already based
+// on the commit they would be applied to are kept as they are, along
+// with their signatures.
+//
+// If applying a commit results in conflicts, Rebase stops and returns
+// a *ConflictError, and the ID of the last commit applied.  The rebase
+// can be continued, once the conflicts are resolved in a commit, by
+// rebasing the commits after the one that failed onto that commit.
+func Rebase(r Interface, upstream, head, onto object.ID, committer object.Signature, opts *PickOptions) (object.ID, error) {
+	p, err := newPicker(r, opts)
+	if err != nil {
+		return p.zero, err
+	}
+	if onto.IsZero() {
+		onto = upstream
+	}
+	_, cur, err := GetCommit(r, onto)
+	if err != nil {
+		return cur, err
+	}
+	var ids []object.ID
+	var commits []*object.Commit
+	err = RevList(r, []object.ID{head}, []object.ID{upstream}, &RevListOptions{Order: TopoOrder}, func(id object.ID, c *object.Commit, _ bool) error {
+		if len(c.Parent) <= 1 {
+			ids = append(ids, id)
+			commits = append(commits, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return cur, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		id, c := ids[i], commits[i]
+		if len(c.Parent) == 1 && c.Parent[0] == cur {
+			cur = id
+			continue
+		}
+		parent := p.zero
+		if len(c.Parent) == 1 {
+			parent = c.Parent[0]
+		}
+		desc, err := p.describe(id, c)
+		if err != nil {
+			return cur, err
+		}
+		commit := &object.Commit{
+			Author:    c.Author,
+			Committer: committer,
+			Message:   c.Message,
+		}
+		// commits that were empty to begin with are kept
+		base, err := p.treeOf(parent)
+		if err != nil {
+			return cur, err
+		}
+		next, err := p.apply(id, cur, base, c.Tree, "parent of "+desc, desc, commit, p.opts.KeepEmpty || base == c.Tree)
+		switch err {
+		case nil:
+			cur = next
+		case ErrEmptyCommit:
+		default:
+			return cur, err
+		}
+	}
+	return cur, nil
+}
+
+// A picker holds the options of CherryPick, Revert or Rebase.
+type picker struct {
+	repo Interface
+	opts *PickOptions
+	zero object.ID
+}
+
+func newPicker(r Interface, opts *PickOptions) (*picker, error) {
+	if opts == nil {
+		opts = new(PickOptions)
+	}
+	format, err := ObjectFormat(r)
+	p := &picker{
+		repo: r,
+		opts: opts,
+		zero: format.ZeroID(),
+	}
+	return p, err
+}
+
+// mainline returns the ID of the parent of the commit with whose tree
+// its changes are compared, which is zero for a root commit.
+func (p *picker) mainline(id object.ID, c *object.Commit) (object.ID, error) {
+	n := p.opts.Mainline
+	switch {
+	case len(c.Parent) == 0 && n == 0:
+		return p.zero, nil
+	case len(c.Parent) == 1 && n == 0:
+		return c.Parent[0], nil
+	case len(c.Parent) <= 1:
+		return p.zero, fmt.Errorf("repository: mainline given but %s is not a merge", id)
+	case n == 0:
+		return p.zero, ErrNoMainline
+	case n < 0 || n > len(c.Parent):
+		return p.zero, fmt.Errorf("repository: %s has no parent %d", id, n)
+	}
+	return c.Parent[n-1], nil
+}
+
+// describe returns the label by which the reference Git client refers
+// to the commit in conflict markers: its abbreviated ID and subject.
+func (p *picker) describe(id object.ID, c *object.Commit) (string, error) {
+	abbrev, err := Abbreviate(p.repo, id, 7)
+	if err != nil {
+		return "", err
+	}
+	return abbrev + " (" + subject(c.Message) + ")", nil
+}
+
+// subject returns the first non-blank line of the message.
+func subject(msg string) string {
+	msg = strings.TrimLeft(msg, "\n")
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = msg[:i]
+	}
+	return msg
+}
+
+// treeOf returns the ID of the tree of the commit with the given ID, or
+// of the empty tree, which it stores, if the ID is zero.
+func (p *picker) treeOf(id object.ID) (object.ID, error) {
+	if id.IsZero() {
+		return p.repo.PutObject(&object.Tree{})
+	}
+	c, _, err := GetCommit(p.repo, id)
+	if err != nil {
+		return id, err
+	}
+	return c.Tree, nil
+}
+
+// apply merges the changes from the tree base to the tree theirs, whose
+// sides are labelled as given, into the tree of the commit onto, and
+// stores the commit with the merged tree and onto as its parent.  id is
+// the ID of the commit being applied.  Unless keepEmpty is set, it
+// returns ErrEmptyCommit if the merged tree is that of onto.
+func (p *picker) apply(id, onto, base, theirs object.ID, baseLabel, theirsLabel string, commit *object.Commit, keepEmpty bool) (object.ID, error) {
+	var opts MergeOptions
+	if p.opts.Merge != nil {
+		opts = *p.opts.Merge
+	}
+	opts.OursLabel = label(opts.OursLabel, "HEAD")
+	opts.BaseLabel = label(opts.BaseLabel, baseLabel)
+	opts.TheirsLabel = label(opts.TheirsLabel, theirsLabel)
+	ours, err := p.treeOf(onto)
+	if err != nil {
+		return p.zero, err
+	}
+	if !onto.IsZero() {
+		commit.Parent = []object.ID{onto}
+	}
+	res, err := MergeTrees(p.repo, base, ours, theirs, &opts)
+	if err != nil {
+		return p.zero, err
+	}
+	if len(res.Conflicts) > 0 {
+		return p.zero, &ConflictError{Commit: id, Onto: onto, Result: res}
+	}
+	if res.Tree == ours && !keepEmpty {
+		return p.zero, ErrEmptyCommit
+	}
+	commit.Tree = res.Tree
+	if p.opts.Sign != nil {
+		if err := p.opts.Sign(commit); err != nil {
+			return p.zero, err
+		}
+	}
+	return p.repo.PutObject(commit)
+}
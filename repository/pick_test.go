@@ -0,0 +1,294 @@
+package repository_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// numbered returns the lines numbered 1 to 10, with those given as
+// number=text pairs replaced by the text.
+func numbered(repl ...string) string {
+	lines := make([]string, 10)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i + 1)
+	}
+	for _, r := range repl {
+		i := strings.IndexByte(r, '=')
+		var n int
+		fmt.Sscan(r[:i], &n)
+		lines[n-1] = r[i+1:]
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// pickHistory returns a repository in which the commits F1 to F4 of
+// branch feature and M1 and M2 of branch main fork from base, and X
+// merges F2 into M1.  F3 makes the same change as M1, and F4 a change
+// conflicting with M2.
+func pickHistory(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("base", map[string]string{"a": numbered(), "b": "b\n"})
+	r.commit("F1", map[string]string{"a": numbered("3=three"), "b": "b\n"}, "base")
+	r.commit("F2", map[string]string{"a": numbered("3=three"), "b": "b\n", "c": "c\n"}, "F1")
+	r.commit("F3", map[string]string{"a": numbered("3=three", "8=MAIN"), "b": "b\n", "c": "c\n"}, "F2")
+	r.commit("F4", map[string]string{"a": numbered("3=three", "5=feature", "8=MAIN"), "b": "b\n", "c": "c\n"}, "F3")
+	r.commit("M1", map[string]string{"a": numbered("8=MAIN"), "b": "b\n"}, "base")
+	r.commit("M2", map[string]string{"a": numbered("5=main", "8=MAIN"), "b": "b\n", "d": "d\n"}, "M1")
+	r.commit("X", map[string]string{"a": numbered("3=three", "8=MAIN"), "b": "b\n", "c": "c\n"}, "M1", "F2")
+	return r
+}
+
+var pickCommitter = object.Signature{
+	Name:  "C O Mitter",
+	Email: "committer@example.com",
+	Date:  testSignature(1112912993).Date,
+}
+
+// checkCommit checks that the commit with the given ID has the given
+// files, parents, author and message, and the test committer.
+func checkCommit(t *testing.T, r *testRepo, desc string, id object.ID, files map[string]string, parents []object.ID, author object.Signature, msg string) {
+	c, _, err := repository.GetCommit(r.repo, id)
+	if err != nil {
+		t.Errorf("%s: %v", desc, err)
+		return
+	}
+	if tree := r.tree(files); c.Tree != tree {
+		t.Errorf("%s: got tree %s, want %s", desc, c.Tree, tree)
+	}
+	if got, want := r.nameList(c.Parent), r.nameList(parents); got != want {
+		t.Errorf("%s: got parents [%s], want [%s]", desc, got, want)
+	}
+	if c.Author.String() != author.String() || c.Committer.String() != pickCommitter.String() {
+		t.Errorf("%s: got author %v and committer %v, want %v and %v", desc, c.Author, c.Committer, author, pickCommitter)
+	}
+	if c.Message != msg {
+		t.Errorf("%s: got message %q, want %q", desc, c.Message, msg)
+	}
+	if c.Extra != nil {
+		t.Errorf("%s: got extra headers %v", desc, c.Extra)
+	}
+}
+
+// author returns the author of the named commit.
+func (r *testRepo) author(name string) object.Signature {
+	c, _, err := repository.GetCommit(r.repo, r.id(name))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return c.Author
+}
+
+// tag stores a tag of the object with the given ID and returns its ID.
+func (r *testRepo) tag(id object.ID, objType object.Type) object.ID {
+	return r.put(&object.Tag{
+		Object:  id,
+		Type:    objType,
+		Tag:     "v1",
+		Tagger:  testSignature(r.time),
+		Message: "tag\n",
+	})
+}
+
+func TestCherryPick(t *testing.T) {
+	r := pickHistory(t)
+	id, err := repository.CherryPick(r.repo, r.id("F1"), r.id("M2"), pickCommitter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommit(t, r, "F1 onto M2", id,
+		map[string]string{"a": numbered("3=three", "5=main", "8=MAIN"), "b": "b\n", "d": "d\n"},
+		r.idList("M2"), r.author("F1"), "F1\n")
+
+	onto := r.tag(r.tag(r.id("M2"), object.TypeCommit), object.TypeTag)
+	tagged, err := repository.CherryPick(r.repo, r.tag(r.id("F1"), object.TypeCommit), onto, pickCommitter, nil)
+	if err != nil || tagged != id {
+		t.Errorf("tag of F1 onto tag of M2: got %s, %v, want %s", tagged, err, id)
+	}
+	tree := r.tag(r.tree(map[string]string{"a": "a\n"}), object.TypeTree)
+	if _, err := repository.CherryPick(r.repo, r.id("F1"), tree, pickCommitter, nil); err == nil {
+		t.Error("F1 onto tag of tree: no error")
+	}
+
+	id, err = repository.CherryPick(r.repo, r.id("F2"), object.ZeroID, pickCommitter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommit(t, r, "F2 onto nothing", id,
+		map[string]string{"c": "c\n"}, nil, r.author("F2"), "F2\n")
+}
+
+func TestCherryPickEmpty(t *testing.T) {
+	r := pickHistory(t)
+	if _, err := repository.CherryPick(r.repo, r.id("F3"), r.id("M1"), pickCommitter, nil); err != repository.ErrEmptyCommit {
+		t.Errorf("F3 onto M1: got %v, want ErrEmptyCommit", err)
+	}
+	id, err := repository.CherryPick(r.repo, r.id("F3"), r.id("M1"), pickCommitter, &repository.PickOptions{KeepEmpty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommit(t, r, "F3 onto M1 kept empty", id,
+		map[string]string{"a": numbered("8=MAIN"), "b": "b\n"},
+		r.idList("M1"), r.author("F3"), "F3\n")
+}
+
+func TestCherryPickConflict(t *testing.T) {
+	r := pickHistory(t)
+	_, err := repository.CherryPick(r.repo, r.id("F4"), r.id("M2"), pickCommitter, nil)
+	e, ok := err.(*repository.ConflictError)
+	if !ok {
+		t.Fatalf("F4 onto M2: got %v, want a *ConflictError", err)
+	}
+	if e.Commit != r.id("F4") || e.Onto != r.id("M2") {
+		t.Errorf("got conflict applying %s onto %s, want F4 onto M2", r.nameList([]object.ID{e.Commit}), r.nameList([]object.ID{e.Onto}))
+	}
+	if len(e.Result.Conflicts) != 1 || e.Result.Conflicts[0].Kind != repository.ConflictContent || e.Result.Conflicts[0].Path != "a" {
+		t.Errorf("got conflicts %+v, want one in a", e.Result.Conflicts)
+	}
+	abbrev, err := repository.Abbreviate(r.repo, r.id("F4"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<<<<<<< HEAD\nmain\n=======\nfeature\n>>>>>>> " + abbrev + " (F4)\n"
+	obj, err := r.repo.GetObject(e.Result.Conflicts[0].Result.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := object.Parse(obj); err != nil || !strings.Contains(string(*b.(*object.Blob)), want) {
+		t.Errorf("conflicting file does not contain %q", want)
+	}
+}
+
+func TestCherryPickMainline(t *testing.T) {
+	r := pickHistory(t)
+	if _, err := repository.CherryPick(r.repo, r.id("X"), r.id("base"), pickCommitter, nil); err != repository.ErrNoMainline {
+		t.Errorf("X without mainline: got %v, want ErrNoMainline", err)
+	}
+	for _, n := range []int{-1, 3} {
+		if _, err := repository.CherryPick(r.repo, r.id("X"), r.id("base"), pickCommitter, &repository.PickOptions{Mainline: n}); err == nil {
+			t.Errorf("X with mainline %d: no error", n)
+		}
+	}
+	if _, err := repository.CherryPick(r.repo, r.id("F1"), r.id("base"), pickCommitter, &repository.PickOptions{Mainline: 1}); err == nil {
+		t.Error("F1 with mainline 1: no error")
+	}
+
+	tests := []struct {
+		mainline int
+		files    map[string]string
+	}{
+		{1, map[string]string{"a": numbered("3=three"), "b": "b\n", "c": "c\n"}},
+		{2, map[string]string{"a": numbered("8=MAIN"), "b": "b\n"}},
+	}
+	for _, tt := range tests {
+		id, err := repository.CherryPick(r.repo, r.id("X"), r.id("base"), pickCommitter, &repository.PickOptions{Mainline: tt.mainline})
+		if err != nil {
+			t.Errorf("X with mainline %d: %v", tt.mainline, err)
+			continue
+		}
+		desc := fmt.Sprintf("X with mainline %d", tt.mainline)
+		checkCommit(t, r, desc, id, tt.files, r.idList("base"), r.author("X"), "X\n")
+	}
+}
+
+func TestRevert(t *testing.T) {
+	r := pickHistory(t)
+	id, err := repository.Revert(r.repo, r.id("M2"), r.id("M2"), pickCommitter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommit(t, r, "revert M2", id,
+		map[string]string{"a": numbered("8=MAIN"), "b": "b\n"},
+		r.idList("M2"), pickCommitter,
+		"Revert \"M2\"\n\nThis reverts commit "+r.id("M2").String()+".\n")
+
+	onto := r.tag(r.id("X"), object.TypeCommit)
+	id, err = repository.Revert(r.repo, r.id("X"), onto, pickCommitter, &repository.PickOptions{Mainline: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCommit(t, r, "revert X with mainline 1", id,
+		map[string]string{"a": numbered("8=MAIN"), "b": "b\n"},
+		r.idList("X"), pickCommitter,
+		"Revert \"X\"\n\nThis reverts commit "+r.id("X").String()+", reversing\nchanges made to "+r.id("M1").String()+".\n")
+
+	if _, err := repository.Revert(r.repo, r.id("F3"), r.id("base"), pickCommitter, nil); err != repository.ErrEmptyCommit {
+		t.Errorf("revert F3 onto base: got %v, want ErrEmptyCommit", err)
+	}
+}
+
+// firstParents returns the IDs of the commit with the given ID and its
+// chain of first parents, down to the named commit, which is not
+// included.
+func (r *testRepo) firstParents(id object.ID, stop string) []object.ID {
+	var chain []object.ID
+	for id != r.id(stop) {
+		c, _, err := repository.GetCommit(r.repo, id)
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		if len(c.Parent) == 0 {
+			r.t.Fatalf("no commit %s among the parents", stop)
+		}
+		chain = append(chain, id)
+		id = c.Parent[0]
+	}
+	return chain
+}
+
+func TestRebase(t *testing.T) {
+	r := pickHistory(t)
+	id, err := repository.Rebase(r.repo, r.id("base"), r.id("F3"), r.id("M1"), pickCommitter, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := r.firstParents(id, "M1")
+	if len(chain) != 2 {
+		t.Fatalf("rebase F3 onto M1: got %d commits, want F1 and F2", len(chain))
+	}
+	checkCommit(t, r, "F1 rebased onto M1", chain[1],
+		map[string]string{"a": numbered("3=three", "8=MAIN"), "b": "b\n"},
+		r.idList("M1"), r.author("F1"), "F1\n")
+	checkCommit(t, r, "F2 rebased onto M1", chain[0],
+		map[string]string{"a": numbered("3=three", "8=MAIN"), "b": "b\n", "c": "c\n"},
+		chain[1:], r.author("F2"), "F2\n")
+
+	id, err = repository.Rebase(r.repo, r.id("base"), r.id("F3"), r.id("M1"), pickCommitter, &repository.PickOptions{KeepEmpty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept := r.firstParents(id, "M1"); len(kept) != 3 || kept[1] != chain[0] {
+		t.Errorf("rebase F3 onto M1 keeping empty commits: got %d commits, want F1, F2 and F3", len(kept))
+	} else {
+		checkCommit(t, r, "F3 rebased onto M1", kept[0],
+			map[string]string{"a": numbered("3=three", "8=MAIN"), "b": "b\n", "c": "c\n"},
+			kept[1:2], r.author("F3"), "F3\n")
+	}
+
+	if id, err := repository.Rebase(r.repo, r.id("base"), r.id("F2"), object.ZeroID, pickCommitter, nil); err != nil || id != r.id("F2") {
+		t.Errorf("rebase F2 onto base: got %s, %v, want F2 as it is", r.nameList([]object.ID{id}), err)
+	}
+}
+
+func TestRebaseConflict(t *testing.T) {
+	r := pickHistory(t)
+	onto := r.tag(r.id("M2"), object.TypeCommit)
+	id, err := repository.Rebase(r.repo, r.id("base"), r.id("F4"), onto, pickCommitter, nil)
+	e, ok := err.(*repository.ConflictError)
+	if !ok {
+		t.Fatalf("rebase F4 onto M2: got %v, want a *ConflictError", err)
+	}
+	if e.Commit != r.id("F4") || e.Onto != id {
+		t.Errorf("got conflict applying %s onto %s, want F4 onto the last commit applied", r.nameList([]object.ID{e.Commit}), e.Onto)
+	}
+	chain := r.firstParents(id, "M2")
+	if len(chain) != 2 {
+		t.Fatalf("rebase F4 onto M2: stopped after %d commits, want F1 and F2", len(chain))
+	}
+	checkCommit(t, r, "F2 rebased onto M2", chain[0],
+		map[string]string{"a": numbered("3=three", "5=main", "8=MAIN"), "b": "b\n", "c": "c\n", "d": "d\n"},
+		chain[1:], r.author("F2"), "F2\n")
+}
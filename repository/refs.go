@@ -8,10 +8,12 @@ import (
 )
 
 var findRefList = []string{
+	"%s",
 	"refs/%s",
 	"refs/tags/%s",
 	"refs/heads/%s",
 	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
 }
 
 // IsValidRef returns true if the argument refname is valid according
@@ -43,10 +45,24 @@ func IsValidRef(name string) bool {
 // FindRef disambiguates an abbreviated refname according to the
 // gitrevisions(7) rules.
 func FindRef(r Interface, name string) (object.ID, error) {
+	_, id, err := dwimRef(r, name)
+	return id, err
+}
+
+// dwimRef is like FindRef, but also returns the full name of the ref.
+// Errors other than ErrRefNotExist from GetRef are returned as is.
+func dwimRef(r Interface, name string) (string, object.ID, error) {
 	for _, format := range findRefList {
-		if id, err := r.GetRef(fmt.Sprintf(format, name)); err == nil {
-			return id, err
+		full := fmt.Sprintf(format, name)
+		id, err := r.GetRef(full)
+		switch err {
+		case nil:
+			return full, id, nil
+		case ErrRefNotExist, ErrInvalidRef:
+		default:
+			return full, id, err
 		}
 	}
-	return object.ZeroID, ErrRefNotExist
+	f, _ := ObjectFormat(r)
+	return "", f.ZeroID(), ErrRefNotExist
 }
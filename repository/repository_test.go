@@ -0,0 +1,148 @@
+package repository_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+	"github.com/lxr/go.git-scm/repository/mem"
+)
+
+// A testRepo builds commit histories in a memory repository.  Commits
+// are given names, by which tests refer to them and report results.
+type testRepo struct {
+	t     *testing.T
+	repo  repository.Interface
+	ids   map[string]object.ID
+	names map[object.ID]string
+	time  int64 // commit time of the last commit made
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	return &testRepo{
+		t:     t,
+		repo:  mem.NewRepository(),
+		ids:   make(map[string]object.ID),
+		names: make(map[object.ID]string),
+		time:  1112911993,
+	}
+}
+
+// testSignature returns the author and committer of test commits made
+// at the given Unix time.
+func testSignature(sec int64) object.Signature {
+	return object.Signature{
+		Name:  "A U Thor",
+		Email: "author@example.com",
+		Date:  time.Unix(sec, 0).In(time.FixedZone("", -7*3600)),
+	}
+}
+
+// put stores obj and returns its ID.
+func (r *testRepo) put(obj object.Interface) object.ID {
+	id, err := r.repo.PutObject(obj)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return id
+}
+
+// blob stores a blob of the given contents and returns its ID.
+func (r *testRepo) blob(contents string) object.ID {
+	b := object.Blob(contents)
+	return r.put(&b)
+}
+
+// entries stores a tree hierarchy with the given entries, keyed by
+// slash-separated path, and returns the ID of its root.
+func (r *testRepo) entries(entries map[string]object.TreeInfo) object.ID {
+	e, err := repository.NewTreeEditor(r.repo, object.ZeroID)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	for name, ti := range entries {
+		if err := e.Set(name, ti.Mode, ti.Object); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	id, err := e.Write()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return id
+}
+
+// tree stores a tree hierarchy of regular files with the given
+// contents, keyed by slash-separated path, and returns the ID of its
+// root.
+func (r *testRepo) tree(files map[string]string) object.ID {
+	entries := make(map[string]object.TreeInfo, len(files))
+	for name, contents := range files {
+		entries[name] = object.TreeInfo{object.ModeBlob, r.blob(contents)}
+	}
+	return r.entries(entries)
+}
+
+// commit stores a commit of the given files with the named parents,
+// made a minute after the last one, and names it.  If files is nil,
+// the commit's tree holds a single file named after the commit.
+func (r *testRepo) commit(name string, files map[string]string, parents ...string) object.ID {
+	return r.commitAt(name, r.time+60, files, parents...)
+}
+
+// commitAt is like commit, but the commit is made at the given Unix
+// time.
+func (r *testRepo) commitAt(name string, sec int64, files map[string]string, parents ...string) object.ID {
+	if files == nil {
+		files = map[string]string{name: name + "\n"}
+	}
+	c := &object.Commit{
+		Tree:      r.tree(files),
+		Author:    testSignature(sec),
+		Committer: testSignature(sec),
+		Message:   name + "\n",
+	}
+	for _, p := range parents {
+		c.Parent = append(c.Parent, r.id(p))
+	}
+	id := r.put(c)
+	r.ids[name] = id
+	r.names[id] = name
+	r.time = sec
+	return id
+}
+
+// id returns the ID of the named commit.
+func (r *testRepo) id(name string) object.ID {
+	id, ok := r.ids[name]
+	if !ok {
+		r.t.Fatalf("no commit named %s", name)
+	}
+	return id
+}
+
+// idList returns the IDs of the commits named in the space-separated
+// list.
+func (r *testRepo) idList(list string) []object.ID {
+	var ids []object.ID
+	for _, name := range strings.Fields(list) {
+		ids = append(ids, r.id(name))
+	}
+	return ids
+}
+
+// nameList returns the names of the commits with the given IDs as a
+// space-separated list.  IDs of unnamed objects are listed as is.
+func (r *testRepo) nameList(ids []object.ID) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		if name, ok := r.names[id]; ok {
+			names[i] = name
+		} else {
+			names[i] = id.String()
+		}
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,592 @@
+package repository
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/lxr/go.git-scm/commitgraph"
+	"github.com/lxr/go.git-scm/object"
+)
+
+// A RevOrder is an order in which RevList lists commits.
+type RevOrder int
+
+const (
+	// DefaultOrder lists commits in reverse chronological order by
+	// committer date, like the reference Git client's log command.
+	// Parents with newer dates than their children are listed
+	// before them.
+	DefaultOrder RevOrder = iota
+
+	// DateOrder lists commits in reverse chronological order by
+	// committer date, but no parent before all of its children.
+	DateOrder
+
+	// AuthorDateOrder is like DateOrder, but orders by author
+	// date.
+	AuthorDateOrder
+
+	// TopoOrder lists no parent before all of its children, and
+	// avoids interleaving commits from different lines of history.
+	TopoOrder
+)
+
+// slop is the number of commits RevList walks past the point where all
+// the commits left to walk are excluded, in case commits with skewed
+// clocks are yet to be excluded.
+const slop = 5
+
+// RevListOptions holds the options of RevList.  The zero value lists
+// all the commits in DefaultOrder.
+type RevListOptions struct {
+	Order RevOrder
+
+	// FirstParent makes RevList follow only the first parent of
+	// the merge commits it lists.  Commits are still excluded along
+	// all the parents of excluded commits.
+	FirstParent bool
+
+	// MaxCount, if positive, is the maximum number of commits
+	// listed, not counting boundary commits.
+	MaxCount int
+
+	// Since and Until, if nonzero, limit the listed commits to
+	// those with committer dates between them, inclusive.  Commits
+	// older than Since end the walk: their ancestors are not
+	// listed.
+	Since, Until time.Time
+
+	// Boundary makes RevList list the parents of the listed
+	// commits that are not listed themselves, such as excluded
+	// commits, after them.
+	Boundary bool
+}
+
+// RevListFunc is the callback function type for RevList.  It takes as
+// its arguments the ID and contents of a listed commit and whether the
+// commit is a boundary commit.
+type RevListFunc func(id object.ID, c *object.Commit, boundary bool) error
+
+// RevList lists the commits reachable from the include IDs but not from
+// the exclude IDs, like the reference Git client's rev-list command,
+// calling fn once for each commit in the order given by the options.
+// Tags among the IDs are dereferenced to commits; other objects are an
+// error.  RevList ends at and returns the first non-nil error returned
+// by fn.  A nil options value is the same as the zero value.
+//
+// RevList only retrieves commits: it walks them with a priority queue
+// ordered by date, and in DefaultOrder without exclude IDs it calls fn
+// as it goes instead of walking the whole history first.
+func RevList(r Interface, include, exclude []object.ID, opts *RevListOptions, fn RevListFunc) error {
+	w := newRevWalker(r)
+	if opts != nil {
+		w.opts = *opts
+	}
+	for _, id := range exclude {
+		n, err := w.node(id)
+		if err != nil {
+			return err
+		}
+		w.markExcluded(n)
+		w.push(n)
+	}
+	for _, id := range include {
+		n, err := w.node(id)
+		if err != nil {
+			return err
+		}
+		w.push(n)
+	}
+	if len(exclude) == 0 && w.opts.Order == DefaultOrder && !w.opts.Boundary {
+		return w.stream(fn)
+	}
+	list, err := w.limit()
+	if err != nil {
+		return err
+	}
+	if w.opts.Order == AuthorDateOrder {
+		if err := w.load(list); err != nil {
+			return err
+		}
+	}
+	if w.opts.Order != DefaultOrder {
+		list = w.sortTopo(list, w.opts.Order)
+	}
+	if w.opts.MaxCount > 0 && len(list) > w.opts.MaxCount {
+		list = list[:w.opts.MaxCount]
+	}
+	if err := w.list(list, false, fn); err != nil {
+		return err
+	}
+	if !w.opts.Boundary {
+		return nil
+	}
+	boundary, err := w.boundary(list)
+	if err != nil {
+		return err
+	}
+	return w.list(boundary, true, fn)
+}
+
+// list calls fn for the commits of the nodes.
+func (w *revWalker) list(nodes []*revNode, boundary bool, fn RevListFunc) error {
+	for _, n := range nodes {
+		c, err := w.commit(n)
+		if err != nil {
+			return err
+		}
+		if err := fn(n.id, c, boundary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A revWalker holds the state of a commit walk.
+type revWalker struct {
+	repo  Interface
+	graph *commitgraph.Graph // the commit-graph of the repository, if any
+	opts  RevListOptions
+	nodes map[object.ID]*revNode
+	queue commitQueue
+	seq   int // number of commits pushed to the queue so far
+
+	// excluded holds the IDs of the excluded commits that have no
+	// nodes yet
+	excluded map[object.ID]bool
+
+	// byGeneration orders the queue by generation before date
+	byGeneration bool
+}
+
+// newRevWalker returns a revWalker of r with the default options.
+func newRevWalker(r Interface) *revWalker {
+	w := &revWalker{
+		repo:     r,
+		nodes:    make(map[object.ID]*revNode),
+		excluded: make(map[object.ID]bool),
+	}
+	// NOTE(lor): A commit-graph that cannot be retrieved is merely
+	// an optimization lost, so the error is ignored.
+	if cg, ok := r.(CommitGrapher); ok {
+		w.graph, _ = cg.CommitGraph()
+	}
+	return w
+}
+
+// genInfinity is the generation of the commits not in the commit-graph,
+// which are newer than all those in it.
+const genInfinity = 1<<63 - 1
+
+// A revNode is a commit encountered by a revWalker.  The parents, date
+// and generation of the commits in the commit-graph are taken from it,
+// and the commits themselves retrieved only when needed.
+type revNode struct {
+	id       object.ID
+	commit   *object.Commit // nil if not yet retrieved
+	parents  []object.ID
+	date     time.Time // committer date
+	gen      int64     // generation, or genInfinity
+	excluded bool      // whether the commit is reachable from an exclude ID
+	queued   bool      // whether the commit has been pushed to the queue
+	paint    int       // flags set by paint
+}
+
+// node returns the node of the commit the ID dereferences to.
+func (w *revWalker) node(id object.ID) (*revNode, error) {
+	if n, ok := w.nodes[id]; ok {
+		return n, nil
+	}
+	if w.graph != nil {
+		if c, ok := w.graph.Find(id); ok {
+			n := &revNode{
+				id:      id,
+				parents: c.Parents,
+				date:    time.Unix(c.Time, 0),
+				gen:     c.Generation,
+			}
+			w.add(n)
+			return n, nil
+		}
+	}
+	c, cid, err := GetCommit(w.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := w.nodes[cid]
+	if !ok {
+		n = &revNode{
+			id:      cid,
+			commit:  c,
+			parents: c.Parent,
+			date:    c.Committer.Date,
+			gen:     genInfinity,
+		}
+		if w.graph != nil {
+			if c, ok := w.graph.Find(cid); ok {
+				n.gen = c.Generation
+			}
+		}
+		w.add(n)
+	}
+	w.nodes[id] = n
+	return n, nil
+}
+
+// add adds the new node n, and marks it as excluded if it was recorded
+// as such.
+func (w *revWalker) add(n *revNode) {
+	w.nodes[n.id] = n
+	if w.excluded[n.id] {
+		delete(w.excluded, n.id)
+		w.markExcluded(n)
+	}
+}
+
+// commit returns the commit of n, retrieving it if necessary.
+func (w *revWalker) commit(n *revNode) (*object.Commit, error) {
+	if n.commit == nil {
+		c, _, err := GetCommit(w.repo, n.id)
+		if err != nil {
+			return nil, err
+		}
+		n.commit = c
+	}
+	return n.commit, nil
+}
+
+// load retrieves the commits of the nodes.
+func (w *revWalker) load(nodes []*revNode) error {
+	for _, n := range nodes {
+		if _, err := w.commit(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parents returns the nodes of the parents of n that have been
+// retrieved.
+func (w *revWalker) parents(n *revNode) []*revNode {
+	var nodes []*revNode
+	for _, id := range n.parents {
+		if p, ok := w.nodes[id]; ok {
+			nodes = append(nodes, p)
+		}
+	}
+	return nodes
+}
+
+// parentIDs returns the IDs of the parents of n, honoring FirstParent.
+func (w *revWalker) parentIDs(n *revNode) []object.ID {
+	if w.opts.FirstParent && len(n.parents) > 1 {
+		return n.parents[:1]
+	}
+	return n.parents
+}
+
+// date returns the date by which n is ordered in the given order.  The
+// commit of n must have been retrieved in AuthorDateOrder.
+func (w *revWalker) date(n *revNode, order RevOrder) time.Time {
+	if order == AuthorDateOrder {
+		return n.commit.Author.Date
+	}
+	return n.date
+}
+
+// push pushes n to the queue unless it has already been.
+func (w *revWalker) push(n *revNode) {
+	if n.queued {
+		return
+	}
+	n.queued = true
+	w.enqueue(n)
+}
+
+// enqueue pushes n to the queue even if it has already been.
+func (w *revWalker) enqueue(n *revNode) {
+	var gen int64
+	if w.byGeneration {
+		gen = n.gen
+	}
+	heap.Push(&w.queue, queueEntry{n, gen, n.date, w.seq})
+	w.seq++
+}
+
+// walk pushes the parents of n to the queue, excluding them if n is
+// excluded.  The parents of commits older than Since are not walked.
+func (w *revWalker) walk(n *revNode) error {
+	if w.tooOld(n) {
+		return nil
+	}
+	ids := w.parentIDs(n)
+	if n.excluded {
+		ids = n.parents
+	}
+	for _, id := range ids {
+		p, err := w.node(id)
+		if err != nil {
+			return err
+		}
+		if n.excluded {
+			w.markExcluded(p)
+		}
+		w.push(p)
+	}
+	return nil
+}
+
+// markExcluded marks n and the ancestors of it that have nodes as
+// excluded, and records the parents without nodes as excluded for when
+// their nodes are created.  Like the reference Git client, it marks the
+// ancestors of newly excluded commits as far as they are known rather
+// than waiting to walk them, so that commits with skewed clocks reached
+// early are excluded in time.
+func (w *revWalker) markExcluded(n *revNode) {
+	pending := []*revNode{n}
+	for len(pending) > 0 {
+		i := len(pending) - 1
+		n, pending = pending[i], pending[:i]
+		if n.excluded {
+			continue
+		}
+		n.excluded = true
+		for _, id := range n.parents {
+			if p, ok := w.nodes[id]; ok {
+				pending = append(pending, p)
+			} else {
+				w.excluded[id] = true
+			}
+		}
+	}
+}
+
+// tooOld and tooNew report whether n is outside the date range of the
+// options.
+func (w *revWalker) tooOld(n *revNode) bool {
+	return !w.opts.Since.IsZero() && n.date.Before(w.opts.Since)
+}
+
+func (w *revWalker) tooNew(n *revNode) bool {
+	return !w.opts.Until.IsZero() && n.date.After(w.opts.Until)
+}
+
+// stream calls fn for the commits in the order they come off the
+// queue.  None of them are excluded.
+func (w *revWalker) stream(fn RevListFunc) error {
+	count := 0
+	for len(w.queue) > 0 {
+		if w.opts.MaxCount > 0 && count == w.opts.MaxCount {
+			break
+		}
+		n := heap.Pop(&w.queue).(queueEntry).node
+		if err := w.walk(n); err != nil {
+			return err
+		}
+		if w.tooOld(n) || w.tooNew(n) {
+			continue
+		}
+		if err := w.list([]*revNode{n}, false, fn); err != nil {
+			return err
+		}
+		count++
+	}
+	return nil
+}
+
+// limit walks the queue until only excluded commits are left in it, and
+// returns the commits walked that are to be listed, in the order they
+// came off the queue.
+func (w *revWalker) limit() ([]*revNode, error) {
+	var walked []*revNode
+	var last *revNode // the last commit walked that may be listed
+	left := slop
+	for len(w.queue) > 0 {
+		n := heap.Pop(&w.queue).(queueEntry).node
+		if err := w.walk(n); err != nil {
+			return nil, err
+		}
+		if !n.excluded {
+			walked = append(walked, n)
+			if !w.tooNew(n) {
+				last = n
+			}
+			left = slop
+			continue
+		}
+		// like the reference Git client, keep walking while
+		// commits no older than the last listed one are queued
+		switch {
+		case len(w.queue) == 0:
+		case last != nil && !w.queue[0].node.date.Before(last.date):
+			left = slop
+		case !w.queue.allExcluded():
+			left = slop
+		default:
+			left--
+		}
+		if left == 0 {
+			break
+		}
+	}
+	// commits walked early may have been excluded since
+	var list []*revNode
+	for _, n := range walked {
+		if !n.excluded && !w.tooOld(n) && !w.tooNew(n) {
+			list = append(list, n)
+		}
+	}
+	return list, nil
+}
+
+// boundary returns the parents of the listed commits that are not
+// listed themselves, sorted as by sortTopo.
+func (w *revWalker) boundary(list []*revNode) ([]*revNode, error) {
+	seen := make(map[*revNode]bool)
+	for _, n := range list {
+		seen[n] = true
+	}
+	// like the reference Git client, collect the boundary commits
+	// in reverse
+	var boundary []*revNode
+	for _, n := range list {
+		for _, id := range n.parents {
+			p, err := w.node(id)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[p] {
+				seen[p] = true
+				boundary = append([]*revNode{p}, boundary...)
+			}
+		}
+	}
+	order := w.opts.Order
+	if order == DefaultOrder {
+		order = TopoOrder
+	} else if order == AuthorDateOrder {
+		if err := w.load(boundary); err != nil {
+			return nil, err
+		}
+	}
+	return w.sortTopo(boundary, order), nil
+}
+
+// sortTopo sorts the list so that no commit comes before its children
+// in it.  In DateOrder and AuthorDateOrder, the commits whose children
+// have all been listed are listed youngest first; in TopoOrder, the
+// parents of the last listed commit are preferred.  Otherwise the
+// order of the list is kept.
+func (w *revWalker) sortTopo(list []*revNode, order RevOrder) []*revNode {
+	children := make(map[*revNode]int)
+	for _, n := range list {
+		children[n] += 0
+	}
+	for _, n := range list {
+		for _, p := range w.parents(n) {
+			if _, ok := children[p]; ok {
+				children[p]++
+			}
+		}
+	}
+
+	// the commits ready to be listed, as a queue ordered by date in
+	// DateOrder and AuthorDateOrder and as a stack in TopoOrder
+	var ready commitQueue
+	var stack []*revNode
+	seq := 0
+	add := func(n *revNode) {
+		if order == TopoOrder {
+			stack = append(stack, n)
+		} else {
+			heap.Push(&ready, queueEntry{n, 0, w.date(n, order), seq})
+			seq++
+		}
+	}
+	for _, n := range list {
+		if children[n] == 0 {
+			add(n)
+		}
+	}
+	if order == TopoOrder {
+		// the commits without children in the list are listed in
+		// their original order
+		for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+			stack[i], stack[j] = stack[j], stack[i]
+		}
+	}
+	sorted := make([]*revNode, 0, len(list))
+	for len(ready) > 0 || len(stack) > 0 {
+		var n *revNode
+		if order == TopoOrder {
+			i := len(stack) - 1
+			n, stack = stack[i], stack[:i]
+		} else {
+			n = heap.Pop(&ready).(queueEntry).node
+		}
+		sorted = append(sorted, n)
+		for _, p := range w.parents(n) {
+			if k, ok := children[p]; ok {
+				if children[p] = k - 1; k == 1 {
+					add(p)
+				}
+			}
+		}
+	}
+	return sorted
+}
+
+// A queueEntry is a commit in a commitQueue.
+type queueEntry struct {
+	node *revNode
+	gen  int64
+	date time.Time
+	seq  int // insertion order, for breaking ties
+}
+
+// A commitQueue is a priority queue of commits, youngest first by
+// generation and then by date, for use with container/heap.  Commits
+// with equal generations and dates come off the queue in the order they
+// were pushed.
+type commitQueue []queueEntry
+
+func (q commitQueue) Len() int {
+	return len(q)
+}
+
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].gen != q[j].gen {
+		return q[i].gen > q[j].gen
+	}
+	if !q[i].date.Equal(q[j].date) {
+		return q[i].date.After(q[j].date)
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q commitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *commitQueue) Push(x interface{}) {
+	*q = append(*q, x.(queueEntry))
+}
+
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	n := len(old) - 1
+	e := old[n]
+	*q = old[:n]
+	return e
+}
+
+// allExcluded reports whether all the commits in the queue are
+// excluded.
+func (q commitQueue) allExcluded() bool {
+	for _, e := range q {
+		if !e.node.excluded {
+			return false
+		}
+	}
+	return true
+}
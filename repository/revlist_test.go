@@ -0,0 +1,182 @@
+package repository_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxr/go.git-scm/object"
+	"github.com/lxr/go.git-scm/repository"
+)
+
+// revListHistory returns a repository in which branch x forks from M1
+// at X1, main merges X2 at M2, x merges M3 at XM, and x merges M4 at
+// XM2.
+func revListHistory(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("M1", nil)
+	r.commit("X1", nil, "M1")
+	r.commit("X2", nil, "X1")
+	r.commit("M2", nil, "M1", "X2")
+	r.commit("M3", nil, "M2")
+	r.commit("XM", nil, "X2", "M3")
+	r.commit("X4", nil, "XM")
+	r.commit("M4", nil, "M3")
+	r.commit("XM2", nil, "X4", "M4")
+	return r
+}
+
+// interleavedHistory returns a repository in which the commits of
+// branches a and b, forked from root, alternate in time until M merges
+// them.  B2 was authored a day before it was committed.
+func interleavedHistory(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("root", nil)
+	r.commit("A1", nil, "root")
+	r.commit("B1", nil, "root")
+	r.commit("A2", nil, "A1")
+	c := &object.Commit{
+		Tree:      r.tree(map[string]string{"B2": "B2\n"}),
+		Parent:    r.idList("B1"),
+		Author:    testSignature(r.time + 60 - 86400),
+		Committer: testSignature(r.time + 60),
+		Message:   "B2\n",
+	}
+	id := r.put(c)
+	r.ids["B2"], r.names[id] = id, "B2"
+	r.time += 60
+	r.commit("A3", nil, "A2")
+	r.commit("B3", nil, "B2")
+	r.commit("M", nil, "A3", "B3")
+	return r
+}
+
+// skewedHistory returns a repository in which branch x forks from m2
+// and is merged back into the main line at m9, after the clock of the
+// main line was set back by a day at m4, so that m4 and its descendants
+// are dated before their ancestors.
+func skewedHistory(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("m1", nil)
+	r.commit("m2", nil, "m1")
+	r.commit("x1", nil, "m2")
+	r.commit("x2", nil, "x1")
+	r.commit("x3", nil, "x2")
+	r.commit("m3", nil, "m2")
+	r.commitAt("m4", r.time-86400, nil, "m3")
+	r.commit("m5", nil, "m4")
+	r.commit("m6", nil, "m5")
+	r.commit("m7", nil, "m6")
+	r.commit("m8", nil, "m7")
+	r.commit("m9", nil, "m8", "x2")
+	r.commit("m10", nil, "m9")
+	return r
+}
+
+// writeCommitGraph creates a branch for every named commit and stores a
+// commit-graph of them.
+func (r *testRepo) writeCommitGraph() {
+	for name, id := range r.ids {
+		if err := r.repo.UpdateRef("refs/heads/"+name, object.ZeroID, id); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	g, err := repository.BuildCommitGraph(r.repo, false)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if err := r.repo.(repository.CommitGrapher).SetCommitGraph(g); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// revList returns the commits RevList lists as a space-separated list
+// of names, with boundary commits prefixed by a minus sign as the
+// reference Git client lists them.
+func revList(r *testRepo, include, exclude string, opts *repository.RevListOptions) (string, error) {
+	var ids []object.ID
+	var boundary []bool
+	err := repository.RevList(r.repo, r.idList(include), r.idList(exclude), opts, func(id object.ID, _ *object.Commit, b bool) error {
+		ids = append(ids, id)
+		boundary = append(boundary, b)
+		return nil
+	})
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = r.nameList([]object.ID{id})
+		if boundary[i] {
+			names[i] = "-" + names[i]
+		}
+	}
+	return strings.Join(names, " "), err
+}
+
+// revListTests are queries of RevList and the commits the reference Git
+// client's rev-list command lists for them.
+var revListTests = []struct {
+	history          func(*testing.T) *testRepo
+	include, exclude string
+	opts             repository.RevListOptions
+	want             string
+}{
+	{interleavedHistory, "M", "", repository.RevListOptions{}, "M B3 A3 B2 A2 B1 A1 root"},
+	{interleavedHistory, "M", "", repository.RevListOptions{Order: repository.DateOrder}, "M B3 A3 B2 A2 B1 A1 root"},
+	{interleavedHistory, "M", "", repository.RevListOptions{Order: repository.AuthorDateOrder}, "M B3 A3 A2 A1 B2 B1 root"},
+	{interleavedHistory, "M", "", repository.RevListOptions{Order: repository.TopoOrder}, "M B3 B2 B1 A3 A2 A1 root"},
+	{interleavedHistory, "M", "A1", repository.RevListOptions{Order: repository.TopoOrder, Boundary: true}, "M B3 B2 B1 A3 A2 -A1 -root"},
+	{interleavedHistory, "A3 B3", "", repository.RevListOptions{Order: repository.TopoOrder}, "B3 B2 B1 A3 A2 A1 root"},
+	{interleavedHistory, "B3 A3", "", repository.RevListOptions{Order: repository.TopoOrder}, "B3 B2 B1 A3 A2 A1 root"},
+	{interleavedHistory, "M", "", repository.RevListOptions{Order: repository.AuthorDateOrder, MaxCount: 4}, "M B3 A3 A2"},
+	{revListHistory, "XM2 M4", "M2", repository.RevListOptions{}, "XM2 M4 X4 XM M3"},
+	{revListHistory, "XM2", "M2", repository.RevListOptions{FirstParent: true}, "XM2 X4 XM"},
+	{revListHistory, "XM2", "", repository.RevListOptions{FirstParent: true}, "XM2 X4 XM X2 X1 M1"},
+	{revListHistory, "XM2", "M3", repository.RevListOptions{Boundary: true}, "XM2 M4 X4 XM -X2 -M3"},
+	{revListHistory, "XM2", "M3", repository.RevListOptions{Boundary: true, Order: repository.TopoOrder}, "XM2 M4 X4 XM -X2 -M3"},
+	{revListHistory, "XM2", "", repository.RevListOptions{MaxCount: 3}, "XM2 M4 X4"},
+	{revListHistory, "XM2", "X2", repository.RevListOptions{MaxCount: 2, Order: repository.TopoOrder}, "XM2 M4"},
+	{revListHistory, "XM2", "", repository.RevListOptions{Since: time.Unix(1112911993+4*60, 0)}, "XM2 M4 X4 XM M3 M2"},
+	{revListHistory, "XM2", "", repository.RevListOptions{Until: time.Unix(1112911993+6*60, 0)}, "XM M3 M2 X2 X1 M1"},
+	{revListHistory, "XM2", "M1", repository.RevListOptions{Since: time.Unix(1112911993+2*60, 0), Until: time.Unix(1112911993+7*60, 0)}, "X4 XM M3 M2 X2 X1"},
+	{skewedHistory, "m10", "", repository.RevListOptions{}, "m10 m9 x2 x1 m2 m1 m8 m7 m6 m5 m4 m3"},
+	{skewedHistory, "m10", "", repository.RevListOptions{Order: repository.DateOrder}, "m10 m9 x2 x1 m8 m7 m6 m5 m4 m3 m2 m1"},
+	{skewedHistory, "m10", "m8", repository.RevListOptions{}, "m10 m9 x2 x1"},
+	{skewedHistory, "m1", "m8", repository.RevListOptions{}, ""},
+	{skewedHistory, "x3 m10", "m9", repository.RevListOptions{Boundary: true}, "x3 m10 -m9 -x2"},
+}
+
+func TestRevList(t *testing.T) {
+	for _, graph := range []bool{false, true} {
+		for i, tt := range revListTests {
+			r := tt.history(t)
+			if graph {
+				r.writeCommitGraph()
+			}
+			got, err := revList(r, tt.include, tt.exclude, &tt.opts)
+			if err != nil {
+				t.Errorf("%d: RevList(%s, ^%s): %v", i, tt.include, tt.exclude, err)
+			} else if got != tt.want {
+				t.Errorf("%d: RevList(%s, ^%s) with commit-graph %v:\ngot  %s\nwant %s", i, tt.include, tt.exclude, graph, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRevListError(t *testing.T) {
+	r := revListHistory(t)
+	stop := errors.New("stop")
+	n := 0
+	err := repository.RevList(r.repo, r.idList("XM2"), nil, nil, func(object.ID, *object.Commit, bool) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 {
+		t.Errorf("got %v after %d commits, want the error of the second", err, n)
+	}
+	tree := r.tree(map[string]string{"a": "a\n"})
+	if err := repository.RevList(r.repo, []object.ID{tree}, nil, nil, func(object.ID, *object.Commit, bool) error { return nil }); err == nil {
+		t.Error("RevList of a tree: no error")
+	}
+}
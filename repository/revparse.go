@@ -0,0 +1,556 @@
+package repository
+
+import (
+	"container/heap"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// Revision parsing error conditions.
+var (
+	ErrBadRevision     = errors.New("repository: malformed revision")
+	ErrUnknownRevision = errors.New("repository: unknown revision")
+	ErrNoReflog        = errors.New("repository: no reflog for ref")
+	ErrNoUpstream      = errors.New("repository: no upstream for branch")
+)
+
+// A Reflogger is a repository that keeps reflogs, the histories of the
+// values of its refs.  RevParse uses this to resolve <ref>@{N}.
+type Reflogger interface {
+	Interface
+
+	// Reflog returns the IDs the named ref, which may also be
+	// "HEAD", has pointed to, most recent first.  It returns
+	// ErrNoReflog if the ref has no reflog.
+	Reflog(name string) ([]object.ID, error)
+}
+
+// An UpstreamTracker is a repository that records the branches its
+// branches track, like the branch.<name>.remote and .merge
+// configuration variables of the reference Git client.  RevParse uses
+// this to resolve <branch>@{upstream}.
+type UpstreamTracker interface {
+	Interface
+
+	// Upstream returns the name of the remote-tracking ref the
+	// named branch (a full refname) tracks.  It returns
+	// ErrNoUpstream if the branch tracks none.
+	Upstream(branch string) (string, error)
+}
+
+// BUG(lor): RevParse does not support the forms of gitrevisions(7)
+// that refer to the index (:<path> and :<n>:<path>), reflog entries by
+// date (<ref>@{<date>}), previously checked out branches (@{-<n>}) or
+// push destinations (<branch>@{push}), as repositories have no index,
+// dates in reflogs or checkout history.  Regular expressions are in
+// the syntax of package regexp instead of POSIX extended ones.
+
+// RevParse resolves a revision, as described in gitrevisions(7), to the
+// ID of the object it names.  The revision may be a full or abbreviated
+// object ID, a refname disambiguated as by FindRef, HEAD or @, followed
+// by any number of the suffixes ~<n>, ^<n>, ^{<type>}, ^{} and
+// ^{/<regex>}, or be of the form <rev>:<path> or :/<regex>.  The forms
+// <ref>@{<n>} and <branch>@{upstream} are supported if r is a Reflogger
+// or an UpstreamTracker respectively.  RevParse does not accept ranges;
+// use ParseRevisions for those.
+//
+// RevParse returns ErrUnknownRevision if the revision names no object,
+// ErrBadRevision if it is malformed, an *AmbiguousError if an
+// abbreviated ID matches several objects, and an *object.TypeError
+// containing the object if a suffix cannot be applied to it.
+func RevParse(r Interface, rev string) (object.ID, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	if strings.HasPrefix(rev, ":/") {
+		starts, err := allRefs(r)
+		if err != nil {
+			return format.ZeroID(), err
+		}
+		return searchMessage(r, starts, rev[2:])
+	}
+	if strings.HasPrefix(rev, ":") {
+		return format.ZeroID(), ErrBadRevision
+	}
+
+	// the path of <rev>:<path> starts after the first colon that is
+	// not inside braces, like in ^{/<regex>}
+	depth := 0
+	for i := 0; i < len(rev); i++ {
+		switch {
+		case rev[i] == '{':
+			depth++
+		case rev[i] == '}' && depth > 0:
+			depth--
+		case rev[i] == ':' && depth == 0:
+			return resolvePath(r, rev[:i], rev[i+1:])
+		}
+	}
+	return resolveRev(r, rev)
+}
+
+// resolvePath resolves <rev>:<path>.
+func resolvePath(r Interface, rev, name string) (object.ID, error) {
+	id, err := resolveRev(r, rev)
+	if err != nil {
+		return id, err
+	}
+	tree, id, err := GetTree(r, id)
+	if err != nil {
+		return id, err
+	}
+	dirs, base, err := splitPath(name)
+	if err == errEmptyPath {
+		return id, nil
+	} else if err != nil {
+		return id, err
+	}
+	ti, err := lookupEntry(r, tree, dirs, base)
+	switch {
+	case err != nil:
+		return id, err
+	case ti == object.TreeInfo{}:
+		return id, ErrUnknownRevision
+	default:
+		return ti.Object, nil
+	}
+}
+
+// resolveRev resolves a revision without a path, applying its suffixes
+// from right to left.
+func resolveRev(r Interface, rev string) (object.ID, error) {
+	if strings.HasSuffix(rev, "}") {
+		if i := strings.LastIndex(rev, "^{"); i >= 0 {
+			id, err := resolveRev(r, rev[:i])
+			if err != nil {
+				return id, err
+			}
+			return peel(r, id, rev[i+2:len(rev)-1])
+		}
+	}
+
+	n := len(rev)
+	for n > 0 && '0' <= rev[n-1] && rev[n-1] <= '9' {
+		n--
+	}
+	if n > 0 && (rev[n-1] == '~' || rev[n-1] == '^') {
+		num := 1
+		if n < len(rev) {
+			var err error
+			if num, err = strconv.Atoi(rev[n:]); err != nil {
+				format, _ := ObjectFormat(r)
+				return format.ZeroID(), ErrBadRevision
+			}
+		}
+		id, err := resolveRev(r, rev[:n-1])
+		if err != nil {
+			return id, err
+		}
+		if rev[n-1] == '^' {
+			return nthParent(r, id, num)
+		}
+		return nthAncestor(r, id, num)
+	}
+	return resolveBasic(r, rev)
+}
+
+// nthParent returns the ID of the nth parent of the commit the ID
+// dereferences to, or the ID of the commit itself if n is zero.
+func nthParent(r Interface, id object.ID, n int) (object.ID, error) {
+	c, id, err := GetCommit(r, id)
+	switch {
+	case err != nil:
+		return id, err
+	case n == 0:
+		return id, nil
+	case n > len(c.Parent):
+		return id, ErrUnknownRevision
+	default:
+		return c.Parent[n-1], nil
+	}
+}
+
+// nthAncestor returns the ID of the nth first-parent ancestor of the
+// commit the ID dereferences to.
+func nthAncestor(r Interface, id object.ID, n int) (object.ID, error) {
+	id, err := nthParent(r, id, 0)
+	for i := 0; i < n && err == nil; i++ {
+		id, err = nthParent(r, id, 1)
+	}
+	return id, err
+}
+
+// peel resolves the ^{<type>} suffix.
+func peel(r Interface, id object.ID, typ string) (object.ID, error) {
+	switch {
+	case typ == "":
+		for {
+			tag, ok, err := getTag(r, id)
+			switch {
+			case err != nil:
+				return id, err
+			case !ok:
+				return id, nil
+			}
+			id = tag.Object
+		}
+	case strings.HasPrefix(typ, "/"):
+		return searchMessage(r, []object.ID{id}, typ[1:])
+	case typ == "object":
+		if ok, err := HasObject(r, id); err != nil || !ok {
+			return id, ErrUnknownRevision
+		}
+		return id, nil
+	case typ == "commit":
+		_, id, err := GetCommit(r, id)
+		return id, err
+	case typ == "tree":
+		_, id, err := GetTree(r, id)
+		return id, err
+	case typ == "tag":
+		_, ok, err := getTag(r, id)
+		if err == nil && !ok {
+			obj, _ := getParsed(r, id)
+			err = &object.TypeError{obj}
+		}
+		return id, err
+	case typ == "blob":
+		for {
+			obj, err := getParsed(r, id)
+			if err != nil {
+				return id, err
+			}
+			switch obj := obj.(type) {
+			case *object.Blob:
+				return id, nil
+			case *object.Tag:
+				id = obj.Object
+			default:
+				return id, &object.TypeError{obj}
+			}
+		}
+	default:
+		return id, ErrBadRevision
+	}
+}
+
+// getTag returns the tag with the given ID, and false if the object is
+// not a tag.
+func getTag(r Interface, id object.ID) (*object.Tag, bool, error) {
+	obj, err := getParsed(r, id)
+	if err != nil {
+		return nil, false, err
+	}
+	tag, ok := obj.(*object.Tag)
+	return tag, ok, nil
+}
+
+// resolveBasic resolves a revision without suffixes: an object ID, a
+// refname, or a reflog or upstream reference.
+func resolveBasic(r Interface, rev string) (object.ID, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	if strings.HasSuffix(rev, "}") {
+		if i := strings.LastIndex(rev, "@{"); i >= 0 {
+			return resolveAt(r, rev[:i], rev[i+2:len(rev)-1])
+		}
+	}
+	switch {
+	case rev == "":
+		return format.ZeroID(), ErrBadRevision
+	case rev == "@" || rev == "HEAD":
+		name, err := r.GetHEAD()
+		if err != nil {
+			return format.ZeroID(), err
+		}
+		return getRef(r, name)
+	case len(rev) == 2*format.Size() && isHex(rev):
+		return object.DecodeID(rev)
+	}
+	if _, id, err := dwimRef(r, rev); err != ErrRefNotExist {
+		return id, err
+	}
+	if len(rev) >= MinAbbrev && isHex(rev) {
+		id, err := ResolvePrefix(r, rev)
+		if err == ErrObjectNotExist {
+			err = ErrUnknownRevision
+		}
+		return id, err
+	}
+	return format.ZeroID(), ErrUnknownRevision
+}
+
+// resolveAt resolves <ref>@{<spec>}.
+func resolveAt(r Interface, ref, spec string) (object.ID, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	var name string
+	switch ref {
+	case "", "@":
+		name, err = r.GetHEAD()
+	case "HEAD":
+		name = "HEAD"
+	default:
+		name, _, err = dwimRef(r, ref)
+	}
+	if err == ErrRefNotExist {
+		return format.ZeroID(), ErrUnknownRevision
+	} else if err != nil {
+		return format.ZeroID(), err
+	}
+
+	switch n, err := strconv.Atoi(spec); {
+	case strings.EqualFold(spec, "u") || strings.EqualFold(spec, "upstream"):
+		if name == "HEAD" {
+			if name, err = r.GetHEAD(); err != nil {
+				return format.ZeroID(), err
+			}
+		}
+		ut, ok := r.(UpstreamTracker)
+		if !ok || !strings.HasPrefix(name, "refs/heads/") {
+			return format.ZeroID(), ErrNoUpstream
+		}
+		upstream, err := ut.Upstream(name)
+		if err != nil {
+			return format.ZeroID(), err
+		}
+		return getRef(r, upstream)
+	case err == nil && n >= 0:
+		rl, ok := r.(Reflogger)
+		if !ok {
+			return format.ZeroID(), ErrNoReflog
+		}
+		log, err := rl.Reflog(name)
+		switch {
+		case err != nil:
+			return format.ZeroID(), err
+		case n >= len(log):
+			return format.ZeroID(), ErrUnknownRevision
+		default:
+			return log[n], nil
+		}
+	default:
+		return format.ZeroID(), ErrBadRevision
+	}
+}
+
+// getRef is like r.GetRef, but returns ErrUnknownRevision if the ref
+// does not exist.
+func getRef(r Interface, name string) (object.ID, error) {
+	id, err := r.GetRef(name)
+	if err == ErrRefNotExist {
+		err = ErrUnknownRevision
+	}
+	return id, err
+}
+
+// isHex reports whether s consists of hexadecimal digits only.
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// allRefs returns the IDs all refs of r and HEAD point to.
+func allRefs(r Interface) ([]object.ID, error) {
+	_, ids, err := r.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	if head, err := resolveBasic(r, "HEAD"); err == nil {
+		ids = append(ids, head)
+	}
+	return ids, nil
+}
+
+// searchMessage returns the ID of the youngest commit reachable from
+// the start IDs whose message matches the regular expression pattern.
+// A pattern beginning with "!-" matches the messages that do not match
+// the rest of it, and one beginning with "!!" matches a literal "!"
+// followed by the rest of it; other patterns beginning with "!" are
+// reserved.
+func searchMessage(r Interface, starts []object.ID, pattern string) (object.ID, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+	negate := false
+	switch {
+	case strings.HasPrefix(pattern, "!-"):
+		pattern, negate = pattern[2:], true
+	case strings.HasPrefix(pattern, "!!"):
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "!"):
+		return format.ZeroID(), ErrBadRevision
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return format.ZeroID(), err
+	}
+
+	// visit the commits youngest first
+	w := newRevWalker(r)
+	for _, id := range starts {
+		n, err := w.node(id)
+		if _, ok := err.(*object.TypeError); ok {
+			continue // refs may point to non-commits
+		} else if err != nil {
+			return format.ZeroID(), err
+		}
+		w.push(n)
+	}
+	for len(w.queue) > 0 {
+		n := heap.Pop(&w.queue).(queueEntry).node
+		c, err := w.commit(n)
+		if err != nil {
+			return format.ZeroID(), err
+		}
+		if re.MatchString(c.Message) != negate {
+			return n.id, nil
+		}
+		if err := w.walk(n); err != nil {
+			return format.ZeroID(), err
+		}
+	}
+	return format.ZeroID(), ErrUnknownRevision
+}
+
+// ParseRevisions resolves revisions and revision ranges, as accepted by
+// the reference Git client's rev-list command, to the sets of objects
+// to include and exclude.  Each argument is either a revision as
+// accepted by RevParse, which is included, or one of the following:
+//
+//	^<rev>       excludes <rev>
+//	<a>..<b>     includes <b> and excludes <a>
+//	<a>...<b>    includes <a> and <b> and excludes their merge bases
+//	<rev>^@      includes the parents of <rev>
+//	<rev>^!      includes <rev> and excludes its parents
+//	<rev>^-<n>   includes <rev> and excludes its nth parent (default 1)
+//
+// An omitted end of a range defaults to HEAD.
+func ParseRevisions(r Interface, args ...string) (include, exclude []object.ID, err error) {
+	for _, arg := range args {
+		inc, exc, err := parseRevision(r, arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		include = append(include, inc...)
+		exclude = append(exclude, exc...)
+	}
+	return include, exclude, nil
+}
+
+// parseRevision is ParseRevisions for a single argument.
+func parseRevision(r Interface, arg string) (include, exclude []object.ID, err error) {
+	if i := strings.Index(arg, ".."); i >= 0 {
+		include, exclude, err := parseRange(r, arg[:i], arg[i+2:])
+		if err == nil {
+			return include, exclude, nil
+		}
+		// the dots may be part of a path, as in <rev>:../<path>
+		if id, err2 := RevParse(r, arg); err2 == nil {
+			return []object.ID{id}, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(arg, "^"):
+		id, err := RevParse(r, arg[1:])
+		return nil, []object.ID{id}, err
+	case strings.HasSuffix(arg, "^@"):
+		c, _, err := revCommit(r, arg[:len(arg)-2])
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.Parent, nil, nil
+	case strings.HasSuffix(arg, "^!"):
+		c, id, err := revCommit(r, arg[:len(arg)-2])
+		if err != nil {
+			return nil, nil, err
+		}
+		return []object.ID{id}, c.Parent, nil
+	}
+	if i := strings.LastIndex(arg, "^-"); i >= 0 && isDigits(arg[i+2:]) {
+		n := 1
+		if i+2 < len(arg) {
+			if n, err = strconv.Atoi(arg[i+2:]); err != nil {
+				return nil, nil, ErrBadRevision
+			}
+		}
+		_, id, err := revCommit(r, arg[:i])
+		if err != nil {
+			return nil, nil, err
+		}
+		parent, err := nthParent(r, id, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []object.ID{id}, []object.ID{parent}, nil
+	}
+	id, err := RevParse(r, arg)
+	return []object.ID{id}, nil, err
+}
+
+// isDigits reports whether s consists of decimal digits only.
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// revCommit resolves a revision to a commit.
+func revCommit(r Interface, rev string) (*object.Commit, object.ID, error) {
+	id, err := RevParse(r, rev)
+	if err != nil {
+		return nil, id, err
+	}
+	return GetCommit(r, id)
+}
+
+// parseRange resolves the range <a>..<b>, or <a>...<b> if b begins
+// with a dot.
+func parseRange(r Interface, a, b string) (include, exclude []object.ID, err error) {
+	symmetric := strings.HasPrefix(b, ".")
+	if symmetric {
+		b = b[1:]
+	}
+	if a == "" {
+		a = "HEAD"
+	}
+	if b == "" {
+		b = "HEAD"
+	}
+	aID, err := RevParse(r, a)
+	if err != nil {
+		return nil, nil, err
+	}
+	bID, err := RevParse(r, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !symmetric {
+		return []object.ID{bID}, []object.ID{aID}, nil
+	}
+	bases, err := MergeBases(r, aID, bID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []object.ID{aID, bID}, bases, nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+var errEmptyPath = errors.New("repository: empty tree path")
+
+// A TreeEditor edits the tree hierarchy rooted at a tree object.
+// Edits are made by path, and the trees along the paths are retrieved
+// from the repository as they are needed; Write stores only the trees
+// that have changed.  Like Git, a TreeEditor does not store empty
+// subtrees: a subtree whose entries are all deleted is removed from its
+// parent.
+type TreeEditor struct {
+	repo   Interface
+	format object.Format
+	root   *treeNode
+}
+
+// A treeNode is a tree in the hierarchy being edited.  Every node in
+// children has a tree entry of mode object.ModeTree in tree, whose ID
+// is stale if the node is dirty.
+type treeNode struct {
+	id       object.ID // ID of the tree as last stored
+	tree     object.Tree
+	children map[string]*treeNode // the subtrees retrieved so far
+	dirty    bool                 // whether the tree has changed since stored
+}
+
+// NewTreeEditor returns a TreeEditor editing the tree hierarchy rooted
+// at the given ID, which may point to a tree, commit or tag object as
+// with GetTree.  If the ID is zero, the hierarchy starts out empty.
+func NewTreeEditor(r Interface, root object.ID) (*TreeEditor, error) {
+	format, err := ObjectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	e := &TreeEditor{repo: r, format: format}
+	if root.IsZero() {
+		e.root = &treeNode{tree: make(object.Tree), dirty: true}
+		return e, nil
+	}
+	tree, id, err := GetTree(r, root)
+	if err != nil {
+		return nil, err
+	}
+	e.root = newTreeNode(id, tree)
+	return e, nil
+}
+
+// newTreeNode returns a node holding a copy of the tree with the given
+// ID, which may be the cached parsed form of an *object.Raw.
+func newTreeNode(id object.ID, tree *object.Tree) *treeNode {
+	n := &treeNode{id: id, tree: make(object.Tree, len(*tree))}
+	for name, ti := range *tree {
+		n.tree[name] = ti
+	}
+	return n
+}
+
+// splitPath splits a slash-separated path into its directory
+// components and its last component, cleaned as by GetPath.
+func splitPath(name string) ([]string, string, error) {
+	comps := strings.Split(path.Clean("/" + name)[1:], "/")
+	n := len(comps) - 1
+	if comps[n] == "" {
+		return nil, "", errEmptyPath
+	}
+	return comps[:n], comps[n], nil
+}
+
+// walk returns the nodes of the directories along the given path,
+// starting from the root.  If create is set, missing directories are
+// created.  It returns an error if a component is missing or is not a
+// tree.
+func (e *TreeEditor) walk(dirs []string, create bool) ([]*treeNode, error) {
+	nodes := []*treeNode{e.root}
+	n := e.root
+	for i, comp := range dirs {
+		child, ok := n.children[comp]
+		if !ok {
+			ti, exists := n.tree[comp]
+			switch {
+			case exists && ti.Mode == object.ModeTree:
+				tree, err := getTree(e.repo, ti.Object)
+				if err != nil {
+					return nil, err
+				}
+				child = newTreeNode(ti.Object, tree)
+			case exists:
+				return nil, fmt.Errorf("not a tree: %s", path.Join(dirs[:i+1]...))
+			case !create:
+				return nil, fmt.Errorf("no such tree entry: %s", comp)
+			default:
+				// the entry's ID is filled in by Write
+				child = &treeNode{tree: make(object.Tree)}
+				n.tree[comp] = object.TreeInfo{object.ModeTree, e.format.ZeroID()}
+			}
+			if n.children == nil {
+				n.children = make(map[string]*treeNode)
+			}
+			n.children[comp] = child
+		}
+		nodes = append(nodes, child)
+		n = child
+	}
+	return nodes, nil
+}
+
+// getTree retrieves the tree with the given ID.  Unlike GetTree, it
+// does not dereference commits or tags.
+func getTree(r Interface, id object.ID) (*object.Tree, error) {
+	obj, err := getParsed(r, id)
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := obj.(*object.Tree)
+	if !ok {
+		return nil, &object.TypeError{obj}
+	}
+	return tree, nil
+}
+
+// markDirty marks the nodes as changed.
+func markDirty(nodes []*treeNode) {
+	for _, n := range nodes {
+		n.dirty = true
+	}
+}
+
+// Set sets the tree entry at the given path to point to the object with
+// the given ID and mode, creating missing directories along the path.
+// An existing entry at the path, including a subtree, is replaced.  It
+// returns an error if a directory along the path exists but is not a
+// tree.
+func (e *TreeEditor) Set(name string, mode object.TreeMode, id object.ID) error {
+	dirs, base, err := splitPath(name)
+	if err != nil {
+		return err
+	}
+	nodes, err := e.walk(dirs, true)
+	if err != nil {
+		return err
+	}
+	n := nodes[len(nodes)-1]
+	n.tree[base] = object.TreeInfo{mode, id}
+	delete(n.children, base)
+	markDirty(nodes)
+	return nil
+}
+
+// Delete deletes the tree entry at the given path, along with all of
+// its descendants if it is a subtree.  It returns an error if there is
+// no entry at the path.
+func (e *TreeEditor) Delete(name string) error {
+	dirs, base, err := splitPath(name)
+	if err != nil {
+		return err
+	}
+	nodes, err := e.walk(dirs, false)
+	if err != nil {
+		return err
+	}
+	n := nodes[len(nodes)-1]
+	if _, ok := n.tree[base]; !ok {
+		return fmt.Errorf("no such tree entry: %s", base)
+	}
+	delete(n.tree, base)
+	delete(n.children, base)
+	markDirty(nodes)
+	return nil
+}
+
+// Rename moves the tree entry at path from, which may be a file or a
+// subtree, to path to, creating missing directories along the latter.
+// It returns an error if there is no entry at from, or if there already
+// is one at to, or if to is inside from.
+func (e *TreeEditor) Rename(from, to string) error {
+	fromDirs, fromBase, err := splitPath(from)
+	if err != nil {
+		return err
+	}
+	toDirs, toBase, err := splitPath(to)
+	if err != nil {
+		return err
+	}
+	from = path.Join(append(fromDirs, fromBase)...)
+	to = path.Join(append(toDirs, toBase)...)
+	if strings.HasPrefix(to+"/", from+"/") {
+		return fmt.Errorf("cannot move %s into itself", from)
+	}
+
+	fromNodes, err := e.walk(fromDirs, false)
+	if err != nil {
+		return err
+	}
+	fromDir := fromNodes[len(fromNodes)-1]
+	ti, ok := fromDir.tree[fromBase]
+	if !ok {
+		return fmt.Errorf("no such tree entry: %s", fromBase)
+	}
+	toNodes, err := e.walk(toDirs, true)
+	if err != nil {
+		return err
+	}
+	toDir := toNodes[len(toNodes)-1]
+	if _, ok := toDir.tree[toBase]; ok {
+		return fmt.Errorf("tree entry exists: %s", to)
+	}
+
+	child := fromDir.children[fromBase]
+	delete(fromDir.tree, fromBase)
+	delete(fromDir.children, fromBase)
+	toDir.tree[toBase] = ti
+	if child != nil {
+		if toDir.children == nil {
+			toDir.children = make(map[string]*treeNode)
+		}
+		toDir.children[toBase] = child
+	}
+	markDirty(fromNodes)
+	markDirty(toNodes)
+	return nil
+}
+
+// Write stores the trees changed since the TreeEditor was created or
+// last written, and returns the ID of the root tree.  The TreeEditor
+// can be used for further edits afterwards.
+func (e *TreeEditor) Write() (object.ID, error) {
+	return e.write(e.root)
+}
+
+// write stores n and its changed subtrees if n has changed, and returns
+// the ID of n.  An empty subtree is not stored, and the caller should
+// remove it from its parent.
+func (e *TreeEditor) write(n *treeNode) (object.ID, error) {
+	if !n.dirty {
+		return n.id, nil
+	}
+	for name, child := range n.children {
+		if !child.dirty {
+			continue
+		}
+		id, err := e.write(child)
+		if err != nil {
+			return id, err
+		}
+		if len(child.tree) == 0 {
+			delete(n.tree, name)
+			delete(n.children, name)
+		} else {
+			n.tree[name] = object.TreeInfo{object.ModeTree, id}
+		}
+	}
+	if len(n.tree) == 0 && n != e.root {
+		n.id, n.dirty = e.format.ZeroID(), false
+		return n.id, nil
+	}
+	id, err := e.repo.PutObject(&n.tree)
+	if err != nil {
+		return id, err
+	}
+	n.id, n.dirty = id, false
+	return id, nil
+}
@@ -0,0 +1,98 @@
+package signature
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// An OpenPGPVerifier verifies OpenPGP signatures, as made by the
+// reference Git client with gpg.format set to openpgp.
+type OpenPGPVerifier struct {
+	// Keyring holds the public keys of the trusted signers.
+	Keyring openpgp.KeyRing
+
+	// Trust maps the fingerprints of keys, in upper-case hexadecimal,
+	// to the trust placed in them.  Keys missing from the map have
+	// the trust level TrustUndefined, like keys without an owner
+	// trust in a GnuPG trust database.
+	Trust map[string]Trust
+}
+
+func (v *OpenPGPVerifier) Format() Format {
+	return FormatOpenPGP
+}
+
+// Verify checks an ASCII-armored detached OpenPGP signature.  The
+// signer of a good signature is the primary user ID of the signing key.
+func (v *OpenPGPVerifier) Verify(payload, sig []byte) (*Result, error) {
+	entity, err := openpgp.CheckArmoredDetachedSignature(v.Keyring,
+		bytes.NewReader(payload),
+		bytes.NewReader(sig),
+	)
+	switch err.(type) {
+	case nil:
+	case pgperrors.SignatureError, pgperrors.StructuralError:
+		return nil, ErrBadSignature
+	default:
+		if err == pgperrors.ErrUnknownIssuer {
+			return nil, ErrUnknownKey
+		}
+		return nil, err
+	}
+	key := fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+	return &Result{
+		Format: FormatOpenPGP,
+		Signer: primaryIdentity(entity),
+		Key:    key,
+		Trust:  v.Trust[key],
+	}, nil
+}
+
+// primaryIdentity returns the name of the identity of e marked as
+// primary, or that of its first identity in lexical order if none is.
+func primaryIdentity(e *openpgp.Entity) string {
+	names := make([]string, 0, len(e.Identities))
+	for name, id := range e.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil &&
+			*id.SelfSignature.IsPrimaryId {
+			return name
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// An OpenPGPSigner makes OpenPGP signatures, like the reference Git
+// client with gpg.format set to openpgp.
+type OpenPGPSigner struct {
+	// Entity holds the signing key, which must have been decrypted.
+	Entity *openpgp.Entity
+
+	// Config sets the hash function and the time of the signatures.
+	// A nil Config selects the defaults.
+	Config *packet.Config
+}
+
+func (s *OpenPGPSigner) Format() Format {
+	return FormatOpenPGP
+}
+
+// Sign returns an ASCII-armored detached OpenPGP signature.
+func (s *OpenPGPSigner) Sign(payload []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := openpgp.ArmoredDetachSign(buf, s.Entity, bytes.NewReader(payload), s.Config)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
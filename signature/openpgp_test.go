@@ -0,0 +1,46 @@
+package signature
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestOpenPGPRoundTrip(t *testing.T) {
+	e, err := openpgp.NewEntity("A U Thor", "", "author@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	v := &OpenPGPVerifier{
+		Keyring: openpgp.EntityList{e},
+		Trust:   map[string]Trust{key: TrustMarginal},
+	}
+	want := Result{
+		Format: FormatOpenPGP,
+		Signer: "A U Thor <author@example.com>",
+		Key:    key,
+		Trust:  TrustMarginal,
+	}
+	results, errs := signAndVerify(t, &OpenPGPSigner{Entity: e}, v)
+	for i, res := range results {
+		if errs[i] != nil {
+			t.Error(errs[i])
+		} else if *res != want {
+			t.Errorf("got %v, want %v", *res, want)
+		}
+	}
+
+	other, err := openpgp.NewEntity("C O Mitter", "", "committer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Keyring = openpgp.EntityList{other}
+	_, errs = signAndVerify(t, &OpenPGPSigner{Entity: e}, v)
+	for _, err := range errs {
+		if err != ErrUnknownKey {
+			t.Errorf("signature by key not in keyring: got %v, want ErrUnknownKey", err)
+		}
+	}
+}
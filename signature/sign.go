@@ -0,0 +1,75 @@
+package signature
+
+import (
+	"strings"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// A Signer makes signatures of one format.
+type Signer interface {
+	// Format returns the signature format the signer makes.
+	Format() Format
+
+	// Sign returns an armored detached signature of payload,
+	// terminated by a newline.
+	Sign(payload []byte) ([]byte, error)
+}
+
+// SignCommit signs a SHA-1 commit with s, replacing its gpgsig header
+// if it already has one.
+func SignCommit(c *object.Commit, s Signer) error {
+	return SignCommitFormat(c, object.SHA1, s)
+}
+
+// SignCommitFormat signs a commit of the given object format with s.
+// The signature is stored in the header named by the format, gpgsig or
+// gpgsig-sha256, which is appended to the commit's extra headers after
+// removing any previous header of that name.  The signatures of other
+// formats are kept, as they do not sign each other.
+func SignCommitFormat(c *object.Commit, f object.Format, s Signer) error {
+	name := commitHeader(f)
+	var extra []object.ExtraHeader
+	for _, h := range c.Extra {
+		if h.Key != name {
+			extra = append(extra, h)
+		}
+	}
+	c.Extra = extra
+	text, err := c.MarshalText()
+	if err != nil {
+		return err
+	}
+	payload, _ := splitCommit(text, name)
+	sig, err := s.Sign(payload)
+	if err != nil {
+		return err
+	}
+	c.Extra = append(c.Extra, object.ExtraHeader{
+		Key:   name,
+		Value: strings.TrimSuffix(string(sig), "\n"),
+	})
+	return nil
+}
+
+// SignTag signs a tag with s, appending the signature to its message
+// after removing any previous one.  A newline is first added to a
+// non-empty message that does not end in one, so that the signature
+// begins on a line of its own.
+func SignTag(t *object.Tag, s Signer) error {
+	msg, _ := splitTag([]byte(t.Message))
+	t.Message = string(msg)
+	if t.Message != "" && !strings.HasSuffix(t.Message, "\n") {
+		t.Message += "\n"
+	}
+	payload, err := t.MarshalText()
+	if err != nil {
+		return err
+	}
+	sig, err := s.Sign(payload)
+	if err != nil {
+		return err
+	}
+	t.Message += string(sig)
+	return nil
+}
@@ -0,0 +1,273 @@
+// Package signature makes and verifies the cryptographic signatures of
+// Git commits and tags.
+//
+// A signed commit carries its signature in a gpgsig header (gpgsig-sha256
+// in SHA-256 repositories), and the signed payload is the commit without
+// that header.  A signed tag carries its signature at the end of its
+// message, and the signed payload is the tag up to the signature.  Split
+// separates an object into the two, and Verify checks the signature with
+// a Verifier for its format, such as an OpenPGPVerifier or an
+// SSHVerifier.  Conversely, SignCommit and SignTag sign objects with a
+// Signer, such as an OpenPGPSigner or an SSHSigner.
+package signature
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+var (
+	// ErrNotSigned is returned when splitting or verifying an object
+	// that carries no signature.
+	ErrNotSigned = errors.New("signature: object is not signed")
+
+	// ErrNoVerifier is returned by Verify if none of the given
+	// verifiers handles the format of the object's signature.
+	ErrNoVerifier = errors.New("signature: no verifier for signature format")
+
+	// ErrBadSignature is returned by a Verifier if the signature does
+	// not match the payload or is malformed.
+	ErrBadSignature = errors.New("signature: invalid signature")
+
+	// ErrUnknownKey is returned by a Verifier if the key that made the
+	// signature is not one it knows of.
+	ErrUnknownKey = errors.New("signature: signed by unknown key")
+)
+
+// A Format is a signature format supported by the reference Git client,
+// as set by its gpg.format configuration variable.
+type Format int
+
+// The signature formats.  Verifiers are not provided for X.509
+// signatures, but they are recognized by Split.
+const (
+	FormatUnknown Format = iota
+	FormatOpenPGP
+	FormatX509
+	FormatSSH
+)
+
+// signatureHeaders lists the first lines of the signatures of each
+// format.
+var signatureHeaders = []struct {
+	format Format
+	header string
+}{
+	{FormatOpenPGP, "-----BEGIN PGP SIGNATURE-----"},
+	{FormatOpenPGP, "-----BEGIN PGP MESSAGE-----"},
+	{FormatX509, "-----BEGIN SIGNED MESSAGE-----"},
+	{FormatSSH, "-----BEGIN SSH SIGNATURE-----"},
+}
+
+// DetectFormat returns the format of the given armored signature, or
+// FormatUnknown if it is not recognized.
+func DetectFormat(sig []byte) Format {
+	for _, h := range signatureHeaders {
+		if bytes.HasPrefix(sig, []byte(h.header)) {
+			return h.format
+		}
+	}
+	return FormatUnknown
+}
+
+// String returns "openpgp", "x509" or "ssh" depending on the value of
+// the format, the same as the values of gpg.format.
+func (f Format) String() string {
+	switch f {
+	case FormatOpenPGP:
+		return "openpgp"
+	case FormatX509:
+		return "x509"
+	case FormatSSH:
+		return "ssh"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// A Trust is the level of trust placed in the key that made a
+// signature, as reported by "git verify-commit --raw" and compared
+// against the gpg.minTrustLevel configuration variable.
+type Trust int
+
+// The trust levels, from least to most trusted.
+const (
+	TrustUndefined Trust = iota
+	TrustNever
+	TrustMarginal
+	TrustFully
+	TrustUltimate
+)
+
+// String returns "undefined", "never", "marginal", "fully" or
+// "ultimate" depending on the value of the trust level.
+func (t Trust) String() string {
+	switch t {
+	case TrustUndefined:
+		return "undefined"
+	case TrustNever:
+		return "never"
+	case TrustMarginal:
+		return "marginal"
+	case TrustFully:
+		return "fully"
+	case TrustUltimate:
+		return "ultimate"
+	default:
+		return fmt.Sprintf("Trust(%d)", int(t))
+	}
+}
+
+// A Result describes a good signature.
+type Result struct {
+	Format Format
+	Signer string // identity of the signer, or "" if not known
+	Key    string // fingerprint of the signing key
+	Trust  Trust  // trust placed in the signing key
+}
+
+// A Verifier checks signatures of one format.
+type Verifier interface {
+	// Format returns the signature format the verifier handles.
+	Format() Format
+
+	// Verify checks that sig is a good signature of payload.  It
+	// returns ErrBadSignature if it is not, and ErrUnknownKey if the
+	// signing key is not known to the verifier.  Along with
+	// ErrUnknownKey, it may return a Result describing the key.
+	Verify(payload, sig []byte) (*Result, error)
+}
+
+// Verify checks the signature of a commit or tag with the first of the
+// given verifiers that handles the signature's format.  It returns
+// ErrNotSigned if obj is not signed and ErrNoVerifier if none of the
+// verifiers is suitable.
+func Verify(obj object.Interface, vs ...Verifier) (*Result, error) {
+	payload, sig, err := Split(obj)
+	if err != nil {
+		return nil, err
+	}
+	format := DetectFormat(sig)
+	for _, v := range vs {
+		if v.Format() == format {
+			return v.Verify(payload, sig)
+		}
+	}
+	return nil, ErrNoVerifier
+}
+
+// Split returns the signed payload and the signature of a commit or
+// tag.  The signature of a commit is taken from the gpgsig header of a
+// SHA-1 object and the gpgsig-sha256 header of a SHA-256 object; only
+// objects held in an object.Raw may be of the latter format.  Split
+// returns ErrNotSigned if the object is not signed, and an
+// object.TypeError if it is neither a commit nor a tag.
+func Split(obj object.Interface) (payload, sig []byte, err error) {
+	objType := object.TypeOf(obj)
+	format := object.SHA1
+	if r, ok := obj.(*object.Raw); ok {
+		format = r.Format()
+	}
+	data, err := obj.MarshalBinary()
+	if err != nil {
+		return nil, nil, err
+	}
+	data = data[bytes.IndexByte(data, 0)+1:]
+	switch objType {
+	case object.TypeCommit:
+		payload, sig = splitCommit(data, commitHeader(format))
+	case object.TypeTag:
+		payload, sig = splitTag(data)
+	default:
+		return nil, nil, &object.TypeError{objType}
+	}
+	if sig == nil {
+		return nil, nil, ErrNotSigned
+	}
+	return payload, sig, nil
+}
+
+// commitHeader returns the name of the commit header holding the
+// signature of a commit of the given object format.
+func commitHeader(f object.Format) string {
+	if f == object.SHA256 {
+		return "gpgsig-sha256"
+	}
+	return "gpgsig"
+}
+
+// splitCommit separates the text of a commit into its payload and the
+// value of its signature header with the given name.  The signature
+// headers of both object formats are removed from the payload, as the
+// reference Git client does, since the headers of each format sign the
+// commit without the other's.
+func splitCommit(text []byte, name string) (payload, sig []byte) {
+	payload = make([]byte, 0, len(text))
+	inHeader := true
+	// collecting is set while reading the signature header that is
+	// returned, and skipping while reading any other one.
+	collecting, skipping := false, false
+	for len(text) > 0 {
+		line := text
+		if i := bytes.IndexByte(text, '\n'); i >= 0 {
+			line = text[:i+1]
+		}
+		text = text[len(line):]
+		if !inHeader {
+			payload = append(payload, line...)
+			continue
+		}
+		switch {
+		case line[0] == '\n':
+			inHeader = false
+			collecting, skipping = false, false
+		case line[0] == ' ' && (collecting || skipping):
+			if collecting {
+				sig = append(sig, line[1:]...)
+			}
+			continue
+		case hasHeader(line, "gpgsig"), hasHeader(line, "gpgsig-sha256"):
+			collecting = sig == nil && hasHeader(line, name)
+			skipping = !collecting
+			if collecting {
+				sig = append([]byte{}, line[len(name)+1:]...)
+			}
+			continue
+		default:
+			collecting, skipping = false, false
+		}
+		payload = append(payload, line...)
+	}
+	return payload, sig
+}
+
+// hasHeader reports whether the line is a header with the given name.
+func hasHeader(line []byte, name string) bool {
+	return len(line) > len(name) &&
+		string(line[:len(name)]) == name &&
+		line[len(name)] == ' '
+}
+
+// splitTag separates the text of a tag into its payload and the
+// signature at the end of its message.  The signature begins at the
+// last line that starts a signature of a known format.
+func splitTag(text []byte) (payload, sig []byte) {
+	start := -1
+	for i := 0; i < len(text); {
+		if DetectFormat(text[i:]) != FormatUnknown {
+			start = i
+		}
+		j := bytes.IndexByte(text[i:], '\n')
+		if j < 0 {
+			break
+		}
+		i += j + 1
+	}
+	if start < 0 {
+		return text, nil
+	}
+	return text[:start], text[start:]
+}
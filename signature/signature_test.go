@@ -0,0 +1,122 @@
+package signature
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// readObject returns the commit or tag in testdata with the given
+// name, which the reference Git client signed.
+func readObject(t *testing.T, objType object.Type, name string) object.Interface {
+	text, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := object.New(objType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+// testVerifiers returns verifiers of the signatures in testdata.
+func testVerifiers(t *testing.T) []Verifier {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "allowed_signers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers, err := ParseAllowedSigners(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join("testdata", "openpgp.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []Verifier{
+		&SSHVerifier{
+			AllowedSigners: signers,
+			Time:           time.Unix(1112911993, 0),
+		},
+		&OpenPGPVerifier{
+			Keyring: keyring,
+			Trust:   map[string]Trust{"60EDF825097D7C416FBAA9624C02A464C88A86C0": TrustUltimate},
+		},
+	}
+}
+
+// gitSignatureTests are the objects in testdata and the results of
+// verifying their signatures.
+var gitSignatureTests = []struct {
+	name    string
+	objType object.Type
+	res     Result
+}{
+	{"ssh-ed25519-commit", object.TypeCommit, Result{FormatSSH, "author@example.com", "SHA256:C7Fgt+R0VZEg2Ce7OEPz7JhGciaurXzrx2qQVQHRwhE", TrustFully}},
+	{"ssh-ed25519-tag", object.TypeTag, Result{FormatSSH, "author@example.com", "SHA256:C7Fgt+R0VZEg2Ce7OEPz7JhGciaurXzrx2qQVQHRwhE", TrustFully}},
+	{"ssh-rsa-commit", object.TypeCommit, Result{FormatSSH, "bob@example.com", "SHA256:OSh0cEhBEeg/HYP0CBlYLhXqoDcgrTe3oCnb7f3EFQM", TrustFully}},
+	{"openpgp-commit", object.TypeCommit, Result{FormatOpenPGP, "A U Thor <author@example.com>", "60EDF825097D7C416FBAA9624C02A464C88A86C0", TrustUltimate}},
+}
+
+func TestVerifyGit(t *testing.T) {
+	vs := testVerifiers(t)
+	for _, tt := range gitSignatureTests {
+		obj := readObject(t, tt.objType, tt.name)
+		res, err := Verify(obj, vs...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if *res != tt.res {
+			t.Errorf("%s: got %v, want %v", tt.name, *res, tt.res)
+		}
+
+		data, err := obj.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := object.NewRaw(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(raw, vs...); err != nil {
+			t.Errorf("%s as a Raw: %v", tt.name, err)
+		}
+
+		switch obj := obj.(type) {
+		case *object.Commit:
+			obj.Message = "tampered\n"
+		case *object.Tag:
+			obj.Tag = "tampered"
+		}
+		if _, err := Verify(obj, vs...); err != ErrBadSignature {
+			t.Errorf("%s tampered with: got %v, want ErrBadSignature", tt.name, err)
+		}
+		if _, err := Verify(obj); err != ErrNoVerifier {
+			t.Errorf("%s without verifiers: got %v, want ErrNoVerifier", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyNotSigned(t *testing.T) {
+	c := readObject(t, object.TypeCommit, "ssh-ed25519-commit").(*object.Commit)
+	c.Extra = nil
+	if _, err := Verify(c, testVerifiers(t)...); err != ErrNotSigned {
+		t.Errorf("got %v, want ErrNotSigned", err)
+	}
+}
@@ -0,0 +1,425 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var errRSASHA1 = errors.New("signature: RSA key cannot make SHA-2 signatures")
+
+// The SSH signature format is described in
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig.
+
+const (
+	sshsigMagic     = "SSHSIG"
+	sshsigVersion   = 1
+	sshsigNamespace = "git"
+	sshsigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshsigEnd       = "-----END SSH SIGNATURE-----"
+)
+
+// An sshsig is the decoded blob of an SSH signature, without the magic
+// preamble.
+type sshsig struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      []byte
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshsigHash returns a new hash.Hash computing the given SSH signature
+// hash algorithm, or nil if it is not supported.
+func sshsigHash(alg string) hash.Hash {
+	switch alg {
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// sshsigSignedData returns the data whose signature an SSH signature
+// of msg carries.
+func sshsigSignedData(namespace, hashAlg string, msg []byte) []byte {
+	h := sshsigHash(hashAlg)
+	h.Write(msg)
+	return append([]byte(sshsigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      []byte
+		HashAlgorithm string
+		Hash          []byte
+	}{namespace, nil, hashAlg, h.Sum(nil)})...)
+}
+
+// decodeSSHSig decodes an armored SSH signature.
+func decodeSSHSig(armored []byte) (*sshsig, error) {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, sshsigBegin) || !strings.HasSuffix(text, sshsigEnd) {
+		return nil, ErrBadSignature
+	}
+	text = text[len(sshsigBegin) : len(text)-len(sshsigEnd)]
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil || !bytes.HasPrefix(blob, []byte(sshsigMagic)) {
+		return nil, ErrBadSignature
+	}
+	sig := new(sshsig)
+	if err := ssh.Unmarshal(blob[len(sshsigMagic):], sig); err != nil {
+		return nil, ErrBadSignature
+	}
+	if sig.Version != sshsigVersion {
+		return nil, ErrBadSignature
+	}
+	return sig, nil
+}
+
+// encodeSSHSig encodes an SSH signature in the armored form written by
+// ssh-keygen.
+func encodeSSHSig(sig *sshsig) []byte {
+	blob := append([]byte(sshsigMagic), ssh.Marshal(sig)...)
+	text := base64.StdEncoding.EncodeToString(blob)
+	buf := new(bytes.Buffer)
+	buf.WriteString(sshsigBegin + "\n")
+	for len(text) > 70 {
+		buf.WriteString(text[:70] + "\n")
+		text = text[70:]
+	}
+	buf.WriteString(text + "\n")
+	buf.WriteString(sshsigEnd + "\n")
+	return buf.Bytes()
+}
+
+// An AllowedSigner is an entry of an OpenSSH allowed signers file, as
+// described in the ssh-keygen(1) manual page.
+type AllowedSigner struct {
+	// Principals holds the patterns of the principals the entry
+	// applies to.  A pattern may contain the wildcards * and ?, and
+	// is negated by a leading !.
+	Principals []string
+
+	// Key is the public key of the signers, or that of the
+	// certificate authority that issues their certificates if
+	// CertAuthority is set.
+	Key           ssh.PublicKey
+	CertAuthority bool
+
+	// Namespaces holds the patterns of the signature namespaces the
+	// key is allowed to sign in.  An empty Namespaces allows any
+	// namespace.
+	Namespaces []string
+
+	// ValidAfter and ValidBefore limit the times at which the key is
+	// valid.  A zero time sets no limit.
+	ValidAfter  time.Time
+	ValidBefore time.Time
+}
+
+// ParseAllowedSigners parses the contents of an OpenSSH allowed
+// signers file, as named by the reference Git client's
+// gpg.ssh.allowedSignersFile configuration variable.  Empty lines and
+// lines starting with # are ignored.
+func ParseAllowedSigners(data []byte) ([]AllowedSigner, error) {
+	var signers []AllowedSigner
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, fmt.Errorf("signature: allowed signers line %d: %v", n, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, s.Err()
+}
+
+func parseAllowedSigner(line string) (AllowedSigner, error) {
+	var signer AllowedSigner
+	var principals string
+	if line[0] == '"' {
+		i := strings.IndexByte(line[1:], '"')
+		if i < 0 {
+			return signer, errors.New("unterminated principals")
+		}
+		principals, line = line[1:i+1], line[i+2:]
+	} else {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return signer, errors.New("missing key")
+		}
+		principals, line = line[:i], line[i:]
+	}
+	signer.Principals = strings.Split(principals, ",")
+
+	key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(line)))
+	if err != nil {
+		return signer, err
+	}
+	signer.Key = key
+	for _, opt := range options {
+		name, value := opt, ""
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			name, value = opt[:i], strings.Trim(opt[i+1:], `"`)
+		}
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			signer.CertAuthority = true
+		case "namespaces":
+			signer.Namespaces = strings.Split(value, ",")
+		case "valid-after":
+			signer.ValidAfter, err = parseSSHTime(value)
+		case "valid-before":
+			signer.ValidBefore, err = parseSSHTime(value)
+		default:
+			return signer, fmt.Errorf("unsupported option %q", name)
+		}
+		if err != nil {
+			return signer, err
+		}
+	}
+	return signer, nil
+}
+
+// parseSSHTime parses an allowed signers timestamp of the form
+// YYYYMMDD[HHMM[SS]], in local time unless followed by a Z.
+func parseSSHTime(s string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(s, "Z") || strings.HasSuffix(s, "z") {
+		s, loc = s[:len(s)-1], time.UTC
+	}
+	var layout string
+	switch len(s) {
+	case 8:
+		layout = "20060102"
+	case 12:
+		layout = "200601021504"
+	case 14:
+		layout = "20060102150405"
+	default:
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
+
+// validAt reports whether the entry allows signing in the git
+// namespace at time t.
+func (a *AllowedSigner) validAt(t time.Time) bool {
+	if len(a.Namespaces) > 0 && !matchPatternList(a.Namespaces, sshsigNamespace) {
+		return false
+	}
+	if !a.ValidAfter.IsZero() && t.Before(a.ValidAfter) {
+		return false
+	}
+	if !a.ValidBefore.IsZero() && !t.Before(a.ValidBefore) {
+		return false
+	}
+	return true
+}
+
+// An SSHVerifier verifies SSH signatures in the sshsig format, as made
+// by the reference Git client with gpg.format set to ssh.
+type SSHVerifier struct {
+	AllowedSigners []AllowedSigner
+
+	// Time is the time at which the validity of the allowed signers
+	// is checked.  The reference Git client uses the date of the
+	// commit's committer or the tag's tagger.  If Time is zero, the
+	// current time is used.
+	Time time.Time
+}
+
+func (v *SSHVerifier) Format() Format {
+	return FormatSSH
+}
+
+// Verify checks an armored SSH signature made in the git namespace.
+// The key of a good signature is the SHA-256 fingerprint of the signing
+// key.  If the key matches an allowed signer, the signer is the
+// entry's principals (or, for a certificate, the certificate principal
+// they match) and the trust level is TrustFully.  Otherwise Verify
+// returns ErrUnknownKey along with a Result with an empty signer and the
+// trust level TrustUndefined, which the reference Git client reports as
+// a good signature by an unknown key.
+func (v *SSHVerifier) Verify(payload, armored []byte) (*Result, error) {
+	sig, err := decodeSSHSig(armored)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Namespace != sshsigNamespace || sshsigHash(sig.HashAlgorithm) == nil {
+		return nil, ErrBadSignature
+	}
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	s := new(ssh.Signature)
+	if err := ssh.Unmarshal(sig.Signature, s); err != nil || s.Format == ssh.KeyAlgoRSA {
+		// sshsig requires RSA signatures to use SHA-2.
+		return nil, ErrBadSignature
+	}
+	data := sshsigSignedData(sig.Namespace, sig.HashAlgorithm, payload)
+	if err := pub.Verify(data, s); err != nil {
+		return nil, ErrBadSignature
+	}
+
+	t := v.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	res := &Result{Format: FormatSSH, Trust: TrustUndefined}
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		res.Key = ssh.FingerprintSHA256(cert.Key)
+		res.Signer = v.findCertSigner(cert, t)
+	} else {
+		res.Key = ssh.FingerprintSHA256(pub)
+		res.Signer = v.findSigner(pub, t)
+	}
+	if res.Signer == "" {
+		return res, ErrUnknownKey
+	}
+	res.Trust = TrustFully
+	return res, nil
+}
+
+// An SSHSigner makes SSH signatures in the sshsig format, like the
+// reference Git client with gpg.format set to ssh.  The signatures use
+// the SHA-512 hash algorithm, like those of ssh-keygen.
+type SSHSigner struct {
+	// Signer holds the signing key, or a certificate of it made with
+	// ssh.NewCertSigner.
+	Signer ssh.Signer
+}
+
+func (s *SSHSigner) Format() Format {
+	return FormatSSH
+}
+
+// Sign returns an armored SSH signature in the git namespace.  It
+// returns an error for an RSA key whose Signer is not an
+// ssh.AlgorithmSigner, as it could only make ssh-rsa signatures, which
+// use SHA-1 and are rejected by verifiers of the sshsig format.
+func (s *SSHSigner) Sign(payload []byte) ([]byte, error) {
+	const hashAlg = "sha512"
+	pub := s.Signer.PublicKey()
+	keyType := pub.Type()
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		keyType = cert.Key.Type()
+	}
+	data := sshsigSignedData(sshsigNamespace, hashAlg, payload)
+	var sig *ssh.Signature
+	var err error
+	if keyType == ssh.KeyAlgoRSA {
+		// sshsig requires RSA signatures to use SHA-2.
+		as, ok := s.Signer.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, errRSASHA1
+		}
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.Signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encodeSSHSig(&sshsig{
+		Version:       sshsigVersion,
+		PublicKey:     pub.Marshal(),
+		Namespace:     sshsigNamespace,
+		HashAlgorithm: hashAlg,
+		Signature:     ssh.Marshal(sig),
+	}), nil
+}
+
+// findSigner returns the principals of the first allowed signer entry
+// for the key that is valid at time t, or "" if there is none.
+func (v *SSHVerifier) findSigner(key ssh.PublicKey, t time.Time) string {
+	b := key.Marshal()
+	for i := range v.AllowedSigners {
+		a := &v.AllowedSigners[i]
+		if !a.CertAuthority && bytes.Equal(a.Key.Marshal(), b) && a.validAt(t) {
+			return strings.Join(a.Principals, ",")
+		}
+	}
+	return ""
+}
+
+// findCertSigner returns the first principal of the user certificate
+// that an allowed certificate authority valid at time t vouches for,
+// or "" if there is none.
+func (v *SSHVerifier) findCertSigner(cert *ssh.Certificate, t time.Time) string {
+	if cert.CertType != ssh.UserCert {
+		return ""
+	}
+	checker := &ssh.CertChecker{Clock: func() time.Time { return t }}
+	ca := cert.SignatureKey.Marshal()
+	for i := range v.AllowedSigners {
+		a := &v.AllowedSigners[i]
+		if !a.CertAuthority || !bytes.Equal(a.Key.Marshal(), ca) || !a.validAt(t) {
+			continue
+		}
+		for _, p := range cert.ValidPrincipals {
+			if matchPatternList(a.Principals, p) && checker.CheckCert(p, cert) == nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// matchPatternList reports whether s matches a list of OpenSSH
+// patterns: at least one of the patterns and none of the negated ones.
+func matchPatternList(patterns []string, s string) bool {
+	matched := false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if matchPattern(p[1:], s) {
+				return false
+			}
+		} else if matchPattern(p, s) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern reports whether s matches the pattern, in which * matches
+// any sequence of characters and ? any single character.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,287 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/lxr/go.git-scm/object"
+)
+
+// testCommit returns an unsigned commit of the empty tree.
+func testCommit(t *testing.T) *object.Commit {
+	tree, err := object.Hash(&object.Tree{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := object.Signature{
+		Name:  "A U Thor",
+		Email: "author@example.com",
+		Date:  time.Unix(1112911993, 0).In(time.FixedZone("", -7*3600)),
+	}
+	return &object.Commit{
+		Tree:      tree,
+		Author:    sig,
+		Committer: sig,
+		Message:   "signed commit\n",
+	}
+}
+
+// testTag returns an unsigned tag of the empty tree.
+func testTag(t *testing.T) *object.Tag {
+	c := testCommit(t)
+	return &object.Tag{
+		Object:  c.Tree,
+		Type:    object.TypeTree,
+		Tag:     "v1",
+		Tagger:  c.Committer,
+		Message: "signed tag",
+	}
+}
+
+func newEd25519Signer(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newRSASigner(t *testing.T) ssh.Signer {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// signAndVerify signs a commit and a tag with s and verifies them with
+// v, and returns the results of the verifications.
+func signAndVerify(t *testing.T, s Signer, v Verifier) ([]*Result, []error) {
+	c := testCommit(t)
+	if err := SignCommit(c, s); err != nil {
+		t.Fatal(err)
+	}
+	tag := testTag(t)
+	if err := SignTag(tag, s); err != nil {
+		t.Fatal(err)
+	}
+	var results []*Result
+	var errs []error
+	for _, obj := range []object.Interface{c, tag} {
+		res, err := Verify(obj, v)
+		results = append(results, res)
+		errs = append(errs, err)
+	}
+	return results, errs
+}
+
+func TestSSHRoundTrip(t *testing.T) {
+	for _, signer := range []ssh.Signer{newEd25519Signer(t), newRSASigner(t)} {
+		v := &SSHVerifier{
+			AllowedSigners: []AllowedSigner{{
+				Principals: []string{"author@example.com"},
+				Key:        signer.PublicKey(),
+				Namespaces: []string{"git"},
+			}},
+		}
+		want := Result{
+			Format: FormatSSH,
+			Signer: "author@example.com",
+			Key:    ssh.FingerprintSHA256(signer.PublicKey()),
+			Trust:  TrustFully,
+		}
+		results, errs := signAndVerify(t, &SSHSigner{signer}, v)
+		for i, res := range results {
+			if errs[i] != nil {
+				t.Errorf("%s: %v", signer.PublicKey().Type(), errs[i])
+			} else if *res != want {
+				t.Errorf("%s: got %v, want %v", signer.PublicKey().Type(), *res, want)
+			}
+		}
+	}
+}
+
+func TestSSHUnknownKey(t *testing.T) {
+	signer := newEd25519Signer(t)
+	other := newEd25519Signer(t)
+	tests := []struct {
+		desc    string
+		allowed []AllowedSigner
+		time    time.Time
+	}{
+		{"no allowed signers", nil, time.Time{}},
+		{"other key", []AllowedSigner{{
+			Principals: []string{"author@example.com"},
+			Key:        other.PublicKey(),
+		}}, time.Time{}},
+		{"other namespace", []AllowedSigner{{
+			Principals: []string{"author@example.com"},
+			Key:        signer.PublicKey(),
+			Namespaces: []string{"file"},
+		}}, time.Time{}},
+		{"expired", []AllowedSigner{{
+			Principals:  []string{"author@example.com"},
+			Key:         signer.PublicKey(),
+			ValidBefore: time.Unix(1112911993, 0),
+		}}, time.Unix(1112911993, 0)},
+		{"not yet valid", []AllowedSigner{{
+			Principals: []string{"author@example.com"},
+			Key:        signer.PublicKey(),
+			ValidAfter: time.Unix(1112911994, 0),
+		}}, time.Unix(1112911993, 0)},
+	}
+	want := Result{
+		Format: FormatSSH,
+		Key:    ssh.FingerprintSHA256(signer.PublicKey()),
+		Trust:  TrustUndefined,
+	}
+	for _, tt := range tests {
+		v := &SSHVerifier{AllowedSigners: tt.allowed, Time: tt.time}
+		results, errs := signAndVerify(t, &SSHSigner{signer}, v)
+		for i, res := range results {
+			if errs[i] != ErrUnknownKey {
+				t.Errorf("%s: got error %v, want ErrUnknownKey", tt.desc, errs[i])
+			} else if res == nil || *res != want {
+				t.Errorf("%s: got %v, want %v", tt.desc, res, want)
+			}
+		}
+	}
+}
+
+func TestSSHCertificate(t *testing.T) {
+	ca := newEd25519Signer(t)
+	signer := newEd25519Signer(t)
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"author@example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &SSHVerifier{
+		AllowedSigners: []AllowedSigner{{
+			Principals:    []string{"*@example.com"},
+			Key:           ca.PublicKey(),
+			CertAuthority: true,
+		}},
+	}
+	want := Result{
+		Format: FormatSSH,
+		Signer: "author@example.com",
+		Key:    ssh.FingerprintSHA256(signer.PublicKey()),
+		Trust:  TrustFully,
+	}
+	results, errs := signAndVerify(t, &SSHSigner{certSigner}, v)
+	for i, res := range results {
+		if errs[i] != nil {
+			t.Error(errs[i])
+		} else if *res != want {
+			t.Errorf("got %v, want %v", *res, want)
+		}
+	}
+
+	// the CA's key does not sign on its own behalf
+	v.AllowedSigners[0].Key = newEd25519Signer(t).PublicKey()
+	if _, errs := signAndVerify(t, &SSHSigner{certSigner}, v); errs[0] != ErrUnknownKey {
+		t.Errorf("certificate by other CA: got %v, want ErrUnknownKey", errs[0])
+	}
+}
+
+// A plainSigner hides the SignWithAlgorithm method of an ssh.Signer.
+type plainSigner struct {
+	signer ssh.Signer
+}
+
+func (s plainSigner) PublicKey() ssh.PublicKey {
+	return s.signer.PublicKey()
+}
+
+func (s plainSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.signer.Sign(rand, data)
+}
+
+func TestSSHSignRSASHA1(t *testing.T) {
+	s := &SSHSigner{plainSigner{newRSASigner(t)}}
+	if sig, err := s.Sign([]byte("payload\n")); err == nil {
+		t.Errorf("RSA signer without SHA-2 made signature %q", sig)
+	}
+	s = &SSHSigner{plainSigner{newEd25519Signer(t)}}
+	if _, err := s.Sign([]byte("payload\n")); err != nil {
+		t.Errorf("Ed25519 signer without SignWithAlgorithm: %v", err)
+	}
+}
+
+func TestParseAllowedSigners(t *testing.T) {
+	key := newEd25519Signer(t).PublicKey()
+	authorized := string(ssh.MarshalAuthorizedKey(key))
+	data := "# comment\n\n" +
+		"author@example.com " + authorized +
+		`"a@example.com,!b@example.com" namespaces="git,file",valid-after="20050101",valid-before="20060101Z" ` + authorized +
+		"*@example.com cert-authority " + authorized
+	signers, err := ParseAllowedSigners([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 3 {
+		t.Fatalf("got %d allowed signers, want 3", len(signers))
+	}
+	s := signers[1]
+	if len(s.Principals) != 2 || s.Principals[1] != "!b@example.com" ||
+		len(s.Namespaces) != 2 || s.Namespaces[1] != "file" ||
+		s.ValidAfter.IsZero() || !s.ValidBefore.Equal(time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("options parsed as %+v", s)
+	}
+	if !signers[2].CertAuthority || signers[0].CertAuthority {
+		t.Error("cert-authority option not parsed")
+	}
+	for _, bad := range []string{
+		"author@example.com\n",
+		`"author@example.com ` + authorized,
+		"author@example.com unknown-option " + authorized,
+		`author@example.com valid-after="2005" ` + authorized,
+	} {
+		if _, err := ParseAllowedSigners([]byte(bad)); err == nil {
+			t.Errorf("%q parsed without error", bad)
+		}
+	}
+}
+
+func TestMatchPatternList(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		s        string
+		match    bool
+	}{
+		{[]string{"*@example.com"}, "a@example.com", true},
+		{[]string{"?@example.com"}, "ab@example.com", false},
+		{[]string{"*", "!b"}, "a", true},
+		{[]string{"*", "!b"}, "b", false},
+		{[]string{"!b"}, "a", false},
+		{[]string{"git"}, "git", true},
+	}
+	for _, tt := range tests {
+		if got := matchPatternList(tt.patterns, tt.s); got != tt.match {
+			t.Errorf("matchPatternList(%q, %q) = %v", tt.patterns, tt.s, got)
+		}
+	}
+}